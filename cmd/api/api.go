@@ -5,15 +5,35 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/blob"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/db"
+	"github.com/sikozonpc/ecom/services/address"
 	"github.com/sikozonpc/ecom/services/cart"
+	"github.com/sikozonpc/ecom/services/category"
+	"github.com/sikozonpc/ecom/services/coupon"
+	"github.com/sikozonpc/ecom/services/currency"
+	"github.com/sikozonpc/ecom/services/gallery"
+	"github.com/sikozonpc/ecom/services/inventory"
 	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/services/payment"
 	"github.com/sikozonpc/ecom/services/product"
+	"github.com/sikozonpc/ecom/services/returns"
+	"github.com/sikozonpc/ecom/services/shipping"
+	"github.com/sikozonpc/ecom/services/tax"
 	"github.com/sikozonpc/ecom/services/user"
+	"github.com/sikozonpc/ecom/services/variant"
+	"github.com/sikozonpc/ecom/services/warehouse"
+	"github.com/sikozonpc/ecom/services/webhook"
 )
 
 // APIServer é a estrutura principal que representa o servidor da API.
@@ -47,17 +67,197 @@ func (s *APIServer) Run() error {
 	userHandler := user.NewHandler(userStore) // Cria o handler responsável por gerenciar rotas de usuários.
 	userHandler.RegisterRoutes(subrouter)     // Registra as rotas relacionadas a usuários no subroteador.
 
+	// Transações usadas pelos serviços que alteram mais de uma tabela de uma vez.
+	transactor := db.NewTransactor(s.db)
+
+	// Configuração do catálogo de endereços dos usuários.
+	addressStore := address.NewStore(s.db)                                    // Cria a camada de armazenamento para endereços.
+	addressHandler := address.NewHandler(addressStore, userStore, transactor) // Cria o handler para o catálogo de endereços.
+	addressHandler.RegisterRoutes(subrouter)                                  // Registra as rotas de endereços no subroteador.
+
+	// Configuração da árvore de categorias.
+	categoryStore := category.NewStore(s.db)                                     // Cria a camada de armazenamento para categorias.
+	categoryHandler := category.NewHandler(categoryStore, userStore, transactor) // Cria o handler para gerenciar a árvore de categorias.
+	categoryHandler.RegisterRoutes(subrouter)                                    // Registra as rotas de categorias no subroteador.
+
+	// Configuração das taxas de câmbio das moedas em que os preços podem ser mostrados e cobrados.
+	rateStore := currency.NewStore(s.db)                                 // Cria a camada de armazenamento para as taxas de câmbio.
+	rateHandler := currency.NewHandler(rateStore, userStore, transactor) // Cria o handler para consultar e manter as taxas.
+	rateHandler.RegisterRoutes(subrouter)                                // Registra as rotas de taxas de câmbio no subroteador.
+
+	// Configuração da tabela de impostos, por destino e classe fiscal dos produtos.
+	taxStore := tax.NewStore(s.db)                                // Cria a camada de armazenamento para as alíquotas.
+	taxHandler := tax.NewHandler(taxStore, userStore, transactor) // Cria o handler para consultar e substituir a tabela de impostos.
+	taxHandler.RegisterRoutes(subrouter)                          // Registra as rotas de impostos no subroteador.
+
+	// Arquivos enviados pelos usuários ficam em "static/uploads", servidos pelo servidor de arquivos estáticos abaixo.
+	blobStore := blob.NewLocalStore(filepath.Join("static", "uploads"), "/uploads")
+
+	// Livro-razão do estoque: toda alteração de quantidade de produtos e variantes passa por ele.
+	ledger := inventory.NewLedger(s.db) // Cria o livro-razão que registra cada movimentação de estoque.
+
+	// Configuração dos depósitos: cada um guarda seu próprio estoque, e o checkout escolhe de quais depósitos
+	// cada item sai conforme a estratégia definida em ALLOCATION_STRATEGY.
+	allocator, err := warehouse.ParseStrategy(configs.Envs.AllocationStrategy)
+	if err != nil {
+		return err
+	}
+	warehouseStore := warehouse.NewStore(s.db)                                      // Cria a camada de armazenamento para os depósitos, seus estoques e as alocações dos pedidos.
+	warehouseHandler := warehouse.NewHandler(warehouseStore, userStore, transactor) // Cria o handler para gerenciar os depósitos e gerar as listas de separação.
+	warehouseHandler.RegisterRoutes(subrouter)                                      // Registra as rotas de depósitos no subroteador.
+
 	// Configuração do serviço de produtos.
-	productStore := product.NewStore(s.db)                        // Cria a camada de armazenamento para produtos.
-	productHandler := product.NewHandler(productStore, userStore) // Cria o handler para gerenciar produtos, integrando usuários.
-	productHandler.RegisterRoutes(subrouter)                      // Registra as rotas de produtos no subroteador.
+	imageStore := gallery.NewStore(s.db)   // Cria a camada de armazenamento para as galerias de imagens.
+	productStore := product.NewStore(s.db) // Cria a camada de armazenamento para produtos.
+	// Cria o handler para gerenciar produtos, integrando categorias, imagens, moedas, depósitos e usuários.
+	productHandler := product.NewHandler(product.Deps{
+		ProductStore:   productStore,
+		CategoryStore:  categoryStore,
+		ImageStore:     imageStore,
+		RateStore:      rateStore,
+		Ledger:         ledger,
+		WarehouseStore: warehouseStore,
+		UserStore:      userStore,
+		Transactor:     transactor,
+	})
+	productHandler.RegisterRoutes(subrouter) // Registra as rotas de produtos no subroteador.
+
+	// Configuração das galerias de imagens dos produtos.
+	galleryHandler := gallery.NewHandler(imageStore, productStore, blobStore, userStore, transactor) // Cria o handler para o envio e a ordenação das imagens.
+	galleryHandler.RegisterRoutes(subrouter)                                                         // Registra as rotas de imagens no subroteador.
+
+	// Configuração das variantes de produtos (tamanho, cor, etc.).
+	variantStore := variant.NewStore(s.db)                                                                     // Cria a camada de armazenamento para opções e variantes.
+	variantHandler := variant.NewHandler(variantStore, productStore, rateStore, ledger, userStore, transactor) // Cria o handler para gerenciar as variantes de cada produto.
+	variantHandler.RegisterRoutes(subrouter)                                                                   // Registra as rotas de variantes no subroteador.
+
+	// Configuração dos pagamentos. Por enquanto o gateway é o falso, em memória, que aprova, recusa ou
+	// deixa expirar os pagamentos conforme a variável FAKE_PAYMENT_MODE.
+	paymentMode, err := payment.ParseMode(configs.Envs.FakePaymentMode)
+	if err != nil {
+		return err
+	}
+	paymentStore := payment.NewStore(s.db)                // Cria a camada de armazenamento para as tentativas de pagamento.
+	paymentGateway := payment.NewFakeGateway(paymentMode) // Cria o gateway que autoriza os pagamentos no checkout.
+
+	// Capturas, estornos e cancelamentos de pagamentos: são registrados junto com a mudança do pedido e enviados ao
+	// gateway só depois do commit; os que falham são repetidos a cada PAYMENT_RETRY_INTERVAL_IN_SECONDS.
+	paymentSettler, err := payment.NewSettler(paymentStore, paymentGateway, time.Duration(configs.Envs.PaymentRetryIntervalInSeconds)*time.Second) // Cria o serviço que envia as operações de pagamento ao gateway.
+	if err != nil {
+		return err
+	}
+	go paymentSettler.Run(context.Background()) // Repete periodicamente as operações que falharam enquanto o servidor estiver no ar.
+
+	// Reservas de estoque: os carrinhos em checkout e os pedidos ainda não pagos seguram suas unidades por RESERVATION_TTL_IN_SECONDS.
+	reservationStore := inventory.NewStore(s.db)                                                                         // Cria a camada de armazenamento para as reservas de estoque.
+	reserver := inventory.NewReserver(reservationStore, time.Duration(configs.Envs.ReservationTTLInSeconds)*time.Second) // Cria o serviço que reserva o estoque dos carrinhos e pedidos.
+
+	// Configuração dos cupons de desconto, aplicados no checkout do carrinho.
+	couponStore := coupon.NewStore(s.db)                                                                           // Cria a camada de armazenamento para os cupons e seus resgates.
+	couponHandler := coupon.NewHandler(couponStore, productStore, categoryStore, rateStore, userStore, transactor) // Cria o handler para a equipe gerenciar os cupons.
+	couponHandler.RegisterRoutes(subrouter)                                                                        // Registra as rotas de cupons no subroteador.
 
 	// Configuração do serviço de pedidos.
 	orderStore := order.NewStore(s.db) // Cria a camada de armazenamento para pedidos.
+	// Cria o handler para o histórico e o cancelamento de pedidos, capturando e devolvendo os pagamentos.
+	orderHandler := order.NewHandler(order.Deps{
+		OrderStore:     orderStore,
+		Ledger:         ledger,
+		CouponStore:    couponStore,
+		Reservations:   reservationStore,
+		PaymentStore:   paymentStore,
+		PaymentSettler: paymentSettler,
+		UserStore:      userStore,
+		Transactor:     transactor,
+	})
+	orderHandler.RegisterRoutes(subrouter) // Registra as rotas de pedidos no subroteador.
+
+	// Configuração dos métodos de entrega e de como cada um calcula o frete.
+	shippingStore := shipping.NewStore(s.db)                                                // Cria a camada de armazenamento para os métodos de entrega.
+	shippingHandler := shipping.NewHandler(shippingStore, rateStore, userStore, transactor) // Cria o handler para a equipe gerenciar os métodos de entrega.
+	shippingHandler.RegisterRoutes(subrouter)                                               // Registra as rotas de métodos de entrega no subroteador.
+
+	// Alertas de estoque baixo: o checkout avisa quando um produto chega ao seu limite de reposição,
+	// pelo log ou por e-mail conforme LOW_STOCK_NOTIFIER.
+	notifier, err := inventory.NewNotifier(configs.Envs.LowStockNotifier, inventory.SMTPConfig{
+		Addr: configs.Envs.SMTPAddress,
+		From: configs.Envs.SMTPFrom,
+		To:   strings.FieldsFunc(configs.Envs.LowStockAlertRecipients, func(r rune) bool { return r == ',' || r == ' ' }),
+	})
+	if err != nil {
+		return err
+	}
+	inventoryHandler := inventory.NewHandler(productStore, userStore) // Cria o handler que lista os produtos com estoque baixo.
+	inventoryHandler.RegisterRoutes(subrouter)                        // Registra as rotas de administração do estoque no subroteador.
 
 	// Configuração do serviço de carrinho de compras.
-	cartHandler := cart.NewHandler(productStore, orderStore, userStore) // Cria o handler para carrinhos.
-	cartHandler.RegisterRoutes(subrouter)                               // Registra as rotas de carrinhos no subroteador.
+	cartStore := cart.NewStore(s.db) // Cria a camada de armazenamento para o carrinho salvo de cada usuário.
+	// Cria o handler para carrinhos, aplicando cupons, impostos e frete, reservando o estoque, alocando os itens
+	// aos depósitos, avisando do estoque baixo e autorizando o pagamento no checkout.
+	cartHandler := cart.NewHandler(cart.Deps{
+		ProductStore:   productStore,
+		VariantStore:   variantStore,
+		OrderStore:     orderStore,
+		CartStore:      cartStore,
+		AddressStore:   addressStore,
+		RateStore:      rateStore,
+		CouponStore:    couponStore,
+		TaxCalculator:  tax.NewCalculator(taxStore),
+		ShippingStore:  shippingStore,
+		PaymentStore:   paymentStore,
+		PaymentGateway: paymentGateway,
+		Reserver:       reserver,
+		Reservations:   reservationStore,
+		Ledger:         ledger,
+		WarehouseStore: warehouseStore,
+		Allocator:      allocator,
+		Notifier:       notifier,
+		UserStore:      userStore,
+		Transactor:     transactor,
+	})
+	cartHandler.RegisterRoutes(subrouter) // Registra as rotas de carrinhos no subroteador.
+
+	// Configuração dos webhooks pelos quais o gateway informa o resultado dos pagamentos, assinados com PAYMENT_WEBHOOK_SECRET;
+	// sem ela a rota não é servida.
+	webhookStore := webhook.NewStore(s.db) // Cria a camada de armazenamento para os eventos já recebidos.
+	// Cria o handler que aplica os eventos de pagamento aos pedidos.
+	webhookHandler := webhook.NewHandler(webhook.Deps{
+		EventStore:     webhookStore,
+		OrderStore:     orderStore,
+		Ledger:         ledger,
+		CouponStore:    couponStore,
+		Reservations:   reservationStore,
+		PaymentStore:   paymentStore,
+		PaymentGateway: paymentGateway,
+		PaymentSettler: paymentSettler,
+		Secret:         []byte(configs.Envs.PaymentWebhookSecret),
+		Transactor:     transactor,
+	})
+	webhookHandler.RegisterRoutes(subrouter) // Registra a rota de webhooks de pagamento no subroteador.
+	if configs.Envs.PaymentWebhookSecret == "" {
+		log.Println("PAYMENT_WEBHOOK_SECRET is not set, payment webhooks are disabled")
+	}
+
+	// Configuração das devoluções: os clientes pedem, a equipe aprova, recebe e reembolsa.
+	returnStore := returns.NewStore(s.db) // Cria a camada de armazenamento para as devoluções.
+	// Cria o handler das devoluções, repondo o estoque e reembolsando os itens recebidos.
+	returnHandler := returns.NewHandler(returns.Deps{
+		ReturnStore:    returnStore,
+		OrderStore:     orderStore,
+		Ledger:         ledger,
+		PaymentStore:   paymentStore,
+		PaymentSettler: paymentSettler,
+		UserStore:      userStore,
+		Transactor:     transactor,
+	})
+	returnHandler.RegisterRoutes(subrouter) // Registra as rotas de devoluções no subroteador.
+
+	// Varredura em segundo plano que libera as reservas vencidas e cancela os pedidos não pagos a tempo.
+	sweeper, err := inventory.NewSweeper(reservationStore, orderStore, ledger, couponStore, transactor, time.Duration(configs.Envs.ReservationSweepIntervalInSeconds)*time.Second) // Cria a varredura que libera as reservas vencidas.
+	if err != nil {
+		return err
+	}
+	go sweeper.Run(context.Background()) // Executa a varredura periodicamente enquanto o servidor estiver no ar.
 
 	// Serve static files
 	// Qualquer rota que não coincida com as anteriores servirá arquivos da pasta "static".
@@ -22,6 +22,8 @@ func main() {
 		Net:                  "tcp",
 		AllowNativePasswords: true,
 		ParseTime:            true,
+		// migrations may hold more than one statement
+		MultiStatements: true,
 	}
 
 	db, err := db.NewMySQLStorage(cfg)
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS addresses (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `userId` INT UNSIGNED NOT NULL,
+  `fullName` VARCHAR(255) NOT NULL,
+  `line1` VARCHAR(255) NOT NULL,
+  `line2` VARCHAR(255) NOT NULL DEFAULT '',
+  `city` VARCHAR(255) NOT NULL,
+  `state` VARCHAR(255) NOT NULL DEFAULT '',
+  `postalCode` VARCHAR(32) NOT NULL,
+  `country` CHAR(2) NOT NULL,
+  `phone` VARCHAR(32) NOT NULL DEFAULT '',
+  `isDefault` BOOLEAN NOT NULL DEFAULT FALSE,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
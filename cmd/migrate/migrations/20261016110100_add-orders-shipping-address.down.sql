@@ -0,0 +1,9 @@
+ALTER TABLE orders
+  DROP COLUMN `shippingFullName`,
+  DROP COLUMN `shippingLine1`,
+  DROP COLUMN `shippingLine2`,
+  DROP COLUMN `shippingCity`,
+  DROP COLUMN `shippingState`,
+  DROP COLUMN `shippingPostalCode`,
+  DROP COLUMN `shippingCountry`,
+  DROP COLUMN `shippingPhone`;
@@ -0,0 +1,9 @@
+ALTER TABLE orders
+  ADD COLUMN `shippingFullName` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingLine1` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingLine2` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingCity` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingState` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingPostalCode` VARCHAR(32) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingCountry` CHAR(2) NOT NULL DEFAULT '',
+  ADD COLUMN `shippingPhone` VARCHAR(32) NOT NULL DEFAULT '';
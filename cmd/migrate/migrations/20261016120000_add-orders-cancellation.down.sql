@@ -0,0 +1,5 @@
+ALTER TABLE orders
+  DROP FOREIGN KEY `fk_orders_cancelled_by`,
+  DROP COLUMN `cancelledBy`,
+  DROP COLUMN `cancelReason`,
+  DROP COLUMN `cancelledAt`;
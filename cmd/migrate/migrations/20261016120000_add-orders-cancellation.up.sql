@@ -0,0 +1,5 @@
+ALTER TABLE orders
+  ADD COLUMN `cancelledBy` INT UNSIGNED NULL,
+  ADD COLUMN `cancelReason` VARCHAR(500) NOT NULL DEFAULT '',
+  ADD COLUMN `cancelledAt` TIMESTAMP NULL,
+  ADD CONSTRAINT `fk_orders_cancelled_by` FOREIGN KEY (`cancelledBy`) REFERENCES users(`id`);
@@ -0,0 +1 @@
+ALTER TABLE products ADD COLUMN `deletedAt` TIMESTAMP NULL;
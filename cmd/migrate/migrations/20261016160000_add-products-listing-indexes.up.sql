@@ -0,0 +1,3 @@
+CREATE INDEX idx_products_price ON products (`price`, `id`);
+CREATE INDEX idx_products_created_at ON products (`createdAt`, `id`);
+CREATE INDEX idx_products_name ON products (`name`, `id`);
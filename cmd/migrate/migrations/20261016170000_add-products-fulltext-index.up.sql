@@ -0,0 +1 @@
+CREATE FULLTEXT INDEX idx_products_search ON products (`name`, `description`);
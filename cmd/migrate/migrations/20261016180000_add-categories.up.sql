@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS categories (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `name` VARCHAR(255) NOT NULL,
+  `parentId` INT UNSIGNED NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_categories_parent_name` (`parentId`, `name`),
+  CONSTRAINT `fk_categories_parent` FOREIGN KEY (`parentId`) REFERENCES categories(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS product_categories (
+  `productId` INT UNSIGNED NOT NULL,
+  `categoryId` INT UNSIGNED NOT NULL,
+
+  PRIMARY KEY (`productId`, `categoryId`),
+  KEY `idx_product_categories_category` (`categoryId`),
+  CONSTRAINT `fk_product_categories_product` FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_product_categories_category` FOREIGN KEY (`categoryId`) REFERENCES categories(`id`) ON DELETE CASCADE
+);
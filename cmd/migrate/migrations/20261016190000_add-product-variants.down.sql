@@ -0,0 +1,13 @@
+ALTER TABLE order_items
+  DROP FOREIGN KEY `fk_order_items_variant`,
+  DROP COLUMN `variantId`;
+
+DELETE FROM cart_items WHERE `variantId` <> 0;
+ALTER TABLE cart_items
+  ADD UNIQUE KEY `userId` (`userId`, `productId`),
+  DROP INDEX `uq_cart_items_line`,
+  DROP COLUMN `variantId`;
+
+DROP TABLE IF EXISTS product_variant_options;
+DROP TABLE IF EXISTS product_variants;
+DROP TABLE IF EXISTS product_options;
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS product_options (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `productId` INT UNSIGNED NOT NULL,
+  `name` VARCHAR(64) NOT NULL,
+  `position` INT UNSIGNED NOT NULL DEFAULT 0,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_product_options_name` (`productId`, `name`),
+  CONSTRAINT `fk_product_options_product` FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `productId` INT UNSIGNED NOT NULL,
+  `sku` VARCHAR(64) NOT NULL,
+  `price` DECIMAL(10, 2) NULL,
+  `quantity` INT UNSIGNED NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `deletedAt` TIMESTAMP NULL DEFAULT NULL,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_product_variants_sku` (`sku`),
+  KEY `idx_product_variants_product` (`productId`),
+  CONSTRAINT `fk_product_variants_product` FOREIGN KEY (`productId`) REFERENCES products(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS product_variant_options (
+  `variantId` INT UNSIGNED NOT NULL,
+  `optionId` INT UNSIGNED NOT NULL,
+  `value` VARCHAR(64) NOT NULL,
+
+  PRIMARY KEY (`variantId`, `optionId`),
+  CONSTRAINT `fk_product_variant_options_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_product_variant_options_option` FOREIGN KEY (`optionId`) REFERENCES product_options(`id`) ON DELETE CASCADE
+);
+
+-- 0 marks a cart line for a product without variants, so the unique key
+-- still holds one line per product and variant
+ALTER TABLE cart_items
+  ADD COLUMN `variantId` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `productId`,
+  ADD UNIQUE KEY `uq_cart_items_line` (`userId`, `productId`, `variantId`),
+  DROP INDEX `userId`;
+
+ALTER TABLE order_items
+  ADD COLUMN `variantId` INT UNSIGNED NULL DEFAULT NULL AFTER `productId`,
+  ADD CONSTRAINT `fk_order_items_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS product_images (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `productId` INT UNSIGNED NOT NULL,
+  `blobKey` VARCHAR(255) NOT NULL,
+  `thumbnailKey` VARCHAR(255) NOT NULL,
+  `url` VARCHAR(255) NOT NULL,
+  `thumbnailUrl` VARCHAR(255) NOT NULL,
+  `contentType` VARCHAR(64) NOT NULL,
+  `width` INT UNSIGNED NOT NULL,
+  `height` INT UNSIGNED NOT NULL,
+  `position` INT UNSIGNED NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_product_images_product` (`productId`, `position`),
+  CONSTRAINT `fk_product_images_product` FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE
+);
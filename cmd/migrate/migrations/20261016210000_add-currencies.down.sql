@@ -0,0 +1,14 @@
+ALTER TABLE order_items
+  DROP COLUMN `exchangeRate`,
+  DROP COLUMN `listPrice`,
+  DROP COLUMN `listCurrency`;
+
+ALTER TABLE orders
+  DROP COLUMN `exchangeRate`,
+  DROP COLUMN `currency`;
+
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE product_variants DROP COLUMN `currency`;
+
+ALTER TABLE products DROP COLUMN `currency`;
@@ -0,0 +1,34 @@
+-- prices so far were all in the default currency
+ALTER TABLE products
+  ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `price`;
+
+-- set together with the price, which stays NULL when the variant sells at
+-- the product's price
+ALTER TABLE product_variants
+  ADD COLUMN `currency` CHAR(3) NULL DEFAULT NULL AFTER `price`;
+
+UPDATE product_variants SET `currency` = 'USD' WHERE `price` IS NOT NULL;
+
+-- how many units of each currency one unit of the default currency buys
+CREATE TABLE IF NOT EXISTS exchange_rates (
+  `currency` CHAR(3) NOT NULL,
+  `rate` DECIMAL(18, 8) NOT NULL,
+  `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`currency`)
+);
+
+-- orders keep the currency they were charged in and the rate of that
+-- currency at checkout
+ALTER TABLE orders
+  ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `userId`,
+  ADD COLUMN `exchangeRate` DECIMAL(18, 8) NOT NULL DEFAULT 1 AFTER `total`;
+
+-- order items keep the catalog price they were sold at and the rate used to
+-- convert it into the order currency
+ALTER TABLE order_items
+  ADD COLUMN `listCurrency` CHAR(3) NOT NULL DEFAULT 'USD' AFTER `price`,
+  ADD COLUMN `listPrice` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `listCurrency`,
+  ADD COLUMN `exchangeRate` DECIMAL(18, 8) NOT NULL DEFAULT 1 AFTER `listPrice`;
+
+UPDATE order_items SET `listPrice` = `price`;
@@ -0,0 +1,14 @@
+ALTER TABLE order_items DROP COLUMN `discount`;
+
+ALTER TABLE orders
+  DROP FOREIGN KEY `fk_orders_coupon`,
+  DROP COLUMN `freeShipping`,
+  DROP COLUMN `couponCode`,
+  DROP COLUMN `couponId`,
+  DROP COLUMN `discount`,
+  DROP COLUMN `subtotal`;
+
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_categories;
+DROP TABLE IF EXISTS coupon_products;
+DROP TABLE IF EXISTS coupons;
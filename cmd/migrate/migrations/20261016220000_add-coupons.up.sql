@@ -0,0 +1,68 @@
+CREATE TABLE IF NOT EXISTS coupons (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(64) NOT NULL,
+  `type` ENUM('percentage', 'fixed_amount', 'free_shipping') NOT NULL,
+  `percentOff` INT UNSIGNED NULL DEFAULT NULL,
+  -- currency of amountOff and minCartValue, converted at checkout like prices
+  `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+  `amountOff` DECIMAL(10, 2) NULL DEFAULT NULL,
+  `minCartValue` DECIMAL(10, 2) NULL DEFAULT NULL,
+  `maxUses` INT UNSIGNED NULL DEFAULT NULL,
+  `maxUsesPerUser` INT UNSIGNED NULL DEFAULT NULL,
+  `uses` INT UNSIGNED NOT NULL DEFAULT 0,
+  `startsAt` TIMESTAMP NULL DEFAULT NULL,
+  `endsAt` TIMESTAMP NULL DEFAULT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_coupons_code` (`code`)
+);
+
+-- a coupon with no products and no categories applies to the whole cart
+CREATE TABLE IF NOT EXISTS coupon_products (
+  `couponId` INT UNSIGNED NOT NULL,
+  `productId` INT UNSIGNED NOT NULL,
+
+  PRIMARY KEY (`couponId`, `productId`),
+  CONSTRAINT `fk_coupon_products_coupon` FOREIGN KEY (`couponId`) REFERENCES coupons(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_coupon_products_product` FOREIGN KEY (`productId`) REFERENCES products(`id`) ON DELETE CASCADE
+);
+
+-- categories also cover their subcategories
+CREATE TABLE IF NOT EXISTS coupon_categories (
+  `couponId` INT UNSIGNED NOT NULL,
+  `categoryId` INT UNSIGNED NOT NULL,
+
+  PRIMARY KEY (`couponId`, `categoryId`),
+  CONSTRAINT `fk_coupon_categories_coupon` FOREIGN KEY (`couponId`) REFERENCES coupons(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_coupon_categories_category` FOREIGN KEY (`categoryId`) REFERENCES categories(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `couponId` INT UNSIGNED NOT NULL,
+  `userId` INT UNSIGNED NOT NULL,
+  `orderId` INT UNSIGNED NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_coupon_redemptions_user` (`couponId`, `userId`),
+  CONSTRAINT `fk_coupon_redemptions_coupon` FOREIGN KEY (`couponId`) REFERENCES coupons(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_coupon_redemptions_user` FOREIGN KEY (`userId`) REFERENCES users(`id`),
+  CONSTRAINT `fk_coupon_redemptions_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`) ON DELETE CASCADE
+);
+
+-- total is subtotal minus discount; orders placed so far had no discount
+ALTER TABLE orders
+  ADD COLUMN `subtotal` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `currency`,
+  ADD COLUMN `discount` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `subtotal`,
+  ADD COLUMN `couponId` INT UNSIGNED NULL DEFAULT NULL AFTER `exchangeRate`,
+  ADD COLUMN `couponCode` VARCHAR(64) NOT NULL DEFAULT '' AFTER `couponId`,
+  ADD COLUMN `freeShipping` BOOLEAN NOT NULL DEFAULT FALSE AFTER `couponCode`,
+  ADD CONSTRAINT `fk_orders_coupon` FOREIGN KEY (`couponId`) REFERENCES coupons(`id`) ON DELETE SET NULL;
+
+UPDATE orders SET `subtotal` = `total`;
+
+-- the part of the order discount taken off each line
+ALTER TABLE order_items
+  ADD COLUMN `discount` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `price`;
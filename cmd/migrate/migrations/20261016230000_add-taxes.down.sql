@@ -0,0 +1,7 @@
+ALTER TABLE order_items DROP COLUMN `tax`;
+
+ALTER TABLE orders DROP COLUMN `tax`;
+
+DROP TABLE IF EXISTS tax_rates;
+
+ALTER TABLE products DROP COLUMN `taxClass`;
@@ -0,0 +1,22 @@
+ALTER TABLE products
+  ADD COLUMN `taxClass` VARCHAR(32) NOT NULL DEFAULT 'standard' AFTER `currency`;
+
+-- the share of the price charged as tax, by destination and tax class; an
+-- empty region covers the whole country
+CREATE TABLE IF NOT EXISTS tax_rates (
+  `country` CHAR(2) NOT NULL,
+  `region` VARCHAR(255) NOT NULL DEFAULT '',
+  `taxClass` VARCHAR(32) NOT NULL,
+  `rate` DECIMAL(18, 8) NOT NULL,
+  `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`country`, `region`, `taxClass`)
+);
+
+-- total is now subtotal minus discount plus tax; orders placed so far were
+-- charged no tax
+ALTER TABLE orders
+  ADD COLUMN `tax` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `discount`;
+
+ALTER TABLE order_items
+  ADD COLUMN `tax` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `discount`;
@@ -0,0 +1,11 @@
+ALTER TABLE orders
+  DROP FOREIGN KEY `fk_orders_shipping_method`,
+  DROP COLUMN `shippingMethod`,
+  DROP COLUMN `shippingMethodId`,
+  DROP COLUMN `shippingCost`;
+
+DROP TABLE IF EXISTS shipping_method_countries;
+
+DROP TABLE IF EXISTS shipping_methods;
+
+ALTER TABLE products DROP COLUMN `weight`;
@@ -0,0 +1,36 @@
+-- shipping weight of one unit, in grams
+ALTER TABLE products
+  ADD COLUMN `weight` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `taxClass`;
+
+CREATE TABLE IF NOT EXISTS shipping_methods (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(64) NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `type` ENUM('flat_rate', 'weight_based', 'free_over_threshold') NOT NULL,
+  -- currency of every amount of the method, converted at checkout like prices
+  `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+  `cost` DECIMAL(10, 2) NOT NULL,
+  `costPerKg` DECIMAL(10, 2) NULL DEFAULT NULL,
+  `freeOver` DECIMAL(10, 2) NULL DEFAULT NULL,
+  `active` BOOLEAN NOT NULL DEFAULT TRUE,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_shipping_methods_code` (`code`)
+);
+
+-- a method with no countries ships everywhere
+CREATE TABLE IF NOT EXISTS shipping_method_countries (
+  `methodId` INT UNSIGNED NOT NULL,
+  `country` CHAR(2) NOT NULL,
+
+  PRIMARY KEY (`methodId`, `country`),
+  CONSTRAINT `fk_shipping_method_countries_method` FOREIGN KEY (`methodId`) REFERENCES shipping_methods(`id`) ON DELETE CASCADE
+);
+
+-- total now also includes shippingCost; orders placed so far shipped for free
+ALTER TABLE orders
+  ADD COLUMN `shippingCost` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `tax`,
+  ADD COLUMN `shippingMethodId` INT UNSIGNED NULL DEFAULT NULL AFTER `freeShipping`,
+  ADD COLUMN `shippingMethod` VARCHAR(255) NOT NULL DEFAULT '' AFTER `shippingMethodId`,
+  ADD CONSTRAINT `fk_orders_shipping_method` FOREIGN KEY (`shippingMethodId`) REFERENCES shipping_methods(`id`) ON DELETE SET NULL;
@@ -0,0 +1,18 @@
+-- every attempt to charge an order; amount is in the order's currency
+CREATE TABLE IF NOT EXISTS payments (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `orderId` INT UNSIGNED NOT NULL,
+  `provider` VARCHAR(32) NOT NULL,
+  -- the gateway's id for the authorization, empty when it was never given one
+  `reference` VARCHAR(255) NOT NULL DEFAULT '',
+  `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+  `amount` DECIMAL(10, 2) NOT NULL,
+  `status` ENUM('authorized', 'captured', 'voided', 'refunded', 'declined', 'failed') NOT NULL,
+  `error` TEXT NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_payments_reference` (`provider`, `reference`),
+  CONSTRAINT `fk_payments_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`)
+);
@@ -0,0 +1,12 @@
+-- every webhook event handled, so redeliveries of the same event are ignored
+CREATE TABLE IF NOT EXISTS webhook_events (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `source` VARCHAR(32) NOT NULL,
+  `eventId` VARCHAR(255) NOT NULL,
+  `type` VARCHAR(64) NOT NULL,
+  `payload` TEXT NOT NULL,
+  `receivedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_webhook_events_event` (`source`, `eventId`)
+);
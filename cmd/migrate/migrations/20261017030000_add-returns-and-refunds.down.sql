@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS refunds;
+
+DROP TABLE IF EXISTS return_items;
+
+DROP TABLE IF EXISTS returns;
+
+ALTER TABLE orders DROP COLUMN `refunded`;
+
+UPDATE orders SET `status` = 'delivered' WHERE `status` = 'partially_refunded';
+
+ALTER TABLE orders MODIFY `status` ENUM('pending', 'paid', 'fulfilled', 'shipped', 'delivered', 'cancelled', 'refunded') NOT NULL DEFAULT 'pending';
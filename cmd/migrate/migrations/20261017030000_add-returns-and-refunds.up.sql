@@ -0,0 +1,47 @@
+ALTER TABLE orders MODIFY `status` ENUM('pending', 'paid', 'fulfilled', 'shipped', 'delivered', 'cancelled', 'refunded', 'partially_refunded') NOT NULL DEFAULT 'pending';
+
+-- the part of total given back so far, in the order's currency
+ALTER TABLE orders
+  ADD COLUMN `refunded` DECIMAL(10, 2) NOT NULL DEFAULT 0 AFTER `total`;
+
+CREATE TABLE IF NOT EXISTS returns (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `orderId` INT UNSIGNED NOT NULL,
+  `userId` INT UNSIGNED NOT NULL,
+  `status` ENUM('requested', 'approved', 'rejected', 'received') NOT NULL DEFAULT 'requested',
+  `reason` VARCHAR(500) NOT NULL,
+  `note` VARCHAR(500) NOT NULL DEFAULT '',
+  `restocked` BOOLEAN NOT NULL DEFAULT FALSE,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_returns_status` (`status`),
+  CONSTRAINT `fk_returns_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+  CONSTRAINT `fk_returns_user` FOREIGN KEY (`userId`) REFERENCES users(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS return_items (
+  `returnId` INT UNSIGNED NOT NULL,
+  `orderItemId` INT UNSIGNED NOT NULL,
+  `quantity` INT UNSIGNED NOT NULL,
+
+  PRIMARY KEY (`returnId`, `orderItemId`),
+  CONSTRAINT `fk_return_items_return` FOREIGN KEY (`returnId`) REFERENCES returns(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_return_items_order_item` FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`)
+);
+
+CREATE TABLE IF NOT EXISTS refunds (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `orderId` INT UNSIGNED NOT NULL,
+  `returnId` INT UNSIGNED NULL DEFAULT NULL,
+  `paymentId` INT UNSIGNED NULL DEFAULT NULL,
+  `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+  `amount` DECIMAL(10, 2) NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  CONSTRAINT `fk_refunds_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+  CONSTRAINT `fk_refunds_return` FOREIGN KEY (`returnId`) REFERENCES returns(`id`),
+  CONSTRAINT `fk_refunds_payment` FOREIGN KEY (`paymentId`) REFERENCES payments(`id`)
+);
@@ -0,0 +1,20 @@
+-- units held for a user's cart while they check out, or for a pending order
+-- until it is paid; exactly one of userId and orderId is set
+CREATE TABLE IF NOT EXISTS stock_reservations (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `productId` INT UNSIGNED NOT NULL,
+  `variantId` INT UNSIGNED NULL DEFAULT NULL,
+  `quantity` INT UNSIGNED NOT NULL,
+  `userId` INT UNSIGNED NULL DEFAULT NULL,
+  `orderId` INT UNSIGNED NULL DEFAULT NULL,
+  `expiresAt` TIMESTAMP NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_stock_reservations_product` (`productId`, `expiresAt`),
+  KEY `idx_stock_reservations_expires` (`expiresAt`),
+  CONSTRAINT `fk_stock_reservations_product` FOREIGN KEY (`productId`) REFERENCES products(`id`),
+  CONSTRAINT `fk_stock_reservations_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`),
+  CONSTRAINT `fk_stock_reservations_user` FOREIGN KEY (`userId`) REFERENCES users(`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_stock_reservations_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,32 @@
+-- every change to the stock of a product or variant; rows are only ever added
+CREATE TABLE IF NOT EXISTS stock_movements (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `productId` INT UNSIGNED NOT NULL,
+  `variantId` INT UNSIGNED NULL DEFAULT NULL,
+  `change` INT NOT NULL,
+  -- the stock left after the movement
+  `balance` INT NOT NULL,
+  `reason` ENUM('sale', 'cancellation', 'return', 'adjustment', 'import') NOT NULL,
+  `actorId` INT UNSIGNED NULL DEFAULT NULL,
+  `reference` VARCHAR(255) NOT NULL DEFAULT '',
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_stock_movements_product` (`productId`, `variantId`),
+  CONSTRAINT `fk_stock_movements_product` FOREIGN KEY (`productId`) REFERENCES products(`id`),
+  CONSTRAINT `fk_stock_movements_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`),
+  CONSTRAINT `fk_stock_movements_actor` FOREIGN KEY (`actorId`) REFERENCES users(`id`)
+);
+
+CREATE TRIGGER `trg_stock_movements_no_update` BEFORE UPDATE ON stock_movements
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'stock_movements is append-only';
+
+CREATE TRIGGER `trg_stock_movements_no_delete` BEFORE DELETE ON stock_movements
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'stock_movements is append-only';
+
+-- the stock on hand when the ledger starts is its opening balance
+INSERT INTO stock_movements (productId, `change`, balance, reason, reference)
+  SELECT id, quantity, quantity, 'adjustment', 'opening balance' FROM products WHERE quantity <> 0;
+
+INSERT INTO stock_movements (productId, variantId, `change`, balance, reason, reference)
+  SELECT productId, id, quantity, quantity, 'adjustment', 'opening balance' FROM product_variants WHERE quantity <> 0;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS order_allocations;
+
+ALTER TABLE stock_movements
+  DROP FOREIGN KEY `fk_stock_movements_warehouse`,
+  DROP COLUMN `warehouseId`;
+
+DROP TABLE IF EXISTS inventory_levels;
+
+DROP TABLE IF EXISTS warehouses;
@@ -0,0 +1,67 @@
+CREATE TABLE IF NOT EXISTS warehouses (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(50) NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `fullName` VARCHAR(255) NOT NULL DEFAULT '',
+  `line1` VARCHAR(255) NOT NULL DEFAULT '',
+  `line2` VARCHAR(255) NOT NULL DEFAULT '',
+  `city` VARCHAR(255) NOT NULL DEFAULT '',
+  `state` VARCHAR(255) NOT NULL DEFAULT '',
+  `postalCode` VARCHAR(20) NOT NULL DEFAULT '',
+  `country` CHAR(2) NOT NULL DEFAULT '',
+  `phone` VARCHAR(50) NOT NULL DEFAULT '',
+  -- the stock that isn't brought into a particular warehouse goes here
+  `isDefault` BOOLEAN NOT NULL DEFAULT FALSE,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_warehouses_code` (`code`)
+);
+
+INSERT INTO warehouses (code, name, isDefault) VALUES ('main', 'Main warehouse', TRUE);
+
+-- the stock of each product and variant in each warehouse
+CREATE TABLE IF NOT EXISTS inventory_levels (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `warehouseId` INT UNSIGNED NOT NULL,
+  `productId` INT UNSIGNED NOT NULL,
+  `variantId` INT UNSIGNED NULL DEFAULT NULL,
+  -- NULLs never collide in a unique key, so the product's own stock is keyed
+  -- on variant 0
+  `variantKey` INT UNSIGNED AS (COALESCE(`variantId`, 0)) STORED,
+  `quantity` INT NOT NULL DEFAULT 0,
+
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_inventory_levels_item` (`warehouseId`, `productId`, `variantKey`),
+  KEY `idx_inventory_levels_product` (`productId`, `variantId`),
+  CONSTRAINT `fk_inventory_levels_warehouse` FOREIGN KEY (`warehouseId`) REFERENCES warehouses(`id`),
+  CONSTRAINT `fk_inventory_levels_product` FOREIGN KEY (`productId`) REFERENCES products(`id`),
+  CONSTRAINT `fk_inventory_levels_variant` FOREIGN KEY (`variantId`) REFERENCES product_variants(`id`)
+);
+
+-- all the stock on hand starts in the default warehouse
+INSERT INTO inventory_levels (warehouseId, productId, quantity)
+  SELECT w.id, p.id, p.quantity FROM products p JOIN warehouses w ON w.isDefault WHERE p.quantity <> 0;
+
+INSERT INTO inventory_levels (warehouseId, productId, variantId, quantity)
+  SELECT w.id, v.productId, v.id, v.quantity FROM product_variants v JOIN warehouses w ON w.isDefault WHERE v.quantity <> 0;
+
+ALTER TABLE stock_movements
+  ADD COLUMN `warehouseId` INT UNSIGNED NULL DEFAULT NULL AFTER `variantId`,
+  ADD CONSTRAINT `fk_stock_movements_warehouse` FOREIGN KEY (`warehouseId`) REFERENCES warehouses(`id`);
+
+-- the part of each order item shipped from each warehouse
+CREATE TABLE IF NOT EXISTS order_allocations (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `orderId` INT UNSIGNED NOT NULL,
+  `orderItemId` INT UNSIGNED NOT NULL,
+  `warehouseId` INT UNSIGNED NOT NULL,
+  `quantity` INT UNSIGNED NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_order_allocations_warehouse` (`warehouseId`, `orderId`),
+  CONSTRAINT `fk_order_allocations_order` FOREIGN KEY (`orderId`) REFERENCES orders(`id`),
+  CONSTRAINT `fk_order_allocations_item` FOREIGN KEY (`orderItemId`) REFERENCES order_items(`id`),
+  CONSTRAINT `fk_order_allocations_warehouse` FOREIGN KEY (`warehouseId`) REFERENCES warehouses(`id`)
+);
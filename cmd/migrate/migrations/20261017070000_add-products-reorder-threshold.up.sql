@@ -0,0 +1,3 @@
+-- the stock, variants included, at or under which a product needs restocking
+ALTER TABLE products
+  ADD COLUMN `reorderThreshold` INT UNSIGNED NOT NULL DEFAULT 0 AFTER `quantity`;
@@ -0,0 +1,21 @@
+-- calls owed to the payment gateway: they are recorded in the transaction
+-- that decides them and made once it commits, and the pending ones are
+-- retried until they succeed or run out of attempts
+CREATE TABLE IF NOT EXISTS payment_operations (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `paymentId` INT UNSIGNED NOT NULL,
+  `type` ENUM('capture', 'void', 'refund') NOT NULL,
+  `currency` CHAR(3) NOT NULL DEFAULT 'USD',
+  `amount` DECIMAL(10, 2) NOT NULL,
+  `status` ENUM('pending', 'succeeded', 'failed') NOT NULL DEFAULT 'pending',
+  `attempts` INT UNSIGNED NOT NULL DEFAULT 0,
+  -- why the last attempt failed
+  `error` TEXT NOT NULL,
+  `createdAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updatedAt` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (`id`),
+  KEY `idx_payment_operations_status` (`status`, `updatedAt`),
+  KEY `idx_payment_operations_payment` (`paymentId`, `status`),
+  CONSTRAINT `fk_payment_operations_payment` FOREIGN KEY (`paymentId`) REFERENCES payments(`id`)
+);
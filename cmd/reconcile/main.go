@@ -0,0 +1,51 @@
+// Command reconcile checks that the stock of every product and variant
+// equals the sum of its movements and the sum of its levels in the
+// warehouses, exiting with status 1 when any of them doesn't.
+package main
+
+import (
+	"log"
+	"os"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/db"
+	"github.com/sikozonpc/ecom/services/inventory"
+)
+
+func main() {
+	cfg := mysqlDriver.Config{
+		User:                 configs.Envs.DBUser,
+		Passwd:               configs.Envs.DBPassword,
+		Addr:                 configs.Envs.DBAddress,
+		DBName:               configs.Envs.DBName,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	}
+
+	db, err := db.NewMySQLStorage(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	discrepancies, err := inventory.NewLedger(db).Reconcile()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, d := range discrepancies {
+		if d.VariantID != nil {
+			log.Printf("product %d variant %d: quantity %d, ledger %d, warehouses %d", d.ProductID, *d.VariantID, d.Quantity, d.Ledger, d.Levels)
+		} else {
+			log.Printf("product %d: quantity %d, ledger %d, warehouses %d", d.ProductID, d.Quantity, d.Ledger, d.Levels)
+		}
+	}
+
+	if len(discrepancies) > 0 {
+		log.Printf("%d stock discrepancies found", len(discrepancies))
+		os.Exit(1)
+	}
+
+	log.Println("stock matches the ledger")
+}
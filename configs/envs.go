@@ -17,6 +17,33 @@ type Config struct {
 	DBName                 string
 	JWTSecret              string
 	JWTExpirationInSeconds int64
+	// FakePaymentMode tells the in-process payment gateway whether to
+	// succeed, decline or time out.
+	FakePaymentMode string
+	// PaymentWebhookSecret signs the events the payment gateway sends to
+	// POST /webhooks/payments. It has no default: without it the webhook
+	// route isn't served.
+	PaymentWebhookSecret string
+	// ReservationTTLInSeconds is how long a cart or an unpaid order holds
+	// its stock.
+	ReservationTTLInSeconds int64
+	// ReservationSweepIntervalInSeconds is how often expired reservations
+	// are released.
+	ReservationSweepIntervalInSeconds int64
+	// PaymentRetryIntervalInSeconds is how often gateway calls that failed
+	// are tried again.
+	PaymentRetryIntervalInSeconds int64
+	// AllocationStrategy picks the warehouses each order ships from:
+	// closest, single-shipment or most-stock.
+	AllocationStrategy string
+	// LowStockNotifier is where low-stock alerts go: log or smtp.
+	LowStockNotifier string
+	// SMTPAddress is the host:port of the mail server the smtp notifier
+	// sends through.
+	SMTPAddress string
+	SMTPFrom    string
+	// LowStockAlertRecipients is a comma-separated list of addresses.
+	LowStockAlertRecipients string
 }
 
 var Envs = initConfig()
@@ -25,14 +52,24 @@ func initConfig() Config {
 	godotenv.Load()
 
 	return Config{
-		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
-		Port:                   getEnv("PORT", "8080"),
-		DBUser:                 getEnv("DB_USER", "*****"),
-		DBPassword:             getEnv("DB_PASSWORD", "*****"),
-		DBAddress:              fmt.Sprintf("%s:%s", getEnv("DB_HOST", "192.168.100.13"), getEnv("DB_PORT", "3306")),
-		DBName:                 getEnv("DB_NAME", "ecom"),
-		JWTSecret:              getEnv("JWT_SECRET", "not-so-secret-now-is-it?"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 3600*24*7),
+		PublicHost:                        getEnv("PUBLIC_HOST", "http://localhost"),
+		Port:                              getEnv("PORT", "8080"),
+		DBUser:                            getEnv("DB_USER", "*****"),
+		DBPassword:                        getEnv("DB_PASSWORD", "*****"),
+		DBAddress:                         fmt.Sprintf("%s:%s", getEnv("DB_HOST", "192.168.100.13"), getEnv("DB_PORT", "3306")),
+		DBName:                            getEnv("DB_NAME", "ecom"),
+		JWTSecret:                         getEnv("JWT_SECRET", "not-so-secret-now-is-it?"),
+		JWTExpirationInSeconds:            getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 3600*24*7),
+		FakePaymentMode:                   getEnv("FAKE_PAYMENT_MODE", "succeed"),
+		PaymentWebhookSecret:              getEnv("PAYMENT_WEBHOOK_SECRET", ""),
+		ReservationTTLInSeconds:           getEnvAsInt("RESERVATION_TTL_IN_SECONDS", 15*60),
+		ReservationSweepIntervalInSeconds: getEnvAsInt("RESERVATION_SWEEP_INTERVAL_IN_SECONDS", 60),
+		PaymentRetryIntervalInSeconds:     getEnvAsInt("PAYMENT_RETRY_INTERVAL_IN_SECONDS", 60),
+		AllocationStrategy:                getEnv("ALLOCATION_STRATEGY", "single-shipment"),
+		LowStockNotifier:                  getEnv("LOW_STOCK_NOTIFIER", "log"),
+		SMTPAddress:                       getEnv("SMTP_ADDRESS", "localhost:1025"),
+		SMTPFrom:                          getEnv("SMTP_FROM", "inventory@localhost"),
+		LowStockAlertRecipients:           getEnv("LOW_STOCK_ALERT_RECIPIENTS", ""),
 	}
 }
 
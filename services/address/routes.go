@@ -0,0 +1,228 @@
+package address
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.AddressStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.AddressStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/users/me/addresses", auth.WithJWTAuth(h.handleGetAddresses, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/users/me/addresses", auth.WithJWTAuth(h.handleCreateAddress, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/users/me/addresses/{addressID}", auth.WithJWTAuth(h.handleGetAddress, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/users/me/addresses/{addressID}", auth.WithJWTAuth(h.handleUpdateAddress, h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/users/me/addresses/{addressID}", auth.WithJWTAuth(h.handleDeleteAddress, h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetAddresses(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addresses, err := h.store.GetAddressesByUserID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, addresses)
+}
+
+func (h *Handler) handleGetAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addressID, err := getAddressIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	address, err := h.store.GetAddressByID(userID, addressID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func (h *Handler) handleCreateAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	payload, err := parseAddressPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var address *types.Address
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		addressID, err := store.CreateAddress(types.Address{
+			UserID:        userID,
+			PostalAddress: payload.PostalAddress,
+		})
+		if err != nil {
+			return err
+		}
+
+		// the first address a user saves becomes their default
+		_, err = store.GetDefaultAddress(userID)
+		if payload.IsDefault || errors.Is(err, types.ErrNotFound) {
+			if err := store.SetDefaultAddress(userID, addressID); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		address, err = store.GetAddressByID(userID, addressID)
+		return err
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, address)
+}
+
+func (h *Handler) handleUpdateAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addressID, err := getAddressIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload, err := parseAddressPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var address *types.Address
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := store.GetAddressByID(userID, addressID); err != nil {
+			return err
+		}
+
+		err := store.UpdateAddress(types.Address{
+			ID:            addressID,
+			UserID:        userID,
+			PostalAddress: payload.PostalAddress,
+		})
+		if err != nil {
+			return err
+		}
+
+		if payload.IsDefault {
+			if err := store.SetDefaultAddress(userID, addressID); err != nil {
+				return err
+			}
+		}
+
+		address, err = store.GetAddressByID(userID, addressID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, address)
+}
+
+func (h *Handler) handleDeleteAddress(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	addressID, err := getAddressIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if err := store.DeleteAddress(userID, addressID); err != nil {
+			return err
+		}
+
+		// if the default address was deleted, promote the next one
+		_, err := store.GetDefaultAddress(userID)
+		if !errors.Is(err, types.ErrNotFound) {
+			return err
+		}
+
+		remaining, err := store.GetAddressesByUserID(userID)
+		if err != nil || len(remaining) == 0 {
+			return err
+		}
+
+		return store.SetDefaultAddress(userID, remaining[0].ID)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseAddressPayload(r *http.Request) (types.AddressPayload, error) {
+	var payload types.AddressPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return payload, fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return payload, nil
+}
+
+func getAddressIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["addressID"]
+	if !ok {
+		return 0, fmt.Errorf("missing address ID")
+	}
+
+	addressID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address ID")
+	}
+
+	return addressID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, types.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,252 @@
+package address
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/types"
+)
+
+// the handlers are exercised without the JWT middleware, so every request
+// runs as the user id GetUserIDFromContext falls back to
+const anonymousUserID = -1
+
+var validAddress = types.PostalAddress{
+	FullName:   "Ada Lovelace",
+	Line1:      "12 St James's Square",
+	City:       "London",
+	PostalCode: "SW1Y 4JH",
+	Country:    "GB",
+}
+
+func TestAddressServiceHandlers(t *testing.T) {
+	newRouter := func(handler *Handler) *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/users/me/addresses", handler.handleGetAddresses).Methods(http.MethodGet)
+		router.HandleFunc("/users/me/addresses", handler.handleCreateAddress).Methods(http.MethodPost)
+		router.HandleFunc("/users/me/addresses/{addressID}", handler.handleGetAddress).Methods(http.MethodGet)
+		router.HandleFunc("/users/me/addresses/{addressID}", handler.handleUpdateAddress).Methods(http.MethodPut)
+		router.HandleFunc("/users/me/addresses/{addressID}", handler.handleDeleteAddress).Methods(http.MethodDelete)
+		return router
+	}
+
+	t.Run("should fail to create an address with a missing field", func(t *testing.T) {
+		handler := NewHandler(newMockAddressStore(), nil, &mockTransactor{})
+
+		invalid := validAddress
+		invalid.City = ""
+		marshalled, err := json.Marshal(types.AddressPayload{PostalAddress: invalid})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/users/me/addresses", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should make the first address the default and move the flag on request", func(t *testing.T) {
+		store := newMockAddressStore()
+		handler := NewHandler(store, nil, &mockTransactor{})
+		router := newRouter(handler)
+
+		for _, payload := range []types.AddressPayload{
+			{PostalAddress: validAddress},
+			{PostalAddress: validAddress, IsDefault: true},
+		} {
+			marshalled, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/users/me/addresses", bytes.NewBuffer(marshalled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+			}
+		}
+
+		if store.addresses[0].IsDefault || !store.addresses[1].IsDefault {
+			t.Errorf("expected only the second address to be the default, got %+v", store.addresses)
+		}
+	})
+
+	t.Run("should not expose another user's address", func(t *testing.T) {
+		store := newMockAddressStore()
+		store.CreateAddress(types.Address{UserID: 7, PostalAddress: validAddress})
+		handler := NewHandler(store, nil, &mockTransactor{})
+
+		req, err := http.NewRequest(http.MethodGet, "/users/me/addresses/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should update an address", func(t *testing.T) {
+		store := newMockAddressStore()
+		store.CreateAddress(types.Address{UserID: anonymousUserID, PostalAddress: validAddress})
+		handler := NewHandler(store, nil, &mockTransactor{})
+
+		updated := validAddress
+		updated.City = "Cambridge"
+		marshalled, err := json.Marshal(types.AddressPayload{PostalAddress: updated})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, "/users/me/addresses/1", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if store.addresses[0].City != "Cambridge" {
+			t.Errorf("expected city to be updated, got %s", store.addresses[0].City)
+		}
+	})
+
+	t.Run("should promote another address when the default is deleted", func(t *testing.T) {
+		store := newMockAddressStore()
+		first, _ := store.CreateAddress(types.Address{UserID: anonymousUserID, PostalAddress: validAddress})
+		store.CreateAddress(types.Address{UserID: anonymousUserID, PostalAddress: validAddress})
+		store.SetDefaultAddress(anonymousUserID, first)
+		handler := NewHandler(store, nil, &mockTransactor{})
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/users/me/addresses/%d", first), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if len(store.addresses) != 1 || !store.addresses[0].IsDefault {
+			t.Errorf("expected the remaining address to be the default, got %+v", store.addresses)
+		}
+	})
+}
+
+type mockAddressStore struct {
+	addresses []types.Address
+	nextID    int
+}
+
+func newMockAddressStore() *mockAddressStore {
+	return &mockAddressStore{addresses: []types.Address{}, nextID: 1}
+}
+
+func (m *mockAddressStore) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	addresses := []types.Address{}
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+
+	return addresses, nil
+}
+
+func (m *mockAddressStore) GetAddressByID(userID int, addressID int) (*types.Address, error) {
+	for _, a := range m.addresses {
+		if a.ID == addressID && a.UserID == userID {
+			return &a, nil
+		}
+	}
+
+	return nil, fmt.Errorf("address %d %w", addressID, types.ErrNotFound)
+}
+
+func (m *mockAddressStore) GetDefaultAddress(userID int) (*types.Address, error) {
+	for _, a := range m.addresses {
+		if a.UserID == userID && a.IsDefault {
+			return &a, nil
+		}
+	}
+
+	return nil, fmt.Errorf("default address %w", types.ErrNotFound)
+}
+
+func (m *mockAddressStore) CreateAddress(a types.Address) (int, error) {
+	a.ID = m.nextID
+	m.nextID++
+	m.addresses = append(m.addresses, a)
+	return a.ID, nil
+}
+
+func (m *mockAddressStore) UpdateAddress(a types.Address) error {
+	for i := range m.addresses {
+		if m.addresses[i].ID == a.ID && m.addresses[i].UserID == a.UserID {
+			m.addresses[i].PostalAddress = a.PostalAddress
+		}
+	}
+
+	return nil
+}
+
+func (m *mockAddressStore) DeleteAddress(userID int, addressID int) error {
+	for i, a := range m.addresses {
+		if a.ID == addressID && a.UserID == userID {
+			m.addresses = append(m.addresses[:i], m.addresses[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("address %d %w", addressID, types.ErrNotFound)
+}
+
+func (m *mockAddressStore) SetDefaultAddress(userID int, addressID int) error {
+	for i := range m.addresses {
+		if m.addresses[i].UserID == userID {
+			m.addresses[i].IsDefault = m.addresses[i].ID == addressID
+		}
+	}
+
+	return nil
+}
+
+func (m *mockAddressStore) WithTx(tx *sql.Tx) types.AddressStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
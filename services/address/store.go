@@ -0,0 +1,141 @@
+package address
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.AddressStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	rows, err := s.db.Query("SELECT * FROM addresses WHERE userId = ? ORDER BY isDefault DESC, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make([]types.Address, 0)
+	for rows.Next() {
+		a, err := scanRowsIntoAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, *a)
+	}
+
+	return addresses, rows.Err()
+}
+
+func (s *Store) GetAddressByID(userID int, addressID int) (*types.Address, error) {
+	rows, err := s.db.Query("SELECT * FROM addresses WHERE id = ? AND userId = ?", addressID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("address %d %w", addressID, types.ErrNotFound)
+	}
+
+	return scanRowsIntoAddress(rows)
+}
+
+func (s *Store) GetDefaultAddress(userID int) (*types.Address, error) {
+	rows, err := s.db.Query("SELECT * FROM addresses WHERE userId = ? AND isDefault = TRUE LIMIT 1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("default address %w", types.ErrNotFound)
+	}
+
+	return scanRowsIntoAddress(rows)
+}
+
+func (s *Store) CreateAddress(a types.Address) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO addresses (userId, fullName, line1, line2, city, state, postalCode, country, phone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		a.UserID, a.FullName, a.Line1, a.Line2, a.City, a.State, a.PostalCode, a.Country, a.Phone,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateAddress(a types.Address) error {
+	_, err := s.db.Exec(
+		"UPDATE addresses SET fullName = ?, line1 = ?, line2 = ?, city = ?, state = ?, postalCode = ?, country = ?, phone = ? WHERE id = ? AND userId = ?",
+		a.FullName, a.Line1, a.Line2, a.City, a.State, a.PostalCode, a.Country, a.Phone, a.ID, a.UserID,
+	)
+	return err
+}
+
+func (s *Store) DeleteAddress(userID int, addressID int) error {
+	res, err := s.db.Exec("DELETE FROM addresses WHERE id = ? AND userId = ?", addressID, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("address %d %w", addressID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) SetDefaultAddress(userID int, addressID int) error {
+	_, err := s.db.Exec("UPDATE addresses SET isDefault = (id = ?) WHERE userId = ?", addressID, userID)
+	return err
+}
+
+func scanRowsIntoAddress(rows *sql.Rows) (*types.Address, error) {
+	a := new(types.Address)
+
+	err := rows.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.FullName,
+		&a.Line1,
+		&a.Line2,
+		&a.City,
+		&a.State,
+		&a.PostalCode,
+		&a.Country,
+		&a.Phone,
+		&a.IsDefault,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
@@ -20,6 +20,9 @@ type contextKey string
 // Declara uma constante 'UserKey', que será usada como chave para armazenar o 'userID' no contexto.
 const UserKey contextKey = "userID"
 
+// Declara uma constante 'RoleKey', usada como chave para armazenar o papel (role) do usuário no contexto.
+const RoleKey contextKey = "role"
+
 // Função 'WithJWTAuth' que adiciona autenticação JWT à rota.
 // Ela recebe uma função de manipulação de requisição (handlerFunc)
 // e um repositório de usuários (store).
@@ -71,6 +74,9 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 		ctx := r.Context()
 		// Usa a chave 'UserKey' para associar o 'userID' ao contexto. Esse valor estará disponível em qualquer parte do código onde o contexto for acessado.
 		ctx = context.WithValue(ctx, UserKey, u.ID)
+		// O papel vem do banco, e não do token, para que promoções e rebaixamentos valham na hora
+		// e tokens emitidos antes de existir o papel continuem funcionando.
+		ctx = context.WithValue(ctx, RoleKey, u.Role)
 		// Atualiza a requisição (r) com o novo contexto que contém o 'userID'.
 		r = r.WithContext(ctx)
 
@@ -79,14 +85,33 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store types.UserStore) http.Handl
 	}
 }
 
-// Função para criar um token JWT para um usuário com base no 'userID'. Recebe o 'secret' para assinar o token e o 'userID' do usuário.
-func CreateJWT(secret []byte, userID int) (string, error) {
+// Função 'RequireRole' restringe a rota aos papéis informados. Ela deve ser usada dentro de 'WithJWTAuth',
+// que é quem coloca o papel do usuário no contexto.
+func RequireRole(handlerFunc http.HandlerFunc, roles ...types.Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := GetRoleFromContext(r.Context())
+
+		for _, allowed := range roles {
+			if role == allowed {
+				handlerFunc(w, r)
+				return
+			}
+		}
+
+		log.Printf("role %q is not allowed to access %s", role, r.URL.Path)
+		permissionDenied(w)
+	}
+}
+
+// Função para criar um token JWT para um usuário com base no 'userID' e no papel dele. Recebe o 'secret' para assinar o token.
+func CreateJWT(secret []byte, userID int, role types.Role) (string, error) {
 	// Define a expiração do token com base no valor configurado (em segundos) no arquivo de configurações.
 	expiration := time.Second * time.Duration(configs.Envs.JWTExpirationInSeconds)
 
 	// Cria um novo JWT com o método de assinatura HS256 e as claims do token, incluindo o 'userID' e o tempo de expiração.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userID":    strconv.Itoa(int(userID)),         // Converte o 'userID' de int para string para armazenar no JWT.
+		"role":      string(role),                      // Papel do usuário no login; 'WithJWTAuth' usa o papel atual do banco.
 		"expiresAt": time.Now().Add(expiration).Unix(), // Calcula o tempo de expiração do token (em segundos desde a época Unix).
 	})
 
@@ -129,3 +154,13 @@ func GetUserIDFromContext(ctx context.Context) int {
 
 	return userID
 }
+
+// Função para obter o papel do usuário armazenado no contexto da requisição. Retorna "" caso não seja encontrado.
+func GetRoleFromContext(ctx context.Context) types.Role {
+	role, ok := ctx.Value(RoleKey).(types.Role)
+	if !ok {
+		return ""
+	}
+
+	return role
+}
@@ -1,7 +1,15 @@
 package auth
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/types"
 )
 
 // TestCreateJWT é uma função de teste para verificar a funcionalidade da criação de JWT.
@@ -11,7 +19,7 @@ func TestCreateJWT(t *testing.T) {
 	secret := []byte("secret")
 
 	// Chama a função CreateJWT (supostamente definida no mesmo pacote) com o segredo e um identificador de usuário.
-	token, err := CreateJWT(secret, 1)
+	token, err := CreateJWT(secret, 1, types.RoleCustomer)
 	if err != nil {
 		t.Errorf("error creating JWT: %v", err)
 	}
@@ -20,3 +28,103 @@ func TestCreateJWT(t *testing.T) {
 		t.Error("expected token to be not empty")
 	}
 }
+
+// TestRequireRole verifica que a rota só é executada para os papéis permitidos.
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, types.RoleAdmin, types.RoleStaff)
+
+	tests := []struct {
+		role types.Role
+		code int
+	}{
+		{types.RoleAdmin, http.StatusOK},
+		{types.RoleStaff, http.StatusOK},
+		{types.RoleCustomer, http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), RoleKey, tt.role))
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected status code %d for role %q, got %d", tt.code, tt.role, rr.Code)
+		}
+	}
+}
+
+// TestWithJWTAuth verifica que o papel colocado no contexto é o atual do banco, mesmo que o token
+// tenha outro papel ou nenhum.
+func TestWithJWTAuth(t *testing.T) {
+	store := &mockUserStore{user: types.User{ID: 1, Role: types.RoleStaff}}
+
+	var role types.Role
+	handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		role = GetRoleFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}, store)
+
+	// token emitido antes de existir o papel
+	withoutRole, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": "1"}).SignedString([]byte(configs.Envs.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// token emitido antes de o usuário ser promovido
+	customer, err := CreateJWT([]byte(configs.Envs.JWTSecret), 1, types.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{withoutRole, customer} {
+		role = ""
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", token)
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if role != types.RoleStaff {
+			t.Errorf("expected the role %q from the database, got %q", types.RoleStaff, role)
+		}
+	}
+}
+
+type mockUserStore struct {
+	user types.User
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, fmt.Errorf("user %s %w", email, types.ErrNotFound)
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	if id != m.user.ID {
+		return nil, fmt.Errorf("user %d %w", id, types.ErrNotFound)
+	}
+
+	u := m.user
+	return &u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{&m.user}, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(id int, role types.Role) error {
+	return nil
+}
@@ -1,8 +1,13 @@
 package cart
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
@@ -12,27 +17,258 @@ import (
 )
 
 type Handler struct {
-	store      types.ProductStore
-	orderStore types.OrderStore
-	userStore  types.UserStore
+	store          types.ProductStore
+	variantStore   types.VariantStore
+	orderStore     types.OrderStore
+	cartStore      types.CartStore
+	addressStore   types.AddressStore
+	rateStore      types.ExchangeRateStore
+	couponStore    types.CouponStore
+	taxCalculator  types.TaxCalculator
+	shippingStore  types.ShippingMethodStore
+	paymentStore   types.PaymentStore
+	paymentGateway types.PaymentGateway
+	reserver       types.StockReserver
+	reservations   types.ReservationStore
+	ledger         types.StockLedger
+	warehouseStore types.WarehouseStore
+	allocator      types.AllocationStrategy
+	notifier       types.StockNotifier
+	userStore      types.UserStore
+	transactor     types.Transactor
 }
 
-func NewHandler(
-	store types.ProductStore,
-	orderStore types.OrderStore,
-	userStore types.UserStore,
-) *Handler {
+// Deps are the stores and services the cart works with. They are named
+// rather than passed in order, so the wiring reads as what goes where.
+type Deps struct {
+	ProductStore   types.ProductStore
+	VariantStore   types.VariantStore
+	OrderStore     types.OrderStore
+	CartStore      types.CartStore
+	AddressStore   types.AddressStore
+	RateStore      types.ExchangeRateStore
+	CouponStore    types.CouponStore
+	TaxCalculator  types.TaxCalculator
+	ShippingStore  types.ShippingMethodStore
+	PaymentStore   types.PaymentStore
+	PaymentGateway types.PaymentGateway
+	Reserver       types.StockReserver
+	Reservations   types.ReservationStore
+	Ledger         types.StockLedger
+	WarehouseStore types.WarehouseStore
+	Allocator      types.AllocationStrategy
+	Notifier       types.StockNotifier
+	UserStore      types.UserStore
+	Transactor     types.Transactor
+}
+
+func NewHandler(deps Deps) *Handler {
 	return &Handler{
-		store:      store,
-		orderStore: orderStore,
-		userStore:  userStore,
+		store:          deps.ProductStore,
+		variantStore:   deps.VariantStore,
+		orderStore:     deps.OrderStore,
+		cartStore:      deps.CartStore,
+		addressStore:   deps.AddressStore,
+		rateStore:      deps.RateStore,
+		couponStore:    deps.CouponStore,
+		taxCalculator:  deps.TaxCalculator,
+		shippingStore:  deps.ShippingStore,
+		paymentStore:   deps.PaymentStore,
+		paymentGateway: deps.PaymentGateway,
+		reserver:       deps.Reserver,
+		reservations:   deps.Reservations,
+		ledger:         deps.Ledger,
+		warehouseStore: deps.WarehouseStore,
+		allocator:      deps.Allocator,
+		notifier:       deps.Notifier,
+		userStore:      deps.UserStore,
+		transactor:     deps.Transactor,
 	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/cart", auth.WithJWTAuth(h.handleGetCart, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/cart/items", auth.WithJWTAuth(h.handleAddCartItem, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/cart/items/{productID}", auth.WithJWTAuth(h.handleUpdateCartItem, h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/cart/items/{productID}", auth.WithJWTAuth(h.handleRemoveCartItem, h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/cart/shipping-quotes", auth.WithJWTAuth(h.handleGetShippingQuotes, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/cart/reservation", auth.WithJWTAuth(h.handleReserveCart, h.userStore)).Methods(http.MethodPost)
 	router.HandleFunc("/cart/checkout", auth.WithJWTAuth(h.handleCheckout, h.userStore)).Methods(http.MethodPost)
 }
 
+func (h *Handler) handleGetCart(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	h.writeCart(w, r, http.StatusOK, userID)
+}
+
+func (h *Handler) handleAddCartItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var payload types.AddCartItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if _, err := h.store.GetProductByID(payload.ProductID); err != nil {
+		if errors.Is(err, types.ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product %d not found", payload.ProductID))
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	variants, err := h.variantStore.GetVariantsByProductIDs([]int{payload.ProductID})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if payload.VariantID == 0 && len(variants) > 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("product %d is sold in variants, please pick one", payload.ProductID))
+		return
+	}
+
+	if payload.VariantID != 0 && !slices.ContainsFunc(variants, func(v types.ProductVariant) bool { return v.ID == payload.VariantID }) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("variant %d of product %d not found", payload.VariantID, payload.ProductID))
+		return
+	}
+
+	if err := h.cartStore.AddCartItem(userID, payload.ProductID, payload.VariantID, payload.Quantity); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusCreated, userID)
+}
+
+func (h *Handler) handleUpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	productID, variantID, err := getCartLineFromRequest(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateCartItemPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	items, err := h.cartStore.GetCartItems(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !cartHasItem(items, productID, variantID) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("product %d is not in the cart", productID))
+		return
+	}
+
+	if err := h.cartStore.UpdateCartItem(userID, productID, variantID, payload.Quantity); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusOK, userID)
+}
+
+func (h *Handler) handleRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	productID, variantID, err := getCartLineFromRequest(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.cartStore.RemoveCartItem(userID, productID, variantID); err != nil {
+		if errors.Is(err, types.ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.writeCart(w, r, http.StatusOK, userID)
+}
+
+// handleReserveCart holds the units of the stored cart for the user while
+// they check out, so other customers can't buy them in the meantime.
+// Reserving again renews the reservation with the cart as it is now.
+func (h *Handler) handleReserveCart(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	var expiresAt time.Time
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		stored, err := h.cartStore.WithTx(tx).GetCartItems(userID)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		if len(stored) == 0 {
+			return fmt.Errorf("cart is empty")
+		}
+
+		cartItems := storedCartToCheckoutItems(stored)
+		productIDs := getStoredCartProductIDs(stored)
+
+		products, err := h.store.WithTx(tx).GetProductsByID(productIDs)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		variants, err := h.variantStore.WithTx(tx).GetVariantsByProductIDs(productIDs)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		reserver := h.reserver.WithTx(tx)
+
+		reserved, err := reserver.Reserved(productIDs, userID)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		if err := checkIfCartIsInStock(cartItems, newCatalog(products, variants), reserved); err != nil {
+			return err
+		}
+
+		expiresAt, err = reserver.ReserveCart(userID, cartItems)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"expires_at": expiresAt})
+}
+
 func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserIDFromContext(r.Context())
 
@@ -48,27 +284,214 @@ func (h *Handler) handleCheckout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	productIds, err := getCartItemsIDs(cart.Items)
+	currency, err := utils.ParseCurrency(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := h.createOrder(cart, userID, currency)
+	if err != nil {
+		writeCheckoutError(w, err)
+		return
+	}
+
+	// the order is saved before the gateway is called, so no transaction is
+	// held open while waiting on it
+	if err := h.pay(r.Context(), &order, userID, len(cart.Items) == 0); err != nil {
+		switch {
+		case errors.Is(err, types.ErrPaymentDeclined):
+			utils.WriteError(w, http.StatusPaymentRequired, err)
+		default:
+			utils.WriteError(w, http.StatusBadGateway, err)
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"subtotal":      order.Subtotal,
+		"discount":      order.Discount,
+		"tax":           order.Tax,
+		"shipping_cost": order.ShippingCost,
+		"total_price":   order.Total,
+		"free_shipping": order.FreeShipping,
+		"order_id":      order.ID,
+		"status":        order.Status,
+	})
+}
+
+// writeCart responds with the user's stored cart, priced with the current
+// catalog data in the currency asked for in the query string.
+func (h *Handler) writeCart(w http.ResponseWriter, r *http.Request, status int, userID int) {
+	currency, err := utils.ParseCurrency(r)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// get products
-	products, err := h.store.GetProductsByID(productIds)
+	items, err := h.cartStore.GetCartItems(userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	orderID, totalPrice, err := h.createOrder(products, cart.Items, userID)
+	cat, err := h.storedCartCatalog(items, currency)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, buildCartView(items, cat))
+}
+
+// handleGetShippingQuotes lists the shipping methods that ship to an address
+// of the user, with what shipping the stored cart costs with each of them.
+// The address is the one in the addressID query parameter, or the user's
+// default address.
+func (h *Handler) handleGetShippingQuotes(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	currency, err := utils.ParseCurrency(r)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
-		"total_price": totalPrice,
-		"order_id":    orderID,
-	})
+	addressID := 0
+	if str := r.URL.Query().Get("addressID"); str != "" {
+		addressID, err = strconv.Atoi(str)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid address ID"))
+			return
+		}
+	}
+
+	address, err := getShippingAddress(h.addressStore, userID, addressID)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := h.cartStore.GetCartItems(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(items) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("cart is empty"))
+		return
+	}
+
+	cat, err := h.storedCartCatalog(items, currency)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+
+	methods, err := h.shippingStore.GetShippingMethods()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	cartItems := storedCartToCheckoutItems(items)
+	quotes, err := quoteShipping(methods, address.PostalAddress, calculateTotalPrice(cartItems, cat), cartWeight(cartItems, cat), cat.rates)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, quotes)
+}
+
+// errNoCatalog wraps the errors the cart handlers get from the stores, as
+// opposed to a cart that can't be priced or bought.
+type errNoCatalog struct{ error }
+
+func (e errNoCatalog) Unwrap() error { return e.error }
+
+// storedCartCatalog loads the products and variants of the stored cart items
+// and prices them in currency.
+func (h *Handler) storedCartCatalog(items []types.CartItem, currency string) (catalog, error) {
+	rates, err := h.rateStore.GetExchangeRates()
+	if err != nil {
+		return catalog{}, errNoCatalog{err}
+	}
+
+	products := []types.Product{}
+	variants := []types.ProductVariant{}
+	if len(items) > 0 {
+		productIDs := getStoredCartProductIDs(items)
+
+		products, err = h.store.GetProductsByID(productIDs)
+		if err != nil {
+			return catalog{}, errNoCatalog{err}
+		}
+
+		variants, err = h.variantStore.GetVariantsByProductIDs(productIDs)
+		if err != nil {
+			return catalog{}, errNoCatalog{err}
+		}
+	}
+
+	return newCatalog(products, variants).priceIn(currency, types.NewExchangeRates(rates))
+}
+
+func writeCatalogError(w http.ResponseWriter, err error) {
+	var noCatalog errNoCatalog
+	if errors.As(err, &noCatalog) {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteError(w, http.StatusBadRequest, err)
+}
+
+// writeCheckoutError also tells apart what checkout couldn't find, such as
+// the address or coupon picked, and stock or coupon uses that ran out while
+// the order was being placed.
+func writeCheckoutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		writeCatalogError(w, err)
+	}
+}
+
+func getProductIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["productID"]
+	if !ok {
+		return 0, fmt.Errorf("missing product ID")
+	}
+
+	productID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid product ID")
+	}
+
+	return productID, nil
+}
+
+// getCartLineFromRequest reads which cart line a request refers to: the
+// product in the path and, for products sold in variants, the variantID query
+// parameter.
+func getCartLineFromRequest(r *http.Request) (int, int, error) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	variantID := 0
+	if str := r.URL.Query().Get("variantID"); str != "" {
+		variantID, err = strconv.Atoi(str)
+		if err != nil || variantID <= 0 {
+			return 0, 0, fmt.Errorf("invalid variant ID")
+		}
+	}
+
+	return productID, variantID, nil
 }
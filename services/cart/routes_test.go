@@ -2,27 +2,79 @@ package cart
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sikozonpc/ecom/types"
 )
 
 var mockProducts = []types.Product{
-	{ID: 1, Name: "product 1", Price: 10, Quantity: 100},
-	{ID: 2, Name: "product 2", Price: 20, Quantity: 200},
-	{ID: 3, Name: "product 3", Price: 30, Quantity: 300},
-	{ID: 4, Name: "empty stock", Price: 30, Quantity: 0},
-	{ID: 5, Name: "almost stock", Price: 30, Quantity: 1},
+	{ID: 1, Name: "product 1", Price: dollars(10), Weight: 500, Quantity: 100},
+	{ID: 2, Name: "product 2", Price: dollars(20), Weight: 1200, Quantity: 200},
+	{ID: 3, Name: "product 3", Price: dollars(30), TaxClass: "reduced", Quantity: 300},
+	{ID: 4, Name: "empty stock", Price: dollars(30), Quantity: 0},
+	{ID: 5, Name: "almost stock", Price: dollars(30), Quantity: 1},
+	{ID: 6, Name: "deleted", Price: dollars(30), Quantity: 100, DeletedAt: &deletedAt},
+	{ID: 7, Name: "t-shirt", Price: dollars(20)},
+}
+
+// the t-shirt is only sold in variants
+var mediumShirtPrice = dollars(25)
+
+var mockVariants = []types.ProductVariant{
+	{ID: 71, ProductID: 7, SKU: "TS-M", Price: &mediumShirtPrice, Quantity: 2, Options: map[string]string{"size": "M"}},
+	{ID: 72, ProductID: 7, SKU: "TS-L", Quantity: 0, Options: map[string]string{"size": "L"}},
+}
+
+var deletedAt = time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)
+
+// dollars builds a price in whole dollars.
+func dollars(n int64) types.Money {
+	return types.NewMoney(n*100, types.DefaultCurrency)
+}
+
+// mockDeps is a cart whose stores and services are all mocks; tests swap
+// in the ones they look at.
+func mockDeps() Deps {
+	return Deps{
+		ProductStore:   &mockProductStore{},
+		VariantStore:   newMockVariantStore(),
+		OrderStore:     &mockOrderStore{},
+		CartStore:      newMockCartStore(),
+		AddressStore:   &mockAddressStore{},
+		RateStore:      &mockExchangeRateStore{},
+		CouponStore:    &mockCouponStore{},
+		TaxCalculator:  &mockTaxCalculator{},
+		ShippingStore:  &mockShippingStore{},
+		PaymentStore:   &mockPaymentStore{},
+		PaymentGateway: &mockPaymentGateway{},
+		Reserver:       &mockReserver{},
+		Reservations:   &mockReservationStore{},
+		Ledger:         &mockLedger{},
+		WarehouseStore: &mockWarehouseStore{},
+		Allocator:      &mockAllocator{},
+		Notifier:       &mockNotifier{},
+		Transactor:     &mockTransactor{},
+	}
 }
 
 func TestCartServiceHandler(t *testing.T) {
 	productStore := &mockProductStore{}
 	orderStore := &mockOrderStore{}
-	handler := NewHandler(productStore, orderStore, nil)
+	deps := mockDeps()
+	deps.ProductStore = productStore
+	deps.OrderStore = orderStore
+	handler := NewHandler(deps)
 
 	t.Run("should fail to checkout if the cart items do not exist", func(t *testing.T) {
 		payload := types.CartCheckoutPayload{
@@ -140,6 +192,35 @@ func TestCartServiceHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("should fail to checkout a deleted product", func(t *testing.T) {
+		payload := types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 6, Quantity: 1},
+			},
+		}
+
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
 	t.Run("should checkout and calculate the price correctly", func(t *testing.T) {
 		payload := types.CartCheckoutPayload{
 			Items: []types.CartCheckoutItem{
@@ -170,45 +251,1959 @@ func TestCartServiceHandler(t *testing.T) {
 			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 		}
 
-		var response map[string]interface{}
+		var response struct {
+			TotalPrice types.Money `json:"total_price"`
+		}
 		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
 			t.Fatal(err)
 		}
 
-		if response["total_price"] != 530.0 {
-			t.Errorf("expected total price to be 530, got %f", response["total_price"])
+		if response.TotalPrice != dollars(530) {
+			t.Errorf("expected total price to be 530, got %v", response.TotalPrice)
 		}
 	})
-}
 
-type mockProductStore struct{}
+	t.Run("should not create the order if the stock runs out during checkout", func(t *testing.T) {
+		ledger, transactor := &mockLedger{fail: true}, &mockTransactor{}
+		deps := mockDeps()
+		deps.Ledger = ledger
+		deps.Transactor = transactor
+		handler := NewHandler(deps)
 
-func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
-	return &types.Product{}, nil
-}
+		payload := types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 1, Quantity: 10},
+			},
+		}
 
-func (m *mockProductStore) GetProducts() ([]*types.Product, error) {
-	return []*types.Product{}, nil
-}
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) error {
-	return nil
-}
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
 
-func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
-	return mockProducts, nil
-}
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
 
-func (m *mockProductStore) UpdateProduct(product types.Product) error {
-	return nil
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		// the order and its items are written before its stock is taken, so
+		// it is the rollback that keeps them from being created
+		if !transactor.rolledBack || len(ledger.movements) != 0 {
+			t.Errorf("expected the order to be rolled back, got %+v", ledger.movements)
+		}
+	})
+
+	t.Run("should fail to checkout to an unknown address", func(t *testing.T) {
+		payload := types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 1, Quantity: 1},
+			},
+			AddressID: 99,
+		}
+
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should report a store failing during checkout as a server error", func(t *testing.T) {
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.TaxCalculator = &mockTaxCalculator{err: fmt.Errorf("tax rates unavailable")}
+		handler := NewHandler(deps)
+
+		marshalled, err := json.Marshal(types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 1, Quantity: 1},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+	})
+
+	t.Run("should snapshot the shipping address onto the order", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.OrderStore = orderStore
+		handler := NewHandler(deps)
+
+		payload := types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 1, Quantity: 1},
+			},
+			AddressID: 1,
+		}
+
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if orderStore.lastOrder.ShippingAddress != mockAddress.PostalAddress {
+			t.Errorf("expected the order to ship to %+v, got %+v", mockAddress.PostalAddress, orderStore.lastOrder.ShippingAddress)
+		}
+
+		if orderStore.lastOrder.Address != "Ada Lovelace, 12 St James's Square, London, SW1Y 4JH, GB" {
+			t.Errorf("unexpected formatted address %q", orderStore.lastOrder.Address)
+		}
+
+		// the second entry is the payment moving the order to paid
+		if len(orderStore.history) != 2 || orderStore.history[0].ToStatus != types.OrderStatusPending {
+			t.Errorf("expected the new order to start its status history, got %+v", orderStore.history)
+		}
+	})
+
+	t.Run("should charge in the requested currency and snapshot the rates", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		rates := &mockExchangeRateStore{rates: types.ExchangeRates{"EUR": 92_000_000}}
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.OrderStore = orderStore
+		deps.RateStore = rates
+		handler := NewHandler(deps)
+
+		checkout := func(currency string) *httptest.ResponseRecorder {
+			marshalled, err := json.Marshal(types.CartCheckoutPayload{Items: []types.CartCheckoutItem{{ProductID: 1, Quantity: 2}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/cart/checkout?currency="+currency, bytes.NewBuffer(marshalled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := checkout("EUR")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var response struct {
+			TotalPrice types.Money `json:"total_price"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		// two items of USD 10.00 at 0.92 EUR per USD
+		want := types.NewMoney(1840, "EUR")
+		if response.TotalPrice != want || orderStore.lastOrder.Total != want {
+			t.Errorf("expected a total of %s, got %s and %s", want, response.TotalPrice, orderStore.lastOrder.Total)
+		}
+
+		if orderStore.lastOrder.ExchangeRate != 92_000_000 {
+			t.Errorf("expected the order to keep the rate 0.92, got %s", orderStore.lastOrder.ExchangeRate)
+		}
+
+		item := orderStore.items[len(orderStore.items)-1]
+		if item.Price != types.NewMoney(920, "EUR") || item.ListPrice != dollars(10) || item.ExchangeRate != 92_000_000 {
+			t.Errorf("expected the item to keep its list price and rate, got %+v", item)
+		}
+
+		if rr := checkout("GBP"); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for a currency without a rate, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
 }
 
-type mockOrderStore struct{}
+// TestCalculateTotalPriceIsExact prices random carts of cent amounts that
+// floats can't represent, like 0.10, and expects the exact sum.
+func TestCalculateTotalPriceIsExact(t *testing.T) {
+	exact := func(cents []uint16, quantities []uint8) bool {
+		products := make([]types.Product, len(cents))
+		items := make([]types.CartCheckoutItem, len(cents))
+		var want int64
 
-func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
-	return 0, nil
+		for i, c := range cents {
+			quantity := 1
+			if i < len(quantities) {
+				quantity = int(quantities[i]) + 1
+			}
+
+			products[i] = types.Product{ID: i + 1, Price: types.NewMoney(int64(c), types.DefaultCurrency), Quantity: quantity}
+			items[i] = types.CartCheckoutItem{ProductID: i + 1, Quantity: quantity}
+			want += int64(c) * int64(quantity)
+		}
+
+		return calculateTotalPrice(items, newCatalog(products, nil)).Amount == want
+	}
+
+	if err := quick.Check(exact, nil); err != nil {
+		t.Error(err)
+	}
+
+	// ten items of 0.10 add up to exactly 1.00
+	items := []types.CartCheckoutItem{{ProductID: 1, Quantity: 10}}
+	products := []types.Product{{ID: 1, Price: types.NewMoney(10, types.DefaultCurrency), Quantity: 10}}
+	if total := calculateTotalPrice(items, newCatalog(products, nil)); total != dollars(1) {
+		t.Errorf("expected a total of 1.00, got %v", total)
+	}
 }
 
-func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) error {
-	return nil
-}
\ No newline at end of file
+func TestStoredCartHandlers(t *testing.T) {
+	productStore := &mockProductStore{}
+
+	newRouter := func(handler *Handler) *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/cart", handler.handleGetCart).Methods(http.MethodGet)
+		router.HandleFunc("/cart/items", handler.handleAddCartItem).Methods(http.MethodPost)
+		router.HandleFunc("/cart/items/{productID}", handler.handleUpdateCartItem).Methods(http.MethodPatch)
+		router.HandleFunc("/cart/items/{productID}", handler.handleRemoveCartItem).Methods(http.MethodDelete)
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		return router
+	}
+
+	t.Run("should fail to add a product that does not exist", func(t *testing.T) {
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		handler := NewHandler(deps)
+
+		marshalled, err := json.Marshal(types.AddCartItemPayload{ProductID: 99, Quantity: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/items", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should add items and return the priced cart with stock warnings", func(t *testing.T) {
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		handler := NewHandler(deps)
+		router := newRouter(handler)
+
+		for _, payload := range []types.AddCartItemPayload{
+			{ProductID: 1, Quantity: 2},
+			{ProductID: 5, Quantity: 3},
+			{ProductID: 1, Quantity: 1},
+		} {
+			marshalled, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/cart/items", bytes.NewBuffer(marshalled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/cart", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var cart types.CartView
+		if err := json.NewDecoder(rr.Body).Decode(&cart); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cart.Items) != 2 {
+			t.Fatalf("expected 2 cart items, got %d", len(cart.Items))
+		}
+
+		if cart.Items[0].Quantity != 3 || cart.Items[0].Warning != "" {
+			t.Errorf("expected 3 units of product 1 without warning, got %+v", cart.Items[0])
+		}
+
+		if cart.Items[1].Warning == "" {
+			t.Errorf("expected a stock warning for product 5, got %+v", cart.Items[1])
+		}
+
+		if cart.Total != dollars(120) {
+			t.Errorf("expected total to be 120, got %v", cart.Total)
+		}
+	})
+
+	t.Run("should fail to update a product that is not in the cart", func(t *testing.T) {
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		handler := NewHandler(deps)
+
+		marshalled, err := json.Marshal(types.UpdateCartItemPayload{Quantity: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPatch, "/cart/items/1", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should remove a product from the cart", func(t *testing.T) {
+		cartStore := newMockCartStore()
+		cartStore.AddCartItem(0, 1, 0, 2)
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.CartStore = cartStore
+		handler := NewHandler(deps)
+
+		req, err := http.NewRequest(http.MethodDelete, "/cart/items/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(cartStore.items) != 0 {
+			t.Errorf("expected the cart to be empty, got %d items", len(cartStore.items))
+		}
+	})
+
+	t.Run("should checkout the stored cart and empty it", func(t *testing.T) {
+		cartStore := newMockCartStore()
+		cartStore.AddCartItem(0, 1, 0, 2)
+		cartStore.AddCartItem(0, 2, 0, 1)
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.CartStore = cartStore
+		handler := NewHandler(deps)
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBufferString("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var response struct {
+			TotalPrice types.Money `json:"total_price"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.TotalPrice != dollars(40) {
+			t.Errorf("expected total price to be 40, got %v", response.TotalPrice)
+		}
+
+		if len(cartStore.items) != 0 {
+			t.Errorf("expected the cart to be empty, got %d items", len(cartStore.items))
+		}
+	})
+
+	t.Run("should fail to checkout an empty stored cart", func(t *testing.T) {
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		handler := NewHandler(deps)
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBufferString("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+func TestCouponCheckout(t *testing.T) {
+	maxUses := 1
+	fiveDollars := dollars(5)
+	coupons := []types.Coupon{
+		{ID: 1, Code: "TENOFF", Type: types.CouponPercentage, PercentOff: 10},
+		{ID: 2, Code: "FIVE", Type: types.CouponFixedAmount, AmountOff: &fiveDollars, ProductIDs: []int{1, 2}},
+		{ID: 3, Code: "SHIPFREE", Type: types.CouponFreeShipping},
+		{ID: 4, Code: "ONCE", Type: types.CouponPercentage, PercentOff: 50, MaxUses: &maxUses},
+		{ID: 5, Code: "SHIRTS", Type: types.CouponPercentage, PercentOff: 10, ProductIDs: []int{7}},
+	}
+
+	checkout := func(t *testing.T, handler *Handler, code string) *httptest.ResponseRecorder {
+		payload := types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{
+				{ProductID: 1, Quantity: 1},
+				{ProductID: 2, Quantity: 2},
+				{ProductID: 3, Quantity: 1},
+			},
+			CouponCode: code,
+		}
+
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should take a percentage off every line", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		couponStore := newMockCouponStore(coupons...)
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CouponStore = couponStore
+		handler := NewHandler(deps)
+
+		rr := checkout(t, handler, "tenoff")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		order := orderStore.lastOrder
+		if order.Subtotal != dollars(80) || order.Discount != dollars(8) || order.Total != dollars(72) {
+			t.Errorf("expected 80 - 8 = 72, got %s - %s = %s", order.Subtotal, order.Discount, order.Total)
+		}
+
+		if order.CouponID == nil || *order.CouponID != 1 || order.CouponCode != "TENOFF" {
+			t.Errorf("expected the order to keep the coupon, got %v %q", order.CouponID, order.CouponCode)
+		}
+
+		want := []types.Money{dollars(1), dollars(4), dollars(3)}
+		for i, item := range orderStore.items {
+			if item.Discount != want[i] {
+				t.Errorf("expected item %d to take %s off, got %s", item.ProductID, want[i], item.Discount)
+			}
+		}
+
+		if len(couponStore.redemptions) != 1 {
+			t.Errorf("expected the coupon to be redeemed once, got %d", len(couponStore.redemptions))
+		}
+	})
+
+	t.Run("should charge tax on the discounted lines", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		taxes := &mockTaxCalculator{rates: map[string]types.Rate{types.DefaultTaxClass: 20_000_000, "reduced": 5_000_000}}
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CouponStore = newMockCouponStore(coupons...)
+		deps.TaxCalculator = taxes
+		handler := NewHandler(deps)
+
+		rr := checkout(t, handler, "TENOFF")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var response struct {
+			Subtotal   types.Money `json:"subtotal"`
+			Discount   types.Money `json:"discount"`
+			Tax        types.Money `json:"tax"`
+			TotalPrice types.Money `json:"total_price"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		// 20% of 9.00 and 36.00, and 5% of 27.00 for the reduced product 3
+		want := []types.Money{types.NewMoney(180, "USD"), types.NewMoney(720, "USD"), types.NewMoney(135, "USD")}
+		for i, item := range orderStore.items {
+			if item.Tax != want[i] {
+				t.Errorf("expected item %d to be taxed %s, got %s", item.ProductID, want[i], item.Tax)
+			}
+		}
+
+		if response.Subtotal != dollars(80) || response.Discount != dollars(8) || response.Tax != types.NewMoney(1035, "USD") || response.TotalPrice != types.NewMoney(8235, "USD") {
+			t.Errorf("expected 80 - 8 + 10.35 = 82.35, got %+v", response)
+		}
+
+		if orderStore.lastOrder.Tax != response.Tax || orderStore.lastOrder.Total != response.TotalPrice {
+			t.Errorf("expected the order to keep the tax and the grand total, got %+v", orderStore.lastOrder)
+		}
+
+		if taxes.to != mockAddress.PostalAddress {
+			t.Errorf("expected the tax of the shipping address, got %+v", taxes.to)
+		}
+	})
+
+	t.Run("should split a fixed amount between the eligible lines", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CouponStore = newMockCouponStore(coupons...)
+		handler := NewHandler(deps)
+
+		rr := checkout(t, handler, "FIVE")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		// 5.00 split over 10.00 and 40.00
+		want := []types.Money{dollars(1), dollars(4), dollars(0)}
+		for i, item := range orderStore.items {
+			if item.Discount != want[i] {
+				t.Errorf("expected item %d to take %s off, got %s", item.ProductID, want[i], item.Discount)
+			}
+		}
+
+		if orderStore.lastOrder.Total != dollars(75) {
+			t.Errorf("expected a total of %s, got %s", dollars(75), orderStore.lastOrder.Total)
+		}
+	})
+
+	t.Run("should flag free shipping", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CouponStore = newMockCouponStore(coupons...)
+		handler := NewHandler(deps)
+
+		rr := checkout(t, handler, "SHIPFREE")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if !orderStore.lastOrder.FreeShipping || orderStore.lastOrder.Total != dollars(80) {
+			t.Errorf("expected free shipping and no discount, got %+v", orderStore.lastOrder)
+		}
+	})
+
+	t.Run("should refuse coupons that can't be used", func(t *testing.T) {
+		couponStore := newMockCouponStore(coupons...)
+		deps := mockDeps()
+		deps.CouponStore = couponStore
+		handler := NewHandler(deps)
+
+		if rr := checkout(t, handler, "ONCE"); rr.Code != http.StatusOK {
+			t.Fatalf("expected the first use to succeed, got %d: %s", rr.Code, rr.Body)
+		}
+
+		for code, want := range map[string]int{"ONCE": http.StatusBadRequest, "NOPE": http.StatusNotFound, "SHIRTS": http.StatusBadRequest} {
+			if rr := checkout(t, handler, code); rr.Code != want {
+				t.Errorf("expected status code %d for %s, got %d", want, code, rr.Code)
+			}
+		}
+
+		if len(couponStore.redemptions) != 1 {
+			t.Errorf("expected a single redemption, got %d", len(couponStore.redemptions))
+		}
+	})
+}
+
+func TestShipping(t *testing.T) {
+	perKg, freeOver := dollars(2), dollars(50)
+	shippingStore := &mockShippingStore{methods: []types.ShippingMethod{
+		{ID: 1, Code: "standard", Name: "Standard", Type: types.ShippingFlatRate, Cost: dollars(5), Countries: []string{"GB"}, Active: true},
+		{ID: 2, Code: "express", Name: "Express", Type: types.ShippingWeightBased, Cost: dollars(10), CostPerKg: &perKg, Active: true},
+		{ID: 3, Code: "saver", Name: "Saver", Type: types.ShippingFreeOverThreshold, Cost: dollars(8), FreeOver: &freeOver, Countries: []string{"US"}, Active: true},
+		{ID: 4, Code: "retired", Name: "Retired", Type: types.ShippingFlatRate, Cost: dollars(1)},
+	}}
+
+	// 2 x 500 g + 1 x 1200 g, for 40.00
+	cartStore := func() *mockCartStore {
+		return &mockCartStore{items: []types.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}}
+	}
+
+	send := func(t *testing.T, handler *Handler, method string, url string, payload any) *httptest.ResponseRecorder {
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/cart/shipping-quotes", handler.handleGetShippingQuotes).Methods(http.MethodGet)
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should quote the methods shipping to the address", func(t *testing.T) {
+		deps := mockDeps()
+		deps.CartStore = cartStore()
+		deps.ShippingStore = shippingStore
+		handler := NewHandler(deps)
+
+		rr := send(t, handler, http.MethodGet, "/cart/shipping-quotes?addressID=1", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var quotes []types.ShippingQuote
+		if err := json.NewDecoder(rr.Body).Decode(&quotes); err != nil {
+			t.Fatal(err)
+		}
+
+		// express charges 3 started kilograms
+		want := []types.ShippingQuote{
+			{MethodID: 1, Code: "standard", Name: "Standard", Cost: dollars(5)},
+			{MethodID: 2, Code: "express", Name: "Express", Cost: dollars(16)},
+		}
+		if !slices.Equal(quotes, want) {
+			t.Errorf("expected %+v, got %+v", want, quotes)
+		}
+
+		if rr := send(t, handler, http.MethodGet, "/cart/shipping-quotes?addressID=99", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for an unknown address, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should add the shipping cost to the order", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CartStore = cartStore()
+		deps.ShippingStore = shippingStore
+		handler := NewHandler(deps)
+
+		rr := send(t, handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{ShippingMethod: "Express"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		order := orderStore.lastOrder
+		if order.ShippingCost != dollars(16) || order.Total != dollars(56) {
+			t.Errorf("expected 40 + 16 = 56, got a shipping cost of %s and a total of %s", order.ShippingCost, order.Total)
+		}
+
+		if order.ShippingMethodID == nil || *order.ShippingMethodID != 2 || order.ShippingMethod != "Express" {
+			t.Errorf("expected the order to keep the method, got %v %q", order.ShippingMethodID, order.ShippingMethod)
+		}
+	})
+
+	t.Run("should waive the cost with a free shipping coupon", func(t *testing.T) {
+		orderStore := &mockOrderStore{}
+		coupons := newMockCouponStore(types.Coupon{ID: 1, Code: "SHIPFREE", Type: types.CouponFreeShipping})
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CartStore = cartStore()
+		deps.CouponStore = coupons
+		deps.ShippingStore = shippingStore
+		handler := NewHandler(deps)
+
+		rr := send(t, handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{ShippingMethod: "standard", CouponCode: "SHIPFREE"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if order := orderStore.lastOrder; !order.ShippingCost.IsZero() || order.Total != dollars(40) || order.ShippingMethod != "Standard" {
+			t.Errorf("expected free standard shipping, got %+v", order)
+		}
+	})
+
+	t.Run("should require a method that ships to the address", func(t *testing.T) {
+		for _, code := range []string{"", "saver", "retired", "nope"} {
+			deps := mockDeps()
+			deps.CartStore = cartStore()
+			deps.ShippingStore = shippingStore
+			handler := NewHandler(deps)
+
+			if rr := send(t, handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{ShippingMethod: code}); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %q, got %d", http.StatusBadRequest, code, rr.Code)
+			}
+		}
+	})
+}
+
+func TestPaymentCheckout(t *testing.T) {
+	// 2 x 10.00 + 1 x 20.00, with standard shipping to the default address
+	cartStore := func() *mockCartStore {
+		return &mockCartStore{items: []types.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}}
+	}
+	shippingStore := &mockShippingStore{methods: []types.ShippingMethod{
+		{ID: 1, Code: "standard", Name: "Standard", Type: types.ShippingFlatRate, Cost: dollars(5), Active: true},
+	}}
+
+	checkout := func(t *testing.T, handler *Handler) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBufferString(`{"shippingMethod": "standard"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should authorize the total and mark the order paid", func(t *testing.T) {
+		orderStore, paymentStore, gateway, carts := &mockOrderStore{}, &mockPaymentStore{}, &mockPaymentGateway{}, cartStore()
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.CartStore = carts
+		deps.ShippingStore = shippingStore
+		deps.PaymentStore = paymentStore
+		deps.PaymentGateway = gateway
+		handler := NewHandler(deps)
+
+		rr := checkout(t, handler)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		var response struct {
+			Status types.OrderStatus `json:"status"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Status != types.OrderStatusPaid || orderStore.status != types.OrderStatusPaid {
+			t.Errorf("expected the order to be paid, got %q", response.Status)
+		}
+
+		if len(gateway.authorized) != 1 || gateway.authorized[0] != dollars(45) {
+			t.Errorf("expected 45 to be authorized, got %v", gateway.authorized)
+		}
+
+		want := types.Payment{ID: 1, OrderID: 1, Provider: "mock", Reference: "auth_1", Amount: dollars(45), Status: types.PaymentAuthorized}
+		if len(paymentStore.payments) != 1 || paymentStore.payments[0] != want {
+			t.Errorf("expected %+v to be recorded, got %+v", want, paymentStore.payments)
+		}
+
+		if last := orderStore.history[len(orderStore.history)-1]; last.ToStatus != types.OrderStatusPaid || last.ActorID != nil {
+			t.Errorf("expected the system to mark the order paid, got %+v", last)
+		}
+
+		if len(carts.items) != 0 {
+			t.Errorf("expected the cart to be empty, got %d items", len(carts.items))
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		code   int
+		status types.PaymentStatus
+	}{
+		{"declined", fmt.Errorf("card refused: %w", types.ErrPaymentDeclined), http.StatusPaymentRequired, types.PaymentDeclined},
+		{"timed out", context.DeadlineExceeded, http.StatusBadGateway, types.PaymentFailed},
+	} {
+		t.Run("should cancel the order and release its stock when the payment "+tc.name, func(t *testing.T) {
+			ledger, orderStore, paymentStore, carts := &mockLedger{}, &mockOrderStore{}, &mockPaymentStore{}, cartStore()
+			deps := mockDeps()
+			deps.OrderStore = orderStore
+			deps.CartStore = carts
+			deps.ShippingStore = shippingStore
+			deps.PaymentStore = paymentStore
+			deps.PaymentGateway = &mockPaymentGateway{err: tc.err}
+			deps.Ledger = ledger
+			handler := NewHandler(deps)
+
+			if rr := checkout(t, handler); rr.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d: %s", tc.code, rr.Code, rr.Body)
+			}
+
+			if len(paymentStore.payments) != 1 || paymentStore.payments[0].Status != tc.status || paymentStore.payments[0].Error == "" {
+				t.Errorf("expected a %s payment to be recorded, got %+v", tc.status, paymentStore.payments)
+			}
+
+			if orderStore.status != types.OrderStatusCancelled || orderStore.cancelReason != "payment "+string(tc.status) {
+				t.Errorf("expected the order to be cancelled, got %q (%q)", orderStore.status, orderStore.cancelReason)
+			}
+
+			if sold, restocked := ledger.total(types.StockSale), ledger.total(types.StockCancellation); sold != -3 || restocked != 3 {
+				t.Errorf("expected the 3 units sold to be restocked, got %d sold and %d restocked", sold, restocked)
+			}
+
+			if len(carts.items) != 2 {
+				t.Errorf("expected the cart to be kept, got %d items", len(carts.items))
+			}
+		})
+	}
+}
+
+func TestVariantCartHandlers(t *testing.T) {
+	newRouter := func(handler *Handler) *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/cart/items", handler.handleAddCartItem).Methods(http.MethodPost)
+		router.HandleFunc("/cart/items/{productID}", handler.handleUpdateCartItem).Methods(http.MethodPatch)
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		return router
+	}
+
+	send := func(handler *Handler, method string, url string, payload any) *httptest.ResponseRecorder {
+		marshalled, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, url, bytes.NewBuffer(marshalled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(handler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should checkout a variant at its own price and stock", func(t *testing.T) {
+		ledger := &mockLedger{}
+		orderStore := &mockOrderStore{}
+		deps := mockDeps()
+		deps.OrderStore = orderStore
+		deps.Ledger = ledger
+		handler := NewHandler(deps)
+
+		rr := send(handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{{ProductID: 7, VariantID: 71, Quantity: 2}},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if orderStore.lastOrder.Total != dollars(50) {
+			t.Errorf("expected a total of 50, got %v", orderStore.lastOrder.Total)
+		}
+
+		item := orderStore.items[0]
+		if item.VariantID == nil || *item.VariantID != 71 || item.Price != dollars(25) {
+			t.Errorf("expected the order item to record variant 71 at 25, got %+v", item)
+		}
+
+		if len(ledger.movements) != 1 {
+			t.Fatalf("expected 1 movement, got %+v", ledger.movements)
+		}
+
+		if m := ledger.movements[0]; m.VariantID == nil || *m.VariantID != 71 || m.Change != -2 || m.Reason != types.StockSale || m.Reference != "order:1" {
+			t.Errorf("expected the sale of 2 units of the variant, got %+v", m)
+		}
+	})
+
+	t.Run("should take the stock from the warehouses each item is allocated to", func(t *testing.T) {
+		ledger := &mockLedger{}
+		warehouses := &mockWarehouseStore{}
+		deps := mockDeps()
+		deps.Ledger = ledger
+		deps.WarehouseStore = warehouses
+		deps.Allocator = &mockAllocator{warehouseID: 2}
+		handler := NewHandler(deps)
+
+		rr := send(handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{{ProductID: 7, VariantID: 71, Quantity: 2}},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(ledger.movements) != 1 || ledger.movements[0].WarehouseID == nil || *ledger.movements[0].WarehouseID != 2 {
+			t.Errorf("expected the sale to come out of warehouse 2, got %+v", ledger.movements)
+		}
+
+		want := types.OrderAllocation{OrderID: 1, OrderItemID: 1, WarehouseID: 2, Quantity: 2}
+		if len(warehouses.allocations) != 1 || warehouses.allocations[0] != want {
+			t.Errorf("expected the allocation %+v, got %+v", want, warehouses.allocations)
+		}
+	})
+
+	t.Run("should reject checkouts no warehouse can fulfil", func(t *testing.T) {
+		transactor := &mockTransactor{}
+		allocator := &mockAllocator{err: fmt.Errorf("product 7 is not available in the quantity requested: %w", types.ErrConflict)}
+		deps := mockDeps()
+		deps.Allocator = allocator
+		deps.Transactor = transactor
+		handler := NewHandler(deps)
+
+		rr := send(handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{{ProductID: 7, VariantID: 71, Quantity: 2}},
+		})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if !transactor.rolledBack {
+			t.Errorf("expected the order to be rolled back")
+		}
+	})
+
+	t.Run("should report the products a sale brings to their reorder threshold", func(t *testing.T) {
+		// product 5 runs out with this order, product 1 was already under
+		// its threshold before it and product 4 isn't part of it
+		productStore := &mockProductStore{lowStock: []types.LowStockProduct{
+			{ProductID: 5, Name: "almost stock", Quantity: 0, ReorderThreshold: 0},
+			{ProductID: 1, Name: "product 1", Quantity: 97, ReorderThreshold: 100},
+			{ProductID: 4, Name: "empty stock", Quantity: 0, ReorderThreshold: 0},
+		}}
+		notifier := &mockNotifier{err: fmt.Errorf("mail server is down")}
+		deps := mockDeps()
+		deps.ProductStore = productStore
+		deps.Notifier = notifier
+		handler := NewHandler(deps)
+
+		rr := send(handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{
+			Items: []types.CartCheckoutItem{{ProductID: 5, Quantity: 1}, {ProductID: 1, Quantity: 2}},
+		})
+
+		// a failed alert doesn't fail the checkout
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(notifier.events) != 1 || notifier.events[0].ProductID != 5 || notifier.events[0].OrderID != 1 {
+			t.Errorf("expected a single alert for product 5, got %+v", notifier.events)
+		}
+	})
+
+	t.Run("should reject checkouts that don't pick an available variant", func(t *testing.T) {
+		handler := NewHandler(mockDeps())
+
+		for _, item := range []types.CartCheckoutItem{
+			{ProductID: 7, Quantity: 1},
+			{ProductID: 7, VariantID: 72, Quantity: 1},
+			{ProductID: 7, VariantID: 71, Quantity: 3},
+			{ProductID: 1, VariantID: 71, Quantity: 1},
+		} {
+			rr := send(handler, http.MethodPost, "/cart/checkout", types.CartCheckoutPayload{Items: []types.CartCheckoutItem{item}})
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %+v, got %d", http.StatusBadRequest, item, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should keep variants apart in the stored cart", func(t *testing.T) {
+		cartStore := newMockCartStore()
+		deps := mockDeps()
+		deps.CartStore = cartStore
+		handler := NewHandler(deps)
+
+		if rr := send(handler, http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 7, Quantity: 1}); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d without a variant, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if rr := send(handler, http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 7, VariantID: 99, Quantity: 1}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d for an unknown variant, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		send(handler, http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 7, VariantID: 71, Quantity: 1})
+		send(handler, http.MethodPost, "/cart/items", types.AddCartItemPayload{ProductID: 7, VariantID: 72, Quantity: 1})
+
+		rr := send(handler, http.MethodPatch, "/cart/items/7?variantID=71", types.UpdateCartItemPayload{Quantity: 2})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var view types.CartView
+		if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(view.Items) != 2 {
+			t.Fatalf("expected 2 cart lines, got %+v", view.Items)
+		}
+
+		medium, large := view.Items[0], view.Items[1]
+		if medium.SKU != "TS-M" || medium.Quantity != 2 || medium.Subtotal != dollars(50) {
+			t.Errorf("expected 2 medium shirts for 50, got %+v", medium)
+		}
+
+		if large.SKU != "TS-L" || large.Warning != "out of stock" {
+			t.Errorf("expected the large shirt to be flagged out of stock, got %+v", large)
+		}
+	})
+}
+
+func TestStockReservations(t *testing.T) {
+	shippingStore := &mockShippingStore{methods: []types.ShippingMethod{
+		{ID: 1, Code: "standard", Name: "Standard", Type: types.ShippingFlatRate, Cost: dollars(5), Active: true},
+	}}
+
+	serve := func(t *testing.T, handler *Handler, path string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/cart/reservation", handler.handleReserveCart).Methods(http.MethodPost)
+		router.HandleFunc("/cart/checkout", handler.handleCheckout).Methods(http.MethodPost)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// other carts hold 60 of the 100 units of product 1
+	newReserver := func() *mockReserver {
+		return &mockReserver{reserved: types.ReservedStock{Products: map[int]int{1: 60}}}
+	}
+
+	t.Run("should not sell units other carts hold", func(t *testing.T) {
+		reserver := newReserver()
+		deps := mockDeps()
+		deps.ShippingStore = shippingStore
+		deps.Reserver = reserver
+		handler := NewHandler(deps)
+
+		rr := serve(t, handler, "/cart/checkout", `{"items": [{"productID": 1, "quantity": 41}], "shippingMethod": "standard"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if len(reserver.orders) != 0 {
+			t.Errorf("expected nothing to be reserved, got %v", reserver.orders)
+		}
+	})
+
+	t.Run("should hold the units of an order until it is paid", func(t *testing.T) {
+		reserver := newReserver()
+		deps := mockDeps()
+		deps.ShippingStore = shippingStore
+		deps.Reserver = reserver
+		handler := NewHandler(deps)
+
+		rr := serve(t, handler, "/cart/checkout", `{"items": [{"productID": 1, "quantity": 40}], "shippingMethod": "standard"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(reserver.orders) != 1 || len(reserver.releasedOrders) != 1 || reserver.releasedOrders[0] != reserver.orders[0] {
+			t.Errorf("expected the order to be reserved and released once paid, got %v and %v", reserver.orders, reserver.releasedOrders)
+		}
+
+		if !reserver.cartReleased {
+			t.Errorf("expected the cart reservation to be released")
+		}
+	})
+
+	t.Run("should reserve the stored cart", func(t *testing.T) {
+		reserver := newReserver()
+		carts := &mockCartStore{items: []types.CartItem{{ProductID: 1, Quantity: 40}, {ProductID: 7, VariantID: 71, Quantity: 2}}}
+		deps := mockDeps()
+		deps.CartStore = carts
+		deps.ShippingStore = shippingStore
+		deps.Reserver = reserver
+		handler := NewHandler(deps)
+
+		rr := serve(t, handler, "/cart/reservation", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(reserver.cart) != 2 || reserver.cart[1].VariantID != 71 {
+			t.Errorf("expected the cart to be reserved, got %+v", reserver.cart)
+		}
+
+		carts.items[0].Quantity = 41
+		if rr := serve(t, handler, "/cart/reservation", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+// mockProductStore serves mockProducts. lowStock is what it reports as low
+// on stock, as the ledger mock doesn't change the quantities.
+type mockProductStore struct {
+	lowStock []types.LowStockProduct
+}
+
+func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
+	for _, p := range mockProducts {
+		if p.ID == productID && p.DeletedAt == nil {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	return []*types.Product{}, 0, nil
+}
+
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	return []types.ProductSearchResult{}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
+}
+
+// GetProductsByID behaves like the real store and leaves out deleted products.
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	products := []types.Product{}
+	for _, p := range mockProducts {
+		if p.DeletedAt == nil {
+			products = append(products, p)
+		}
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	return nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	products := []types.LowStockProduct{}
+	for _, p := range m.lowStock {
+		if productIDs == nil || slices.Contains(productIDs, p.ProductID) {
+			products = append(products, p)
+		}
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+// mockVariantStore serves mockVariants.
+type mockVariantStore struct{}
+
+func newMockVariantStore() *mockVariantStore {
+	return &mockVariantStore{}
+}
+
+func (m *mockVariantStore) GetOptions(productID int) ([]types.ProductOption, error) {
+	return []types.ProductOption{}, nil
+}
+
+func (m *mockVariantStore) SetOptions(productID int, names []string) error {
+	return nil
+}
+
+func (m *mockVariantStore) GetVariantsByProductIDs(productIDs []int) ([]types.ProductVariant, error) {
+	variants := []types.ProductVariant{}
+	for _, v := range mockVariants {
+		if slices.Contains(productIDs, v.ProductID) {
+			variants = append(variants, v)
+		}
+	}
+
+	return variants, nil
+}
+
+func (m *mockVariantStore) GetVariantByID(productID int, variantID int) (*types.ProductVariant, error) {
+	for _, v := range mockVariants {
+		if v.ID == variantID && v.ProductID == productID {
+			return &v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("variant %d %w", variantID, types.ErrNotFound)
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	return nil, fmt.Errorf("variant %s %w", sku, types.ErrNotFound)
+}
+
+func (m *mockVariantStore) CreateVariant(v types.ProductVariant) (int, error) {
+	return 0, nil
+}
+
+func (m *mockVariantStore) UpdateVariant(v types.ProductVariant) error {
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(productID int, variantID int) error {
+	return nil
+}
+
+func (m *mockVariantStore) WithTx(tx *sql.Tx) types.VariantStore {
+	return m
+}
+
+// mockLedger records the movements, failing every sale when fail is set as
+// if the stock ran out during checkout.
+type mockLedger struct {
+	fail      bool
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	if m.fail && movement.Change < 0 {
+		return fmt.Errorf("product %d is not available in the quantity requested: %w", movement.ProductID, types.ErrConflict)
+	}
+
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+// mockNotifier records the alerts it's given, and fails them all when err
+// is set.
+type mockNotifier struct {
+	events []types.LowStockEvent
+	err    error
+}
+
+func (m *mockNotifier) NotifyLowStock(ctx context.Context, event types.LowStockEvent) error {
+	m.events = append(m.events, event)
+	return m.err
+}
+
+// mockAllocator ships every line from a single warehouse, the first one
+// when warehouseID isn't set.
+type mockAllocator struct {
+	warehouseID int
+	err         error
+}
+
+func (m *mockAllocator) Allocate(to types.PostalAddress, lines []types.AllocationLine, warehouses []types.Warehouse, levels []types.InventoryLevel) ([]types.OrderAllocation, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	allocations := []types.OrderAllocation{}
+	for _, line := range lines {
+		allocations = append(allocations, types.OrderAllocation{OrderItemID: line.OrderItemID, WarehouseID: max(m.warehouseID, 1), Quantity: line.Quantity})
+	}
+
+	return allocations, nil
+}
+
+type mockWarehouseStore struct {
+	allocations []types.OrderAllocation
+}
+
+func (m *mockWarehouseStore) GetWarehouses() ([]types.Warehouse, error) {
+	return []types.Warehouse{{ID: 1, Code: "main", IsDefault: true}}, nil
+}
+
+func (m *mockWarehouseStore) GetWarehouse(id int) (*types.Warehouse, error) {
+	return nil, fmt.Errorf("warehouse %d %w", id, types.ErrNotFound)
+}
+
+func (m *mockWarehouseStore) CreateWarehouse(w types.Warehouse) (int, error) {
+	return 0, nil
+}
+
+func (m *mockWarehouseStore) UpdateWarehouse(w types.Warehouse) error {
+	return nil
+}
+
+func (m *mockWarehouseStore) GetInventoryLevels(productIDs []int) ([]types.InventoryLevel, error) {
+	return []types.InventoryLevel{}, nil
+}
+
+func (m *mockWarehouseStore) GetAvailability(productIDs []int) (map[int][]types.WarehouseStock, error) {
+	return map[int][]types.WarehouseStock{}, nil
+}
+
+func (m *mockWarehouseStore) CreateAllocations(allocations []types.OrderAllocation) error {
+	m.allocations = append(m.allocations, allocations...)
+	return nil
+}
+
+func (m *mockWarehouseStore) GetAllocations(orderID int) ([]types.OrderAllocation, error) {
+	return m.allocations, nil
+}
+
+func (m *mockWarehouseStore) GetPickingList(warehouseID int) ([]types.PickingListItem, error) {
+	return []types.PickingListItem{}, nil
+}
+
+func (m *mockWarehouseStore) WithTx(tx *sql.Tx) types.WarehouseStore {
+	return m
+}
+
+// total adds up the change of the movements recorded for reason.
+func (m *mockLedger) total(reason types.StockMovementReason) int {
+	total := 0
+	for _, movement := range m.movements {
+		if movement.Reason == reason {
+			total += movement.Change
+		}
+	}
+
+	return total
+}
+
+type mockOrderStore struct {
+	orders       int
+	lastOrder    types.Order
+	items        []types.OrderItem
+	history      []types.OrderStatusChange
+	status       types.OrderStatus
+	cancelReason string
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	m.orders++
+	m.lastOrder = order
+	return m.orders, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	m.items = append(m.items, orderItem)
+	return len(m.items), nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	return []types.Order{}, 0, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrder(orderID int) (*types.Order, error) {
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	items := []types.OrderItemDetail{}
+	for _, item := range m.items {
+		if item.OrderID == orderID {
+			items = append(items, types.OrderItemDetail{OrderItem: item})
+		}
+	}
+
+	return items, nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	m.status = to
+	return nil
+}
+
+func (m *mockOrderStore) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	m.cancelReason = reason
+	return nil
+}
+
+func (m *mockOrderStore) CreateStatusChange(change types.OrderStatusChange) error {
+	m.history = append(m.history, change)
+	return nil
+}
+
+func (m *mockOrderStore) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	return m.history, nil
+}
+
+func (m *mockOrderStore) RecordRefund(refund types.Refund) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetRefunds(orderID int) ([]types.Refund, error) {
+	return []types.Refund{}, nil
+}
+
+func (m *mockOrderStore) WithTx(tx *sql.Tx) types.OrderStore {
+	return m
+}
+
+// mockAddressStore knows a single address, id 1, which is every user's default.
+type mockAddressStore struct{}
+
+var mockAddress = types.Address{
+	ID: 1,
+	PostalAddress: types.PostalAddress{
+		FullName:   "Ada Lovelace",
+		Line1:      "12 St James's Square",
+		City:       "London",
+		PostalCode: "SW1Y 4JH",
+		Country:    "GB",
+	},
+	IsDefault: true,
+}
+
+func (m *mockAddressStore) GetAddressesByUserID(userID int) ([]types.Address, error) {
+	return []types.Address{mockAddress}, nil
+}
+
+func (m *mockAddressStore) GetAddressByID(userID int, addressID int) (*types.Address, error) {
+	if addressID != mockAddress.ID {
+		return nil, fmt.Errorf("address %d %w", addressID, types.ErrNotFound)
+	}
+
+	a := mockAddress
+	return &a, nil
+}
+
+func (m *mockAddressStore) GetDefaultAddress(userID int) (*types.Address, error) {
+	a := mockAddress
+	return &a, nil
+}
+
+func (m *mockAddressStore) CreateAddress(a types.Address) (int, error) {
+	return 0, nil
+}
+
+func (m *mockAddressStore) UpdateAddress(a types.Address) error {
+	return nil
+}
+
+func (m *mockAddressStore) DeleteAddress(userID int, addressID int) error {
+	return nil
+}
+
+func (m *mockAddressStore) SetDefaultAddress(userID int, addressID int) error {
+	return nil
+}
+
+func (m *mockAddressStore) WithTx(tx *sql.Tx) types.AddressStore {
+	return m
+}
+
+type mockExchangeRateStore struct {
+	rates types.ExchangeRates
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+// mockTransactor notes when a transaction fails, which a real one would
+// roll back.
+// mockReservationStore keeps the orders whose reservations were released.
+type mockReservationStore struct {
+	released []int
+}
+
+func (m *mockReservationStore) GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (types.ReservedStock, error) {
+	return types.ReservedStock{}, nil
+}
+
+func (m *mockReservationStore) CreateReservations(reservations []types.StockReservation) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseCartReservations(userID int) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseOrderReservations(orderID int) error {
+	m.released = append(m.released, orderID)
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseExpiredCartReservations(now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *mockReservationStore) GetExpiredOrderIDs(now time.Time, limit int) ([]int, error) {
+	return []int{}, nil
+}
+
+func (m *mockReservationStore) WithTx(tx *sql.Tx) types.ReservationStore {
+	return m
+}
+
+type mockTransactor struct {
+	rolledBack bool
+}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	err := fn(nil)
+	m.rolledBack = m.rolledBack || err != nil
+	return err
+}
+
+type mockPaymentStore struct {
+	payments []types.Payment
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	return nil
+}
+
+func (m *mockPaymentStore) CreatePayment(p types.Payment) (int, error) {
+	p.ID = len(m.payments) + 1
+	m.payments = append(m.payments, p)
+	return p.ID, nil
+}
+
+func (m *mockPaymentStore) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	return 0, nil
+}
+
+func (m *mockPaymentStore) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	return nil, nil
+}
+
+func (m *mockPaymentStore) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *mockPaymentStore) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	return false, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx *sql.Tx) types.PaymentStore {
+	return m
+}
+
+// mockPaymentGateway authorizes every payment unless err is set, in which
+// case every authorization fails with it.
+type mockPaymentGateway struct {
+	err        error
+	authorized []types.Money
+}
+
+func (m *mockPaymentGateway) Name() string {
+	return "mock"
+}
+
+func (m *mockPaymentGateway) Authorize(ctx context.Context, orderID int, amount types.Money) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+
+	m.authorized = append(m.authorized, amount)
+	return fmt.Sprintf("auth_%d", orderID), nil
+}
+
+func (m *mockPaymentGateway) Capture(ctx context.Context, reference string, amount types.Money) error {
+	return nil
+}
+
+func (m *mockPaymentGateway) Refund(ctx context.Context, reference string, amount types.Money) error {
+	return nil
+}
+
+func (m *mockPaymentGateway) Void(ctx context.Context, reference string) error {
+	return nil
+}
+
+// mockReserver holds back reserved from the stock and keeps track of the
+// reservations made and released.
+type mockReserver struct {
+	reserved       types.ReservedStock
+	cart           []types.CartCheckoutItem
+	cartReleased   bool
+	orders         []int
+	releasedOrders []int
+}
+
+func (m *mockReserver) Reserved(productIDs []int, userID int) (types.ReservedStock, error) {
+	return m.reserved, nil
+}
+
+func (m *mockReserver) ReserveCart(userID int, items []types.CartCheckoutItem) (time.Time, error) {
+	m.cart = items
+	return time.Now().Add(time.Minute), nil
+}
+
+func (m *mockReserver) ReserveOrder(orderID int, items []types.CartCheckoutItem) error {
+	m.orders = append(m.orders, orderID)
+	return nil
+}
+
+func (m *mockReserver) ReleaseCart(userID int) error {
+	m.cartReleased = true
+	return nil
+}
+
+func (m *mockReserver) ReleaseOrder(orderID int) error {
+	m.releasedOrders = append(m.releasedOrders, orderID)
+	return nil
+}
+
+func (m *mockReserver) WithTx(tx *sql.Tx) types.StockReserver {
+	return m
+}
+
+// mockCartStore keeps a single user's cart in memory, in insertion order.
+type mockCartStore struct {
+	items []types.CartItem
+}
+
+func newMockCartStore() *mockCartStore {
+	return &mockCartStore{items: []types.CartItem{}}
+}
+
+func (m *mockCartStore) GetCartItems(userID int) ([]types.CartItem, error) {
+	return append([]types.CartItem{}, m.items...), nil
+}
+
+func (m *mockCartStore) AddCartItem(userID int, productID int, variantID int, quantity int) error {
+	for i := range m.items {
+		if m.items[i].ProductID == productID && m.items[i].VariantID == variantID {
+			m.items[i].Quantity += quantity
+			return nil
+		}
+	}
+
+	m.items = append(m.items, types.CartItem{UserID: userID, ProductID: productID, VariantID: variantID, Quantity: quantity})
+	return nil
+}
+
+func (m *mockCartStore) UpdateCartItem(userID int, productID int, variantID int, quantity int) error {
+	for i := range m.items {
+		if m.items[i].ProductID == productID && m.items[i].VariantID == variantID {
+			m.items[i].Quantity = quantity
+		}
+	}
+
+	return nil
+}
+
+func (m *mockCartStore) RemoveCartItem(userID int, productID int, variantID int) error {
+	for i := range m.items {
+		if m.items[i].ProductID == productID && m.items[i].VariantID == variantID {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("product %d is not in the cart: %w", productID, types.ErrNotFound)
+}
+
+func (m *mockCartStore) ClearCart(userID int) error {
+	m.items = []types.CartItem{}
+	return nil
+}
+
+func (m *mockCartStore) WithTx(tx *sql.Tx) types.CartStore {
+	return m
+}
+
+type mockCouponStore struct {
+	coupons     []types.Coupon
+	redemptions map[int]int // order ID to coupon ID
+}
+
+func newMockCouponStore(coupons ...types.Coupon) *mockCouponStore {
+	return &mockCouponStore{coupons: coupons}
+}
+
+func (m *mockCouponStore) GetCoupons() ([]types.Coupon, error) {
+	return m.coupons, nil
+}
+
+func (m *mockCouponStore) GetCouponByID(couponID int) (*types.Coupon, error) {
+	for _, c := range m.coupons {
+		if c.ID == couponID {
+			return &c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) GetCouponByCode(code string) (*types.Coupon, error) {
+	for _, c := range m.coupons {
+		if strings.EqualFold(c.Code, code) {
+			return &c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) CreateCoupon(c types.Coupon) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCouponStore) UpdateCoupon(c types.Coupon) error {
+	return nil
+}
+
+func (m *mockCouponStore) DeleteCoupon(couponID int) error {
+	return nil
+}
+
+// GetEligibleProductIDs only looks at the products of the coupon; the carts
+// in these tests don't need categories.
+func (m *mockCouponStore) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	c, err := m.GetCouponByID(couponID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.ProductIDs) == 0 {
+		return productIDs, nil
+	}
+
+	eligible := []int{}
+	for _, id := range productIDs {
+		if slices.Contains(c.ProductIDs, id) {
+			eligible = append(eligible, id)
+		}
+	}
+
+	return eligible, nil
+}
+
+func (m *mockCouponStore) RedeemCoupon(couponID int, userID int, orderID int) error {
+	for i := range m.coupons {
+		c := &m.coupons[i]
+		if c.ID != couponID {
+			continue
+		}
+
+		if c.MaxUses != nil && c.Uses >= *c.MaxUses {
+			return fmt.Errorf("coupon %d %w", couponID, types.ErrConflict)
+		}
+
+		c.Uses++
+		if m.redemptions == nil {
+			m.redemptions = map[int]int{}
+		}
+		m.redemptions[orderID] = couponID
+		return nil
+	}
+
+	return fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) ReleaseRedemption(orderID int) error {
+	couponID, ok := m.redemptions[orderID]
+	if !ok {
+		return nil
+	}
+
+	for i := range m.coupons {
+		if m.coupons[i].ID == couponID {
+			m.coupons[i].Uses--
+		}
+	}
+
+	delete(m.redemptions, orderID)
+	return nil
+}
+
+func (m *mockCouponStore) WithTx(tx *sql.Tx) types.CouponStore {
+	return m
+}
+
+// mockTaxCalculator charges a flat rate per tax class, wherever the order
+// ships to, unless err is set, in which case it fails with it as if its
+// rates couldn't be read.
+type mockTaxCalculator struct {
+	rates map[string]types.Rate
+	to    types.PostalAddress
+	err   error
+}
+
+func (m *mockTaxCalculator) Calculate(to types.PostalAddress, lines []types.TaxLine) ([]types.Money, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.to = to
+
+	taxes := make([]types.Money, len(lines))
+	for i, line := range lines {
+		taxClass := line.TaxClass
+		if taxClass == "" {
+			taxClass = types.DefaultTaxClass
+		}
+
+		taxes[i] = line.Amount.Convert(line.Amount.Currency, m.rates[taxClass])
+	}
+
+	return taxes, nil
+}
+
+type mockShippingStore struct {
+	methods []types.ShippingMethod
+}
+
+func (m *mockShippingStore) GetShippingMethods() ([]types.ShippingMethod, error) {
+	return m.methods, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByID(methodID int) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if method.ID == methodID {
+			return &method, nil
+		}
+	}
+
+	return nil, fmt.Errorf("shipping method %d %w", methodID, types.ErrNotFound)
+}
+
+func (m *mockShippingStore) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if strings.EqualFold(method.Code, code) {
+			return &method, nil
+		}
+	}
+
+	return nil, fmt.Errorf("shipping method %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockShippingStore) CreateShippingMethod(method types.ShippingMethod) (int, error) {
+	return 0, nil
+}
+
+func (m *mockShippingStore) UpdateShippingMethod(method types.ShippingMethod) error {
+	return nil
+}
+
+func (m *mockShippingStore) DeleteShippingMethod(methodID int) error {
+	return nil
+}
+
+func (m *mockShippingStore) WithTx(tx *sql.Tx) types.ShippingMethodStore {
+	return m
+}
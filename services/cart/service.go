@@ -1,12 +1,25 @@
 package cart
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/sikozonpc/ecom/services/coupon"
+	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/services/shipping"
 	"github.com/sikozonpc/ecom/types"
 )
 
 func getCartItemsIDs(items []types.CartCheckoutItem) ([]int, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("cart is empty")
+	}
+
 	productIds := make([]int, len(items))
 	for i, item := range items {
 		if item.Quantity <= 0 {
@@ -19,31 +32,243 @@ func getCartItemsIDs(items []types.CartCheckoutItem) ([]int, error) {
 	return productIds, nil
 }
 
-func checkIfCartIsInStock(cartItems []types.CartCheckoutItem, products map[int]types.Product) error {
+func getStoredCartProductIDs(items []types.CartItem) []int {
+	productIds := make([]int, len(items))
+	for i, item := range items {
+		productIds[i] = item.ProductID
+	}
+
+	return productIds
+}
+
+func cartHasItem(items []types.CartItem, productID int, variantID int) bool {
+	for _, item := range items {
+		if item.ProductID == productID && item.VariantID == variantID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// catalog holds the products a cart refers to together with their variants,
+// and prices them in the currency the client asked for.
+type catalog struct {
+	products map[int]types.Product
+	variants map[int]types.ProductVariant
+	// hasVariants marks the products that can only be bought as a variant
+	hasVariants map[int]bool
+	currency    string
+	rates       types.ExchangeRates
+}
+
+// newCatalog prices lines in the default currency until priceIn is called.
+func newCatalog(products []types.Product, variants []types.ProductVariant) catalog {
+	c := catalog{
+		products:    make(map[int]types.Product, len(products)),
+		variants:    make(map[int]types.ProductVariant, len(variants)),
+		hasVariants: map[int]bool{},
+		currency:    types.DefaultCurrency,
+	}
+
+	for _, product := range products {
+		c.products[product.ID] = product
+	}
+
+	for _, variant := range variants {
+		c.variants[variant.ID] = variant
+		c.hasVariants[variant.ProductID] = true
+	}
+
+	return c
+}
+
+// priceIn returns a copy of the catalog that converts prices into currency,
+// failing with types.ErrNoExchangeRate if rates has none for it.
+func (c catalog) priceIn(currency string, rates types.ExchangeRates) (catalog, error) {
+	if _, err := rates.CrossRate(types.DefaultCurrency, currency); err != nil {
+		return c, err
+	}
+
+	c.currency, c.rates = currency, rates
+	return c, nil
+}
+
+// catalogLine is what one cart line buys: a product, or one of its variants.
+// listPrice is the catalog price and price the same converted into the
+// currency of the cart, at rate.
+type catalogLine struct {
+	product   types.Product
+	sku       string
+	listPrice types.Money
+	price     types.Money
+	rate      types.Rate
+	stock     int
+}
+
+func (l catalogLine) name() string {
+	if l.sku == "" {
+		return l.product.Name
+	}
+
+	return fmt.Sprintf("%s (%s)", l.product.Name, l.sku)
+}
+
+// line resolves a cart line against the catalog, taking the price and stock
+// from the variant when one is picked.
+func (c catalog) line(productID int, variantID int) (catalogLine, error) {
+	product, ok := c.products[productID]
+	if !ok {
+		return catalogLine{}, fmt.Errorf("product %d is not available in the store, please refresh your cart", productID)
+	}
+
+	if variantID == 0 {
+		if c.hasVariants[productID] {
+			return catalogLine{}, fmt.Errorf("product %s is sold in variants, please pick one", product.Name)
+		}
+
+		return c.priced(catalogLine{product: product, listPrice: product.Price, stock: product.Quantity})
+	}
+
+	variant, ok := c.variants[variantID]
+	if !ok || variant.ProductID != productID {
+		return catalogLine{}, fmt.Errorf("variant %d of product %s is not available in the store, please refresh your cart", variantID, product.Name)
+	}
+
+	line := catalogLine{product: product, sku: variant.SKU, listPrice: product.Price, stock: variant.Quantity}
+	if variant.Price != nil {
+		line.listPrice = *variant.Price
+	}
+
+	return c.priced(line)
+}
+
+func (c catalog) priced(line catalogLine) (catalogLine, error) {
+	price, rate, err := c.rates.Convert(line.listPrice, c.currency)
+	if err != nil {
+		return catalogLine{}, err
+	}
+
+	line.price, line.rate = price, rate
+	return line, nil
+}
+
+// storedCartToCheckoutItems turns the persisted cart into the same shape a
+// client-supplied checkout payload has.
+func storedCartToCheckoutItems(items []types.CartItem) []types.CartCheckoutItem {
+	checkoutItems := make([]types.CartCheckoutItem, len(items))
+	for i, item := range items {
+		checkoutItems[i] = types.CartCheckoutItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		}
+	}
+
+	return checkoutItems
+}
+
+// buildCartView prices the stored cart with the current product data and adds
+// a warning to every line the stock can't currently cover.
+func buildCartView(items []types.CartItem, c catalog) types.CartView {
+	view := types.CartView{Items: make([]types.CartViewItem, 0, len(items))}
+	for _, item := range items {
+		l, err := c.line(item.ProductID, item.VariantID)
+		if err != nil {
+			warning := "product is no longer available"
+			if _, ok := c.products[item.ProductID]; ok {
+				warning = "pick an available variant of this product"
+			}
+
+			view.Items = append(view.Items, types.CartViewItem{
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Warning:   warning,
+			})
+			continue
+		}
+
+		line := types.CartViewItem{
+			ProductID: l.product.ID,
+			VariantID: item.VariantID,
+			SKU:       l.sku,
+			Name:      l.product.Name,
+			Image:     l.product.Image,
+			Price:     l.price,
+			Quantity:  item.Quantity,
+			Subtotal:  l.price.Mul(item.Quantity),
+			InStock:   l.stock,
+		}
+
+		switch {
+		case l.stock == 0:
+			line.Warning = "out of stock"
+		case l.stock < item.Quantity:
+			line.Warning = fmt.Sprintf("only %d left in stock", l.stock)
+		}
+
+		view.Total = view.Total.Add(line.Subtotal)
+		view.Items = append(view.Items, line)
+	}
+
+	return view
+}
+
+// getShippingAddress loads the address the order ships to: the one picked at
+// checkout, or the user's default when none was picked.
+func getShippingAddress(store types.AddressStore, userID int, addressID int) (*types.Address, error) {
+	if addressID == 0 {
+		address, err := store.GetDefaultAddress(userID)
+		if errors.Is(err, types.ErrNotFound) {
+			return nil, fmt.Errorf("no address given and no default address saved")
+		}
+		if err != nil {
+			return nil, errNoCatalog{err}
+		}
+
+		return address, nil
+	}
+
+	address, err := store.GetAddressByID(userID, addressID)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errNoCatalog{err}
+	}
+
+	return address, nil
+}
+
+// checkIfCartIsInStock checks the stock left once the units other customers
+// hold in reserved are set aside can cover the cart.
+func checkIfCartIsInStock(cartItems []types.CartCheckoutItem, c catalog, reserved types.ReservedStock) error {
 	if len(cartItems) == 0 {
 		return fmt.Errorf("cart is empty")
 	}
 
 	for _, item := range cartItems {
-		product, ok := products[item.ProductID]
-		if !ok {
-			return fmt.Errorf("product %d is not available in the store, please refresh your cart", item.ProductID)
+		line, err := c.line(item.ProductID, item.VariantID)
+		if err != nil {
+			return err
 		}
 
-		if product.Quantity < item.Quantity {
-			return fmt.Errorf("product %s is not available in the quantity requested", product.Name)
+		if line.stock-reserved.Of(item.ProductID, item.VariantID) < item.Quantity {
+			return fmt.Errorf("product %s is not available in the quantity requested", line.name())
 		}
 	}
 
 	return nil
 }
 
-func calculateTotalPrice(cartItems []types.CartCheckoutItem, products map[int]types.Product) float64 {
-	var total float64
+// calculateTotalPrice expects the cart to have passed checkIfCartIsInStock.
+func calculateTotalPrice(cartItems []types.CartCheckoutItem, c catalog) types.Money {
+	var total types.Money
 
 	for _, item := range cartItems {
-		product := products[item.ProductID]
-		total += product.Price * float64(item.Quantity)
+		line, _ := c.line(item.ProductID, item.VariantID)
+		total = total.Add(line.price.Mul(item.Quantity))
 	}
 
 	return total
@@ -55,49 +280,497 @@ Calcular o valor total da compra.
 Atualizar os estoques de produtos.
 Criar o pedido no banco de dados e os itens do pedido.
 Retornar o ID do pedido, o valor total da compra e um possível erro.
+
+Os preços são convertidos para a moeda pedida, e a moeda e as taxas usadas
+ficam registradas no pedido e em cada item.
+
+Tudo acontece dentro de uma única transação: se qualquer etapa falhar, o
+estoque e o pedido voltam ao estado anterior. Quando nenhum item é enviado,
+o carrinho salvo do usuário é usado; ele só é esvaziado depois que o pagamento
+é autorizado (veja pay). O endereço escolhido (ou o padrão do usuário) é
+copiado para o pedido. As unidades reservadas pelos carrinhos de outros
+usuários não contam como disponíveis, e o pedido criado reserva as suas até
+ser pago, liberando a reserva do carrinho. Cada item é alocado a um ou mais
+depósitos pela estratégia configurada, e o estoque sai dos depósitos
+escolhidos. Os produtos que a venda deixa no limite de reposição, ou abaixo
+dele, geram um alerta de estoque baixo, enviado depois que o pedido é gravado.
 */
-func (h *Handler) createOrder(products []types.Product, cartItems []types.CartCheckoutItem, userID int) (int, float64, error) {
-	// create a map of products for easier access
-	productsMap := make(map[int]types.Product)
-	for _, product := range products {
-		productsMap[product.ID] = product
+func (h *Handler) createOrder(payload types.CartCheckoutPayload, userID int, currency string) (types.Order, error) {
+	var placed types.Order
+	var alerts []types.LowStockEvent
+
+	cartItems := payload.Items
+
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		productStore := h.store.WithTx(tx)
+		variantStore := h.variantStore.WithTx(tx)
+		orderStore := h.orderStore.WithTx(tx)
+		cartStore := h.cartStore.WithTx(tx)
+		reserver := h.reserver.WithTx(tx)
+
+		address, err := getShippingAddress(h.addressStore.WithTx(tx), userID, payload.AddressID)
+		if err != nil {
+			return err
+		}
+
+		fromStoredCart := len(cartItems) == 0
+		if fromStoredCart {
+			stored, err := cartStore.GetCartItems(userID)
+			if err != nil {
+				return errNoCatalog{err}
+			}
+
+			cartItems = storedCartToCheckoutItems(stored)
+		}
+
+		productIds, err := getCartItemsIDs(cartItems)
+		if err != nil {
+			return err
+		}
+
+		products, err := productStore.GetProductsByID(productIds)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		variants, err := variantStore.GetVariantsByProductIDs(productIds)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		// the rates are read inside the transaction so the order and its
+		// items are priced with the same ones
+		rates, err := h.rateStore.WithTx(tx).GetExchangeRates()
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		cat, err := newCatalog(products, variants).priceIn(currency, types.NewExchangeRates(rates))
+		if err != nil {
+			return err
+		}
+
+		// check if all products are available, leaving out what other carts
+		// hold
+		reserved, err := reserver.Reserved(productIds, userID)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		if err := checkIfCartIsInStock(cartItems, cat, reserved); err != nil {
+			return err
+		}
+
+		// calculate the subtotal, take off the coupon's discount and add the
+		// tax on what is left
+		subtotal := calculateTotalPrice(cartItems, cat)
+
+		var c *types.Coupon
+		discount := coupon.Discount{Lines: make([]types.Money, len(cartItems)), Total: types.NewMoney(0, currency)}
+		if payload.CouponCode != "" {
+			c, discount, err = h.applyCoupon(tx, payload.CouponCode, cartItems, cat)
+			if err != nil {
+				return err
+			}
+		}
+
+		taxes, tax, err := calculateTaxes(h.taxCalculator, address.PostalAddress, cartItems, cat, discount.Lines)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		// shipping is quoted on the subtotal before the discount, like on
+		// GET /cart/shipping-quotes
+		method, shippingCost, err := h.chooseShipping(tx, payload.ShippingMethod, address.PostalAddress, subtotal, cartWeight(cartItems, cat), cat.rates)
+		if err != nil {
+			return err
+		}
+
+		if discount.FreeShipping {
+			shippingCost = types.NewMoney(0, currency)
+		}
+
+		// create order record, along with the rate of its currency
+		exchangeRate, _ := cat.rates.CrossRate(types.DefaultCurrency, currency)
+		placed = types.Order{
+			UserID:          userID,
+			Subtotal:        subtotal,
+			Discount:        discount.Total,
+			Tax:             tax,
+			ShippingCost:    shippingCost,
+			Total:           subtotal.Sub(discount.Total).Add(tax).Add(shippingCost),
+			ExchangeRate:    exchangeRate,
+			FreeShipping:    discount.FreeShipping,
+			Status:          types.OrderStatusPending,
+			Address:         address.PostalAddress.String(),
+			ShippingAddress: address.PostalAddress,
+		}
+		if c != nil {
+			placed.CouponID = &c.ID
+			placed.CouponCode = c.Code
+		}
+		if method != nil {
+			placed.ShippingMethodID = &method.ID
+			placed.ShippingMethod = method.Name
+		}
+
+		orderID, err := orderStore.CreateOrder(placed)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+		placed.ID = orderID
+
+		if err := order.RecordPlaced(orderStore, orderID, &userID); err != nil {
+			return errNoCatalog{err}
+		}
+
+		// create order the items records
+		lines := make([]types.AllocationLine, len(cartItems))
+		for i, item := range cartItems {
+			line, _ := cat.line(item.ProductID, item.VariantID)
+
+			orderItem := types.OrderItem{
+				OrderID:      orderID,
+				ProductID:    item.ProductID,
+				Quantity:     item.Quantity,
+				Price:        line.price,
+				Discount:     discount.Lines[i],
+				Tax:          taxes[i],
+				ListPrice:    line.listPrice,
+				ExchangeRate: line.rate,
+			}
+			if item.VariantID != 0 {
+				orderItem.VariantID = &item.VariantID
+			}
+
+			orderItemID, err := orderStore.CreateOrderItem(orderItem)
+			if err != nil {
+				return errNoCatalog{err}
+			}
+
+			lines[i] = types.AllocationLine{
+				OrderItemID: orderItemID,
+				ProductID:   item.ProductID,
+				VariantID:   orderItem.VariantID,
+				Quantity:    item.Quantity,
+			}
+		}
+
+		// pick the warehouses each item ships from, then reduce the quantity
+		// of products (or of the variants picked) in each of them; the
+		// decrement is conditional so a concurrent checkout can't oversell
+		allocations, err := h.allocate(tx, address.PostalAddress, productIds, lines)
+		if err != nil {
+			return err
+		}
+
+		byItem := make(map[int]types.AllocationLine, len(lines))
+		for _, line := range lines {
+			byItem[line.OrderItemID] = line
+		}
+
+		ledger := h.ledger.WithTx(tx)
+		for i, a := range allocations {
+			line := byItem[a.OrderItemID]
+
+			err := ledger.Move(types.StockMovement{
+				ProductID:   line.ProductID,
+				VariantID:   line.VariantID,
+				WarehouseID: &allocations[i].WarehouseID,
+				Change:      -a.Quantity,
+				Reason:      types.StockSale,
+				ActorID:     &userID,
+				Reference:   order.Reference(orderID),
+			})
+			if err != nil {
+				return errNoCatalog{err}
+			}
+
+			allocations[i].OrderID = orderID
+		}
+
+		if err := h.warehouseStore.WithTx(tx).CreateAllocations(allocations); err != nil {
+			return errNoCatalog{err}
+		}
+
+		alerts, err = lowStock(productStore, orderID, cartItems, productIds)
+		if err != nil {
+			return errNoCatalog{err}
+		}
+
+		// the units the cart held are now taken off the stock; the order
+		// holds them until it is paid
+		if err := reserver.ReleaseCart(userID); err != nil {
+			return errNoCatalog{err}
+		}
+
+		if err := reserver.ReserveOrder(orderID, cartItems); err != nil {
+			return errNoCatalog{err}
+		}
+
+		// the usage limits are checked and the use counted in one statement,
+		// so concurrent checkouts can't redeem the coupon past its limits
+		if c != nil {
+			err := h.couponStore.WithTx(tx).RedeemCoupon(c.ID, userID, orderID)
+			if errors.Is(err, types.ErrConflict) {
+				return fmt.Errorf("coupon %s has reached its usage limit: %w", c.Code, types.ErrConflict)
+			}
+			if err != nil {
+				return errNoCatalog{err}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.Order{}, err
 	}
 
-	// check if all products are available
-	if err := checkIfCartIsInStock(cartItems, productsMap); err != nil {
-		return 0, 0, err
+	// the order is placed whether or not the alerts get through
+	for _, event := range alerts {
+		if err := h.notifier.NotifyLowStock(context.Background(), event); err != nil {
+			log.Printf("failed to report the low stock of product %d: %v", event.ProductID, err)
+		}
 	}
 
-	// calculate total price
-	totalPrice := calculateTotalPrice(cartItems, productsMap)
+	return placed, nil
+}
 
-	// reduce the quantity of products in the store
+// lowStock lists the products the items of an order brought to or under
+// their reorder threshold. Products that were already there before the
+// order don't raise another alert.
+func lowStock(productStore types.ProductStore, orderID int, cartItems []types.CartCheckoutItem, productIDs []int) ([]types.LowStockEvent, error) {
+	sold := make(map[int]int, len(productIDs))
 	for _, item := range cartItems {
-		product := productsMap[item.ProductID]
-		product.Quantity -= item.Quantity
-		h.store.UpdateProduct(product)
+		sold[item.ProductID] += item.Quantity
 	}
 
-	// create order record
-	orderID, err := h.orderStore.CreateOrder(types.Order{
-		UserID:  userID,
-		Total:   totalPrice,
-		Status:  "pending",
-		Address: "some address", // could fetch address from a user addresses table
+	products, err := productStore.GetLowStockProducts(productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []types.LowStockEvent{}
+	for _, p := range products {
+		if p.Quantity+sold[p.ProductID] > p.ReorderThreshold {
+			events = append(events, types.LowStockEvent{LowStockProduct: p, OrderID: orderID})
+		}
+	}
+
+	return events, nil
+}
+
+// allocate decides which warehouses the lines of an order ship from, out of
+// the stock they hold of the products ordered.
+func (h *Handler) allocate(tx *sql.Tx, to types.PostalAddress, productIDs []int, lines []types.AllocationLine) ([]types.OrderAllocation, error) {
+	warehouseStore := h.warehouseStore.WithTx(tx)
+
+	warehouses, err := warehouseStore.GetWarehouses()
+	if err != nil {
+		return nil, errNoCatalog{err}
+	}
+
+	levels, err := warehouseStore.GetInventoryLevels(productIDs)
+	if err != nil {
+		return nil, errNoCatalog{err}
+	}
+
+	return h.allocator.Allocate(to, lines, warehouses, levels)
+}
+
+// pay authorizes the total of a freshly placed order with the payment
+// gateway and records the attempt. An authorized order moves to paid and the
+// stored cart it came from is emptied. Otherwise the order is cancelled and
+// its stock released, while the cart is kept so the customer can try again;
+// the returned error wraps the gateway's, so declines can be told apart.
+func (h *Handler) pay(ctx context.Context, placed *types.Order, userID int, fromStoredCart bool) error {
+	payment := types.Payment{
+		OrderID:  placed.ID,
+		Provider: h.paymentGateway.Name(),
+		Amount:   placed.Total,
+		Status:   types.PaymentAuthorized,
+	}
+
+	reference, authErr := h.paymentGateway.Authorize(ctx, placed.ID, placed.Total)
+	switch {
+	case authErr == nil:
+		payment.Reference = reference
+	case errors.Is(authErr, types.ErrPaymentDeclined):
+		payment.Status, payment.Error = types.PaymentDeclined, authErr.Error()
+	default:
+		payment.Status, payment.Error = types.PaymentFailed, authErr.Error()
+	}
+
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		orderStore := h.orderStore.WithTx(tx)
+
+		if _, err := h.paymentStore.WithTx(tx).CreatePayment(payment); err != nil {
+			return err
+		}
+
+		// cancelling the order also releases the units it held
+		if authErr != nil {
+			return order.Cancel(orderStore, h.ledger.WithTx(tx), h.couponStore.WithTx(tx), h.reservations.WithTx(tx), placed, nil, "payment "+string(payment.Status))
+		}
+
+		// paid, the order no longer needs to hold its units
+		if err := h.reserver.WithTx(tx).ReleaseOrder(placed.ID); err != nil {
+			return err
+		}
+
+		if err := order.Transition(orderStore, placed, types.OrderStatusPaid, nil, "payment authorized"); err != nil {
+			return err
+		}
+
+		if fromStoredCart {
+			return h.cartStore.WithTx(tx).ClearCart(userID)
+		}
+
+		return nil
 	})
 	if err != nil {
-		return 0, 0, err
+		// the order stays pending; don't leave the customer's money held
+		// for it
+		if authErr == nil {
+			if err := h.paymentGateway.Void(ctx, reference); err != nil {
+				log.Printf("failed to void payment %s of order %d: %v", reference, placed.ID, err)
+			}
+		}
+
+		return err
+	}
+
+	if authErr != nil {
+		return fmt.Errorf("order %d was cancelled: %w", placed.ID, authErr)
+	}
+
+	return nil
+}
+
+// calculateTaxes works out the tax on each cart item, once its discount is
+// taken off, and their sum.
+func calculateTaxes(calculator types.TaxCalculator, to types.PostalAddress, cartItems []types.CartCheckoutItem, c catalog, discounts []types.Money) ([]types.Money, types.Money, error) {
+	lines := make([]types.TaxLine, len(cartItems))
+	for i, item := range cartItems {
+		line, _ := c.line(item.ProductID, item.VariantID)
+		lines[i] = types.TaxLine{
+			TaxClass: line.product.TaxClass,
+			Amount:   line.price.Mul(item.Quantity).Sub(discounts[i]),
+		}
+	}
+
+	taxes, err := calculator.Calculate(to, lines)
+	if err != nil {
+		return nil, types.Money{}, err
+	}
+
+	total := types.NewMoney(0, c.currency)
+	for _, tax := range taxes {
+		total = total.Add(tax)
 	}
 
-	// create order the items records
+	return taxes, total, nil
+}
+
+// cartWeight is the shipping weight of cartItems, in grams.
+func cartWeight(cartItems []types.CartCheckoutItem, c catalog) int {
+	weight := 0
 	for _, item := range cartItems {
-		h.orderStore.CreateOrderItem(types.OrderItem{
-			OrderID:   orderID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     productsMap[item.ProductID].Price,
-		})
+		line, _ := c.line(item.ProductID, item.VariantID)
+		weight += line.product.Weight * item.Quantity
+	}
+
+	return weight
+}
+
+// quoteShipping prices shipping an order worth subtotal and weighing weight
+// grams with each of methods that ships to the address.
+func quoteShipping(methods []types.ShippingMethod, to types.PostalAddress, subtotal types.Money, weight int, rates types.ExchangeRates) ([]types.ShippingQuote, error) {
+	quotes := make([]types.ShippingQuote, 0, len(methods))
+	for _, m := range methods {
+		if !shipping.ShipsTo(m, to) {
+			continue
+		}
+
+		cost, err := shipping.Quote(m, subtotal, weight, rates)
+		if err != nil {
+			return nil, err
+		}
+
+		quotes = append(quotes, types.ShippingQuote{MethodID: m.ID, Code: m.Code, Name: m.Name, Cost: cost})
+	}
+
+	return quotes, nil
+}
+
+// chooseShipping prices shipping with the method whose code the client
+// picked. No code is only accepted when no method ships to the address, in
+// which case shipping is free.
+func (h *Handler) chooseShipping(tx *sql.Tx, code string, to types.PostalAddress, subtotal types.Money, weight int, rates types.ExchangeRates) (*types.ShippingMethod, types.Money, error) {
+	methods, err := h.shippingStore.WithTx(tx).GetShippingMethods()
+	if err != nil {
+		return nil, types.Money{}, errNoCatalog{err}
+	}
+
+	for _, m := range methods {
+		if !shipping.ShipsTo(m, to) {
+			continue
+		}
+
+		if code == "" {
+			return nil, types.Money{}, fmt.Errorf("pick a shipping method")
+		}
+
+		if !strings.EqualFold(m.Code, code) {
+			continue
+		}
+
+		cost, err := shipping.Quote(m, subtotal, weight, rates)
+		if err != nil {
+			return nil, types.Money{}, err
+		}
+
+		return &m, cost, nil
+	}
+
+	if code != "" {
+		return nil, types.Money{}, fmt.Errorf("shipping method %s doesn't ship to %s", code, to.Country)
+	}
+
+	return nil, types.NewMoney(0, subtotal.Currency), nil
+}
+
+// applyCoupon looks up the coupon with code and works out its discount on
+// cartItems. The discount of each item is in the same position in the
+// returned Lines.
+func (h *Handler) applyCoupon(tx *sql.Tx, code string, cartItems []types.CartCheckoutItem, cat catalog) (*types.Coupon, coupon.Discount, error) {
+	couponStore := h.couponStore.WithTx(tx)
+
+	c, err := couponStore.GetCouponByCode(code)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, coupon.Discount{}, fmt.Errorf("coupon %s doesn't exist: %w", code, types.ErrNotFound)
+	}
+	if err != nil {
+		return nil, coupon.Discount{}, errNoCatalog{err}
+	}
+
+	lines := make([]coupon.Line, len(cartItems))
+	productIDs := make([]int, len(cartItems))
+	for i, item := range cartItems {
+		line, _ := cat.line(item.ProductID, item.VariantID)
+		lines[i] = coupon.Line{ProductID: item.ProductID, Subtotal: line.price.Mul(item.Quantity)}
+		productIDs[i] = item.ProductID
+	}
+
+	eligible, err := couponStore.GetEligibleProductIDs(c.ID, productIDs)
+	if err != nil {
+		return nil, coupon.Discount{}, errNoCatalog{err}
+	}
+
+	discount, err := coupon.Apply(*c, lines, eligible, cat.currency, cat.rates, time.Now())
+	if err != nil {
+		return nil, coupon.Discount{}, err
 	}
 
-	return orderID, totalPrice, nil
+	return c, discount, nil
 }
@@ -0,0 +1,102 @@
+package cart
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.CartStore {
+	return &Store{db: tx}
+}
+
+// cartItemColumns lists the columns read by scanRowsIntoCartItem, in order.
+const cartItemColumns = "id, userId, productId, variantId, quantity, createdAt, updatedAt"
+
+func (s *Store) GetCartItems(userID int) ([]types.CartItem, error) {
+	rows, err := s.db.Query("SELECT "+cartItemColumns+" FROM cart_items WHERE userId = ? ORDER BY createdAt, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]types.CartItem, 0)
+	for rows.Next() {
+		item, err := scanRowsIntoCartItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, *item)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *Store) AddCartItem(userID int, productID int, variantID int, quantity int) error {
+	_, err := s.db.Exec(
+		"INSERT INTO cart_items (userId, productId, variantId, quantity) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)",
+		userID, productID, variantID, quantity,
+	)
+	return err
+}
+
+func (s *Store) UpdateCartItem(userID int, productID int, variantID int, quantity int) error {
+	_, err := s.db.Exec(
+		"UPDATE cart_items SET quantity = ? WHERE userId = ? AND productId = ? AND variantId = ?",
+		quantity, userID, productID, variantID,
+	)
+	return err
+}
+
+func (s *Store) RemoveCartItem(userID int, productID int, variantID int) error {
+	res, err := s.db.Exec("DELETE FROM cart_items WHERE userId = ? AND productId = ? AND variantId = ?", userID, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("product %d is not in the cart: %w", productID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) ClearCart(userID int) error {
+	_, err := s.db.Exec("DELETE FROM cart_items WHERE userId = ?", userID)
+	return err
+}
+
+func scanRowsIntoCartItem(rows *sql.Rows) (*types.CartItem, error) {
+	item := new(types.CartItem)
+
+	err := rows.Scan(
+		&item.ID,
+		&item.UserID,
+		&item.ProductID,
+		&item.VariantID,
+		&item.Quantity,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
@@ -0,0 +1,255 @@
+package category
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.CategoryStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.CategoryStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/categories", h.handleGetCategories).Methods(http.MethodGet)
+	router.HandleFunc("/categories/{categoryID}", h.handleGetCategory).Methods(http.MethodGet)
+
+	// admin routes
+	router.HandleFunc("/categories", auth.WithJWTAuth(auth.RequireRole(h.handleCreateCategory, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/categories/{categoryID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateCategory, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/categories/{categoryID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteCategory, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+}
+
+// handleGetCategories returns the whole category tree.
+func (h *Handler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.store.GetCategories()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, buildTree(categories))
+}
+
+func (h *Handler) handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := getCategoryIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, err := h.store.GetCategoryByID(categoryID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, category)
+}
+
+func (h *Handler) handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	payload, err := parseCategoryPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var category *types.Category
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if err := checkParentExists(store, payload.ParentID); err != nil {
+			return err
+		}
+
+		categoryID, err := store.CreateCategory(types.Category{Name: payload.Name, ParentID: payload.ParentID})
+		if err != nil {
+			return err
+		}
+
+		category, err = store.GetCategoryByID(categoryID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, category)
+}
+
+func (h *Handler) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := getCategoryIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload, err := parseCategoryPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var category *types.Category
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		// the subtree also tells whether the category exists
+		subtree, err := store.GetSubtreeIDs(categoryID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkParentExists(store, payload.ParentID); err != nil {
+			return err
+		}
+
+		if payload.ParentID != nil && slices.Contains(subtree, *payload.ParentID) {
+			return errInvalidParent{fmt.Errorf("category %d cannot be moved under itself or one of its subcategories", categoryID)}
+		}
+
+		err = store.UpdateCategory(types.Category{ID: categoryID, Name: payload.Name, ParentID: payload.ParentID})
+		if err != nil {
+			return err
+		}
+
+		category, err = store.GetCategoryByID(categoryID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, category)
+}
+
+// handleDeleteCategory deletes a category that has no subcategories. Its
+// products simply stop being listed under it.
+func (h *Handler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := getCategoryIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		subtree, err := store.GetSubtreeIDs(categoryID)
+		if err != nil {
+			return err
+		}
+
+		if len(subtree) > 1 {
+			return fmt.Errorf("category %d still has subcategories %w", categoryID, types.ErrConflict)
+		}
+
+		return store.DeleteCategory(categoryID)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errInvalidParent reports a parent category that doesn't exist or would
+// turn the tree into a cycle.
+type errInvalidParent struct{ error }
+
+func checkParentExists(store types.CategoryStore, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	_, err := store.GetCategoryByID(*parentID)
+	if errors.Is(err, types.ErrNotFound) {
+		return errInvalidParent{fmt.Errorf("parent category %d not found", *parentID)}
+	}
+
+	return err
+}
+
+// buildTree nests the categories under their parents and returns the top
+// level ones, keeping the order they were given in.
+func buildTree(categories []types.Category) []*types.Category {
+	nodes := make(map[int]*types.Category, len(categories))
+	for i := range categories {
+		nodes[categories[i].ID] = &categories[i]
+	}
+
+	roots := []*types.Category{}
+	for i := range categories {
+		c := &categories[i]
+		if c.ParentID != nil {
+			if parent, ok := nodes[*c.ParentID]; ok {
+				parent.Children = append(parent.Children, c)
+				continue
+			}
+		}
+
+		roots = append(roots, c)
+	}
+
+	return roots
+}
+
+func parseCategoryPayload(r *http.Request) (types.CategoryPayload, error) {
+	var payload types.CategoryPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return payload, fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return payload, nil
+}
+
+func getCategoryIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["categoryID"]
+	if !ok {
+		return 0, fmt.Errorf("missing category ID")
+	}
+
+	categoryID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid category ID")
+	}
+
+	return categoryID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalidParent errInvalidParent
+	switch {
+	case errors.As(err, &invalidParent):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,306 @@
+package category
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+func TestCategoryHandlers(t *testing.T) {
+	clothing, shirts := 1, 2
+	newRouter := func() (*mux.Router, *mockCategoryStore) {
+		store := newMockCategoryStore(
+			types.Category{ID: 1, Name: "Clothing"},
+			types.Category{ID: 2, Name: "Shirts", ParentID: &clothing},
+			types.Category{ID: 3, Name: "Polos", ParentID: &shirts},
+			types.Category{ID: 4, Name: "Kitchen"},
+		)
+
+		router := mux.NewRouter()
+		NewHandler(store, newMockUserStore(customer, staff), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should return the category tree", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := send(router, http.MethodGet, "/categories", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var tree []types.Category
+		if err := json.NewDecoder(rr.Body).Decode(&tree); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tree) != 2 || tree[0].Name != "Clothing" || tree[1].Name != "Kitchen" {
+			t.Fatalf("expected Clothing and Kitchen at the top, got %+v", tree)
+		}
+
+		shirts := tree[0].Children
+		if len(shirts) != 1 || len(shirts[0].Children) != 1 || shirts[0].Children[0].Name != "Polos" {
+			t.Errorf("expected Clothing > Shirts > Polos, got %+v", shirts)
+		}
+	})
+
+	t.Run("should return 404 for an unknown category", func(t *testing.T) {
+		router, _ := newRouter()
+
+		if rr := send(router, http.MethodGet, "/categories/99", "", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should only let staff change categories", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodPost, "/categories", `{"name": "Hats"}`, customer),
+			send(router, http.MethodPut, "/categories/4", `{"name": "Home"}`, customer),
+			send(router, http.MethodDelete, "/categories/4", "", nil),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should create a subcategory", func(t *testing.T) {
+		router, store := newRouter()
+
+		rr := send(router, http.MethodPost, "/categories", `{"name": "Hats", "parentID": 1}`, staff)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+		}
+
+		var category types.Category
+		if err := json.NewDecoder(rr.Body).Decode(&category); err != nil {
+			t.Fatal(err)
+		}
+
+		if category.ParentID == nil || *category.ParentID != 1 || store.categories[category.ID] == nil {
+			t.Errorf("expected Hats to be stored under Clothing, got %+v", category)
+		}
+	})
+
+	t.Run("should reject an unknown parent", func(t *testing.T) {
+		router, _ := newRouter()
+
+		if rr := send(router, http.MethodPost, "/categories", `{"name": "Hats", "parentID": 99}`, staff); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should not move a category under its own subtree", func(t *testing.T) {
+		router, store := newRouter()
+
+		for _, payload := range []string{`{"name": "Clothing", "parentID": 3}`, `{"name": "Clothing", "parentID": 1}`} {
+			if rr := send(router, http.MethodPut, "/categories/1", payload, staff); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+
+		if store.categories[1].ParentID != nil {
+			t.Errorf("expected Clothing to stay at the top")
+		}
+
+		if rr := send(router, http.MethodPut, "/categories/3", `{"name": "Polo shirts", "parentID": 1}`, staff); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should only delete categories without subcategories", func(t *testing.T) {
+		router, store := newRouter()
+
+		if rr := send(router, http.MethodDelete, "/categories/2", "", staff); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if rr := send(router, http.MethodDelete, "/categories/3", "", staff); rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if _, ok := store.categories[3]; ok {
+			t.Errorf("expected Polos to be deleted")
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+// mockCategoryStore keeps the categories in memory, listed by name like the
+// real store does.
+type mockCategoryStore struct {
+	categories map[int]*types.Category
+	nextID     int
+}
+
+func newMockCategoryStore(categories ...types.Category) *mockCategoryStore {
+	m := &mockCategoryStore{categories: map[int]*types.Category{}}
+	for _, c := range categories {
+		m.categories[c.ID] = &c
+		m.nextID = max(m.nextID, c.ID)
+	}
+
+	return m
+}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	categories := []types.Category{}
+	for _, c := range m.categories {
+		categories = append(categories, *c)
+	}
+
+	slices.SortFunc(categories, func(a, b types.Category) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return categories, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(categoryID int) (*types.Category, error) {
+	c, ok := m.categories[categoryID]
+	if !ok {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	category := *c
+	return &category, nil
+}
+
+func (m *mockCategoryStore) GetSubtreeIDs(categoryID int) ([]int, error) {
+	if _, ok := m.categories[categoryID]; !ok {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	ids := []int{categoryID}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range m.categories {
+			if c.ParentID != nil && *c.ParentID == ids[i] {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func (m *mockCategoryStore) GetCategoriesByProductIDs(productIDs []int) (map[int][]types.Category, error) {
+	return map[int][]types.Category{}, nil
+}
+
+func (m *mockCategoryStore) CreateCategory(c types.Category) (int, error) {
+	m.nextID++
+	c.ID = m.nextID
+	m.categories[c.ID] = &c
+	return c.ID, nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(c types.Category) error {
+	m.categories[c.ID] = &c
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(categoryID int) error {
+	if _, ok := m.categories[categoryID]; !ok {
+		return fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	delete(m.categories, categoryID)
+	return nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) WithTx(tx *sql.Tx) types.CategoryStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
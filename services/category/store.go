@@ -0,0 +1,199 @@
+package category
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.CategoryStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetCategories() ([]types.Category, error) {
+	rows, err := s.db.Query("SELECT id, name, parentId, createdAt FROM categories ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make([]types.Category, 0)
+	for rows.Next() {
+		c, err := scanRowsIntoCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, *c)
+	}
+
+	return categories, rows.Err()
+}
+
+func (s *Store) GetCategoryByID(categoryID int) (*types.Category, error) {
+	rows, err := s.db.Query("SELECT id, name, parentId, createdAt FROM categories WHERE id = ?", categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	return scanRowsIntoCategory(rows)
+}
+
+func (s *Store) GetSubtreeIDs(categoryID int) ([]int, error) {
+	rows, err := s.db.Query(
+		"WITH RECURSIVE subtree (id) AS ("+
+			"SELECT id FROM categories WHERE id = ? "+
+			"UNION ALL "+
+			"SELECT c.id FROM categories c JOIN subtree ON c.parentId = subtree.id"+
+			") SELECT id FROM subtree",
+		categoryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	return ids, nil
+}
+
+func (s *Store) GetCategoriesByProductIDs(productIDs []int) (map[int][]types.Category, error) {
+	categories := map[int][]types.Category{}
+	if len(productIDs) == 0 {
+		return categories, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(
+		"SELECT pc.productId, c.id, c.name, c.parentId, c.createdAt FROM product_categories pc "+
+			"JOIN categories c ON c.id = pc.categoryId "+
+			"WHERE pc.productId IN (?"+strings.Repeat(",?", len(productIDs)-1)+") ORDER BY c.name, c.id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID int
+		var c types.Category
+		if err := rows.Scan(&productID, &c.ID, &c.Name, &c.ParentID, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		categories[productID] = append(categories[productID], c)
+	}
+
+	return categories, rows.Err()
+}
+
+func (s *Store) CreateCategory(c types.Category) (int, error) {
+	res, err := s.db.Exec("INSERT INTO categories (name, parentId) VALUES (?, ?)", c.Name, c.ParentID)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateCategory(c types.Category) error {
+	_, err := s.db.Exec("UPDATE categories SET name = ?, parentId = ? WHERE id = ?", c.Name, c.ParentID, c.ID)
+	return err
+}
+
+func (s *Store) DeleteCategory(categoryID int) error {
+	res, err := s.db.Exec("DELETE FROM categories WHERE id = ?", categoryID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) SetProductCategories(productID int, categoryIDs []int) error {
+	if _, err := s.db.Exec("DELETE FROM product_categories WHERE productId = ?", productID); err != nil {
+		return err
+	}
+
+	if len(categoryIDs) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(categoryIDs)*2)
+	for _, id := range categoryIDs {
+		args = append(args, productID, id)
+	}
+
+	_, err := s.db.Exec(
+		"INSERT IGNORE INTO product_categories (productId, categoryId) VALUES (?, ?)"+strings.Repeat(", (?, ?)", len(categoryIDs)-1),
+		args...,
+	)
+	return err
+}
+
+func scanRowsIntoCategory(rows *sql.Rows) (*types.Category, error) {
+	c := new(types.Category)
+
+	err := rows.Scan(
+		&c.ID,
+		&c.Name,
+		&c.ParentID,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,156 @@
+package coupon
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// Line is a checkout line as the discount engine sees it: what the whole line
+// costs before any discount, in the currency of the order.
+type Line struct {
+	ProductID int
+	Subtotal  types.Money
+}
+
+// Discount is what a coupon takes off an order. Lines holds the discount of
+// each line, in the order the lines were given, and adds up to Total.
+type Discount struct {
+	Lines        []types.Money
+	Total        types.Money
+	FreeShipping bool
+}
+
+// Apply works out the discount c gives an order made of lines, all priced in
+// currency. eligible lists the products the coupon applies to, and rates
+// convert the amounts of the coupon into currency. It fails when the coupon
+// can't be used on this order; the usage limits are only checked for real
+// when the coupon is redeemed.
+func Apply(c types.Coupon, lines []Line, eligible []int, currency string, rates types.ExchangeRates, now time.Time) (Discount, error) {
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return Discount{}, fmt.Errorf("coupon %s is not active yet", c.Code)
+	}
+
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return Discount{}, fmt.Errorf("coupon %s has expired", c.Code)
+	}
+
+	if c.MaxUses != nil && c.Uses >= *c.MaxUses {
+		return Discount{}, fmt.Errorf("coupon %s has reached its usage limit", c.Code)
+	}
+
+	zero := types.NewMoney(0, currency)
+	subtotal, eligibleSubtotal := zero, zero
+	for _, l := range lines {
+		subtotal = subtotal.Add(l.Subtotal)
+		if slices.Contains(eligible, l.ProductID) {
+			eligibleSubtotal = eligibleSubtotal.Add(l.Subtotal)
+		}
+	}
+
+	if c.MinCartValue != nil {
+		minCartValue, _, err := rates.Convert(*c.MinCartValue, currency)
+		if err != nil {
+			return Discount{}, err
+		}
+
+		if subtotal.Cmp(minCartValue) < 0 {
+			return Discount{}, fmt.Errorf("coupon %s needs a cart of at least %s", c.Code, minCartValue)
+		}
+	}
+
+	if !eligibleSubtotal.IsPositive() {
+		return Discount{}, fmt.Errorf("coupon %s doesn't apply to any product in the cart", c.Code)
+	}
+
+	discount := Discount{Lines: make([]types.Money, len(lines)), Total: zero}
+	for i := range discount.Lines {
+		discount.Lines[i] = zero
+	}
+
+	switch c.Type {
+	case types.CouponPercentage:
+		for i, l := range lines {
+			if slices.Contains(eligible, l.ProductID) {
+				discount.Lines[i] = l.Subtotal.Scale(int64(c.PercentOff), 100)
+			}
+		}
+
+	case types.CouponFixedAmount:
+		if c.AmountOff == nil {
+			return Discount{}, fmt.Errorf("coupon %s has no amount", c.Code)
+		}
+
+		amountOff, _, err := rates.Convert(*c.AmountOff, currency)
+		if err != nil {
+			return Discount{}, err
+		}
+
+		// the coupon can't take more than the products it applies to cost
+		if amountOff.Cmp(eligibleSubtotal) > 0 {
+			amountOff = eligibleSubtotal
+		}
+
+		weights := make([]int64, len(lines))
+		for i, l := range lines {
+			if slices.Contains(eligible, l.ProductID) {
+				weights[i] = l.Subtotal.Amount
+			}
+		}
+
+		for i, share := range allocate(amountOff.Amount, weights) {
+			discount.Lines[i] = types.NewMoney(share, currency)
+		}
+
+	case types.CouponFreeShipping:
+		discount.FreeShipping = true
+
+	default:
+		return Discount{}, fmt.Errorf("coupon %s has an unknown type %q", c.Code, c.Type)
+	}
+
+	for _, d := range discount.Lines {
+		discount.Total = discount.Total.Add(d)
+	}
+
+	return discount, nil
+}
+
+// allocate splits amount in proportion to the weights, which must not all be
+// zero. Each share is rounded down and the minor units left over go to the
+// shares with the largest remainders, earlier ones first on ties, so the
+// shares add up to amount exactly.
+func allocate(amount int64, weights []int64) []int64 {
+	total := new(big.Int)
+	for _, w := range weights {
+		total.Add(total, big.NewInt(w))
+	}
+
+	shares := make([]int64, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	left := amount
+	for i, w := range weights {
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(big.NewInt(amount), big.NewInt(w)), total, new(big.Int))
+		shares[i], remainders[i] = q.Int64(), r
+		left -= shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].Cmp(remainders[order[b]]) > 0
+	})
+
+	for _, i := range order[:left] {
+		shares[i]++
+	}
+
+	return shares
+}
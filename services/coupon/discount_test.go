@@ -0,0 +1,101 @@
+package coupon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	lines := []Line{
+		{ProductID: 1, Subtotal: types.NewMoney(1000, "EUR")},
+		{ProductID: 2, Subtotal: types.NewMoney(2000, "EUR")},
+		{ProductID: 3, Subtotal: types.NewMoney(333, "EUR")},
+	}
+	rates := types.ExchangeRates{"EUR": 50_000_000}
+
+	t.Run("should round each line of a percentage", func(t *testing.T) {
+		c := types.Coupon{Code: "TEN", Type: types.CouponPercentage, PercentOff: 15}
+
+		d, err := Apply(c, lines, []int{1, 3}, "EUR", rates, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 15% of 3.33 is 0.4995, rounded to 0.50
+		want := []int64{150, 0, 50}
+		for i, line := range d.Lines {
+			if line.Amount != want[i] {
+				t.Errorf("line %d: expected %d off, got %d", i, want[i], line.Amount)
+			}
+		}
+
+		if d.Total != types.NewMoney(200, "EUR") {
+			t.Errorf("expected a total of 2.00 EUR, got %s", d.Total)
+		}
+	})
+
+	t.Run("should convert and cap a fixed amount", func(t *testing.T) {
+		amountOff := types.NewMoney(10000, types.DefaultCurrency)
+		c := types.Coupon{Code: "BIG", Type: types.CouponFixedAmount, AmountOff: &amountOff}
+
+		d, err := Apply(c, lines, []int{2}, "EUR", rates, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// USD 100.00 is EUR 50.00, more than the 20.00 line it applies to
+		if d.Total != types.NewMoney(2000, "EUR") || d.Lines[1] != d.Total {
+			t.Errorf("expected the whole eligible line off, got %+v", d)
+		}
+	})
+
+	t.Run("should check the window and the cart value", func(t *testing.T) {
+		later, minCartValue := now.Add(time.Hour), types.NewMoney(10000, types.DefaultCurrency)
+
+		for name, c := range map[string]types.Coupon{
+			"not started":  {Code: "X", Type: types.CouponFreeShipping, StartsAt: &later},
+			"ended":        {Code: "X", Type: types.CouponFreeShipping, EndsAt: &now},
+			"small cart":   {Code: "X", Type: types.CouponFreeShipping, MinCartValue: &minCartValue},
+			"nothing fits": {Code: "X", Type: types.CouponPercentage, PercentOff: 10, ProductIDs: []int{9}},
+		} {
+			eligible := []int{1, 2, 3}
+			if len(c.ProductIDs) > 0 {
+				eligible = nil
+			}
+
+			if _, err := Apply(c, lines, eligible, "EUR", rates, now); err == nil {
+				t.Errorf("%s: expected the coupon to be refused", name)
+			}
+		}
+	})
+}
+
+func TestAllocate(t *testing.T) {
+	for _, tc := range []struct {
+		amount  int64
+		weights []int64
+		want    []int64
+	}{
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{500, []int64{1000, 4000, 0}, []int64{100, 400, 0}},
+		{7, []int64{10, 30}, []int64{2, 5}},
+	} {
+		got := allocate(tc.amount, tc.weights)
+
+		var sum int64
+		for i := range got {
+			sum += got[i]
+			if got[i] != tc.want[i] {
+				t.Errorf("allocate(%d, %v) = %v, want %v", tc.amount, tc.weights, got, tc.want)
+				break
+			}
+		}
+
+		if sum != tc.amount {
+			t.Errorf("allocate(%d, %v) adds up to %d", tc.amount, tc.weights, sum)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package coupon
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store         types.CouponStore
+	productStore  types.ProductStore
+	categoryStore types.CategoryStore
+	rateStore     types.ExchangeRateStore
+	userStore     types.UserStore
+	transactor    types.Transactor
+}
+
+func NewHandler(store types.CouponStore, productStore types.ProductStore, categoryStore types.CategoryStore, rateStore types.ExchangeRateStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, productStore: productStore, categoryStore: categoryStore, rateStore: rateStore, userStore: userStore, transactor: transactor}
+}
+
+// RegisterRoutes only registers admin routes: customers learn about coupons
+// by redeeming them at checkout.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/coupons", auth.WithJWTAuth(auth.RequireRole(h.handleGetCoupons, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/coupons/{couponID}", auth.WithJWTAuth(auth.RequireRole(h.handleGetCoupon, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/coupons", auth.WithJWTAuth(auth.RequireRole(h.handleCreateCoupon, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/coupons/{couponID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateCoupon, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/coupons/{couponID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteCoupon, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetCoupons(w http.ResponseWriter, r *http.Request) {
+	coupons, err := h.store.GetCoupons()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, coupons)
+}
+
+func (h *Handler) handleGetCoupon(w http.ResponseWriter, r *http.Request) {
+	couponID, err := getCouponIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	coupon, err := h.store.GetCouponByID(couponID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, coupon)
+}
+
+func (h *Handler) handleCreateCoupon(w http.ResponseWriter, r *http.Request) {
+	payload, err := parseCouponPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var coupon *types.Coupon
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		c := couponFromPayload(payload)
+		if err := h.checkCoupon(tx, c, 0); err != nil {
+			return err
+		}
+
+		couponID, err := store.CreateCoupon(c)
+		if err != nil {
+			return err
+		}
+
+		coupon, err = store.GetCouponByID(couponID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, coupon)
+}
+
+// handleUpdateCoupon replaces every field of a coupon but its uses so far.
+func (h *Handler) handleUpdateCoupon(w http.ResponseWriter, r *http.Request) {
+	couponID, err := getCouponIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload, err := parseCouponPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var coupon *types.Coupon
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := store.GetCouponByID(couponID); err != nil {
+			return err
+		}
+
+		c := couponFromPayload(payload)
+		c.ID = couponID
+		if err := h.checkCoupon(tx, c, couponID); err != nil {
+			return err
+		}
+
+		if err := store.UpdateCoupon(c); err != nil {
+			return err
+		}
+
+		coupon, err = store.GetCouponByID(couponID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, coupon)
+}
+
+// handleDeleteCoupon deletes a coupon. Orders that redeemed it keep its code.
+func (h *Handler) handleDeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	couponID, err := getCouponIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteCoupon(couponID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errInvalidCoupon reports a coupon that is well formed but can't be stored,
+// such as one scoped to a product that doesn't exist.
+type errInvalidCoupon struct{ error }
+
+// checkCoupon makes sure the code of c isn't taken by another coupon than
+// couponID and that everything c refers to exists.
+func (h *Handler) checkCoupon(tx *sql.Tx, c types.Coupon, couponID int) error {
+	existing, err := h.store.WithTx(tx).GetCouponByCode(c.Code)
+	if err == nil && existing.ID != couponID {
+		return fmt.Errorf("coupon code %s is already taken: %w", c.Code, types.ErrConflict)
+	}
+	if err != nil && !errors.Is(err, types.ErrNotFound) {
+		return err
+	}
+
+	if c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt) {
+		return errInvalidCoupon{fmt.Errorf("endsAt must come after startsAt")}
+	}
+
+	if c.AmountOff != nil && c.MinCartValue != nil && c.AmountOff.Currency != c.MinCartValue.Currency {
+		return errInvalidCoupon{fmt.Errorf("amountOff and minCartValue must be in the same currency")}
+	}
+
+	rates, err := h.rateStore.WithTx(tx).GetExchangeRates()
+	if err != nil {
+		return err
+	}
+
+	for _, amount := range []*types.Money{c.AmountOff, c.MinCartValue} {
+		if amount == nil {
+			continue
+		}
+
+		if _, err := types.NewExchangeRates(rates).CrossRate(amount.Currency, types.DefaultCurrency); err != nil {
+			return errInvalidCoupon{err}
+		}
+	}
+
+	// coupons without products apply to the whole store
+	if len(c.ProductIDs) > 0 {
+		products, err := h.productStore.WithTx(tx).GetProductsByID(c.ProductIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, productID := range c.ProductIDs {
+			if !containsProduct(products, productID) {
+				return errInvalidCoupon{fmt.Errorf("product %d not found", productID)}
+			}
+		}
+	}
+
+	for _, categoryID := range c.CategoryIDs {
+		_, err := h.categoryStore.WithTx(tx).GetCategoryByID(categoryID)
+		if errors.Is(err, types.ErrNotFound) {
+			return errInvalidCoupon{fmt.Errorf("category %d not found", categoryID)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsProduct(products []types.Product, productID int) bool {
+	for _, p := range products {
+		if p.ID == productID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCouponPayload(r *http.Request) (types.CouponPayload, error) {
+	var payload types.CouponPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return payload, fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return payload, nil
+}
+
+// couponFromPayload only keeps the fields that make sense for the type of
+// coupon.
+func couponFromPayload(payload types.CouponPayload) types.Coupon {
+	c := types.Coupon{
+		Code:           strings.ToUpper(payload.Code),
+		Type:           payload.Type,
+		MinCartValue:   payload.MinCartValue,
+		MaxUses:        payload.MaxUses,
+		MaxUsesPerUser: payload.MaxUsesPerUser,
+		StartsAt:       payload.StartsAt,
+		EndsAt:         payload.EndsAt,
+		ProductIDs:     payload.ProductIDs,
+		CategoryIDs:    payload.CategoryIDs,
+	}
+
+	switch payload.Type {
+	case types.CouponPercentage:
+		c.PercentOff = payload.PercentOff
+	case types.CouponFixedAmount:
+		c.AmountOff = payload.AmountOff
+	}
+
+	return c
+}
+
+func getCouponIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["couponID"]
+	if !ok {
+		return 0, fmt.Errorf("missing coupon ID")
+	}
+
+	couponID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coupon ID")
+	}
+
+	return couponID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalidCoupon errInvalidCoupon
+	switch {
+	case errors.As(err, &invalidCoupon):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,416 @@
+package coupon
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+func TestCouponHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockCouponStore) {
+		store := newMockCouponStore(types.Coupon{ID: 1, Code: "WELCOME", Type: types.CouponPercentage, PercentOff: 10})
+		rates := &mockExchangeRateStore{rates: types.ExchangeRates{"EUR": 92_000_000}}
+
+		router := mux.NewRouter()
+		NewHandler(store, &mockProductStore{}, &mockCategoryStore{}, rates, newMockUserStore(customer, staff), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should only let staff see and change coupons", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodGet, "/coupons", "", customer),
+			send(router, http.MethodPost, "/coupons", `{"code": "FREE", "type": "free_shipping"}`, customer),
+			send(router, http.MethodDelete, "/coupons/1", "", nil),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should create a coupon with an upper case code", func(t *testing.T) {
+		router, store := newRouter()
+
+		payload := `{"code": "spring5", "type": "fixed_amount", "amountOff": {"amount": 500, "currency": "EUR"}, "productIDs": [1], "categoryIDs": [3]}`
+		rr := send(router, http.MethodPost, "/coupons", payload, staff)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var coupon types.Coupon
+		if err := json.NewDecoder(rr.Body).Decode(&coupon); err != nil {
+			t.Fatal(err)
+		}
+
+		if coupon.Code != "SPRING5" || coupon.AmountOff == nil || *coupon.AmountOff != types.NewMoney(500, "EUR") {
+			t.Errorf("unexpected coupon %+v", coupon)
+		}
+
+		if _, err := store.GetCouponByCode("spring5"); err != nil {
+			t.Errorf("expected the coupon to be stored: %v", err)
+		}
+	})
+
+	t.Run("should create and update a store-wide coupon", func(t *testing.T) {
+		router, _ := newRouter()
+
+		if rr := send(router, http.MethodPost, "/coupons", `{"code": "ALL10", "type": "percentage", "percentOff": 10}`, staff); rr.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		if rr := send(router, http.MethodPut, "/coupons/1", `{"code": "WELCOME", "type": "percentage", "percentOff": 20, "productIDs": []}`, staff); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should reject invalid coupons", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for name, payload := range map[string]string{
+			"missing percentage":    `{"code": "HALF", "type": "percentage"}`,
+			"percentage over 100":   `{"code": "HALF", "type": "percentage", "percentOff": 150}`,
+			"missing amount":        `{"code": "HALF", "type": "fixed_amount"}`,
+			"currency with no rate": `{"code": "HALF", "type": "fixed_amount", "amountOff": {"amount": 500, "currency": "GBP"}}`,
+			"mixed currencies":      `{"code": "HALF", "type": "fixed_amount", "amountOff": {"amount": 500, "currency": "EUR"}, "minCartValue": {"amount": 5000, "currency": "USD"}}`,
+			"unknown product":       `{"code": "HALF", "type": "free_shipping", "productIDs": [99]}`,
+			"unknown category":      `{"code": "HALF", "type": "free_shipping", "categoryIDs": [99]}`,
+			"window ends first":     `{"code": "HALF", "type": "free_shipping", "startsAt": "2026-06-01T00:00:00Z", "endsAt": "2026-05-01T00:00:00Z"}`,
+		} {
+			if rr := send(router, http.MethodPost, "/coupons", payload, staff); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should not reuse a code", func(t *testing.T) {
+		router, _ := newRouter()
+
+		if rr := send(router, http.MethodPost, "/coupons", `{"code": "welcome", "type": "free_shipping"}`, staff); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		// a coupon keeps its own code when updated
+		if rr := send(router, http.MethodPut, "/coupons/1", `{"code": "WELCOME", "type": "percentage", "percentOff": 15}`, staff); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should return 404 for an unknown coupon", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodGet, "/coupons/99", "", staff),
+			send(router, http.MethodPut, "/coupons/99", `{"code": "OTHER", "type": "free_shipping"}`, staff),
+			send(router, http.MethodDelete, "/coupons/99", "", staff),
+		} {
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should delete a coupon", func(t *testing.T) {
+		router, store := newRouter()
+
+		if rr := send(router, http.MethodDelete, "/coupons/1", "", staff); rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if len(store.coupons) != 0 {
+			t.Errorf("expected the coupon to be gone, got %+v", store.coupons)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+type mockCouponStore struct {
+	coupons map[int]*types.Coupon
+	nextID  int
+}
+
+func newMockCouponStore(coupons ...types.Coupon) *mockCouponStore {
+	m := &mockCouponStore{coupons: map[int]*types.Coupon{}}
+	for _, c := range coupons {
+		m.coupons[c.ID] = &c
+		m.nextID = max(m.nextID, c.ID)
+	}
+
+	return m
+}
+
+func (m *mockCouponStore) GetCoupons() ([]types.Coupon, error) {
+	coupons := []types.Coupon{}
+	for _, c := range m.coupons {
+		coupons = append(coupons, *c)
+	}
+
+	return coupons, nil
+}
+
+func (m *mockCouponStore) GetCouponByID(couponID int) (*types.Coupon, error) {
+	c, ok := m.coupons[couponID]
+	if !ok {
+		return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+	}
+
+	coupon := *c
+	return &coupon, nil
+}
+
+func (m *mockCouponStore) GetCouponByCode(code string) (*types.Coupon, error) {
+	for _, c := range m.coupons {
+		if strings.EqualFold(c.Code, code) {
+			coupon := *c
+			return &coupon, nil
+		}
+	}
+
+	return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) CreateCoupon(c types.Coupon) (int, error) {
+	m.nextID++
+	c.ID = m.nextID
+	c.Code = strings.ToUpper(c.Code)
+	m.coupons[c.ID] = &c
+	return c.ID, nil
+}
+
+func (m *mockCouponStore) UpdateCoupon(c types.Coupon) error {
+	c.Code = strings.ToUpper(c.Code)
+	m.coupons[c.ID] = &c
+	return nil
+}
+
+func (m *mockCouponStore) DeleteCoupon(couponID int) error {
+	if _, ok := m.coupons[couponID]; !ok {
+		return fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+	}
+
+	delete(m.coupons, couponID)
+	return nil
+}
+
+func (m *mockCouponStore) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	return productIDs, nil
+}
+
+func (m *mockCouponStore) RedeemCoupon(couponID int, userID int, orderID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) ReleaseRedemption(orderID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) WithTx(tx *sql.Tx) types.CouponStore {
+	return m
+}
+
+// mockProductStore knows products 1 to 3.
+type mockProductStore struct{}
+
+func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
+	return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+}
+
+// GetProductsByID fails on an empty list, which the real store can't build
+// a query for.
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no product ids to look up")
+	}
+
+	products := []types.Product{}
+	for _, id := range ids {
+		if id >= 1 && id <= 3 {
+			products = append(products, types.Product{ID: id})
+		}
+	}
+
+	return products, nil
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	return []*types.Product{}, 0, nil
+}
+
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	return []types.ProductSearchResult{}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	return []types.LowStockProduct{}, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+// mockCategoryStore knows categories 1 to 3.
+type mockCategoryStore struct{}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	return []types.Category{}, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(categoryID int) (*types.Category, error) {
+	if categoryID < 1 || categoryID > 3 {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	return &types.Category{ID: categoryID}, nil
+}
+
+func (m *mockCategoryStore) GetSubtreeIDs(categoryID int) ([]int, error) {
+	return []int{categoryID}, nil
+}
+
+func (m *mockCategoryStore) GetCategoriesByProductIDs(productIDs []int) (map[int][]types.Category, error) {
+	return map[int][]types.Category{}, nil
+}
+
+func (m *mockCategoryStore) CreateCategory(c types.Category) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(c types.Category) error {
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(categoryID int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) WithTx(tx *sql.Tx) types.CategoryStore {
+	return m
+}
+
+type mockExchangeRateStore struct {
+	rates types.ExchangeRates
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,383 @@
+package coupon
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.CouponStore {
+	return &Store{db: tx}
+}
+
+// couponColumns lists the columns read by scanRowsIntoCoupon, in order.
+const couponColumns = "id, code, type, percentOff, currency, amountOff, minCartValue, maxUses, maxUsesPerUser, uses, startsAt, endsAt, createdAt"
+
+func (s *Store) GetCoupons() ([]types.Coupon, error) {
+	return s.getCoupons("SELECT " + couponColumns + " FROM coupons ORDER BY code")
+}
+
+func (s *Store) GetCouponByID(couponID int) (*types.Coupon, error) {
+	coupons, err := s.getCoupons("SELECT "+couponColumns+" FROM coupons WHERE id = ?", couponID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(coupons) == 0 {
+		return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+	}
+
+	return &coupons[0], nil
+}
+
+func (s *Store) GetCouponByCode(code string) (*types.Coupon, error) {
+	coupons, err := s.getCoupons("SELECT "+couponColumns+" FROM coupons WHERE code = ?", strings.ToUpper(code))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(coupons) == 0 {
+		return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+	}
+
+	return &coupons[0], nil
+}
+
+// getCoupons runs a query selecting couponColumns and fills in the scope of
+// every coupon it returns.
+func (s *Store) getCoupons(query string, args ...any) ([]types.Coupon, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupons := make([]types.Coupon, 0)
+	for rows.Next() {
+		c, err := scanRowsIntoCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		coupons = append(coupons, *c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(coupons) == 0 {
+		return coupons, nil
+	}
+
+	index := make(map[int]*types.Coupon, len(coupons))
+	ids := make([]any, len(coupons))
+	for i := range coupons {
+		index[coupons[i].ID] = &coupons[i]
+		ids[i] = coupons[i].ID
+	}
+
+	placeholders := strings.Repeat(",?", len(ids)-1)
+	scopes := []struct {
+		query string
+		add   func(c *types.Coupon, id int)
+	}{
+		{
+			"SELECT couponId, productId FROM coupon_products WHERE couponId IN (?" + placeholders + ") ORDER BY productId",
+			func(c *types.Coupon, id int) { c.ProductIDs = append(c.ProductIDs, id) },
+		},
+		{
+			"SELECT couponId, categoryId FROM coupon_categories WHERE couponId IN (?" + placeholders + ") ORDER BY categoryId",
+			func(c *types.Coupon, id int) { c.CategoryIDs = append(c.CategoryIDs, id) },
+		},
+	}
+
+	for _, scope := range scopes {
+		if err := s.loadScope(scope.query, ids, index, scope.add); err != nil {
+			return nil, err
+		}
+	}
+
+	return coupons, nil
+}
+
+func (s *Store) loadScope(query string, ids []any, index map[int]*types.Coupon, add func(c *types.Coupon, id int)) error {
+	rows, err := s.db.Query(query, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var couponID, id int
+		if err := rows.Scan(&couponID, &id); err != nil {
+			return err
+		}
+
+		add(index[couponID], id)
+	}
+
+	return rows.Err()
+}
+
+func (s *Store) CreateCoupon(c types.Coupon) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO coupons (code, type, percentOff, currency, amountOff, minCartValue, maxUses, maxUsesPerUser, startsAt, endsAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		strings.ToUpper(c.Code), c.Type, percentOff(c), couponCurrency(c), c.AmountOff, c.MinCartValue, c.MaxUses, c.MaxUsesPerUser, c.StartsAt, c.EndsAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.setScope(int(id), c); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// UpdateCoupon leaves the number of uses alone.
+func (s *Store) UpdateCoupon(c types.Coupon) error {
+	res, err := s.db.Exec(
+		"UPDATE coupons SET code = ?, type = ?, percentOff = ?, currency = ?, amountOff = ?, minCartValue = ?, maxUses = ?, maxUsesPerUser = ?, startsAt = ?, endsAt = ? WHERE id = ?",
+		strings.ToUpper(c.Code), c.Type, percentOff(c), couponCurrency(c), c.AmountOff, c.MinCartValue, c.MaxUses, c.MaxUsesPerUser, c.StartsAt, c.EndsAt, c.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// an update that changes nothing also affects no rows, so check the
+	// coupon exists before treating it as missing
+	if affected == 0 {
+		if _, err := s.GetCouponByID(c.ID); err != nil {
+			return err
+		}
+	}
+
+	return s.setScope(c.ID, c)
+}
+
+// setScope replaces the products and categories the coupon is limited to.
+func (s *Store) setScope(couponID int, c types.Coupon) error {
+	scopes := []struct {
+		table, column string
+		ids           []int
+	}{
+		{"coupon_products", "productId", c.ProductIDs},
+		{"coupon_categories", "categoryId", c.CategoryIDs},
+	}
+
+	for _, scope := range scopes {
+		if _, err := s.db.Exec("DELETE FROM "+scope.table+" WHERE couponId = ?", couponID); err != nil {
+			return err
+		}
+
+		if len(scope.ids) == 0 {
+			continue
+		}
+
+		args := make([]any, 0, len(scope.ids)*2)
+		for _, id := range scope.ids {
+			args = append(args, couponID, id)
+		}
+
+		_, err := s.db.Exec(
+			"INSERT IGNORE INTO "+scope.table+" (couponId, "+scope.column+") VALUES (?, ?)"+strings.Repeat(", (?, ?)", len(scope.ids)-1),
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteCoupon(couponID int) error {
+	res, err := s.db.Exec("DELETE FROM coupons WHERE id = ?", couponID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	if len(productIDs) == 0 {
+		return []int{}, nil
+	}
+
+	args := []any{couponID}
+	for _, id := range productIDs {
+		args = append(args, id)
+	}
+	args = append(args, couponID, couponID)
+
+	// the categories of the coupon are expanded to their whole subtrees
+	rows, err := s.db.Query(
+		"WITH RECURSIVE scope (id) AS ("+
+			"SELECT categoryId FROM coupon_categories WHERE couponId = ? "+
+			"UNION ALL "+
+			"SELECT c.id FROM categories c JOIN scope ON c.parentId = scope.id"+
+			") SELECT p.id FROM products p WHERE p.id IN (?"+strings.Repeat(",?", len(productIDs)-1)+") AND ("+
+			"NOT EXISTS (SELECT 1 FROM coupon_products WHERE couponId = ?) AND NOT EXISTS (SELECT 1 FROM scope) "+
+			"OR p.id IN (SELECT productId FROM coupon_products WHERE couponId = ?) "+
+			"OR p.id IN (SELECT pc.productId FROM product_categories pc JOIN scope ON pc.categoryId = scope.id)"+
+			") ORDER BY p.id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) RedeemCoupon(couponID int, userID int, orderID int) error {
+	// the update locks the coupon row until the transaction ends, so
+	// concurrent checkouts redeeming the same coupon take turns, and each one
+	// sees the uses and redemptions of those that committed before it
+	res, err := s.db.Exec(
+		"UPDATE coupons SET uses = uses + 1 WHERE id = ? "+
+			"AND (maxUses IS NULL OR uses < maxUses) "+
+			"AND (maxUsesPerUser IS NULL OR maxUsesPerUser > (SELECT COUNT(*) FROM coupon_redemptions WHERE couponId = ? AND userId = ?))",
+		couponID, couponID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("coupon %d has reached its usage limit: %w", couponID, types.ErrConflict)
+	}
+
+	_, err = s.db.Exec("INSERT INTO coupon_redemptions (couponId, userId, orderId) VALUES (?, ?, ?)", couponID, userID, orderID)
+	return err
+}
+
+func (s *Store) ReleaseRedemption(orderID int) error {
+	_, err := s.db.Exec(
+		"UPDATE coupons c JOIN coupon_redemptions r ON r.couponId = c.id SET c.uses = c.uses - 1 WHERE r.orderId = ?",
+		orderID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DELETE FROM coupon_redemptions WHERE orderId = ?", orderID)
+	return err
+}
+
+// percentOff is NULL for coupons that aren't percentages.
+func percentOff(c types.Coupon) any {
+	if c.Type != types.CouponPercentage {
+		return nil
+	}
+
+	return c.PercentOff
+}
+
+// couponCurrency is the currency of the amounts of the coupon.
+func couponCurrency(c types.Coupon) string {
+	switch {
+	case c.AmountOff != nil:
+		return c.AmountOff.Currency
+	case c.MinCartValue != nil:
+		return c.MinCartValue.Currency
+	default:
+		return types.DefaultCurrency
+	}
+}
+
+func scanRowsIntoCoupon(rows *sql.Rows) (*types.Coupon, error) {
+	c := new(types.Coupon)
+
+	var percentOff sql.NullInt64
+	var currency string
+	var amountOff, minCartValue sql.NullString
+	err := rows.Scan(
+		&c.ID,
+		&c.Code,
+		&c.Type,
+		&percentOff,
+		&currency,
+		&amountOff,
+		&minCartValue,
+		&c.MaxUses,
+		&c.MaxUsesPerUser,
+		&c.Uses,
+		&c.StartsAt,
+		&c.EndsAt,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.PercentOff = int(percentOff.Int64)
+	c.ProductIDs, c.CategoryIDs = []int{}, []int{}
+
+	for _, amount := range []struct {
+		dst **types.Money
+		src sql.NullString
+	}{{&c.AmountOff, amountOff}, {&c.MinCartValue, minCartValue}} {
+		if !amount.src.Valid {
+			continue
+		}
+
+		m, err := types.ParseMoney(amount.src.String, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		*amount.dst = &m
+	}
+
+	return c, nil
+}
@@ -0,0 +1,129 @@
+package currency
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.ExchangeRateStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.ExchangeRateStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/exchange-rates", h.handleGetExchangeRates).Methods(http.MethodGet)
+
+	// admin routes
+	router.HandleFunc("/exchange-rates", auth.WithJWTAuth(auth.RequireRole(h.handleSetExchangeRates, types.RoleAdmin), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/exchange-rates/{currency}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteExchangeRate, types.RoleAdmin), h.userStore)).Methods(http.MethodDelete)
+}
+
+// handleGetExchangeRates lists the rates of every currency prices can be
+// shown in besides the default one.
+func (h *Handler) handleGetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.store.GetExchangeRates()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+// handleSetExchangeRates adds or replaces the rates in the payload, all of
+// them or none. Currencies left out keep their current rate.
+func (h *Handler) handleSetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	var payload types.ExchangeRatesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	for currency := range payload.Rates {
+		if err := checkCurrency(currency); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var rates []types.ExchangeRate
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if err := store.SetExchangeRates(payload.Rates); err != nil {
+			return err
+		}
+
+		var err error
+		rates, err = store.GetExchangeRates()
+		return err
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+// handleDeleteExchangeRate stops a currency from being offered. It is
+// refused while products are still priced in it.
+func (h *Handler) handleDeleteExchangeRate(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+	if err := checkCurrency(currency); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteExchangeRate(currency); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkCurrency accepts the currencies that can have a rate: the known ones
+// other than the default, whose rate is always 1.
+func checkCurrency(currency string) error {
+	if currency == types.DefaultCurrency {
+		return fmt.Errorf("%s is the default currency, its rate is always 1", currency)
+	}
+
+	if !types.KnownCurrency(currency) {
+		return fmt.Errorf("unknown currency %q", currency)
+	}
+
+	return nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,234 @@
+package currency
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	staff = &types.User{ID: 2, Role: types.RoleStaff}
+	admin = &types.User{ID: 3, Role: types.RoleAdmin}
+)
+
+func TestExchangeRateHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockExchangeRateStore) {
+		store := &mockExchangeRateStore{
+			rates:   map[string]types.Rate{"EUR": 92_000_000, "BRL": 540_000_000},
+			inUseBy: map[string]bool{"BRL": true},
+		}
+
+		router := mux.NewRouter()
+		NewHandler(store, newMockUserStore(staff, admin), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should list the rates", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := send(router, http.MethodGet, "/exchange-rates", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var rates []types.ExchangeRate
+		if err := json.NewDecoder(rr.Body).Decode(&rates); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rates) != 2 || rates[0].Currency != "BRL" || rates[0].Rate != 540_000_000 {
+			t.Errorf("expected the BRL and EUR rates, got %+v", rates)
+		}
+	})
+
+	t.Run("should only let admins change the rates", func(t *testing.T) {
+		router, store := newRouter()
+
+		for _, user := range []*types.User{nil, staff} {
+			if rr := send(router, http.MethodPut, "/exchange-rates", `{"rates": {"EUR": "0.95"}}`, user); rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+
+		if store.rates["EUR"] != 92_000_000 {
+			t.Errorf("expected the EUR rate to be left alone, got %s", store.rates["EUR"])
+		}
+	})
+
+	t.Run("should set rates given as strings or numbers", func(t *testing.T) {
+		router, store := newRouter()
+
+		rr := send(router, http.MethodPut, "/exchange-rates", `{"rates": {"EUR": "0.95", "GBP": 0.79}}`, admin)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if store.rates["EUR"] != 95_000_000 || store.rates["GBP"] != 79_000_000 || store.rates["BRL"] != 540_000_000 {
+			t.Errorf("expected EUR and GBP to be set and BRL kept, got %v", store.rates)
+		}
+	})
+
+	t.Run("should reject invalid rates", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, payload := range []string{
+			`{"rates": {}}`,
+			`{"rates": {"EUR": "0"}}`,
+			`{"rates": {"EUR": "-1"}}`,
+			`{"rates": {"EUR": "0.123456789"}}`,
+			`{"rates": {"USD": "1"}}`,
+			`{"rates": {"XYZ": "1"}}`,
+		} {
+			if rr := send(router, http.MethodPut, "/exchange-rates", payload, admin); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should delete a rate unless products use it", func(t *testing.T) {
+		router, store := newRouter()
+
+		if rr := send(router, http.MethodDelete, "/exchange-rates/eur", "", admin); rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if _, ok := store.rates["EUR"]; ok {
+			t.Errorf("expected the EUR rate to be deleted")
+		}
+
+		if rr := send(router, http.MethodDelete, "/exchange-rates/BRL", "", admin); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if rr := send(router, http.MethodDelete, "/exchange-rates/GBP", "", admin); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+// mockExchangeRateStore keeps the rates in memory, along with the currencies
+// products are priced in.
+type mockExchangeRateStore struct {
+	rates   map[string]types.Rate
+	inUseBy map[string]bool
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Currency < rates[j].Currency
+	})
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	for currency, rate := range rates {
+		m.rates[currency] = rate
+	}
+
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	if m.inUseBy[currency] {
+		return fmt.Errorf("products are still priced in %s: %w", currency, types.ErrConflict)
+	}
+
+	if _, ok := m.rates[currency]; !ok {
+		return fmt.Errorf("exchange rate for %s %w", currency, types.ErrNotFound)
+	}
+
+	delete(m.rates, currency)
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
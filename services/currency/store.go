@@ -0,0 +1,87 @@
+package currency
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rows, err := s.db.Query("SELECT currency, rate, updatedAt FROM exchange_rates ORDER BY currency")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make([]types.ExchangeRate, 0)
+	for rows.Next() {
+		var rate types.ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (s *Store) SetExchangeRates(rates map[string]types.Rate) error {
+	for currency, rate := range rates {
+		_, err := s.db.Exec(
+			"INSERT INTO exchange_rates (currency, rate) VALUES (?, ?) ON DUPLICATE KEY UPDATE rate = VALUES(rate)",
+			currency, rate,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteExchangeRate(currency string) error {
+	var inUse bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM products WHERE currency = ? AND deletedAt IS NULL) "+
+			"OR EXISTS (SELECT 1 FROM product_variants WHERE currency = ? AND deletedAt IS NULL)",
+		currency, currency,
+	).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+
+	if inUse {
+		return fmt.Errorf("products are still priced in %s: %w", currency, types.ErrConflict)
+	}
+
+	res, err := s.db.Exec("DELETE FROM exchange_rates WHERE currency = ?", currency)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("exchange rate for %s %w", currency, types.ErrNotFound)
+	}
+
+	return nil
+}
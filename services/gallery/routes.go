@@ -0,0 +1,404 @@
+package gallery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+const (
+	// maxImageSize bounds each uploaded file, in bytes.
+	maxImageSize = 5 << 20
+	// maxImagesPerUpload bounds the files sent in a single request.
+	maxImagesPerUpload = 10
+	// maxGallerySize bounds the images of a single product.
+	maxGallerySize = 20
+)
+
+type Handler struct {
+	store        types.ImageStore
+	productStore types.ProductStore
+	blobStore    types.BlobStore
+	userStore    types.UserStore
+	transactor   types.Transactor
+}
+
+func NewHandler(store types.ImageStore, productStore types.ProductStore, blobStore types.BlobStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, productStore: productStore, blobStore: blobStore, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{productID}/images", h.handleGetImages).Methods(http.MethodGet)
+
+	// admin routes
+	router.HandleFunc("/products/{productID}/images", auth.WithJWTAuth(auth.RequireRole(h.handleUploadImages, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products/{productID}/images/order", auth.WithJWTAuth(auth.RequireRole(h.handleReorderImages, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/products/{productID}/images/{imageID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteImage, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.productStore.GetProductByID(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	images, err := getGallery(h.store, productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, images)
+}
+
+// handleUploadImages takes one or more files in the "images" field of a
+// multipart form and appends them to the product's gallery, in the order they
+// were sent. It answers with the whole gallery.
+func (h *Handler) handleUploadImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// leave some room for the multipart headers on top of the files
+	r.Body = http.MaxBytesReader(w, r.Body, maxImagesPerUpload*maxImageSize+1<<20)
+	if err := r.ParseMultipartForm(maxImageSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload larger than %d bytes", tooLarge.Limit))
+			return
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing images"))
+		return
+	}
+
+	if len(files) > maxImagesPerUpload {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("at most %d images can be uploaded at once", maxImagesPerUpload))
+		return
+	}
+
+	if _, err := h.productStore.GetProductByID(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// validate every file before storing any of them
+	uploads := make([]*upload, len(files))
+	for i, fh := range files {
+		if fh.Size > maxImageSize {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("image %s is larger than %d bytes", fh.Filename, maxImageSize))
+			return
+		}
+
+		if uploads[i], err = readUpload(fh); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("image %s: %v", fh.Filename, err))
+			return
+		}
+	}
+
+	images, stored, err := h.storeUploads(productID, uploads)
+	if err != nil {
+		h.deleteBlobs(stored...)
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, images)
+}
+
+// storeUploads puts the files in the blob store and adds them to the
+// gallery. It returns the keys it stored even when it fails, so the caller can
+// remove them.
+func (h *Handler) storeUploads(productID int, uploads []*upload) ([]types.ProductImage, []string, error) {
+	var stored []string
+	images := make([]types.ProductImage, len(uploads))
+	for i, u := range uploads {
+		name, err := randomName()
+		if err != nil {
+			return nil, stored, err
+		}
+
+		images[i] = types.ProductImage{
+			ProductID:    productID,
+			BlobKey:      fmt.Sprintf("products/%d/%s.%s", productID, name, imageTypes[u.contentType]),
+			ThumbnailKey: fmt.Sprintf("products/%d/%s_thumb.%s", productID, name, u.thumbnailExt),
+			ContentType:  u.contentType,
+			Width:        u.width,
+			Height:       u.height,
+		}
+
+		if images[i].URL, err = h.blobStore.Put(images[i].BlobKey, bytes.NewReader(u.data)); err != nil {
+			return nil, stored, err
+		}
+		stored = append(stored, images[i].BlobKey)
+
+		if images[i].ThumbnailURL, err = h.blobStore.Put(images[i].ThumbnailKey, bytes.NewReader(u.thumbnail)); err != nil {
+			return nil, stored, err
+		}
+		stored = append(stored, images[i].ThumbnailKey)
+	}
+
+	var gallery []types.ProductImage
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		existing, err := getGallery(store, productID)
+		if err != nil {
+			return err
+		}
+
+		if len(existing)+len(images) > maxGallerySize {
+			return fmt.Errorf("product %d can't hold more than %d images: %w", productID, maxGallerySize, types.ErrConflict)
+		}
+
+		for _, img := range images {
+			if _, err := store.CreateImage(img); err != nil {
+				return err
+			}
+		}
+
+		gallery, err = getGallery(store, productID)
+		return err
+	})
+
+	return gallery, stored, err
+}
+
+// handleReorderImages moves the images of a product to the order given. The
+// first image becomes the primary one.
+func (h *Handler) handleReorderImages(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ReorderImagesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	var gallery []types.ProductImage
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := h.productStore.WithTx(tx).GetProductByID(productID); err != nil {
+			return err
+		}
+
+		existing, err := getGallery(store, productID)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int, len(existing))
+		for i, img := range existing {
+			ids[i] = img.ID
+		}
+
+		order := slices.Clone(payload.ImageIDs)
+		slices.Sort(ids)
+		slices.Sort(order)
+		if !slices.Equal(ids, order) {
+			return errInvalidOrder{fmt.Errorf("the new order must list each image of product %d once", productID)}
+		}
+
+		if err := store.ReorderImages(productID, payload.ImageIDs); err != nil {
+			return err
+		}
+
+		gallery, err = getGallery(store, productID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, gallery)
+}
+
+func (h *Handler) handleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	imageID, err := getIDFromPath(r, "imageID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var img *types.ProductImage
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		img, err = store.GetImageByID(productID, imageID)
+		if err != nil {
+			return err
+		}
+
+		return store.DeleteImage(productID, imageID)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// the image is gone from the gallery either way, a file left behind only
+	// wastes some space
+	h.deleteBlobs(img.BlobKey, img.ThumbnailKey)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		if err := h.blobStore.Delete(key); err != nil {
+			log.Printf("failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// upload is a validated image file along with its thumbnail.
+type upload struct {
+	data         []byte
+	contentType  string
+	width        int
+	height       int
+	thumbnail    []byte
+	thumbnailExt string
+}
+
+func readUpload(fh *multipart.FileHeader) (*upload, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxImageSize)
+	}
+
+	decoded, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, ext, err := decoded.thumbnail()
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.img.Bounds()
+	return &upload{
+		data:         data,
+		contentType:  decoded.contentType,
+		width:        bounds.Dx(),
+		height:       bounds.Dy(),
+		thumbnail:    thumbnail,
+		thumbnailExt: ext,
+	}, nil
+}
+
+// getGallery returns the images of a single product, never nil.
+func getGallery(store types.ImageStore, productID int) ([]types.ProductImage, error) {
+	images, err := store.GetImagesByProductIDs([]int{productID})
+	if err != nil {
+		return nil, err
+	}
+
+	if images[productID] == nil {
+		return []types.ProductImage{}, nil
+	}
+
+	return images[productID], nil
+}
+
+// randomName returns a name that can't be guessed from the product or the
+// uploaded file.
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// errInvalidOrder reports a new gallery order that doesn't list exactly the
+// images of the product.
+type errInvalidOrder struct{ error }
+
+func getIDFromPath(r *http.Request, name string) (int, error) {
+	str, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s", name)
+	}
+
+	id, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+
+	return id, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalidOrder errInvalidOrder
+	switch {
+	case errors.As(err, &invalidOrder):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,443 @@
+package gallery
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+type file struct {
+	name string
+	data []byte
+}
+
+func TestGalleryHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockImageStore, *mockBlobStore) {
+		store := &mockImageStore{images: map[int]*types.ProductImage{}}
+		blobs := &mockBlobStore{blobs: map[string][]byte{}}
+
+		router := mux.NewRouter()
+		NewHandler(store, &mockProductStore{}, blobs, newMockUserStore(customer, staff), &mockTransactor{}).RegisterRoutes(router)
+		return router, store, blobs
+	}
+
+	upload := func(router *mux.Router, url string, user *types.User, files ...file) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for _, f := range files {
+			part, err := form.CreateFormFile("images", f.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			part.Write(f.data)
+		}
+		form.Close()
+
+		req := newAuthenticatedRequest(t, http.MethodPost, url, &body, user)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decodeGallery := func(rr *httptest.ResponseRecorder) []types.ProductImage {
+		var images []types.ProductImage
+		if err := json.NewDecoder(rr.Body).Decode(&images); err != nil {
+			t.Fatal(err)
+		}
+
+		return images
+	}
+
+	wide, tall := encodePNG(t, 800, 400), encodePNG(t, 100, 200)
+
+	t.Run("should upload images with thumbnails", func(t *testing.T) {
+		router, _, blobs := newRouter()
+
+		rr := upload(router, "/products/1/images", staff, file{"wide.png", wide}, file{"tall.png", tall})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		images := decodeGallery(rr)
+		if len(images) != 2 || !images[0].Primary || images[1].Primary {
+			t.Fatalf("expected two images with the first one as primary, got %+v", images)
+		}
+
+		if images[0].Width != 800 || images[0].Height != 400 || images[0].ContentType != "image/png" {
+			t.Errorf("expected an 800x400 png, got %+v", images[0])
+		}
+
+		thumb, err := png.DecodeConfig(bytes.NewReader(blobs.blobs[blobs.keyOf(images[0].ThumbnailURL)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if thumb.Width != thumbnailSize || thumb.Height != thumbnailSize/2 {
+			t.Errorf("expected a %dx%d thumbnail, got %dx%d", thumbnailSize, thumbnailSize/2, thumb.Width, thumb.Height)
+		}
+
+		// images that already fit are kept as they are
+		thumb, err = png.DecodeConfig(bytes.NewReader(blobs.blobs[blobs.keyOf(images[1].ThumbnailURL)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if thumb.Width != 100 || thumb.Height != 200 {
+			t.Errorf("expected a 100x200 thumbnail, got %dx%d", thumb.Width, thumb.Height)
+		}
+	})
+
+	t.Run("should reject files that aren't images", func(t *testing.T) {
+		router, _, blobs := newRouter()
+
+		rr := upload(router, "/products/1/images", staff, file{"wide.png", wide}, file{"notes.png", []byte("just some text")})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if len(blobs.blobs) != 0 {
+			t.Errorf("expected nothing to be stored, got %d blobs", len(blobs.blobs))
+		}
+
+		if rr := upload(router, "/products/1/images", staff); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for an empty upload, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject files that are too large", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		large := append(slices.Clone(wide), make([]byte, maxImageSize)...)
+		if rr := upload(router, "/products/1/images", staff, file{"large.png", large}); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status code %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+		}
+	})
+
+	t.Run("should only let staff change galleries", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			upload(router, "/products/1/images", customer, file{"wide.png", wide}),
+			send(router, http.MethodPut, "/products/1/images/order", `{"imageIDs": [1]}`, customer),
+			send(router, http.MethodDelete, "/products/1/images/1", "", nil),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should return 404 for an unknown product", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		if rr := upload(router, "/products/99/images", staff, file{"wide.png", wide}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		if rr := send(router, http.MethodGet, "/products/99/images", "", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should not let a gallery grow past its size", func(t *testing.T) {
+		router, store, blobs := newRouter()
+		for i := 0; i < maxGallerySize; i++ {
+			store.CreateImage(types.ProductImage{ProductID: 1})
+		}
+
+		if rr := upload(router, "/products/1/images", staff, file{"wide.png", wide}); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if len(blobs.blobs) != 0 {
+			t.Errorf("expected the stored files to be removed, got %d blobs", len(blobs.blobs))
+		}
+	})
+
+	t.Run("should reorder the gallery", func(t *testing.T) {
+		router, _, _ := newRouter()
+		upload(router, "/products/1/images", staff, file{"wide.png", wide}, file{"tall.png", tall})
+
+		for _, payload := range []string{`{"imageIDs": [2]}`, `{"imageIDs": [2, 1, 1]}`, `{"imageIDs": [2, 3]}`} {
+			if rr := send(router, http.MethodPut, "/products/1/images/order", payload, staff); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+
+		rr := send(router, http.MethodPut, "/products/1/images/order", `{"imageIDs": [2, 1]}`, staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		images := decodeGallery(rr)
+		if len(images) != 2 || images[0].ID != 2 || !images[0].Primary {
+			t.Errorf("expected image 2 to become the primary one, got %+v", images)
+		}
+	})
+
+	t.Run("should delete an image and its files", func(t *testing.T) {
+		router, store, blobs := newRouter()
+		upload(router, "/products/1/images", staff, file{"wide.png", wide}, file{"tall.png", tall})
+
+		if rr := send(router, http.MethodDelete, "/products/1/images/1", "", staff); rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if len(blobs.blobs) != 2 {
+			t.Errorf("expected only the files of image 2 to be left, got %d blobs", len(blobs.blobs))
+		}
+
+		if img := store.images[2]; img.Position != 0 {
+			t.Errorf("expected image 2 to move up to the primary position, got %d", img.Position)
+		}
+
+		if rr := send(router, http.MethodDelete, "/products/1/images/1", "", staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func encodePNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+type mockBlobStore struct {
+	blobs map[string][]byte
+}
+
+func (m *mockBlobStore) Put(key string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	m.blobs[key] = data
+	return "/uploads/" + key, nil
+}
+
+func (m *mockBlobStore) Delete(key string) error {
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *mockBlobStore) keyOf(url string) string {
+	return url[len("/uploads/"):]
+}
+
+// mockImageStore keeps the galleries in memory, with the same positions as
+// the real store.
+type mockImageStore struct {
+	images map[int]*types.ProductImage
+	nextID int
+}
+
+func (m *mockImageStore) GetImagesByProductIDs(productIDs []int) (map[int][]types.ProductImage, error) {
+	images := map[int][]types.ProductImage{}
+	for _, img := range m.images {
+		if slices.Contains(productIDs, img.ProductID) {
+			images[img.ProductID] = append(images[img.ProductID], *img)
+		}
+	}
+
+	for _, gallery := range images {
+		slices.SortFunc(gallery, func(a, b types.ProductImage) int {
+			return a.Position - b.Position
+		})
+	}
+
+	return images, nil
+}
+
+func (m *mockImageStore) GetImageByID(productID int, imageID int) (*types.ProductImage, error) {
+	img, ok := m.images[imageID]
+	if !ok || img.ProductID != productID {
+		return nil, fmt.Errorf("image %d %w", imageID, types.ErrNotFound)
+	}
+
+	image := *img
+	return &image, nil
+}
+
+func (m *mockImageStore) CreateImage(img types.ProductImage) (int, error) {
+	images, _ := m.GetImagesByProductIDs([]int{img.ProductID})
+
+	m.nextID++
+	img.ID = m.nextID
+	img.Position = len(images[img.ProductID])
+	img.Primary = img.Position == 0
+	m.images[img.ID] = &img
+	return img.ID, nil
+}
+
+func (m *mockImageStore) DeleteImage(productID int, imageID int) error {
+	img, err := m.GetImageByID(productID, imageID)
+	if err != nil {
+		return err
+	}
+
+	delete(m.images, imageID)
+	for _, other := range m.images {
+		if other.ProductID == productID && other.Position > img.Position {
+			other.Position--
+			other.Primary = other.Position == 0
+		}
+	}
+
+	return nil
+}
+
+func (m *mockImageStore) ReorderImages(productID int, imageIDs []int) error {
+	for position, id := range imageIDs {
+		m.images[id].Position = position
+		m.images[id].Primary = position == 0
+	}
+
+	return nil
+}
+
+func (m *mockImageStore) WithTx(tx *sql.Tx) types.ImageStore {
+	return m
+}
+
+// mockProductStore only knows product 1.
+type mockProductStore struct{}
+
+func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
+	if productID != 1 {
+		return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+	}
+
+	return &types.Product{ID: productID}, nil
+}
+
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	return []types.Product{}, nil
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	return []*types.Product{}, 0, nil
+}
+
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	return []types.ProductSearchResult{}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	return []types.LowStockProduct{}, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,159 @@
+package gallery
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ImageStore {
+	return &Store{db: tx}
+}
+
+// imageColumns lists the columns read by scanRowsIntoImage, in order.
+const imageColumns = "id, productId, blobKey, thumbnailKey, url, thumbnailUrl, contentType, width, height, position, createdAt"
+
+func (s *Store) GetImagesByProductIDs(productIDs []int) (map[int][]types.ProductImage, error) {
+	images := map[int][]types.ProductImage{}
+	if len(productIDs) == 0 {
+		return images, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	rows, err := s.db.Query(
+		"SELECT "+imageColumns+" FROM product_images WHERE productId IN (?"+placeholders+") ORDER BY productId, position",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		img, err := scanRowsIntoImage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		images[img.ProductID] = append(images[img.ProductID], *img)
+	}
+
+	return images, rows.Err()
+}
+
+func (s *Store) GetImageByID(productID int, imageID int) (*types.ProductImage, error) {
+	rows, err := s.db.Query("SELECT "+imageColumns+" FROM product_images WHERE id = ? AND productId = ?", imageID, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("image %d %w", imageID, types.ErrNotFound)
+	}
+
+	return scanRowsIntoImage(rows)
+}
+
+func (s *Store) CreateImage(img types.ProductImage) (int, error) {
+	var position int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM product_images WHERE productId = ?", img.ProductID).Scan(&position)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.Exec(
+		"INSERT INTO product_images (productId, blobKey, thumbnailKey, url, thumbnailUrl, contentType, width, height, position) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		img.ProductID, img.BlobKey, img.ThumbnailKey, img.URL, img.ThumbnailURL, img.ContentType, img.Width, img.Height, position,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), s.syncPrimaryImage(img.ProductID)
+}
+
+func (s *Store) DeleteImage(productID int, imageID int) error {
+	img, err := s.GetImageByID(productID, imageID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec("DELETE FROM product_images WHERE id = ?", imageID); err != nil {
+		return err
+	}
+
+	// close the gap so the positions keep running from 0
+	_, err = s.db.Exec("UPDATE product_images SET position = position - 1 WHERE productId = ? AND position > ?", productID, img.Position)
+	if err != nil {
+		return err
+	}
+
+	return s.syncPrimaryImage(productID)
+}
+
+func (s *Store) ReorderImages(productID int, imageIDs []int) error {
+	for position, id := range imageIDs {
+		_, err := s.db.Exec("UPDATE product_images SET position = ? WHERE id = ? AND productId = ?", position, id, productID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.syncPrimaryImage(productID)
+}
+
+// syncPrimaryImage points the product's image at the first image of its
+// gallery, or clears it once the gallery is empty.
+func (s *Store) syncPrimaryImage(productID int) error {
+	_, err := s.db.Exec(
+		"UPDATE products SET image = COALESCE((SELECT url FROM product_images WHERE productId = ? AND position = 0 LIMIT 1), '') WHERE id = ?",
+		productID, productID,
+	)
+	return err
+}
+
+func scanRowsIntoImage(rows *sql.Rows) (*types.ProductImage, error) {
+	img := new(types.ProductImage)
+
+	err := rows.Scan(
+		&img.ID,
+		&img.ProductID,
+		&img.BlobKey,
+		&img.ThumbnailKey,
+		&img.URL,
+		&img.ThumbnailURL,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Position,
+		&img.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	img.Primary = img.Position == 0
+	return img, nil
+}
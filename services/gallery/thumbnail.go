@@ -0,0 +1,113 @@
+package gallery
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder used by image.Decode
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// thumbnailSize bounds the longest side of a thumbnail, in pixels.
+	thumbnailSize = 320
+	// maxImagePixels keeps a small file that decodes into a huge image from
+	// exhausting memory.
+	maxImagePixels = 40_000_000
+)
+
+// imageTypes maps the accepted content types to their file extensions.
+var imageTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// decodedImage is an upload that passed validation.
+type decodedImage struct {
+	contentType string
+	img         image.Image
+}
+
+// decodeImage sniffs the content type of data, rather than trusting the one
+// sent by the client, and decodes it.
+func decodeImage(data []byte) (*decodedImage, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := imageTypes[contentType]; !ok {
+		return nil, fmt.Errorf("unsupported image type %s", contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+
+	return &decodedImage{contentType: contentType, img: img}, nil
+}
+
+// thumbnail scales the image down to fit thumbnailSize and encodes it. JPEGs
+// stay JPEGs, the other types become PNGs to keep their transparency.
+func (d *decodedImage) thumbnail() ([]byte, string, error) {
+	thumb := scaleDown(d.img, thumbnailSize)
+
+	var buf bytes.Buffer
+	if d.contentType == "image/jpeg" {
+		err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "jpg", err
+	}
+
+	err := png.Encode(&buf, thumb)
+	return buf.Bytes(), "png", err
+}
+
+// scaleDown shrinks img so its longest side is at most size, averaging the
+// source pixels that fall into each thumbnail pixel. Smaller images are
+// returned as they are.
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	tw, th := size, max(1, h*size/w)
+	if h > w {
+		tw, th = max(1, w*size/h), size
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+(x+1)*w/tw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+
+			thumb.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+
+	return thumb
+}
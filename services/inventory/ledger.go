@@ -0,0 +1,217 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// Ledger is the stock ledger. Each movement updates the stock of the product
+// or variant, its level in the warehouse the units went in or out of, and
+// appends its entry, all in the same transaction as the caller's.
+type Ledger struct {
+	db types.DBTX
+}
+
+func NewLedger(db *sql.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// WithTx returns a copy of the ledger that runs its queries inside tx.
+func (l *Ledger) WithTx(tx *sql.Tx) types.StockLedger {
+	return &Ledger{db: tx}
+}
+
+func (l *Ledger) Move(m types.StockMovement) error {
+	query := "UPDATE products SET quantity = quantity + ? WHERE id = ?"
+	args := []any{m.Change, m.ProductID}
+	table, id, name := "products", m.ProductID, "product"
+	if m.VariantID != nil {
+		query = "UPDATE product_variants SET quantity = quantity + ? WHERE id = ? AND productId = ?"
+		args = []any{m.Change, *m.VariantID, m.ProductID}
+		table, id, name = "product_variants", *m.VariantID, "variant"
+	}
+
+	if m.Change < 0 {
+		// the WHERE clause makes the check and the decrement a single atomic
+		// statement, so concurrent checkouts can't push the stock below zero
+		query += " AND quantity + ? >= 0 AND deletedAt IS NULL"
+		args = append(args, m.Change)
+	}
+
+	res, err := l.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		if m.Change < 0 {
+			return fmt.Errorf("%s %d is not available in the quantity requested: %w", name, id, types.ErrConflict)
+		}
+		return fmt.Errorf("%s %d %w", name, id, types.ErrNotFound)
+	}
+
+	// the update holds the row lock, so the balance read is the one it left
+	var balance int
+	if err := l.db.QueryRow("SELECT quantity FROM "+table+" WHERE id = ?", id).Scan(&balance); err != nil {
+		return err
+	}
+
+	warehouseID, err := l.warehouse(m.WarehouseID)
+	if err != nil {
+		return err
+	}
+
+	if err := l.moveLevel(warehouseID, m); err != nil {
+		return err
+	}
+
+	_, err = l.db.Exec(
+		"INSERT INTO stock_movements (productId, variantId, warehouseId, `change`, balance, reason, actorId, reference) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		m.ProductID, m.VariantID, warehouseID, m.Change, balance, m.Reason, m.ActorID, m.Reference,
+	)
+	return err
+}
+
+// warehouse returns id, or the id of the default warehouse when it's nil.
+func (l *Ledger) warehouse(id *int) (int, error) {
+	var warehouseID int
+	if id != nil {
+		err := l.db.QueryRow("SELECT id FROM warehouses WHERE id = ?", *id).Scan(&warehouseID)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("warehouse %d %w", *id, types.ErrNotFound)
+		}
+		return warehouseID, err
+	}
+
+	err := l.db.QueryRow("SELECT id FROM warehouses WHERE isDefault = TRUE").Scan(&warehouseID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("default warehouse %w", types.ErrNotFound)
+	}
+	return warehouseID, err
+}
+
+func (l *Ledger) moveLevel(warehouseID int, m types.StockMovement) error {
+	if m.Change > 0 {
+		_, err := l.db.Exec(
+			"INSERT INTO inventory_levels (warehouseId, productId, variantId, quantity) VALUES (?, ?, ?, ?)"+
+				" ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)",
+			warehouseID, m.ProductID, m.VariantID, m.Change,
+		)
+		return err
+	}
+
+	query := "UPDATE inventory_levels SET quantity = quantity + ? WHERE warehouseId = ? AND productId = ? AND variantId IS NULL AND quantity + ? >= 0"
+	args := []any{m.Change, warehouseID, m.ProductID, m.Change}
+	if m.VariantID != nil {
+		query = "UPDATE inventory_levels SET quantity = quantity + ? WHERE warehouseId = ? AND productId = ? AND variantId = ? AND quantity + ? >= 0"
+		args = []any{m.Change, warehouseID, m.ProductID, *m.VariantID, m.Change}
+	}
+
+	res, err := l.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("warehouse %d doesn't hold the quantity requested: %w", warehouseID, types.ErrConflict)
+	}
+
+	return nil
+}
+
+func (l *Ledger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return l.queryMovements("WHERE productId = ? ORDER BY id DESC", productID)
+}
+
+func (l *Ledger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	return l.queryMovements("WHERE reference = ? ORDER BY id", reference)
+}
+
+func (l *Ledger) queryMovements(where string, args ...any) ([]types.StockMovement, error) {
+	rows, err := l.db.Query(
+		"SELECT id, productId, variantId, warehouseId, `change`, balance, reason, actorId, reference, createdAt FROM stock_movements "+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := make([]types.StockMovement, 0)
+	for rows.Next() {
+		var m types.StockMovement
+		var variantID, warehouseID, actorID sql.NullInt64
+		err := rows.Scan(&m.ID, &m.ProductID, &variantID, &warehouseID, &m.Change, &m.Balance, &m.Reason, &actorID, &m.Reference, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if variantID.Valid {
+			id := int(variantID.Int64)
+			m.VariantID = &id
+		}
+		if warehouseID.Valid {
+			id := int(warehouseID.Int64)
+			m.WarehouseID = &id
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			m.ActorID = &id
+		}
+
+		movements = append(movements, m)
+	}
+
+	return movements, rows.Err()
+}
+
+func (l *Ledger) Reconcile() ([]types.StockDiscrepancy, error) {
+	rows, err := l.db.Query(
+		"SELECT * FROM (" +
+			" SELECT p.id AS productId, NULL AS variantId, p.quantity AS quantity," +
+			" (SELECT COALESCE(SUM(m.`change`), 0) FROM stock_movements m WHERE m.productId = p.id AND m.variantId IS NULL) AS ledger," +
+			" (SELECT COALESCE(SUM(l.quantity), 0) FROM inventory_levels l WHERE l.productId = p.id AND l.variantId IS NULL) AS levels" +
+			" FROM products p" +
+			" UNION ALL" +
+			" SELECT v.productId, v.id, v.quantity," +
+			" (SELECT COALESCE(SUM(m.`change`), 0) FROM stock_movements m WHERE m.variantId = v.id) AS ledger," +
+			" (SELECT COALESCE(SUM(l.quantity), 0) FROM inventory_levels l WHERE l.variantId = v.id) AS levels" +
+			" FROM product_variants v" +
+			") stock" +
+			" WHERE quantity <> ledger OR quantity <> levels",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := make([]types.StockDiscrepancy, 0)
+	for rows.Next() {
+		var d types.StockDiscrepancy
+		var variantID sql.NullInt64
+		if err := rows.Scan(&d.ProductID, &variantID, &d.Quantity, &d.Ledger, &d.Levels); err != nil {
+			return nil, err
+		}
+
+		if variantID.Valid {
+			id := int(variantID.Int64)
+			d.VariantID = &id
+		}
+
+		discrepancies = append(discrepancies, d)
+	}
+
+	return discrepancies, rows.Err()
+}
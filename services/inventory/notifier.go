@@ -0,0 +1,139 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// NewNotifier builds the low-stock notifier named in the configuration:
+// "log" writes the alerts to the server log and "smtp" mails them.
+func NewNotifier(kind string, mail SMTPConfig) (types.StockNotifier, error) {
+	switch kind {
+	case "log":
+		return NewLogNotifier(log.Default()), nil
+	case "smtp":
+		if mail.Addr == "" || mail.From == "" || len(mail.To) == 0 {
+			return nil, fmt.Errorf("the smtp low-stock notifier needs a server, a sender and recipients")
+		}
+		return NewSMTPNotifier(mail), nil
+	default:
+		return nil, fmt.Errorf("unknown low-stock notifier %q", kind)
+	}
+}
+
+// LogNotifier writes low-stock alerts to a logger.
+type LogNotifier struct {
+	logger *log.Logger
+}
+
+func NewLogNotifier(logger *log.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) NotifyLowStock(ctx context.Context, event types.LowStockEvent) error {
+	n.logger.Print(lowStockSummary(event))
+	return nil
+}
+
+// SMTPConfig points at the mail server low-stock alerts go through. The
+// server is expected to be a local relay, such as Mailpit in development, so
+// the notifier doesn't authenticate.
+type SMTPConfig struct {
+	Addr string
+	From string
+	To   []string
+	// Timeout bounds the whole exchange with the server, so a slow server
+	// doesn't hold up checkout. It defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// SMTPNotifier mails low-stock alerts.
+type SMTPNotifier struct {
+	config SMTPConfig
+}
+
+func NewSMTPNotifier(config SMTPConfig) *SMTPNotifier {
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	return &SMTPNotifier{config: config}
+}
+
+func (n *SMTPNotifier) NotifyLowStock(ctx context.Context, event types.LowStockEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.config.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, err := net.SplitHostPort(n.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(n.message(event)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) message(event types.LowStockEvent) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Low stock: "+event.Name))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%s.\r\n", lowStockSummary(event))
+
+	return []byte(b.String())
+}
+
+func lowStockSummary(event types.LowStockEvent) string {
+	return fmt.Sprintf(
+		"product %d (%s) is low on stock after order %d: %d left, reorder threshold %d",
+		event.ProductID, event.Name, event.OrderID, event.Quantity, event.ReorderThreshold,
+	)
+}
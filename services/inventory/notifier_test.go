@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+var lowStockEvent = types.LowStockEvent{
+	LowStockProduct: types.LowStockProduct{ProductID: 4, Name: "mug", Quantity: 1, ReorderThreshold: 2},
+	OrderID:         9,
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := NewLogNotifier(log.New(&buf, "", 0))
+
+	if err := n.NotifyLowStock(context.Background(), lowStockEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "product 4 (mug) is low on stock after order 9: 1 left, reorder threshold 2\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan smtpMessage, 1)
+	go serveSMTP(t, listener, received)
+
+	n := NewSMTPNotifier(SMTPConfig{Addr: listener.Addr().String(), From: "inventory@localhost", To: []string{"stock@localhost", "buyer@localhost"}})
+	if err := n.NotifyLowStock(context.Background(), lowStockEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-received
+	if msg.from != "<inventory@localhost>" || strings.Join(msg.to, ",") != "<stock@localhost>,<buyer@localhost>" {
+		t.Errorf("unexpected envelope from %s to %v", msg.from, msg.to)
+	}
+
+	if !strings.Contains(msg.data, "Subject: Low stock: mug\n") || !strings.Contains(msg.data, "product 4 (mug) is low on stock") {
+		t.Errorf("unexpected message %q", msg.data)
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	if _, err := NewNotifier("log", SMTPConfig{}); err != nil {
+		t.Errorf("expected the log notifier, got %v", err)
+	}
+
+	if _, err := NewNotifier("smtp", SMTPConfig{Addr: "localhost:1025", From: "inventory@localhost"}); err == nil {
+		t.Errorf("expected the smtp notifier to need recipients")
+	}
+
+	if _, err := NewNotifier("pager", SMTPConfig{}); err == nil {
+		t.Errorf("expected an unknown notifier to fail")
+	}
+}
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP answers a single session with just enough of SMTP for
+// net/smtp to deliver a message.
+func serveSMTP(t *testing.T, listener net.Listener, received chan<- smtpMessage) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	c := textproto.NewConn(conn)
+	var msg smtpMessage
+
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = strings.TrimPrefix(arg, "FROM:")
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.TrimPrefix(arg, "TO:"))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			msg.data = string(data)
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			received <- msg
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
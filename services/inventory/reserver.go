@@ -0,0 +1,69 @@
+package inventory
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// Reserver implements types.StockReserver on a ReservationStore. Every
+// reservation it makes lasts ttl.
+type Reserver struct {
+	store types.ReservationStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+func NewReserver(store types.ReservationStore, ttl time.Duration) *Reserver {
+	return &Reserver{store: store, ttl: ttl, now: time.Now}
+}
+
+// WithTx returns a copy of the reserver that runs its queries inside tx.
+func (r *Reserver) WithTx(tx *sql.Tx) types.StockReserver {
+	return &Reserver{store: r.store.WithTx(tx), ttl: r.ttl, now: r.now}
+}
+
+func (r *Reserver) Reserved(productIDs []int, userID int) (types.ReservedStock, error) {
+	return r.store.GetReservedStock(productIDs, userID, r.now())
+}
+
+func (r *Reserver) ReserveCart(userID int, items []types.CartCheckoutItem) (time.Time, error) {
+	if err := r.store.ReleaseCartReservations(userID); err != nil {
+		return time.Time{}, err
+	}
+
+	expiresAt := r.now().Add(r.ttl)
+	return expiresAt, r.store.CreateReservations(reservations(items, &userID, nil, expiresAt))
+}
+
+func (r *Reserver) ReserveOrder(orderID int, items []types.CartCheckoutItem) error {
+	return r.store.CreateReservations(reservations(items, nil, &orderID, r.now().Add(r.ttl)))
+}
+
+func (r *Reserver) ReleaseCart(userID int) error {
+	return r.store.ReleaseCartReservations(userID)
+}
+
+func (r *Reserver) ReleaseOrder(orderID int) error {
+	return r.store.ReleaseOrderReservations(orderID)
+}
+
+func reservations(items []types.CartCheckoutItem, userID *int, orderID *int, expiresAt time.Time) []types.StockReservation {
+	rs := make([]types.StockReservation, len(items))
+	for i, item := range items {
+		rs[i] = types.StockReservation{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			UserID:    userID,
+			OrderID:   orderID,
+			ExpiresAt: expiresAt,
+		}
+		if item.VariantID != 0 {
+			variantID := item.VariantID
+			rs[i].VariantID = &variantID
+		}
+	}
+
+	return rs
+}
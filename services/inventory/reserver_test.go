@@ -0,0 +1,48 @@
+package inventory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestReserver(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	store := &mockReservationStore{reservations: []types.StockReservation{
+		{ID: 1, ProductID: 1, Quantity: 5, UserID: intPtr(7), ExpiresAt: now.Add(time.Minute)},
+		{ID: 2, ProductID: 7, VariantID: intPtr(71), Quantity: 1, UserID: intPtr(8), ExpiresAt: now.Add(time.Minute)},
+		{ID: 3, ProductID: 1, Quantity: 9, UserID: intPtr(8), ExpiresAt: now.Add(-time.Minute)},
+	}}
+	reserver := NewReserver(store, 15*time.Minute)
+	reserver.now = func() time.Time { return now }
+
+	expiresAt, err := reserver.ReserveCart(7, []types.CartCheckoutItem{{ProductID: 1, Quantity: 2}, {ProductID: 7, VariantID: 71, Quantity: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !expiresAt.Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("expected the reservation to last 15 minutes, got %s", expiresAt)
+	}
+
+	// the user's own cart doesn't count against them, nor do expired ones
+	reserved, err := reserver.Reserved([]int{1, 7}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reserved.Of(1, 0) != 0 || reserved.Of(7, 71) != 1 {
+		t.Errorf("expected only user 8's variant to be held, got %+v", reserved)
+	}
+
+	reserved, err = reserver.Reserved([]int{1, 7}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reserved.Of(1, 0) != 2 || reserved.Of(7, 71) != 1 {
+		t.Errorf("expected user 7's new reservation to replace the old one, got %+v", reserved)
+	}
+}
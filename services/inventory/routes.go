@@ -0,0 +1,33 @@
+package inventory
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	productStore types.ProductStore
+	userStore    types.UserStore
+}
+
+func NewHandler(productStore types.ProductStore, userStore types.UserStore) *Handler {
+	return &Handler{productStore: productStore, userStore: userStore}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/inventory/low-stock", auth.WithJWTAuth(auth.RequireRole(h.handleGetLowStock, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+}
+
+func (h *Handler) handleGetLowStock(w http.ResponseWriter, r *http.Request) {
+	products, err := h.productStore.GetLowStockProducts(nil)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, products)
+}
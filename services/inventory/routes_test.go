@@ -0,0 +1,161 @@
+package inventory
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+func TestLowStockHandler(t *testing.T) {
+	productStore := &mockProductStore{lowStock: []types.LowStockProduct{
+		{ProductID: 4, Name: "mug", Quantity: 0, ReorderThreshold: 0},
+		{ProductID: 2, Name: "cap", Quantity: 3, ReorderThreshold: 5},
+	}}
+
+	router := mux.NewRouter()
+	NewHandler(productStore, newMockUserStore(customer, staff)).RegisterRoutes(router)
+
+	get := func(user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, http.MethodGet, "/admin/inventory/low-stock", nil, user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should list the products at or under their threshold", func(t *testing.T) {
+		rr := get(staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var products []types.LowStockProduct
+		if err := json.NewDecoder(rr.Body).Decode(&products); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(products) != 2 || products[0].ProductID != 4 || products[1].ReorderThreshold != 5 {
+			t.Errorf("unexpected low-stock products %+v", products)
+		}
+	})
+
+	t.Run("should only let staff see them", func(t *testing.T) {
+		for _, user := range []*types.User{customer, nil} {
+			if rr := get(user); rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+// mockProductStore only knows which products are low on stock.
+type mockProductStore struct {
+	lowStock []types.LowStockProduct
+}
+
+func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
+	return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+}
+
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	return []types.Product{}, nil
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	return []*types.Product{}, 0, nil
+}
+
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	return []types.ProductSearchResult{}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	return m.lowStock, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
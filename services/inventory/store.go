@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ReservationStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (types.ReservedStock, error) {
+	reserved := types.ReservedStock{Products: map[int]int{}, Variants: map[int]int{}}
+	if len(productIDs) == 0 {
+		return reserved, nil
+	}
+
+	args := make([]any, 0, len(productIDs)+2)
+	for _, id := range productIDs {
+		args = append(args, id)
+	}
+	args = append(args, exceptUserID, now)
+
+	// order reservations are left out: their units are already off the stock
+	rows, err := s.db.Query(
+		"SELECT productId, variantId, SUM(quantity) FROM stock_reservations"+
+			" WHERE productId IN (?"+strings.Repeat(",?", len(productIDs)-1)+") AND userId IS NOT NULL AND userId <> ? AND expiresAt > ?"+
+			" GROUP BY productId, variantId",
+		args...,
+	)
+	if err != nil {
+		return reserved, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID, quantity int
+		var variantID sql.NullInt64
+		if err := rows.Scan(&productID, &variantID, &quantity); err != nil {
+			return reserved, err
+		}
+
+		if variantID.Valid {
+			reserved.Variants[int(variantID.Int64)] += quantity
+		} else {
+			reserved.Products[productID] += quantity
+		}
+	}
+
+	return reserved, rows.Err()
+}
+
+func (s *Store) CreateReservations(reservations []types.StockReservation) error {
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(reservations)*6)
+	for _, r := range reservations {
+		args = append(args, r.ProductID, r.VariantID, r.Quantity, r.UserID, r.OrderID, r.ExpiresAt)
+	}
+
+	_, err := s.db.Exec(
+		"INSERT INTO stock_reservations (productId, variantId, quantity, userId, orderId, expiresAt) VALUES (?, ?, ?, ?, ?, ?)"+
+			strings.Repeat(", (?, ?, ?, ?, ?, ?)", len(reservations)-1),
+		args...,
+	)
+	return err
+}
+
+func (s *Store) ReleaseCartReservations(userID int) error {
+	_, err := s.db.Exec("DELETE FROM stock_reservations WHERE userId = ?", userID)
+	return err
+}
+
+func (s *Store) ReleaseOrderReservations(orderID int) error {
+	_, err := s.db.Exec("DELETE FROM stock_reservations WHERE orderId = ?", orderID)
+	return err
+}
+
+func (s *Store) ReleaseExpiredCartReservations(now time.Time) (int, error) {
+	res, err := s.db.Exec("DELETE FROM stock_reservations WHERE userId IS NOT NULL AND expiresAt <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := res.RowsAffected()
+	return int(affected), err
+}
+
+func (s *Store) GetExpiredOrderIDs(now time.Time, limit int) ([]int, error) {
+	rows, err := s.db.Query(
+		"SELECT orderId FROM stock_reservations WHERE orderId IS NOT NULL AND expiresAt <= ? GROUP BY orderId ORDER BY MIN(expiresAt) LIMIT ?",
+		now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,113 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/types"
+)
+
+// sweepBatch caps the orders cancelled by a single sweep, so a backlog is
+// worked through over a few sweeps instead of at once.
+const sweepBatch = 100
+
+// Sweeper releases expired reservations in the background. Expired cart
+// reservations are dropped, and orders still pending once theirs expire are
+// cancelled, which puts their units back in stock.
+type Sweeper struct {
+	store       types.ReservationStore
+	orderStore  types.OrderStore
+	ledger      types.StockLedger
+	couponStore types.CouponStore
+	transactor  types.Transactor
+	interval    time.Duration
+	now         func() time.Time
+}
+
+// NewSweeper fails unless interval is positive.
+func NewSweeper(
+	store types.ReservationStore,
+	orderStore types.OrderStore,
+	ledger types.StockLedger,
+	couponStore types.CouponStore,
+	transactor types.Transactor,
+	interval time.Duration,
+) (*Sweeper, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the sweep interval must be positive, got %s", interval)
+	}
+
+	return &Sweeper{
+		store:       store,
+		orderStore:  orderStore,
+		ledger:      ledger,
+		couponStore: couponStore,
+		transactor:  transactor,
+		interval:    interval,
+		now:         time.Now,
+	}, nil
+}
+
+// Run sweeps every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep()
+		}
+	}
+}
+
+// Sweep releases what expired by now. Failures are logged and retried on
+// the next sweep.
+func (s *Sweeper) Sweep() {
+	now := s.now()
+
+	released, err := s.store.ReleaseExpiredCartReservations(now)
+	if err != nil {
+		log.Printf("failed to release expired cart reservations: %v", err)
+	} else if released > 0 {
+		log.Printf("released %d expired cart reservations", released)
+	}
+
+	orderIDs, err := s.store.GetExpiredOrderIDs(now, sweepBatch)
+	if err != nil {
+		log.Printf("failed to list orders with expired reservations: %v", err)
+		return
+	}
+
+	for _, orderID := range orderIDs {
+		if err := s.expireOrder(orderID); err != nil {
+			log.Printf("failed to release the reservation of order %d: %v", orderID, err)
+		}
+	}
+}
+
+// expireOrder cancels an order that wasn't paid in time, which also drops
+// its reservation. Orders that moved on in the meantime only lose their
+// reservation.
+func (s *Sweeper) expireOrder(orderID int) error {
+	return s.transactor.WithinTx(func(tx *sql.Tx) error {
+		orderStore := s.orderStore.WithTx(tx)
+
+		o, err := orderStore.GetOrder(orderID)
+		if err != nil {
+			return err
+		}
+
+		if o.Status == types.OrderStatusPending {
+			return order.Cancel(orderStore, s.ledger.WithTx(tx), s.couponStore.WithTx(tx), s.store.WithTx(tx), o, nil, "payment not received in time")
+		}
+
+		return s.store.WithTx(tx).ReleaseOrderReservations(orderID)
+	})
+}
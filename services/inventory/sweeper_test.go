@@ -0,0 +1,360 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestSweeper(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// order 1 is still pending, order 2 was paid before its reservation
+	// expired
+	store := &mockReservationStore{
+		reservations: []types.StockReservation{
+			{ID: 1, ProductID: 1, Quantity: 2, UserID: intPtr(7), ExpiresAt: now.Add(-time.Minute)},
+			{ID: 2, ProductID: 1, Quantity: 1, UserID: intPtr(8), ExpiresAt: now.Add(time.Minute)},
+			{ID: 3, ProductID: 1, Quantity: 3, OrderID: intPtr(1), ExpiresAt: now.Add(-time.Minute)},
+			{ID: 4, ProductID: 1, Quantity: 1, OrderID: intPtr(2), ExpiresAt: now.Add(-time.Minute)},
+		},
+	}
+	orderStore := &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, Status: types.OrderStatusPending},
+			{ID: 2, Status: types.OrderStatusPaid},
+		},
+		items: map[int][]types.OrderItemDetail{
+			1: {{OrderItem: types.OrderItem{ID: 1, OrderID: 1, ProductID: 1, Quantity: 3}}},
+			2: {{OrderItem: types.OrderItem{ID: 2, OrderID: 2, ProductID: 1, Quantity: 1}}},
+		},
+	}
+	ledger, couponStore := &mockLedger{}, &mockCouponStore{}
+
+	sweeper, err := NewSweeper(store, orderStore, ledger, couponStore, &mockTransactor{}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweeper.now = func() time.Time { return now }
+	sweeper.Sweep()
+
+	if len(store.reservations) != 1 || store.reservations[0].ID != 2 {
+		t.Errorf("expected only the live cart reservation to be left, got %+v", store.reservations)
+	}
+
+	if orderStore.orders[0].Status != types.OrderStatusCancelled || len(ledger.movements) != 1 || ledger.movements[0].Change != 3 {
+		t.Errorf("expected the unpaid order to be cancelled and restocked, got %s and %+v", orderStore.orders[0].Status, ledger.movements)
+	}
+
+	if orderStore.orders[1].Status != types.OrderStatusPaid {
+		t.Errorf("expected the paid order to be left alone, got %s", orderStore.orders[1].Status)
+	}
+
+	if len(orderStore.history) != 1 || orderStore.history[0].ActorID != nil {
+		t.Errorf("expected the system to cancel the order, got %+v", orderStore.history)
+	}
+
+	if len(couponStore.released) != 1 || couponStore.released[0] != 1 {
+		t.Errorf("expected the coupon of the unpaid order to be given back, got %v", couponStore.released)
+	}
+}
+
+func TestNewSweeper(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewSweeper(&mockReservationStore{}, &mockOrderStore{}, &mockLedger{}, &mockCouponStore{}, &mockTransactor{}, interval); err == nil {
+			t.Errorf("expected an interval of %s to be refused", interval)
+		}
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+type mockReservationStore struct {
+	reservations []types.StockReservation
+}
+
+func (m *mockReservationStore) GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (types.ReservedStock, error) {
+	reserved := types.ReservedStock{Products: map[int]int{}, Variants: map[int]int{}}
+	for _, r := range m.reservations {
+		if r.UserID == nil || *r.UserID == exceptUserID || !r.ExpiresAt.After(now) {
+			continue
+		}
+
+		if r.VariantID != nil {
+			reserved.Variants[*r.VariantID] += r.Quantity
+		} else {
+			reserved.Products[r.ProductID] += r.Quantity
+		}
+	}
+
+	return reserved, nil
+}
+
+func (m *mockReservationStore) CreateReservations(reservations []types.StockReservation) error {
+	for _, r := range reservations {
+		r.ID = len(m.reservations) + 1
+		m.reservations = append(m.reservations, r)
+	}
+
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseCartReservations(userID int) error {
+	m.release(func(r types.StockReservation) bool { return r.UserID != nil && *r.UserID == userID })
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseOrderReservations(orderID int) error {
+	m.release(func(r types.StockReservation) bool { return r.OrderID != nil && *r.OrderID == orderID })
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseExpiredCartReservations(now time.Time) (int, error) {
+	return m.release(func(r types.StockReservation) bool { return r.UserID != nil && !r.ExpiresAt.After(now) }), nil
+}
+
+func (m *mockReservationStore) GetExpiredOrderIDs(now time.Time, limit int) ([]int, error) {
+	ids := []int{}
+	for _, r := range m.reservations {
+		if r.OrderID != nil && !r.ExpiresAt.After(now) && len(ids) < limit {
+			ids = append(ids, *r.OrderID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (m *mockReservationStore) WithTx(tx *sql.Tx) types.ReservationStore {
+	return m
+}
+
+// release drops the reservations matching and returns how many there were.
+func (m *mockReservationStore) release(matching func(r types.StockReservation) bool) int {
+	kept := []types.StockReservation{}
+	for _, r := range m.reservations {
+		if !matching(r) {
+			kept = append(kept, r)
+		}
+	}
+
+	released := len(m.reservations) - len(kept)
+	m.reservations = kept
+	return released
+}
+
+type mockOrderStore struct {
+	orders  []types.Order
+	items   map[int][]types.OrderItemDetail
+	history []types.OrderStatusChange
+	refunds []types.Refund
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	orders := []types.Order{}
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		if m.orders[i].UserID == userID {
+			orders = append(orders, m.orders[i])
+		}
+	}
+
+	total := len(orders)
+	if offset > total {
+		offset = total
+	}
+
+	return orders[offset:min(offset+limit, total)], total, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID && o.UserID == userID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrder(orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	return m.items[orderID], nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	for i := range m.orders {
+		if m.orders[i].ID != orderID {
+			continue
+		}
+
+		if m.orders[i].Status != from {
+			return fmt.Errorf("order %d is no longer %s: %w", orderID, from, types.ErrConflict)
+		}
+
+		m.orders[i].Status = to
+		return nil
+	}
+
+	return fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	for i := range m.orders {
+		if m.orders[i].ID == orderID {
+			m.orders[i].CancelledBy = cancelledBy
+			m.orders[i].CancelReason = reason
+		}
+	}
+
+	return nil
+}
+
+func (m *mockOrderStore) CreateStatusChange(change types.OrderStatusChange) error {
+	m.history = append(m.history, change)
+	return nil
+}
+
+func (m *mockOrderStore) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	history := []types.OrderStatusChange{}
+	for _, change := range m.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+
+	return history, nil
+}
+
+func (m *mockOrderStore) RecordRefund(refund types.Refund) (int, error) {
+	refund.ID = len(m.refunds) + 1
+	m.refunds = append(m.refunds, refund)
+
+	for i := range m.orders {
+		if m.orders[i].ID == refund.OrderID {
+			m.orders[i].Refunded = m.orders[i].Refunded.Add(refund.Amount)
+		}
+	}
+
+	return refund.ID, nil
+}
+
+func (m *mockOrderStore) GetRefunds(orderID int) ([]types.Refund, error) {
+	refunds := []types.Refund{}
+	for _, refund := range m.refunds {
+		if refund.OrderID == orderID {
+			refunds = append(refunds, refund)
+		}
+	}
+
+	return refunds, nil
+}
+
+func (m *mockOrderStore) WithTx(tx *sql.Tx) types.OrderStore {
+	return m
+}
+
+// mockLedger keeps the movements recorded, in order.
+type mockLedger struct {
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+// mockCouponStore keeps the orders whose redemption was released.
+type mockCouponStore struct {
+	released []int
+}
+
+func (m *mockCouponStore) GetCoupons() ([]types.Coupon, error) {
+	return []types.Coupon{}, nil
+}
+
+func (m *mockCouponStore) GetCouponByID(couponID int) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) GetCouponByCode(code string) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) CreateCoupon(c types.Coupon) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCouponStore) UpdateCoupon(c types.Coupon) error {
+	return nil
+}
+
+func (m *mockCouponStore) DeleteCoupon(couponID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	return productIDs, nil
+}
+
+func (m *mockCouponStore) RedeemCoupon(couponID int, userID int, orderID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) ReleaseRedemption(orderID int) error {
+	m.released = append(m.released, orderID)
+	return nil
+}
+
+func (m *mockCouponStore) WithTx(tx *sql.Tx) types.CouponStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
@@ -0,0 +1,444 @@
+package order
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+const (
+	defaultOrdersLimit = 20
+	maxOrdersLimit     = 100
+)
+
+type Handler struct {
+	store          types.OrderStore
+	ledger         types.StockLedger
+	couponStore    types.CouponStore
+	reservations   types.ReservationStore
+	paymentStore   types.PaymentStore
+	paymentSettler types.PaymentSettler
+	userStore      types.UserStore
+	transactor     types.Transactor
+}
+
+// Deps are the stores and services the order handlers work with.
+type Deps struct {
+	OrderStore     types.OrderStore
+	Ledger         types.StockLedger
+	CouponStore    types.CouponStore
+	Reservations   types.ReservationStore
+	PaymentStore   types.PaymentStore
+	PaymentSettler types.PaymentSettler
+	UserStore      types.UserStore
+	Transactor     types.Transactor
+}
+
+func NewHandler(deps Deps) *Handler {
+	return &Handler{
+		store:          deps.OrderStore,
+		ledger:         deps.Ledger,
+		couponStore:    deps.CouponStore,
+		reservations:   deps.Reservations,
+		paymentStore:   deps.PaymentStore,
+		paymentSettler: deps.PaymentSettler,
+		userStore:      deps.UserStore,
+		transactor:     deps.Transactor,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders", auth.WithJWTAuth(h.handleGetOrders, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{orderID}", auth.WithJWTAuth(h.handleGetOrder, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{orderID}/cancel", auth.WithJWTAuth(h.handleCancelOrder, h.userStore)).Methods(http.MethodPost)
+
+	// staff routes
+	router.HandleFunc("/orders/{orderID}/status", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateOrderStatus, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetOrders(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	orders, total, err := h.store.GetOrdersByUserID(userID, limit, offset)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderPage{
+		Orders: orders,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+func (h *Handler) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := getOrderIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// orders of other users are reported as missing rather than forbidden,
+	// so their ids can't be probed
+	order, err := h.store.GetOrderByID(userID, orderID)
+	if err != nil {
+		if errors.Is(err, types.ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order %d not found", orderID))
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items, err := h.store.GetOrderItems(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	history, err := h.store.GetStatusHistory(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	refunds, err := h.store.GetRefunds(order.ID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OrderDetail{Order: *order, Items: items, StatusHistory: history, Refunds: refunds})
+}
+
+func (h *Handler) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := getOrderIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.CancelOrderPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	var order *types.Order
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		orderStore := h.store.WithTx(tx)
+
+		current, err := orderStore.GetOrderByID(userID, orderID)
+		if err != nil {
+			return err
+		}
+
+		// customers can only back out before the order is paid
+		if current.Status != types.OrderStatusPending {
+			return fmt.Errorf("order %d is %s: %w", orderID, current.Status, types.ErrConflict)
+		}
+
+		if err := Cancel(orderStore, h.ledger.WithTx(tx), h.couponStore.WithTx(tx), h.reservations.WithTx(tx), current, &userID, payload.Reason); err != nil {
+			return err
+		}
+
+		order, err = orderStore.GetOrderByID(userID, orderID)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order %d not found", orderID))
+		case errors.Is(err, types.ErrConflict):
+			utils.WriteError(w, http.StatusConflict, fmt.Errorf("only pending orders can be cancelled"))
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, order)
+}
+
+func (h *Handler) handleUpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	actorID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := getOrderIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateOrderStatusPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	status, err := ParseStatus(payload.Status)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// only a received return, which records what was refunded, partially
+	// refunds an order
+	if status == types.OrderStatusPartiallyRefunded {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("orders are partially refunded by receiving a return, not by hand"))
+		return
+	}
+
+	var (
+		order   *types.Order
+		settled *types.Payment
+	)
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		orderStore := h.store.WithTx(tx)
+
+		current, err := orderStore.GetOrder(orderID)
+		if err != nil {
+			return err
+		}
+		order = current
+
+		if status == types.OrderStatusCancelled {
+			err = Cancel(orderStore, h.ledger.WithTx(tx), h.couponStore.WithTx(tx), h.reservations.WithTx(tx), order, &actorID, payload.Note)
+		} else {
+			err = Transition(orderStore, order, status, &actorID, payload.Note)
+		}
+		if err != nil {
+			return err
+		}
+
+		settled, err = h.settlePayment(tx, order)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order %d not found", orderID))
+		case errors.Is(err, types.ErrConflict):
+			utils.WriteError(w, http.StatusConflict, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// the gateway is only called once the new status is committed; what it
+	// fails to do is retried in the background, and the order answered as
+	// accepted until then
+	code := http.StatusOK
+	if settled != nil {
+		if err := h.paymentSettler.Settle(r.Context(), settled.ID); err != nil {
+			log.Printf("failed to settle payment %d of order %d, it will be retried: %v", settled.ID, order.ID, err)
+			code = http.StatusAccepted
+		}
+	}
+
+	utils.WriteJSON(w, code, order)
+}
+
+// settlePayment keeps the payment of an order in step with its new status.
+// The payment is captured once the order is fulfilled, and whatever it still
+// holds is given back when the order is cancelled or refunded, which is
+// recorded as a refund when captured money goes back. It returns the payment
+// left with gateway calls to make once tx commits, if any.
+func (h *Handler) settlePayment(tx *sql.Tx, order *types.Order) (*types.Payment, error) {
+	paymentStore := h.paymentStore.WithTx(tx)
+
+	p, err := payment.Current(paymentStore, order.ID)
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	switch order.Status {
+	case types.OrderStatusFulfilled:
+		if p.Status == types.PaymentAuthorized {
+			return p, payment.Capture(paymentStore, p)
+		}
+
+	case types.OrderStatusCancelled, types.OrderStatusRefunded:
+		if p.Status == types.PaymentAuthorized {
+			return p, payment.Void(paymentStore, p)
+		}
+
+		// returns may already have refunded part of the order
+		if left := order.Total.Sub(order.Refunded); left.IsPositive() {
+			if err := payment.Refund(paymentStore, p, left); err != nil {
+				return nil, err
+			}
+
+			if _, err := h.store.WithTx(tx).RecordRefund(types.Refund{OrderID: order.ID, PaymentID: &p.ID, Amount: left}); err != nil {
+				return nil, err
+			}
+		}
+
+		return p, paymentStore.UpdatePaymentStatus(p.ID, types.PaymentRefunded)
+	}
+
+	return nil, nil
+}
+
+// Cancel moves the order to cancelled, records who did it and why, gives
+// back the use of the coupon it redeemed, drops the stock reserved for it,
+// and puts every unit sold back on the shelf of the warehouse it was taken
+// from. actorID is nil when the system cancels the order.
+func Cancel(
+	orderStore types.OrderStore,
+	ledger types.StockLedger,
+	couponStore types.CouponStore,
+	reservations types.ReservationStore,
+	order *types.Order,
+	actorID *int,
+	reason string,
+) error {
+	if err := Transition(orderStore, order, types.OrderStatusCancelled, actorID, reason); err != nil {
+		return err
+	}
+
+	if err := orderStore.RecordCancellation(order.ID, actorID, reason); err != nil {
+		return err
+	}
+
+	if err := couponStore.ReleaseRedemption(order.ID); err != nil {
+		return err
+	}
+
+	// the units go back on sale below, so they mustn't stay held as well
+	if err := reservations.ReleaseOrderReservations(order.ID); err != nil {
+		return err
+	}
+
+	movements, err := ledger.GetMovementsByReference(Reference(order.ID))
+	if err != nil {
+		return err
+	}
+
+	sold := false
+	for _, m := range movements {
+		if m.Reason != types.StockSale {
+			continue
+		}
+		sold = true
+
+		err := ledger.Move(types.StockMovement{
+			ProductID:   m.ProductID,
+			VariantID:   m.VariantID,
+			WarehouseID: m.WarehouseID,
+			Change:      -m.Change,
+			Reason:      types.StockCancellation,
+			ActorID:     actorID,
+			Reference:   Reference(order.ID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if sold {
+		return nil
+	}
+
+	// orders placed before the stock ledger have no sale to reverse, so
+	// their items go back to the default warehouse
+	items, err := orderStore.GetOrderItems(order.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		err := ledger.Move(types.StockMovement{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Change:    item.Quantity,
+			Reason:    types.StockCancellation,
+			ActorID:   actorID,
+			Reference: Reference(order.ID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reference is how stock movements point at the order that caused them.
+func Reference(orderID int) string {
+	return fmt.Sprintf("order:%d", orderID)
+}
+
+func parsePagination(r *http.Request) (int, int, error) {
+	limit, offset := defaultOrdersLimit, 0
+	query := r.URL.Query()
+
+	if str := query.Get("limit"); str != "" {
+		l, err := strconv.Atoi(str)
+		if err != nil || l <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit")
+		}
+
+		limit = min(l, maxOrdersLimit)
+	}
+
+	if str := query.Get("offset"); str != "" {
+		o, err := strconv.Atoi(str)
+		if err != nil || o < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
+func getOrderIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["orderID"]
+	if !ok {
+		return 0, fmt.Errorf("missing order ID")
+	}
+
+	orderID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order ID")
+	}
+
+	return orderID, nil
+}
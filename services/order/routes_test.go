@@ -0,0 +1,1055 @@
+package order
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+)
+
+// the handlers are exercised without the JWT middleware, so every request
+// runs as the user id GetUserIDFromContext falls back to
+const anonymousUserID = -1
+
+// dollars builds a price in whole dollars.
+func dollars(n int64) types.Money {
+	return types.NewMoney(n*100, types.DefaultCurrency)
+}
+
+func TestOrderServiceHandlers(t *testing.T) {
+	orderStore := &mockOrderStore{
+		orders: []types.Order{
+			{ID: 1, UserID: anonymousUserID, Total: dollars(10), Status: "pending"},
+			{ID: 2, UserID: 7, Total: dollars(20), Status: "pending"},
+			{ID: 3, UserID: anonymousUserID, Total: dollars(30), Status: "pending"},
+		},
+		items: map[int][]types.OrderItemDetail{
+			3: {{OrderItem: types.OrderItem{ID: 1, OrderID: 3, ProductID: 1, Quantity: 3, Price: dollars(10)}, ProductName: "product 1"}},
+		},
+	}
+	handler := NewHandler(Deps{
+		OrderStore:   orderStore,
+		Ledger:       &mockLedger{},
+		CouponStore:  &mockCouponStore{},
+		Reservations: &mockReservationStore{},
+		PaymentStore: &mockPaymentStore{},
+		Transactor:   &mockTransactor{},
+	})
+
+	newRouter := func() *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/orders", handler.handleGetOrders).Methods(http.MethodGet)
+		router.HandleFunc("/orders/{orderID}", handler.handleGetOrder).Methods(http.MethodGet)
+		return router
+	}
+
+	t.Run("should list only the user's orders, newest first", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/orders?limit=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var page types.OrderPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != 2 || page.Limit != 1 {
+			t.Errorf("expected total 2 and limit 1, got %d and %d", page.Total, page.Limit)
+		}
+
+		if len(page.Orders) != 1 || page.Orders[0].ID != 3 {
+			t.Errorf("expected only order 3 on the first page, got %+v", page.Orders)
+		}
+	})
+
+	t.Run("should fail if the limit is invalid", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/orders?limit=-5", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should return the order with its items", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/orders/3", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var order types.OrderDetail
+		if err := json.NewDecoder(rr.Body).Decode(&order); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(order.Items) != 1 || order.Items[0].ProductName != "product 1" {
+			t.Errorf("expected the order items to be joined with the product, got %+v", order.Items)
+		}
+	})
+
+	t.Run("should not leak another user's order", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/orders/2", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestCancelOrder(t *testing.T) {
+	mediumVariantID := 21
+
+	newHandler := func() (*Handler, *mockOrderStore, *mockLedger) {
+		orderStore := &mockOrderStore{
+			orders: []types.Order{
+				{ID: 1, UserID: anonymousUserID, Total: dollars(50), Status: "pending"},
+				{ID: 2, UserID: anonymousUserID, Total: dollars(20), Status: types.OrderStatusPaid},
+				{ID: 3, UserID: 7, Total: dollars(30), Status: "pending"},
+			},
+			items: map[int][]types.OrderItemDetail{
+				1: {
+					{OrderItem: types.OrderItem{ID: 1, OrderID: 1, ProductID: 1, Quantity: 3, Price: dollars(10)}},
+					{OrderItem: types.OrderItem{ID: 2, OrderID: 1, ProductID: 2, VariantID: &mediumVariantID, Quantity: 1, Price: dollars(20)}},
+				},
+			},
+		}
+		ledger := &mockLedger{}
+		return NewHandler(Deps{
+			OrderStore:   orderStore,
+			Ledger:       ledger,
+			CouponStore:  &mockCouponStore{},
+			Reservations: &mockReservationStore{},
+			PaymentStore: &mockPaymentStore{},
+			Transactor:   &mockTransactor{},
+		}), orderStore, ledger
+	}
+
+	cancel := func(handler *Handler, orderID int, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/orders/%d/cancel", orderID), bytes.NewBufferString(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/orders/{orderID}/cancel", handler.handleCancelOrder).Methods(http.MethodPost)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should fail without a reason", func(t *testing.T) {
+		handler, _, _ := newHandler()
+
+		rr := cancel(handler, 1, `{}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should cancel a pending order and restore its stock", func(t *testing.T) {
+		handler, orderStore, ledger := newHandler()
+
+		rr := cancel(handler, 1, `{"reason": "changed my mind"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		order := orderStore.orders[0]
+		if order.Status != "cancelled" || order.CancelReason != "changed my mind" || order.CancelledBy == nil || *order.CancelledBy != anonymousUserID {
+			t.Errorf("expected the order to record the cancellation, got %+v", order)
+		}
+
+		if len(ledger.movements) != 2 {
+			t.Fatalf("expected a movement per item, got %+v", ledger.movements)
+		}
+
+		product, variant := ledger.movements[0], ledger.movements[1]
+		if product.ProductID != 1 || product.VariantID != nil || product.Change != 3 {
+			t.Errorf("expected product 1 to be restocked, got %+v", product)
+		}
+
+		if variant.VariantID == nil || *variant.VariantID != mediumVariantID || variant.Change != 1 {
+			t.Errorf("expected the variant sold to be restocked, got %+v", variant)
+		}
+
+		if product.Reason != types.StockCancellation || product.Reference != "order:1" || product.ActorID == nil || *product.ActorID != anonymousUserID {
+			t.Errorf("expected the movement to point at the cancellation, got %+v", product)
+		}
+
+		if len(orderStore.history) != 1 || orderStore.history[0].ToStatus != types.OrderStatusCancelled {
+			t.Errorf("expected the cancellation to be recorded in the status history, got %+v", orderStore.history)
+		}
+	})
+
+	t.Run("should put the stock back in the warehouses it was sold from", func(t *testing.T) {
+		handler, _, ledger := newHandler()
+		ledger.movements = []types.StockMovement{
+			{ProductID: 1, WarehouseID: intPtr(2), Change: -2, Reason: types.StockSale, Reference: "order:1"},
+			{ProductID: 1, WarehouseID: intPtr(1), Change: -1, Reason: types.StockSale, Reference: "order:1"},
+		}
+
+		rr := cancel(handler, 1, `{"reason": "changed my mind"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(ledger.movements) != 4 {
+			t.Fatalf("expected a movement per sale, got %+v", ledger.movements)
+		}
+
+		for i, want := range []struct{ warehouseID, change int }{{2, 2}, {1, 1}} {
+			m := ledger.movements[2+i]
+			if m.WarehouseID == nil || *m.WarehouseID != want.warehouseID || m.Change != want.change || m.Reason != types.StockCancellation {
+				t.Errorf("expected %d units back in warehouse %d, got %+v", want.change, want.warehouseID, m)
+			}
+		}
+	})
+
+	t.Run("should give back the use of the coupon the order redeemed", func(t *testing.T) {
+		orderStore := &mockOrderStore{orders: []types.Order{{ID: 1, UserID: anonymousUserID, Total: dollars(50), Status: types.OrderStatusPending}}}
+		couponStore := &mockCouponStore{redemptions: map[int]int{1: 5}}
+		handler := NewHandler(Deps{
+			OrderStore:   orderStore,
+			Ledger:       &mockLedger{},
+			CouponStore:  couponStore,
+			Reservations: &mockReservationStore{},
+			PaymentStore: &mockPaymentStore{},
+			Transactor:   &mockTransactor{},
+		})
+
+		rr := cancel(handler, 1, `{"reason": "changed my mind"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(couponStore.redemptions) != 0 {
+			t.Errorf("expected the redemption to be released, got %v", couponStore.redemptions)
+		}
+	})
+
+	t.Run("should drop the reservations of the order", func(t *testing.T) {
+		orderStore := &mockOrderStore{orders: []types.Order{{ID: 1, UserID: anonymousUserID, Total: dollars(50), Status: types.OrderStatusPending}}}
+		reservations := &mockReservationStore{}
+		handler := NewHandler(Deps{
+			OrderStore:   orderStore,
+			Ledger:       &mockLedger{},
+			CouponStore:  &mockCouponStore{},
+			Reservations: reservations,
+			PaymentStore: &mockPaymentStore{},
+			Transactor:   &mockTransactor{},
+		})
+
+		rr := cancel(handler, 1, `{"reason": "changed my mind"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(reservations.released) != 1 || reservations.released[0] != 1 {
+			t.Errorf("expected the reservations of order 1 to be released, got %v", reservations.released)
+		}
+	})
+
+	t.Run("should refuse to cancel an order that is not pending", func(t *testing.T) {
+		handler, _, ledger := newHandler()
+
+		rr := cancel(handler, 2, `{"reason": "too late"}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if len(ledger.movements) != 0 {
+			t.Errorf("expected no stock to be restored, got %+v", ledger.movements)
+		}
+	})
+
+	t.Run("should not cancel another user's order", func(t *testing.T) {
+		handler, orderStore, _ := newHandler()
+
+		rr := cancel(handler, 3, `{"reason": "not mine"}`)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		if orderStore.orders[2].Status != "pending" {
+			t.Errorf("expected the order to stay pending, got %s", orderStore.orders[2].Status)
+		}
+	})
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	customer := &types.User{ID: 1, Role: types.RoleCustomer}
+	staff := &types.User{ID: 2, Role: types.RoleStaff}
+	userStore := &mockUserStore{users: map[int]*types.User{customer.ID: customer, staff.ID: staff}}
+
+	newRouter := func() (*mux.Router, *mockOrderStore, *mockLedger) {
+		orderStore := &mockOrderStore{
+			orders: []types.Order{
+				{ID: 1, UserID: customer.ID, Status: types.OrderStatusPending},
+				{ID: 2, UserID: customer.ID, Status: types.OrderStatusPaid},
+			},
+			items: map[int][]types.OrderItemDetail{
+				2: {{OrderItem: types.OrderItem{ID: 1, OrderID: 2, ProductID: 1, Quantity: 4}}},
+			},
+		}
+		ledger := &mockLedger{}
+
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			OrderStore:   orderStore,
+			Ledger:       ledger,
+			CouponStore:  &mockCouponStore{},
+			Reservations: &mockReservationStore{},
+			PaymentStore: &mockPaymentStore{},
+			UserStore:    userStore,
+			Transactor:   &mockTransactor{},
+		}).RegisterRoutes(router)
+		return router, orderStore, ledger
+	}
+
+	updateStatus := func(router *mux.Router, user *types.User, orderID int, payload string) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, http.MethodPost, fmt.Sprintf("/orders/%d/status", orderID), bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should not let customers change an order status", func(t *testing.T) {
+		router, orderStore, _ := newRouter()
+
+		rr := updateStatus(router, customer, 1, `{"status": "paid"}`)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		if orderStore.orders[0].Status != types.OrderStatusPending {
+			t.Errorf("expected the order to stay pending, got %s", orderStore.orders[0].Status)
+		}
+	})
+
+	t.Run("should let staff move an order forward", func(t *testing.T) {
+		router, orderStore, _ := newRouter()
+
+		rr := updateStatus(router, staff, 1, `{"status": "paid", "note": "bank transfer received"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(orderStore.history) != 1 || *orderStore.history[0].ActorID != staff.ID {
+			t.Errorf("expected the change to be recorded against the staff member, got %+v", orderStore.history)
+		}
+	})
+
+	t.Run("should reject an illegal transition", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		rr := updateStatus(router, staff, 1, `{"status": "delivered"}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+	})
+
+	t.Run("should make staff cancel a paid order rather than refund it", func(t *testing.T) {
+		router, orderStore, _ := newRouter()
+
+		rr := updateStatus(router, staff, 2, `{"status": "refunded"}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if orderStore.orders[1].Status != types.OrderStatusPaid {
+			t.Errorf("expected the order to stay paid, got %s", orderStore.orders[1].Status)
+		}
+	})
+
+	t.Run("should not partially refund an order by hand", func(t *testing.T) {
+		router, orderStore, _ := newRouter()
+		orderStore.orders[1].Status = types.OrderStatusDelivered
+
+		rr := updateStatus(router, staff, 2, `{"status": "partially_refunded"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if orderStore.orders[1].Status != types.OrderStatusDelivered {
+			t.Errorf("expected the order to stay delivered, got %s", orderStore.orders[1].Status)
+		}
+	})
+
+	t.Run("should reject an unknown status", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		rr := updateStatus(router, staff, 1, `{"status": "lost"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should restock when staff cancel a paid order", func(t *testing.T) {
+		router, orderStore, ledger := newRouter()
+
+		rr := updateStatus(router, staff, 2, `{"status": "cancelled", "note": "out of stock at the warehouse"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if orderStore.orders[1].Status != types.OrderStatusCancelled || len(ledger.movements) != 1 || ledger.movements[0].Change != 4 {
+			t.Errorf("expected the order to be cancelled and restocked, got %s and %+v", orderStore.orders[1].Status, ledger.movements)
+		}
+	})
+
+	t.Run("should drop the reservations when staff cancel an order", func(t *testing.T) {
+		orderStore := &mockOrderStore{orders: []types.Order{{ID: 1, UserID: customer.ID, Status: types.OrderStatusPending}}}
+		reservations := &mockReservationStore{}
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			OrderStore:   orderStore,
+			Ledger:       &mockLedger{},
+			CouponStore:  &mockCouponStore{},
+			Reservations: reservations,
+			PaymentStore: &mockPaymentStore{},
+			UserStore:    userStore,
+			Transactor:   &mockTransactor{},
+		}).RegisterRoutes(router)
+
+		rr := updateStatus(router, staff, 1, `{"status": "cancelled", "note": "duplicate order"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(reservations.released) != 1 || reservations.released[0] != 1 {
+			t.Errorf("expected the reservations of order 1 to be released, got %v", reservations.released)
+		}
+	})
+}
+
+func TestSettlePayment(t *testing.T) {
+	staff := &types.User{ID: 2, Role: types.RoleStaff}
+	userStore := &mockUserStore{users: map[int]*types.User{staff.ID: staff}}
+
+	type fixture struct {
+		router       *mux.Router
+		orderStore   *mockOrderStore
+		paymentStore *mockPaymentStore
+		gateway      *mockPaymentGateway
+		settler      *payment.Settler
+		transactor   *mockTransactor
+	}
+
+	// order 1 is paid with its payment only authorized, order 2 was shipped,
+	// captured and has had $10 of it returned
+	setup := func() fixture {
+		f := fixture{
+			orderStore: &mockOrderStore{orders: []types.Order{
+				{ID: 1, UserID: 1, Total: dollars(40), Status: types.OrderStatusPaid},
+				{ID: 2, UserID: 1, Total: dollars(40), Refunded: dollars(10), Status: types.OrderStatusPartiallyRefunded},
+			}},
+			paymentStore: &mockPaymentStore{payments: []types.Payment{
+				{ID: 1, OrderID: 1, Reference: "auth_1", Amount: dollars(40), Status: types.PaymentAuthorized},
+				{ID: 2, OrderID: 2, Reference: "auth_2", Amount: dollars(40), Status: types.PaymentCaptured},
+			}},
+			gateway:    &mockPaymentGateway{},
+			transactor: &mockTransactor{},
+		}
+
+		settler, err := payment.NewSettler(f.paymentStore, f.gateway, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.settler = settler
+
+		f.router = mux.NewRouter()
+		NewHandler(Deps{
+			OrderStore:     f.orderStore,
+			Ledger:         &mockLedger{},
+			CouponStore:    &mockCouponStore{},
+			Reservations:   &mockReservationStore{},
+			PaymentStore:   f.paymentStore,
+			PaymentSettler: f.settler,
+			UserStore:      userStore,
+			Transactor:     f.transactor,
+		}).RegisterRoutes(f.router)
+		return f
+	}
+
+	updateStatus := func(f fixture, orderID int, payload string) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, http.MethodPost, fmt.Sprintf("/orders/%d/status", orderID), bytes.NewBufferString(payload), staff)
+		rr := httptest.NewRecorder()
+		f.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should capture the payment when the order is fulfilled", func(t *testing.T) {
+		f := setup()
+
+		rr := updateStatus(f, 1, `{"status": "fulfilled"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(f.gateway.captured) != 1 || f.paymentStore.payments[0].Status != types.PaymentCaptured {
+			t.Errorf("expected the payment to be captured, got %v and %s", f.gateway.captured, f.paymentStore.payments[0].Status)
+		}
+	})
+
+	t.Run("should void the authorization when staff cancel the order", func(t *testing.T) {
+		f := setup()
+
+		rr := updateStatus(f, 1, `{"status": "cancelled"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(f.gateway.voided) != 1 || f.paymentStore.payments[0].Status != types.PaymentVoided {
+			t.Errorf("expected the payment to be voided, got %v and %s", f.gateway.voided, f.paymentStore.payments[0].Status)
+		}
+
+		if len(f.orderStore.refunds) != 0 {
+			t.Errorf("expected no refund for money never taken, got %+v", f.orderStore.refunds)
+		}
+	})
+
+	t.Run("should refund what is left of a captured payment", func(t *testing.T) {
+		f := setup()
+
+		rr := updateStatus(f, 2, `{"status": "refunded"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(f.gateway.refunded) != 1 || f.gateway.refunded[0] != dollars(30) {
+			t.Errorf("expected $30 to be refunded, got %v", f.gateway.refunded)
+		}
+
+		if len(f.orderStore.refunds) != 1 || f.orderStore.orders[1].Refunded != dollars(40) {
+			t.Errorf("expected the refund to be recorded, got %+v", f.orderStore.refunds)
+		}
+
+		if f.paymentStore.payments[1].Status != types.PaymentRefunded {
+			t.Errorf("expected the payment to be refunded, got %s", f.paymentStore.payments[1].Status)
+		}
+	})
+
+	t.Run("should only call the gateway once the new status is committed", func(t *testing.T) {
+		f := setup()
+		f.gateway.onCall = func() {
+			if f.transactor.open {
+				t.Error("expected no transaction to be open when the gateway is called")
+			}
+		}
+
+		if rr := updateStatus(f, 1, `{"status": "fulfilled"}`); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(f.gateway.captured) != 1 {
+			t.Errorf("expected the payment to be captured, got %v", f.gateway.captured)
+		}
+	})
+
+	t.Run("should keep a capture the gateway failed for a retry", func(t *testing.T) {
+		f := setup()
+		f.gateway.err = fmt.Errorf("gateway unavailable")
+
+		rr := updateStatus(f, 1, `{"status": "fulfilled"}`)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d, got %d", http.StatusAccepted, rr.Code)
+		}
+
+		if f.orderStore.orders[0].Status != types.OrderStatusFulfilled {
+			t.Errorf("expected the order to stay fulfilled, got %s", f.orderStore.orders[0].Status)
+		}
+
+		if len(f.paymentStore.operations) != 1 || f.paymentStore.operations[0].Status != types.PaymentOperationPending || f.paymentStore.operations[0].Error == "" {
+			t.Fatalf("expected the capture to be left pending with its error, got %+v", f.paymentStore.operations)
+		}
+
+		f.gateway.err = nil
+		if err := f.settler.Settle(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(f.gateway.captured) != 1 || f.paymentStore.operations[0].Status != types.PaymentOperationSucceeded {
+			t.Errorf("expected the retry to capture the payment, got %v and %+v", f.gateway.captured, f.paymentStore.operations)
+		}
+	})
+}
+
+// newAuthenticatedRequest builds a request carrying a JWT for user.
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", token)
+	return req
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(id int, role types.Role) error {
+	return nil
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+type mockOrderStore struct {
+	orders  []types.Order
+	items   map[int][]types.OrderItemDetail
+	history []types.OrderStatusChange
+	refunds []types.Refund
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	orders := []types.Order{}
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		if m.orders[i].UserID == userID {
+			orders = append(orders, m.orders[i])
+		}
+	}
+
+	total := len(orders)
+	if offset > total {
+		offset = total
+	}
+
+	return orders[offset:min(offset+limit, total)], total, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID && o.UserID == userID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrder(orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	return m.items[orderID], nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	for i := range m.orders {
+		if m.orders[i].ID != orderID {
+			continue
+		}
+
+		if m.orders[i].Status != from {
+			return fmt.Errorf("order %d is no longer %s: %w", orderID, from, types.ErrConflict)
+		}
+
+		m.orders[i].Status = to
+		return nil
+	}
+
+	return fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	for i := range m.orders {
+		if m.orders[i].ID == orderID {
+			m.orders[i].CancelledBy = cancelledBy
+			m.orders[i].CancelReason = reason
+		}
+	}
+
+	return nil
+}
+
+func (m *mockOrderStore) CreateStatusChange(change types.OrderStatusChange) error {
+	m.history = append(m.history, change)
+	return nil
+}
+
+func (m *mockOrderStore) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	history := []types.OrderStatusChange{}
+	for _, change := range m.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+
+	return history, nil
+}
+
+func (m *mockOrderStore) RecordRefund(refund types.Refund) (int, error) {
+	refund.ID = len(m.refunds) + 1
+	m.refunds = append(m.refunds, refund)
+
+	for i := range m.orders {
+		if m.orders[i].ID == refund.OrderID {
+			m.orders[i].Refunded = m.orders[i].Refunded.Add(refund.Amount)
+		}
+	}
+
+	return refund.ID, nil
+}
+
+func (m *mockOrderStore) GetRefunds(orderID int) ([]types.Refund, error) {
+	refunds := []types.Refund{}
+	for _, refund := range m.refunds {
+		if refund.OrderID == orderID {
+			refunds = append(refunds, refund)
+		}
+	}
+
+	return refunds, nil
+}
+
+func (m *mockOrderStore) WithTx(tx *sql.Tx) types.OrderStore {
+	return m
+}
+
+// mockLedger keeps the movements recorded, in order.
+type mockLedger struct {
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+// mockCouponStore only keeps the redemptions, by order ID.
+type mockCouponStore struct {
+	redemptions map[int]int
+}
+
+func (m *mockCouponStore) GetCoupons() ([]types.Coupon, error) {
+	return []types.Coupon{}, nil
+}
+
+func (m *mockCouponStore) GetCouponByID(couponID int) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) GetCouponByCode(code string) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) CreateCoupon(c types.Coupon) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCouponStore) UpdateCoupon(c types.Coupon) error {
+	return nil
+}
+
+func (m *mockCouponStore) DeleteCoupon(couponID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	return productIDs, nil
+}
+
+func (m *mockCouponStore) RedeemCoupon(couponID int, userID int, orderID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) ReleaseRedemption(orderID int) error {
+	delete(m.redemptions, orderID)
+	return nil
+}
+
+func (m *mockCouponStore) WithTx(tx *sql.Tx) types.CouponStore {
+	return m
+}
+
+type mockPaymentStore struct {
+	payments   []types.Payment
+	operations []types.PaymentOperation
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	for _, p := range m.payments {
+		if p.ID == paymentID {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) CreatePayment(p types.Payment) (int, error) {
+	return 0, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	for i := range m.payments {
+		if m.payments[i].ID == paymentID {
+			m.payments[i].Status = status
+		}
+	}
+
+	return nil
+}
+
+func (m *mockPaymentStore) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	op.ID = len(m.operations) + 1
+	op.Status = types.PaymentOperationPending
+	m.operations = append(m.operations, op)
+	return op.ID, nil
+}
+
+func (m *mockPaymentStore) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	ops := []types.PaymentOperation{}
+	for _, op := range m.operations {
+		if op.PaymentID == paymentID && op.Status == types.PaymentOperationPending {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops, nil
+}
+
+func (m *mockPaymentStore) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *mockPaymentStore) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	m.operations[op.ID-1].Attempts++
+	return true, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	m.operations[operationID-1].Status = status
+	m.operations[operationID-1].Error = errMsg
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx *sql.Tx) types.PaymentStore {
+	return m
+}
+
+// mockPaymentGateway keeps track of what was done with the payments, or
+// fails every call with err. onCall, when set, runs on every call.
+type mockPaymentGateway struct {
+	err      error
+	onCall   func()
+	captured []string
+	voided   []string
+	refunded []types.Money
+}
+
+func (m *mockPaymentGateway) Name() string {
+	return "mock"
+}
+
+func (m *mockPaymentGateway) Authorize(ctx context.Context, orderID int, amount types.Money) (string, error) {
+	return "", m.err
+}
+
+func (m *mockPaymentGateway) Capture(ctx context.Context, reference string, amount types.Money) error {
+	if m.onCall != nil {
+		m.onCall()
+	}
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.captured = append(m.captured, reference)
+	return nil
+}
+
+func (m *mockPaymentGateway) Refund(ctx context.Context, reference string, amount types.Money) error {
+	if m.onCall != nil {
+		m.onCall()
+	}
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.refunded = append(m.refunded, amount)
+	return nil
+}
+
+func (m *mockPaymentGateway) Void(ctx context.Context, reference string) error {
+	if m.onCall != nil {
+		m.onCall()
+	}
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.voided = append(m.voided, reference)
+	return nil
+}
+
+// mockReservationStore keeps the orders whose reservations were released.
+type mockReservationStore struct {
+	released []int
+}
+
+func (m *mockReservationStore) GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (types.ReservedStock, error) {
+	return types.ReservedStock{}, nil
+}
+
+func (m *mockReservationStore) CreateReservations(reservations []types.StockReservation) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseCartReservations(userID int) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseOrderReservations(orderID int) error {
+	m.released = append(m.released, orderID)
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseExpiredCartReservations(now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *mockReservationStore) GetExpiredOrderIDs(now time.Time, limit int) ([]int, error) {
+	return []int{}, nil
+}
+
+func (m *mockReservationStore) WithTx(tx *sql.Tx) types.ReservationStore {
+	return m
+}
+
+// mockTransactor runs fn without a transaction, keeping track of whether
+// one would be open.
+type mockTransactor struct {
+	open bool
+}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	m.open = true
+	defer func() { m.open = false }()
+
+	return fn(nil)
+}
@@ -0,0 +1,84 @@
+package order
+
+import (
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// ErrIllegalTransition is returned when an order is asked to move to a status
+// its current status can't lead to. It wraps types.ErrConflict.
+var ErrIllegalTransition = fmt.Errorf("illegal order status transition: %w", types.ErrConflict)
+
+// transitions lists, for every status, the statuses an order can move to
+// next. Cancelled and refunded orders are final. Orders that haven't shipped
+// aren't refunded but cancelled, which also puts their units back in stock.
+var transitions = map[types.OrderStatus][]types.OrderStatus{
+	types.OrderStatusPending:   {types.OrderStatusPaid, types.OrderStatusCancelled},
+	types.OrderStatusPaid:      {types.OrderStatusFulfilled, types.OrderStatusCancelled},
+	types.OrderStatusFulfilled: {types.OrderStatusShipped, types.OrderStatusCancelled},
+	types.OrderStatusShipped:   {types.OrderStatusDelivered, types.OrderStatusRefunded},
+	types.OrderStatusDelivered: {types.OrderStatusPartiallyRefunded, types.OrderStatusRefunded},
+	types.OrderStatusCancelled: {},
+	types.OrderStatusRefunded:  {},
+	// returned units are refunded a return at a time
+	types.OrderStatusPartiallyRefunded: {types.OrderStatusRefunded},
+}
+
+// ParseStatus validates a status coming from outside the service.
+func ParseStatus(s string) (types.OrderStatus, error) {
+	status := types.OrderStatus(s)
+	if _, ok := transitions[status]; !ok {
+		return "", fmt.Errorf("unknown order status %q", s)
+	}
+
+	return status, nil
+}
+
+// CanTransition reports whether an order can move from one status to another.
+func CanTransition(from types.OrderStatus, to types.OrderStatus) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, from, to)
+}
+
+// Transition moves the order to a new status and records the change in its
+// history. actorID is nil when the system, not a user, makes the change. On
+// success order.Status is updated in place.
+func Transition(store types.OrderStore, order *types.Order, to types.OrderStatus, actorID *int, note string) error {
+	if err := CanTransition(order.Status, to); err != nil {
+		return err
+	}
+
+	if err := store.UpdateOrderStatus(order.ID, order.Status, to); err != nil {
+		return err
+	}
+
+	err := store.CreateStatusChange(types.OrderStatusChange{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   to,
+		ActorID:    actorID,
+		Note:       note,
+	})
+	if err != nil {
+		return err
+	}
+
+	order.Status = to
+	return nil
+}
+
+// RecordPlaced writes the first history entry of a freshly created order.
+func RecordPlaced(store types.OrderStore, orderID int, actorID *int) error {
+	return store.CreateStatusChange(types.OrderStatusChange{
+		OrderID:  orderID,
+		ToStatus: types.OrderStatusPending,
+		ActorID:  actorID,
+		Note:     "order placed",
+	})
+}
@@ -0,0 +1,87 @@
+package order
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from    types.OrderStatus
+		to      types.OrderStatus
+		allowed bool
+	}{
+		{types.OrderStatusPending, types.OrderStatusPaid, true},
+		{types.OrderStatusPending, types.OrderStatusCancelled, true},
+		{types.OrderStatusPending, types.OrderStatusShipped, false},
+		{types.OrderStatusPending, types.OrderStatusRefunded, false},
+		{types.OrderStatusPaid, types.OrderStatusFulfilled, true},
+		{types.OrderStatusPaid, types.OrderStatusRefunded, false},
+		{types.OrderStatusFulfilled, types.OrderStatusShipped, true},
+		{types.OrderStatusFulfilled, types.OrderStatusRefunded, false},
+		{types.OrderStatusShipped, types.OrderStatusRefunded, true},
+		{types.OrderStatusShipped, types.OrderStatusDelivered, true},
+		{types.OrderStatusShipped, types.OrderStatusCancelled, false},
+		{types.OrderStatusDelivered, types.OrderStatusRefunded, true},
+		{types.OrderStatusDelivered, types.OrderStatusPending, false},
+		{types.OrderStatusCancelled, types.OrderStatusPending, false},
+		{types.OrderStatusRefunded, types.OrderStatusPaid, false},
+		{types.OrderStatusPaid, types.OrderStatusPaid, false},
+	}
+
+	for _, tt := range tests {
+		err := CanTransition(tt.from, tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("expected %s -> %s to be allowed, got %v", tt.from, tt.to, err)
+		}
+
+		if !tt.allowed && !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("expected %s -> %s to be rejected, got %v", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	if _, err := ParseStatus("shipped"); err != nil {
+		t.Errorf("expected shipped to be a valid status, got %v", err)
+	}
+
+	if _, err := ParseStatus("completed"); err == nil {
+		t.Error("expected completed to be rejected")
+	}
+}
+
+func TestTransitionRecordsHistory(t *testing.T) {
+	store := &mockOrderStore{
+		orders: []types.Order{{ID: 1, Status: types.OrderStatusPending}},
+	}
+	order := store.orders[0]
+	actorID := 42
+
+	if err := Transition(store, &order, types.OrderStatusPaid, &actorID, "payment captured"); err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != types.OrderStatusPaid || store.orders[0].Status != types.OrderStatusPaid {
+		t.Errorf("expected the order to be paid, got %s", store.orders[0].Status)
+	}
+
+	if len(store.history) != 1 {
+		t.Fatalf("expected one history entry, got %d", len(store.history))
+	}
+
+	change := store.history[0]
+	if change.FromStatus != types.OrderStatusPending || change.ToStatus != types.OrderStatusPaid || *change.ActorID != actorID || change.Note != "payment captured" {
+		t.Errorf("unexpected history entry %+v", change)
+	}
+
+	if err := Transition(store, &order, types.OrderStatusPending, nil, ""); !errors.Is(err, types.ErrConflict) {
+		t.Errorf("expected going back to pending to be rejected, got %v", err)
+	}
+
+	if len(store.history) != 1 {
+		t.Errorf("expected a rejected transition not to be recorded, got %d entries", len(store.history))
+	}
+}
@@ -2,6 +2,7 @@ package order
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/sikozonpc/ecom/types"
 )
@@ -9,9 +10,9 @@ import (
 //****** Esse código, portanto, faz a inserção de pedidos e itens de pedidos em um banco de dados,
 //retornando o ID do pedido e tratando erros quando necessário.**/
 
-// Define a estrutura 'Store', que representa o armazenamento de dados (banco de dados) com um campo 'db' do tipo *sql.DB.
+// Define a estrutura 'Store', que representa o armazenamento de dados (banco de dados) com um campo 'db' do tipo types.DBTX.
 type Store struct {
-	db *sql.DB // 'db' é uma referência para uma conexão com o banco de dados.
+	db types.DBTX // 'db' é a conexão com o banco de dados ou uma transação em andamento.
 }
 
 // Função que cria uma nova instância de 'Store' com uma conexão de banco de dados fornecida.
@@ -19,11 +20,26 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db} // Retorna um ponteiro para uma nova instância de 'Store' com o banco de dados associado.
 }
 
+// WithTx retorna uma cópia da 'Store' que executa as consultas dentro da transação 'tx'.
+func (s *Store) WithTx(tx *sql.Tx) types.OrderStore {
+	return &Store{db: tx}
+}
+
 // Método 'CreateOrder' da estrutura 'Store', que cria um novo pedido no banco de dados.
 func (s *Store) CreateOrder(order types.Order) (int, error) {
 	// Executa um comando SQL para inserir um novo pedido na tabela 'orders'.
 	// Os valores são passados como parâmetros, substituindo os pontos de interrogação.
-	res, err := s.db.Exec("INSERT INTO orders (userId, total, status, address) VALUES (?, ?, ?, ?)", order.UserID, order.Total, order.Status, order.Address)
+	// O endereço de entrega é copiado para o pedido, para que edições futuras no catálogo de endereços não alterem o histórico.
+	// A moeda e a taxa de câmbio também são guardadas, para que o total possa ser reproduzido depois.
+	// O cupom usado fica registrado pelo ID e pelo código, que sobrevive à exclusão do cupom.
+	// Do método de entrega também são guardados o nome e o custo cobrado, pelo mesmo motivo.
+	shipping := order.ShippingAddress
+	res, err := s.db.Exec(
+		"INSERT INTO orders (userId, currency, subtotal, discount, tax, shippingCost, total, exchangeRate, couponId, couponCode, freeShipping, shippingMethodId, shippingMethod, status, address, shippingFullName, shippingLine1, shippingLine2, shippingCity, shippingState, shippingPostalCode, shippingCountry, shippingPhone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		order.UserID, order.Total.Currency, order.Subtotal, order.Discount, order.Tax, order.ShippingCost, order.Total, order.ExchangeRate,
+		order.CouponID, order.CouponCode, order.FreeShipping, order.ShippingMethodID, order.ShippingMethod, order.Status, order.Address,
+		shipping.FullName, shipping.Line1, shipping.Line2, shipping.City, shipping.State, shipping.PostalCode, shipping.Country, shipping.Phone,
+	)
 	if err != nil {
 		return 0, err
 	}
@@ -40,7 +56,303 @@ func (s *Store) CreateOrder(order types.Order) (int, error) {
 }
 
 // Método 'CreateOrderItem' da estrutura 'Store', que cria um item de pedido no banco de dados.
-func (s *Store) CreateOrderItem(orderItem types.OrderItem) error {
-	_, err := s.db.Exec("INSERT INTO order_items (orderId, productId, quantity, price) VALUES (?, ?, ?, ?)", orderItem.OrderID, orderItem.ProductID, orderItem.Quantity, orderItem.Price)
+// O preço do item está na moeda do pedido; o preço de catálogo e a taxa usada na conversão ficam junto.
+// O desconto é o quanto do desconto do pedido coube à linha inteira, e não a cada unidade; o imposto também vale para a linha inteira.
+// Retorna o ID do item criado, usado para alocar o item aos depósitos.
+func (s *Store) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO order_items (orderId, productId, variantId, quantity, price, discount, tax, listCurrency, listPrice, exchangeRate) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		orderItem.OrderID, orderItem.ProductID, orderItem.VariantID, orderItem.Quantity, orderItem.Price, orderItem.Discount, orderItem.Tax,
+		orderItem.ListPrice.Currency, orderItem.ListPrice, orderItem.ExchangeRate,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Método 'UpdateOrderStatus' altera o status de um pedido, desde que ele ainda esteja no status 'from'.
+// A condição sobre o status torna a verificação e a alteração uma única operação atômica.
+func (s *Store) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	res, err := s.db.Exec("UPDATE orders SET status = ? WHERE id = ? AND status = ?", to, orderID, from)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// Nenhuma linha alterada significa que outra requisição já mudou o status do pedido.
+	if affected == 0 {
+		return fmt.Errorf("order %d is no longer %s: %w", orderID, from, types.ErrConflict)
+	}
+
+	return nil
+}
+
+// Método 'RecordCancellation' registra quem cancelou o pedido e o motivo.
+// 'cancelledBy' é nulo quando o pedido foi cancelado pelo sistema, como após um pagamento recusado.
+func (s *Store) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	_, err := s.db.Exec(
+		"UPDATE orders SET cancelledBy = ?, cancelReason = ?, cancelledAt = CURRENT_TIMESTAMP WHERE id = ?",
+		cancelledBy, reason, orderID,
+	)
 	return err
 }
+
+// Método 'CreateStatusChange' adiciona uma entrada ao histórico de status do pedido.
+func (s *Store) CreateStatusChange(change types.OrderStatusChange) error {
+	_, err := s.db.Exec(
+		"INSERT INTO order_status_history (orderId, fromStatus, toStatus, actorId, note) VALUES (?, ?, ?, ?, ?)",
+		change.OrderID, change.FromStatus, change.ToStatus, change.ActorID, change.Note,
+	)
+	return err
+}
+
+// Método 'GetStatusHistory' retorna o histórico de status de um pedido, do mais antigo para o mais recente.
+func (s *Store) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	rows, err := s.db.Query("SELECT * FROM order_status_history WHERE orderId = ? ORDER BY createdAt, id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]types.OrderStatusChange, 0)
+	for rows.Next() {
+		var change types.OrderStatusChange
+		err := rows.Scan(
+			&change.ID,
+			&change.OrderID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ActorID,
+			&change.Note,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// Colunas lidas de 'orders', na ordem esperada por 'scanRowsIntoOrder'.
+// A moeda é lida antes de cada valor em dinheiro porque define como ele é lido.
+const orderColumns = "id, userId, currency, subtotal, currency, discount, currency, tax, currency, shippingCost, currency, total, currency, refunded, exchangeRate, couponId, couponCode, freeShipping, shippingMethodId, shippingMethod, status, address, shippingFullName, shippingLine1, shippingLine2, shippingCity, shippingState, shippingPostalCode, shippingCountry, shippingPhone, cancelledBy, cancelReason, cancelledAt, createdAt"
+
+// Método 'GetOrdersByUserID' retorna uma página dos pedidos do usuário, do mais recente para o mais antigo,
+// junto com o total de pedidos que ele possui.
+func (s *Store) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM orders WHERE userId = ?", userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE userId = ? ORDER BY createdAt DESC, id DESC LIMIT ? OFFSET ?", userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := make([]types.Order, 0)
+	for rows.Next() {
+		o, err := scanRowsIntoOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		orders = append(orders, *o)
+	}
+
+	return orders, total, rows.Err()
+}
+
+// Método 'GetOrderByID' busca um pedido pelo ID, mas somente se ele pertencer ao usuário informado.
+// Pedidos de outros usuários são tratados como inexistentes.
+func (s *Store) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE id = ? AND userId = ?", orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+	}
+
+	return scanRowsIntoOrder(rows)
+}
+
+// Método 'GetOrder' busca um pedido pelo ID, independentemente do dono. É usado pelas rotas da equipe.
+func (s *Store) GetOrder(orderID int) (*types.Order, error) {
+	rows, err := s.db.Query("SELECT "+orderColumns+" FROM orders WHERE id = ?", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+	}
+
+	return scanRowsIntoOrder(rows)
+}
+
+// Método 'GetOrderItems' retorna os itens de um pedido junto com o nome e a imagem de cada produto
+// e, quando o item é uma variante, o SKU vendido.
+func (s *Store) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	rows, err := s.db.Query(
+		`SELECT oi.id, oi.orderId, oi.productId, oi.variantId, oi.quantity, o.currency, oi.price, o.currency, oi.discount, o.currency, oi.tax, oi.listCurrency, oi.listPrice, oi.exchangeRate,
+			p.name, p.image, COALESCE(v.sku, '')
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.orderId
+		JOIN products p ON p.id = oi.productId
+		LEFT JOIN product_variants v ON v.id = oi.variantId
+		WHERE oi.orderId = ?
+		ORDER BY oi.id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]types.OrderItemDetail, 0)
+	for rows.Next() {
+		var item types.OrderItemDetail
+		err := rows.Scan(
+			&item.ID,
+			&item.OrderID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.Quantity,
+			&item.Price.Currency,
+			&item.Price,
+			&item.Discount.Currency,
+			&item.Discount,
+			&item.Tax.Currency,
+			&item.Tax,
+			&item.ListPrice.Currency,
+			&item.ListPrice,
+			&item.ExchangeRate,
+			&item.ProductName,
+			&item.ProductImage,
+			&item.VariantSKU,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Método 'RecordRefund' registra um reembolso e o soma ao total reembolsado do pedido.
+// O valor está na moeda do pedido, como o total.
+func (s *Store) RecordRefund(refund types.Refund) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO refunds (orderId, returnId, paymentId, currency, amount) VALUES (?, ?, ?, ?, ?)",
+		refund.OrderID, refund.ReturnID, refund.PaymentID, refund.Amount.Currency, refund.Amount,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// O total reembolsado é somado no banco, para que reembolsos simultâneos não se sobrescrevam.
+	_, err = s.db.Exec("UPDATE orders SET refunded = refunded + ? WHERE id = ?", refund.Amount, refund.OrderID)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Método 'GetRefunds' retorna os reembolsos de um pedido, do mais antigo para o mais recente.
+func (s *Store) GetRefunds(orderID int) ([]types.Refund, error) {
+	rows, err := s.db.Query(
+		"SELECT id, orderId, returnId, paymentId, currency, amount, createdAt FROM refunds WHERE orderId = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := make([]types.Refund, 0)
+	for rows.Next() {
+		var r types.Refund
+		err := rows.Scan(&r.ID, &r.OrderID, &r.ReturnID, &r.PaymentID, &r.Amount.Currency, &r.Amount, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, r)
+	}
+
+	return refunds, rows.Err()
+}
+
+// Função auxiliar que mapeia uma linha com as colunas de 'orderColumns' para a estrutura 'Order'.
+func scanRowsIntoOrder(rows *sql.Rows) (*types.Order, error) {
+	o := new(types.Order)
+
+	err := rows.Scan(
+		&o.ID,
+		&o.UserID,
+		&o.Subtotal.Currency,
+		&o.Subtotal,
+		&o.Discount.Currency,
+		&o.Discount,
+		&o.Tax.Currency,
+		&o.Tax,
+		&o.ShippingCost.Currency,
+		&o.ShippingCost,
+		&o.Total.Currency,
+		&o.Total,
+		&o.Refunded.Currency,
+		&o.Refunded,
+		&o.ExchangeRate,
+		&o.CouponID,
+		&o.CouponCode,
+		&o.FreeShipping,
+		&o.ShippingMethodID,
+		&o.ShippingMethod,
+		&o.Status,
+		&o.Address,
+		&o.ShippingAddress.FullName,
+		&o.ShippingAddress.Line1,
+		&o.ShippingAddress.Line2,
+		&o.ShippingAddress.City,
+		&o.ShippingAddress.State,
+		&o.ShippingAddress.PostalCode,
+		&o.ShippingAddress.Country,
+		&o.ShippingAddress.Phone,
+		&o.CancelledBy,
+		&o.CancelReason,
+		&o.CancelledAt,
+		&o.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
@@ -0,0 +1,181 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// Mode tells the fake gateway how to answer.
+type Mode string
+
+const (
+	ModeSucceed Mode = "succeed"
+	// ModeDecline refuses every authorization. Captures, refunds and voids
+	// of earlier authorizations still go through.
+	ModeDecline Mode = "decline"
+	// ModeTimeout fails every call as if the gateway never answered.
+	ModeTimeout Mode = "timeout"
+)
+
+// ParseMode validates a mode coming from the configuration.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeSucceed, ModeDecline, ModeTimeout:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown fake payment mode %q", s)
+	}
+}
+
+// FakeGateway implements types.PaymentGateway in memory, for development
+// and tests. It keeps track of what each authorization holds so captures,
+// refunds and voids are checked like a real provider would.
+type FakeGateway struct {
+	mu             sync.Mutex
+	mode           Mode
+	authorizations map[string]*authorization
+}
+
+type authorization struct {
+	amount   types.Money
+	captured types.Money
+	refunded types.Money
+	voided   bool
+}
+
+func NewFakeGateway(mode Mode) *FakeGateway {
+	return &FakeGateway{mode: mode, authorizations: map[string]*authorization{}}
+}
+
+// SetMode changes how the gateway answers from the next call on.
+func (g *FakeGateway) SetMode(mode Mode) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.mode = mode
+}
+
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, orderID int, amount types.Money) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.answer(ctx); err != nil {
+		return "", err
+	}
+
+	if g.mode == ModeDecline {
+		return "", fmt.Errorf("fake gateway refused order %d: %w", orderID, types.ErrPaymentDeclined)
+	}
+
+	if !amount.IsPositive() {
+		return "", fmt.Errorf("cannot authorize %s", amount)
+	}
+
+	reference := fmt.Sprintf("fake_%d_%d", orderID, len(g.authorizations)+1)
+	g.authorizations[reference] = &authorization{
+		amount:   amount,
+		captured: types.NewMoney(0, amount.Currency),
+		refunded: types.NewMoney(0, amount.Currency),
+	}
+
+	return reference, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, reference string, amount types.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, err := g.authorization(ctx, reference, amount)
+	if err != nil {
+		return err
+	}
+
+	if a.voided {
+		return fmt.Errorf("authorization %s was voided: %w", reference, types.ErrConflict)
+	}
+
+	if a.captured.Add(amount).Cmp(a.amount) > 0 {
+		return fmt.Errorf("cannot capture %s of the %s left on %s: %w", amount, a.amount.Sub(a.captured), reference, types.ErrConflict)
+	}
+
+	a.captured = a.captured.Add(amount)
+	return nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, reference string, amount types.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, err := g.authorization(ctx, reference, amount)
+	if err != nil {
+		return err
+	}
+
+	if a.refunded.Add(amount).Cmp(a.captured) > 0 {
+		return fmt.Errorf("cannot refund %s of the %s captured on %s: %w", amount, a.captured.Sub(a.refunded), reference, types.ErrConflict)
+	}
+
+	a.refunded = a.refunded.Add(amount)
+	return nil
+}
+
+func (g *FakeGateway) Void(ctx context.Context, reference string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, err := g.authorization(ctx, reference, types.Money{})
+	if err != nil {
+		return err
+	}
+
+	if !a.captured.IsZero() {
+		return fmt.Errorf("authorization %s was already captured: %w", reference, types.ErrConflict)
+	}
+
+	a.voided = true
+	return nil
+}
+
+// answer fails the call when the gateway is timing out or the caller has
+// given up on it.
+func (g *FakeGateway) answer(ctx context.Context) error {
+	if g.mode == ModeTimeout {
+		return fmt.Errorf("fake gateway did not answer: %w", context.DeadlineExceeded)
+	}
+
+	return ctx.Err()
+}
+
+// authorization looks up an earlier authorization that amount can be taken
+// from. The zero amount skips the amount checks.
+func (g *FakeGateway) authorization(ctx context.Context, reference string, amount types.Money) (*authorization, error) {
+	if err := g.answer(ctx); err != nil {
+		return nil, err
+	}
+
+	a, ok := g.authorizations[reference]
+	if !ok {
+		return nil, fmt.Errorf("authorization %s %w", reference, types.ErrNotFound)
+	}
+
+	if amount == (types.Money{}) {
+		return a, nil
+	}
+
+	if amount.Currency != a.amount.Currency {
+		return nil, fmt.Errorf("authorization %s is in %s, not %s", reference, a.amount.Currency, amount.Currency)
+	}
+
+	if !amount.IsPositive() {
+		return nil, fmt.Errorf("amount must be positive, got %s", amount)
+	}
+
+	return a, nil
+}
@@ -0,0 +1,121 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, types.DefaultCurrency) }
+
+	t.Run("should authorize, capture and refund up to the amounts held", func(t *testing.T) {
+		g := NewFakeGateway(ModeSucceed)
+
+		ref, err := g.Authorize(ctx, 1, usd(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Refund(ctx, ref, usd(100)); !errors.Is(err, types.ErrConflict) {
+			t.Errorf("expected a refund before the capture to conflict, got %v", err)
+		}
+
+		if err := g.Capture(ctx, ref, usd(600)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Capture(ctx, ref, usd(500)); !errors.Is(err, types.ErrConflict) {
+			t.Errorf("expected capturing past the authorization to conflict, got %v", err)
+		}
+
+		if err := g.Refund(ctx, ref, usd(600)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Refund(ctx, ref, usd(1)); !errors.Is(err, types.ErrConflict) {
+			t.Errorf("expected refunding past the capture to conflict, got %v", err)
+		}
+
+		if err := g.Void(ctx, ref); !errors.Is(err, types.ErrConflict) {
+			t.Errorf("expected voiding a captured authorization to conflict, got %v", err)
+		}
+	})
+
+	t.Run("should not capture a voided authorization", func(t *testing.T) {
+		g := NewFakeGateway(ModeSucceed)
+
+		ref, err := g.Authorize(ctx, 1, usd(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Void(ctx, ref); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Capture(ctx, ref, usd(1000)); !errors.Is(err, types.ErrConflict) {
+			t.Errorf("expected capturing a voided authorization to conflict, got %v", err)
+		}
+
+		if err := g.Void(ctx, "fake_9_9"); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("expected an unknown reference to be not found, got %v", err)
+		}
+	})
+
+	t.Run("should decline authorizations only", func(t *testing.T) {
+		g := NewFakeGateway(ModeSucceed)
+
+		ref, err := g.Authorize(ctx, 1, usd(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g.SetMode(ModeDecline)
+
+		if _, err := g.Authorize(ctx, 2, usd(1000)); !errors.Is(err, types.ErrPaymentDeclined) {
+			t.Errorf("expected the authorization to be declined, got %v", err)
+		}
+
+		if err := g.Capture(ctx, ref, usd(1000)); err != nil {
+			t.Errorf("expected the earlier authorization to be captured, got %v", err)
+		}
+	})
+
+	t.Run("should time out every call", func(t *testing.T) {
+		g := NewFakeGateway(ModeTimeout)
+
+		_, err := g.Authorize(ctx, 1, usd(1000))
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, types.ErrPaymentDeclined) {
+			t.Errorf("expected the authorization to time out, got %v", err)
+		}
+	})
+
+	t.Run("should reject amounts in another currency", func(t *testing.T) {
+		g := NewFakeGateway(ModeSucceed)
+
+		ref, err := g.Authorize(ctx, 1, usd(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.Capture(ctx, ref, types.NewMoney(1000, "EUR")); err == nil {
+			t.Errorf("expected a capture in EUR to fail")
+		}
+	})
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"succeed", "decline", "timeout"} {
+		if mode, err := ParseMode(s); err != nil || string(mode) != s {
+			t.Errorf("expected %q to parse, got %q, %v", s, mode, err)
+		}
+	}
+
+	if _, err := ParseMode("maybe"); err == nil {
+		t.Errorf("expected an unknown mode to fail")
+	}
+}
@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+const (
+	// maxAttempts is how many times an operation is tried before it is left
+	// for staff to settle by hand.
+	maxAttempts = 10
+	// retryBatch caps the payments a single retry goes through.
+	retryBatch = 100
+)
+
+// Current returns the payment holding the money of an order: its latest
+// authorized or captured payment, or nil when there is none.
+func Current(store types.PaymentStore, orderID int) (*types.Payment, error) {
+	payments, err := store.GetPaymentsByOrderID(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(payments) - 1; i >= 0; i-- {
+		switch payments[i].Status {
+		case types.PaymentAuthorized, types.PaymentCaptured:
+			return &payments[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// The helpers below move a payment to where it is headed and record the
+// gateway call that takes it there. Call them inside a transaction and
+// settle the payment once it commits.
+
+// Capture collects an authorized payment in full.
+func Capture(store types.PaymentStore, p *types.Payment) error {
+	return record(store, p, types.PaymentOperationCapture, p.Amount, types.PaymentCaptured)
+}
+
+// Void releases an authorized payment.
+func Void(store types.PaymentStore, p *types.Payment) error {
+	return record(store, p, types.PaymentOperationVoid, p.Amount, types.PaymentVoided)
+}
+
+// Refund gives back part of a captured payment. The payment stays captured;
+// callers mark it refunded once all of it has been given back.
+func Refund(store types.PaymentStore, p *types.Payment, amount types.Money) error {
+	return record(store, p, types.PaymentOperationRefund, amount, p.Status)
+}
+
+// GiveBack returns a whole payment: an authorization is voided and a
+// captured payment refunded in full.
+func GiveBack(store types.PaymentStore, p *types.Payment) error {
+	if p.Status != types.PaymentCaptured {
+		return Void(store, p)
+	}
+
+	return record(store, p, types.PaymentOperationRefund, p.Amount, types.PaymentRefunded)
+}
+
+func record(store types.PaymentStore, p *types.Payment, opType types.PaymentOperationType, amount types.Money, status types.PaymentStatus) error {
+	if _, err := store.CreatePaymentOperation(types.PaymentOperation{PaymentID: p.ID, Type: opType, Amount: amount}); err != nil {
+		return err
+	}
+
+	if status == p.Status {
+		return nil
+	}
+
+	if err := store.UpdatePaymentStatus(p.ID, status); err != nil {
+		return err
+	}
+
+	p.Status = status
+	return nil
+}
+
+// Settler makes the payment operations recorded for a payment, right after
+// the transaction that recorded them commits and again in the background
+// for those that failed.
+type Settler struct {
+	store    types.PaymentStore
+	gateway  types.PaymentGateway
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewSettler fails unless interval, which is both how often failed
+// operations are retried and how long they wait before it, is positive.
+func NewSettler(store types.PaymentStore, gateway types.PaymentGateway, interval time.Duration) (*Settler, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the payment retry interval must be positive, got %s", interval)
+	}
+
+	return &Settler{
+		store:    store,
+		gateway:  gateway,
+		interval: interval,
+		now:      time.Now,
+	}, nil
+}
+
+// Settle makes the pending operations of a payment in the order they were
+// recorded. It stops at the first one that fails, which is left pending so
+// the ones after it don't run ahead of it, and returns why.
+func (s *Settler) Settle(ctx context.Context, paymentID int) error {
+	ops, err := s.store.GetPendingPaymentOperations(paymentID)
+	if err != nil {
+		return err
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	p, err := s.store.GetPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		claimed, err := s.store.ClaimPaymentOperation(op)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return fmt.Errorf("operation %d of payment %d is being made elsewhere", op.ID, paymentID)
+		}
+
+		if err := s.call(ctx, p, op); err != nil {
+			// a refusal, or a call the payment's state at the gateway
+			// doesn't allow, won't change by asking again
+			status := types.PaymentOperationPending
+			if errors.Is(err, types.ErrPaymentDeclined) || errors.Is(err, types.ErrConflict) || op.Attempts+1 >= maxAttempts {
+				status = types.PaymentOperationFailed
+				log.Printf("giving up on operation %d of payment %d after %d attempts, it has to be settled by hand: %v", op.ID, paymentID, op.Attempts+1, err)
+			}
+
+			if err := s.store.UpdatePaymentOperation(op.ID, status, err.Error()); err != nil {
+				return err
+			}
+
+			return err
+		}
+
+		if err := s.store.UpdatePaymentOperation(op.ID, types.PaymentOperationSucceeded, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run retries failed operations every interval until ctx is done.
+func (s *Settler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Retry(ctx)
+		}
+	}
+}
+
+// Retry settles the payments with operations that weren't tried for an
+// interval. Failures are logged and retried on the next run.
+func (s *Settler) Retry(ctx context.Context) {
+	paymentIDs, err := s.store.GetPaymentIDsWithPendingOperations(s.now().Add(-s.interval), retryBatch)
+	if err != nil {
+		log.Printf("failed to list payments with pending operations: %v", err)
+		return
+	}
+
+	for _, paymentID := range paymentIDs {
+		if err := s.Settle(ctx, paymentID); err != nil {
+			log.Printf("failed to settle payment %d: %v", paymentID, err)
+		}
+	}
+}
+
+func (s *Settler) call(ctx context.Context, p *types.Payment, op types.PaymentOperation) error {
+	var err error
+	switch op.Type {
+	case types.PaymentOperationCapture:
+		err = s.gateway.Capture(ctx, p.Reference, op.Amount)
+	case types.PaymentOperationVoid:
+		err = s.gateway.Void(ctx, p.Reference)
+	case types.PaymentOperationRefund:
+		err = s.gateway.Refund(ctx, p.Reference, op.Amount)
+	default:
+		return fmt.Errorf("unknown payment operation %q", op.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s %s of payment %s: %w", op.Type, op.Amount, p.Reference, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestSettler(t *testing.T) {
+	ctx := context.Background()
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, types.DefaultCurrency) }
+
+	// setup authorizes $40 with the gateway and records the payment for it
+	setup := func(t *testing.T) (*Settler, *FakeGateway, *mockPaymentStore) {
+		gateway := NewFakeGateway(ModeSucceed)
+
+		ref, err := gateway.Authorize(ctx, 1, usd(4000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := &mockPaymentStore{payments: []types.Payment{
+			{ID: 1, OrderID: 1, Provider: "fake", Reference: ref, Amount: usd(4000), Status: types.PaymentAuthorized},
+		}}
+
+		settler, err := NewSettler(store, gateway, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return settler, gateway, store
+	}
+
+	t.Run("should make the operations of a payment in the order they were recorded", func(t *testing.T) {
+		settler, _, store := setup(t)
+		p := &store.payments[0]
+
+		if err := Capture(store, p); err != nil {
+			t.Fatal(err)
+		}
+		if err := Refund(store, p, usd(1500)); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Status != types.PaymentCaptured {
+			t.Errorf("expected the payment to be captured right away, got %s", p.Status)
+		}
+
+		if err := settler.Settle(ctx, p.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, op := range store.operations {
+			if op.Status != types.PaymentOperationSucceeded || op.Attempts != 1 {
+				t.Errorf("expected operation %d to be made once, got %+v", op.ID, op)
+			}
+		}
+	})
+
+	t.Run("should stop at an operation the gateway failed and retry it later", func(t *testing.T) {
+		settler, gateway, store := setup(t)
+		p := &store.payments[0]
+
+		if err := Capture(store, p); err != nil {
+			t.Fatal(err)
+		}
+		if err := Refund(store, p, usd(1500)); err != nil {
+			t.Fatal(err)
+		}
+
+		gateway.SetMode(ModeTimeout)
+		if err := settler.Settle(ctx, p.ID); err == nil {
+			t.Fatal("expected the gateway failure to be returned")
+		}
+
+		capture, refund := store.operations[0], store.operations[1]
+		if capture.Status != types.PaymentOperationPending || capture.Attempts != 1 || capture.Error == "" {
+			t.Errorf("expected the capture to be left pending with its error, got %+v", capture)
+		}
+		if refund.Attempts != 0 {
+			t.Errorf("expected the refund not to run ahead of the capture, got %+v", refund)
+		}
+
+		gateway.SetMode(ModeSucceed)
+		settler.Retry(ctx)
+
+		for _, op := range store.operations {
+			if op.Status != types.PaymentOperationSucceeded {
+				t.Errorf("expected operation %d to be made on the retry, got %+v", op.ID, op)
+			}
+		}
+	})
+
+	t.Run("should give up on an operation the gateway refuses", func(t *testing.T) {
+		settler, _, store := setup(t)
+		p := &store.payments[0]
+
+		// nothing was captured yet, so there is nothing to refund
+		if err := Refund(store, p, usd(1500)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := settler.Settle(ctx, p.ID); err == nil {
+			t.Fatal("expected the refusal to be returned")
+		}
+
+		if op := store.operations[0]; op.Status != types.PaymentOperationFailed {
+			t.Errorf("expected the refund to be given up on, got %+v", op)
+		}
+	})
+
+	t.Run("should give up once an operation runs out of attempts", func(t *testing.T) {
+		settler, gateway, store := setup(t)
+
+		if err := Void(store, &store.payments[0]); err != nil {
+			t.Fatal(err)
+		}
+		store.operations[0].Attempts = maxAttempts - 1
+
+		gateway.SetMode(ModeTimeout)
+		if err := settler.Settle(ctx, 1); err == nil {
+			t.Fatal("expected the gateway failure to be returned")
+		}
+
+		if op := store.operations[0]; op.Status != types.PaymentOperationFailed || op.Attempts != maxAttempts {
+			t.Errorf("expected the void to be given up on after %d attempts, got %+v", maxAttempts, op)
+		}
+	})
+}
+
+func TestNewSettler(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := NewSettler(&mockPaymentStore{}, NewFakeGateway(ModeSucceed), interval); err == nil {
+			t.Errorf("expected an interval of %s to be refused", interval)
+		}
+	}
+}
+
+type mockPaymentStore struct {
+	payments   []types.Payment
+	operations []types.PaymentOperation
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	for _, p := range m.payments {
+		if p.ID == paymentID {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) CreatePayment(p types.Payment) (int, error) {
+	return 0, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	for i := range m.payments {
+		if m.payments[i].ID == paymentID {
+			m.payments[i].Status = status
+		}
+	}
+
+	return nil
+}
+
+func (m *mockPaymentStore) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	op.ID = len(m.operations) + 1
+	op.Status = types.PaymentOperationPending
+	m.operations = append(m.operations, op)
+	return op.ID, nil
+}
+
+func (m *mockPaymentStore) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	ops := []types.PaymentOperation{}
+	for _, op := range m.operations {
+		if op.PaymentID == paymentID && op.Status == types.PaymentOperationPending {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops, nil
+}
+
+// GetPaymentIDsWithPendingOperations treats every pending operation as due.
+func (m *mockPaymentStore) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, op := range m.operations {
+		if op.Status == types.PaymentOperationPending && !seen[op.PaymentID] {
+			seen[op.PaymentID] = true
+			ids = append(ids, op.PaymentID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (m *mockPaymentStore) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	stored := &m.operations[op.ID-1]
+	if stored.Status != types.PaymentOperationPending || stored.Attempts != op.Attempts {
+		return false, nil
+	}
+
+	stored.Attempts++
+	return true, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	m.operations[operationID-1].Status = status
+	m.operations[operationID-1].Error = errMsg
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx *sql.Tx) types.PaymentStore {
+	return m
+}
@@ -0,0 +1,226 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.PaymentStore {
+	return &Store{db: tx}
+}
+
+// paymentColumns lists the columns read by scanRowsIntoPayment, in order.
+const paymentColumns = "id, orderId, provider, reference, currency, amount, status, error, createdAt, updatedAt"
+
+func (s *Store) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	return s.getPayments("SELECT "+paymentColumns+" FROM payments WHERE orderId = ? ORDER BY id", orderID)
+}
+
+func (s *Store) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	payments, err := s.getPayments("SELECT "+paymentColumns+" FROM payments WHERE id = ?", paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+	}
+
+	return &payments[0], nil
+}
+
+func (s *Store) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	payments, err := s.getPayments("SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND reference = ? ORDER BY id DESC LIMIT 1", provider, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+	}
+
+	return &payments[0], nil
+}
+
+func (s *Store) getPayments(query string, args ...any) ([]types.Payment, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]types.Payment, 0)
+	for rows.Next() {
+		p, err := scanRowsIntoPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, *p)
+	}
+
+	return payments, rows.Err()
+}
+
+func (s *Store) CreatePayment(p types.Payment) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO payments (orderId, provider, reference, currency, amount, status, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		p.OrderID, p.Provider, p.Reference, p.Amount.Currency, p.Amount, p.Status, p.Error,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	_, err := s.db.Exec("UPDATE payments SET status = ? WHERE id = ?", status, paymentID)
+	return err
+}
+
+func (s *Store) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO payment_operations (paymentId, type, currency, amount, status, error) VALUES (?, ?, ?, ?, ?, '')",
+		op.PaymentID, op.Type, op.Amount.Currency, op.Amount, types.PaymentOperationPending,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	rows, err := s.db.Query(
+		"SELECT id, paymentId, type, currency, amount, status, attempts, error, createdAt, updatedAt FROM payment_operations WHERE paymentId = ? AND status = ? ORDER BY id",
+		paymentID, types.PaymentOperationPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := make([]types.PaymentOperation, 0)
+	for rows.Next() {
+		op, err := scanRowsIntoPaymentOperation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, *op)
+	}
+
+	return ops, rows.Err()
+}
+
+func (s *Store) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	rows, err := s.db.Query(
+		"SELECT paymentId FROM payment_operations WHERE status = ? AND updatedAt <= ? GROUP BY paymentId ORDER BY MIN(id) LIMIT ?",
+		types.PaymentOperationPending, before, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	res, err := s.db.Exec(
+		"UPDATE payment_operations SET attempts = attempts + 1 WHERE id = ? AND status = ? AND attempts = ?",
+		op.ID, types.PaymentOperationPending, op.Attempts,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (s *Store) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	_, err := s.db.Exec("UPDATE payment_operations SET status = ?, error = ? WHERE id = ?", status, errMsg, operationID)
+	return err
+}
+
+func scanRowsIntoPayment(rows *sql.Rows) (*types.Payment, error) {
+	p := new(types.Payment)
+
+	err := rows.Scan(
+		&p.ID,
+		&p.OrderID,
+		&p.Provider,
+		&p.Reference,
+		&p.Amount.Currency,
+		&p.Amount,
+		&p.Status,
+		&p.Error,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func scanRowsIntoPaymentOperation(rows *sql.Rows) (*types.PaymentOperation, error) {
+	op := new(types.PaymentOperation)
+
+	err := rows.Scan(
+		&op.ID,
+		&op.PaymentID,
+		&op.Type,
+		&op.Amount.Currency,
+		&op.Amount,
+		&op.Status,
+		&op.Attempts,
+		&op.Error,
+		&op.CreatedAt,
+		&op.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
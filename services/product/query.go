@@ -0,0 +1,154 @@
+package product
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+const (
+	defaultProductsLimit = 20
+	maxProductsLimit     = 100
+	defaultProductsSort  = "-createdAt"
+
+	// cursorTimeFormat is how createdAt values are written into cursors; MySQL
+	// compares it directly against the TIMESTAMP column.
+	cursorTimeFormat = "2006-01-02 15:04:05.999999"
+)
+
+// parseProductQuery reads the listing options from the query string:
+//
+//	limit     page size, at most maxProductsLimit
+//	cursor    nextCursor of the previous page
+//	currency  currency to show prices in, and in which minPrice and maxPrice
+//	          are given; the default currency when missing
+//	minPrice  lowest price to include, as a decimal like 9.99
+//	maxPrice  highest price to include, as a decimal like 9.99
+//	inStock   true for products with stock, false for sold out ones
+//	sort      price, createdAt or name; prefixed with "-" for descending
+func parseProductQuery(r *http.Request) (types.ProductQuery, error) {
+	params := r.URL.Query()
+	query := types.ProductQuery{Limit: defaultProductsLimit}
+
+	currency, err := utils.ParseCurrency(r)
+	if err != nil {
+		return query, err
+	}
+	query.Currency = currency
+
+	sort := params.Get("sort")
+	if sort == "" {
+		sort = defaultProductsSort
+	}
+
+	query.SortBy = strings.TrimPrefix(sort, "-")
+	query.Desc = strings.HasPrefix(sort, "-")
+	if _, ok := sortColumns[query.SortBy]; !ok {
+		return query, fmt.Errorf("invalid sort %q, expected price, createdAt or name", sort)
+	}
+
+	if str := params.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit")
+		}
+
+		query.Limit = min(limit, maxProductsLimit)
+	}
+
+	if str := params.Get("minPrice"); str != "" {
+		minPrice, err := types.ParseMoney(str, query.Currency)
+		if err != nil || minPrice.Amount < 0 {
+			return query, fmt.Errorf("invalid minPrice")
+		}
+
+		query.MinPrice = &minPrice
+	}
+
+	if str := params.Get("maxPrice"); str != "" {
+		maxPrice, err := types.ParseMoney(str, query.Currency)
+		if err != nil || maxPrice.Amount < 0 {
+			return query, fmt.Errorf("invalid maxPrice")
+		}
+
+		query.MaxPrice = &maxPrice
+	}
+
+	if query.MinPrice != nil && query.MaxPrice != nil && query.MinPrice.Cmp(*query.MaxPrice) > 0 {
+		return query, fmt.Errorf("minPrice can't be greater than maxPrice")
+	}
+
+	if str := params.Get("inStock"); str != "" {
+		inStock, err := strconv.ParseBool(str)
+		if err != nil {
+			return query, fmt.Errorf("invalid inStock")
+		}
+
+		query.InStock = &inStock
+	}
+
+	if str := params.Get("cursor"); str != "" {
+		cursor, err := decodeCursor(str)
+		if err != nil {
+			return query, err
+		}
+
+		// a cursor only makes sense for the ordering it was issued for
+		if cursor.SortBy != query.SortBy || cursor.Desc != query.Desc {
+			return query, fmt.Errorf("cursor doesn't match the requested sort")
+		}
+
+		query.After = cursor
+	}
+
+	return query, nil
+}
+
+// cursorAfter builds the cursor that resumes the listing after p.
+func cursorAfter(p *types.Product, query types.ProductQuery) types.ProductCursor {
+	cursor := types.ProductCursor{SortBy: query.SortBy, Desc: query.Desc, ID: p.ID}
+
+	switch query.SortBy {
+	case "price":
+		// the store looks the price up by id, as it compares prices once
+		// converted into the default currency
+	case "name":
+		cursor.Value = p.Name
+	default:
+		cursor.Value = p.CreatedAt.UTC().Format(cursorTimeFormat)
+	}
+
+	return cursor
+}
+
+func encodeCursor(cursor types.ProductCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*types.ProductCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	cursor := new(types.ProductCursor)
+	if err := json.Unmarshal(b, cursor); err != nil || cursor.ID <= 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	if cursor.SortBy == "createdAt" {
+		if _, err := time.Parse(cursorTimeFormat, cursor.Value); err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+	}
+
+	return cursor, nil
+}
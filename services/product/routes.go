@@ -1,6 +1,8 @@
 package product
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -13,48 +15,227 @@ import (
 )
 
 type Handler struct {
-	store     types.ProductStore
-	userStore types.UserStore
+	store          types.ProductStore
+	categoryStore  types.CategoryStore
+	imageStore     types.ImageStore
+	rateStore      types.ExchangeRateStore
+	ledger         types.StockLedger
+	warehouseStore types.WarehouseStore
+	userStore      types.UserStore
+	transactor     types.Transactor
 }
 
-func NewHandler(store types.ProductStore, userStore types.UserStore) *Handler {
-	return &Handler{store: store, userStore: userStore}
+// Deps are the stores and services the product handlers work with.
+type Deps struct {
+	ProductStore   types.ProductStore
+	CategoryStore  types.CategoryStore
+	ImageStore     types.ImageStore
+	RateStore      types.ExchangeRateStore
+	Ledger         types.StockLedger
+	WarehouseStore types.WarehouseStore
+	UserStore      types.UserStore
+	Transactor     types.Transactor
+}
+
+func NewHandler(deps Deps) *Handler {
+	return &Handler{
+		store:          deps.ProductStore,
+		categoryStore:  deps.CategoryStore,
+		imageStore:     deps.ImageStore,
+		rateStore:      deps.RateStore,
+		ledger:         deps.Ledger,
+		warehouseStore: deps.WarehouseStore,
+		userStore:      deps.UserStore,
+		transactor:     deps.Transactor,
+	}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/products", h.handleGetProducts).Methods(http.MethodGet)
+	// registered before /products/{productID} so "search" is not taken as an ID
+	router.HandleFunc("/products/search", h.handleSearchProducts).Methods(http.MethodGet)
 	router.HandleFunc("/products/{productID}", h.handleGetProduct).Methods(http.MethodGet)
+	router.HandleFunc("/categories/{categoryID}/products", h.handleGetCategoryProducts).Methods(http.MethodGet)
 
 	// admin routes
-	router.HandleFunc("/products", auth.WithJWTAuth(h.handleCreateProduct, h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products", auth.WithJWTAuth(auth.RequireRole(h.handleCreateProduct, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products/{productID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateProduct, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/products/{productID}", auth.WithJWTAuth(auth.RequireRole(h.handlePatchProduct, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPatch)
+	router.HandleFunc("/products/{productID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteProduct, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+	router.HandleFunc("/products/{productID}/categories", auth.WithJWTAuth(auth.RequireRole(h.handleSetProductCategories, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/products/{productID}/stock-history", auth.WithJWTAuth(auth.RequireRole(h.handleGetStockHistory, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/products/{productID}/stock-movements", auth.WithJWTAuth(auth.RequireRole(h.handleCreateStockMovement, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
 }
 
+// handleGetProducts lists the catalog. Besides the options read by
+// parseProductQuery it takes a category id, which also matches products in
+// its subcategories.
 func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.store.GetProducts()
+	query, err := parseProductQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if str := r.URL.Query().Get("category"); str != "" {
+		categoryID, err := strconv.Atoi(str)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid category"))
+			return
+		}
+
+		if query.CategoryIDs, err = h.categoryStore.GetSubtreeIDs(categoryID); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	h.writeProductPage(w, query)
+}
+
+// handleGetCategoryProducts lists the products of a category and of all its
+// subcategories, with the same options as handleGetProducts.
+func (h *Handler) handleGetCategoryProducts(w http.ResponseWriter, r *http.Request) {
+	query, err := parseProductQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	str := mux.Vars(r)["categoryID"]
+	categoryID, err := strconv.Atoi(str)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid category ID"))
+		return
+	}
+
+	if query.CategoryIDs, err = h.categoryStore.GetSubtreeIDs(categoryID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.writeProductPage(w, query)
+}
+
+func (h *Handler) writeProductPage(w http.ResponseWriter, query types.ProductQuery) {
+	rates, err := h.exchangeRates(query.Currency)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// ask for one product more than the page holds to learn whether there
+	// is a next page
+	pageSize := query.Limit
+	query.Limit++
+
+	products, total, err := h.store.GetProducts(query)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := types.ProductPage{Products: products, Total: total}
+	if len(products) > pageSize {
+		page.Products = products[:pageSize]
+		page.NextCursor = encodeCursor(cursorAfter(page.Products[pageSize-1], query))
+	}
+
+	if err := convertPrices(rates, query.Currency, page.Products...); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := h.attachDetails(page.Products...); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) handleSearchProducts(w http.ResponseWriter, r *http.Request) {
+	text, limit, err := parseSearchQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	currency, err := utils.ParseCurrency(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rates, err := h.exchangeRates(currency)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	results, err := h.store.SearchProducts(text, limit)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, products)
+	products := make([]*types.Product, len(results))
+	for i := range results {
+		products[i] = &results[i].Product
+	}
+
+	if err := convertPrices(rates, currency, products...); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := h.attachDetails(products...); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	pattern := termsPattern(searchTerms(text))
+	for i := range results {
+		results[i].Highlights = types.ProductHighlights{
+			Name:        highlight(results[i].Name, pattern),
+			Description: highlight(snippet(results[i].Description, pattern), pattern),
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, results)
 }
 
 func (h *Handler) handleGetProduct(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	str, ok := vars["productID"]
-	if !ok {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing product ID"))
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	productID, err := strconv.Atoi(str)
+	currency, err := utils.ParseCurrency(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rates, err := h.exchangeRates(currency)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid product ID"))
+		writeStoreError(w, err)
 		return
 	}
 
 	product, err := h.store.GetProductByID(productID)
 	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := convertPrices(rates, currency, product); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := h.attachDetails(product); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -75,7 +256,37 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.store.CreateProduct(product)
+	// prices can only be set in currencies that can be converted
+	if _, err := h.exchangeRates(product.Price.Currency); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if product.TaxClass == "" {
+		product.TaxClass = types.DefaultTaxClass
+	}
+
+	actorID := auth.GetUserIDFromContext(r.Context())
+
+	// the product starts out empty and its stock comes in as an import
+	err := h.transactor.WithinTx(func(tx *sql.Tx) error {
+		productID, err := h.store.WithTx(tx).CreateProduct(product)
+		if err != nil {
+			return err
+		}
+
+		if product.Quantity == 0 {
+			return nil
+		}
+
+		return h.ledger.WithTx(tx).Move(types.StockMovement{
+			ProductID: productID,
+			Change:    product.Quantity,
+			Reason:    types.StockImport,
+			ActorID:   &actorID,
+			Reference: "initial stock",
+		})
+	})
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -83,3 +294,389 @@ func (h *Handler) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	utils.WriteJSON(w, http.StatusCreated, product)
 }
+
+func (h *Handler) handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateProductPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	product.Name = payload.Name
+	product.Description = payload.Description
+	product.Image = payload.Image
+	product.Price = payload.Price
+	product.TaxClass = payload.TaxClass
+	product.Weight = payload.Weight
+	product.ReorderThreshold = payload.ReorderThreshold
+
+	if product.TaxClass == "" {
+		product.TaxClass = types.DefaultTaxClass
+	}
+
+	if _, err := h.exchangeRates(product.Price.Currency); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := h.store.UpdateProduct(*product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.attachDetails(product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) handlePatchProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.PatchProductPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if !applyProductPatch(product, payload) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("nothing to update"))
+		return
+	}
+
+	if _, err := h.exchangeRates(product.Price.Currency); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if err := h.store.UpdateProduct(*product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.attachDetails(product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteProduct(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetProductCategories replaces the categories a product is listed
+// under with the ones in the payload.
+func (h *Handler) handleSetProductCategories(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ProductCategoriesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	product, err := h.store.GetProductByID(productID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	for _, categoryID := range payload.CategoryIDs {
+		_, err := h.categoryStore.GetCategoryByID(categoryID)
+		if errors.Is(err, types.ErrNotFound) {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("category %d not found", categoryID))
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		return h.categoryStore.WithTx(tx).SetProductCategories(productID, payload.CategoryIDs)
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.attachDetails(product); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, product)
+}
+
+func (h *Handler) handleGetStockHistory(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.store.GetProductByID(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	movements, err := h.ledger.GetMovements(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, movements)
+}
+
+// handleCreateStockMovement records stock that came in or was counted off
+// by hand, such as a delivery from a supplier or units found damaged.
+func (h *Handler) handleCreateStockMovement(w http.ResponseWriter, r *http.Request) {
+	actorID := auth.GetUserIDFromContext(r.Context())
+
+	productID, err := getProductIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.StockMovementPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	if _, err := h.store.GetProductByID(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	movement := types.StockMovement{
+		ProductID:   productID,
+		VariantID:   payload.VariantID,
+		WarehouseID: payload.WarehouseID,
+		Change:      payload.Change,
+		Reason:      payload.Reason,
+		ActorID:     &actorID,
+		Reference:   payload.Reference,
+	}
+
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		return h.ledger.WithTx(tx).Move(movement)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, movement)
+}
+
+// attachDetails fills in the categories and the image gallery of the given
+// products.
+func (h *Handler) attachDetails(products ...*types.Product) error {
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+
+	categories, err := h.categoryStore.GetCategoriesByProductIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	images, err := h.imageStore.GetImagesByProductIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	availability, err := h.warehouseStore.GetAvailability(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		p.Categories = categories[p.ID]
+		if p.Categories == nil {
+			p.Categories = []types.Category{}
+		}
+
+		p.Images = images[p.ID]
+		if p.Images == nil {
+			p.Images = []types.ProductImage{}
+		}
+
+		p.Availability = availability[p.ID]
+		if p.Availability == nil {
+			p.Availability = []types.WarehouseStock{}
+		}
+	}
+
+	return nil
+}
+
+// exchangeRates loads the current exchange rates, failing with
+// types.ErrNoExchangeRate if prices can't be converted into currency.
+func (h *Handler) exchangeRates(currency string) (types.ExchangeRates, error) {
+	list, err := h.rateStore.GetExchangeRates()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := types.NewExchangeRates(list)
+	if _, err := rates.CrossRate(types.DefaultCurrency, currency); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// convertPrices changes the price of each product into currency.
+func convertPrices(rates types.ExchangeRates, currency string, products ...*types.Product) error {
+	for _, p := range products {
+		price, _, err := rates.Convert(p.Price, currency)
+		if err != nil {
+			return err
+		}
+
+		p.Price = price
+	}
+
+	return nil
+}
+
+// applyProductPatch copies the fields present in the payload onto the
+// product, reporting whether there was anything to copy.
+func applyProductPatch(product *types.Product, payload types.PatchProductPayload) bool {
+	changed := false
+
+	if payload.Name != nil {
+		product.Name = *payload.Name
+		changed = true
+	}
+
+	if payload.Description != nil {
+		product.Description = *payload.Description
+		changed = true
+	}
+
+	if payload.Image != nil {
+		product.Image = *payload.Image
+		changed = true
+	}
+
+	if payload.Price != nil {
+		product.Price = *payload.Price
+		changed = true
+	}
+
+	if payload.TaxClass != nil {
+		product.TaxClass = *payload.TaxClass
+		changed = true
+	}
+
+	if payload.Weight != nil {
+		product.Weight = *payload.Weight
+		changed = true
+	}
+
+	if payload.ReorderThreshold != nil {
+		product.ReorderThreshold = *payload.ReorderThreshold
+		changed = true
+	}
+
+	return changed
+}
+
+func getProductIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["productID"]
+	if !ok {
+		return 0, fmt.Errorf("missing product ID")
+	}
+
+	productID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid product ID")
+	}
+
+	return productID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	case errors.Is(err, types.ErrNoExchangeRate):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -2,19 +2,57 @@ package product
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
 	"github.com/sikozonpc/ecom/types"
 )
 
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+	admin    = &types.User{ID: 3, Role: types.RoleAdmin}
+)
+
+// dollars builds a price in whole dollars.
+func dollars(n int64) types.Money {
+	return types.NewMoney(n*100, types.DefaultCurrency)
+}
+
 func TestProductServiceHandlers(t *testing.T) {
-	productStore := &mockProductStore{}
-	userStore := &mockUserStore{}
-	handler := NewHandler(productStore, userStore)
+	productStore := newMockProductStore(types.Product{ID: 42, Name: "product 42", Price: dollars(10), Quantity: 1})
+	userStore := newMockUserStore()
+	imageStore := &mockImageStore{images: map[int][]types.ProductImage{
+		42: {
+			{ID: 1, ProductID: 42, URL: "/uploads/front.jpg", Position: 0, Primary: true},
+			{ID: 2, ProductID: 42, URL: "/uploads/back.jpg", Position: 1},
+		},
+	}}
+	warehouseStore := &mockWarehouseStore{availability: map[int][]types.WarehouseStock{
+		42: {{WarehouseID: 1, Warehouse: "main", Quantity: 1}},
+	}}
+	handler := NewHandler(Deps{
+		ProductStore:   productStore,
+		CategoryStore:  newMockCategoryStore(),
+		ImageStore:     imageStore,
+		RateStore:      &mockExchangeRateStore{},
+		Ledger:         &mockLedger{},
+		WarehouseStore: warehouseStore,
+		UserStore:      userStore,
+		Transactor:     &mockTransactor{},
+	})
 
 	t.Run("should handle get products", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, "/products", nil)
@@ -66,7 +104,20 @@ func TestProductServiceHandlers(t *testing.T) {
 		router.ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusOK {
-			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var product types.Product
+		if err := json.NewDecoder(rr.Body).Decode(&product); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(product.Images) != 2 || !product.Images[0].Primary || product.Images[1].URL != "/uploads/back.jpg" {
+			t.Errorf("expected the product's gallery in order, got %+v", product.Images)
+		}
+
+		if len(product.Availability) != 1 || product.Availability[0].Warehouse != "main" || product.Availability[0].Quantity != 1 {
+			t.Errorf("expected the stock of the main warehouse, got %+v", product.Availability)
 		}
 	})
 
@@ -88,10 +139,38 @@ func TestProductServiceHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("should reject a negative quantity or a price that isn't positive", func(t *testing.T) {
+		for _, payload := range []types.CreateProductPayload{
+			{Name: "test", Price: dollars(100), Quantity: -1},
+			{Name: "test", Price: dollars(0), Quantity: 1},
+		} {
+			marshalled, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/products", bytes.NewBuffer(marshalled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+
+			router.HandleFunc("/products", handler.handleCreateProduct).Methods(http.MethodPost)
+
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %+v, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+	})
+
 	t.Run("should handle creating a product", func(t *testing.T) {
 		payload := types.CreateProductPayload{
 			Name:        "test",
-			Price:       100,
+			Price:       dollars(100),
 			Image:       "test.jpg",
 			Description: "test description",
 			Quantity:    10,
@@ -120,21 +199,842 @@ func TestProductServiceHandlers(t *testing.T) {
 	})
 }
 
-type mockProductStore struct{}
+func TestProductListing(t *testing.T) {
+	productStore := newMockProductStore(
+		types.Product{ID: 1, Name: "lamp", Price: dollars(30), Quantity: 2},
+		types.Product{ID: 2, Name: "mug", Price: dollars(10), Quantity: 0},
+		types.Product{ID: 3, Name: "chair", Price: dollars(20), Quantity: 5},
+	)
+	handler := NewHandler(Deps{
+		ProductStore:   productStore,
+		CategoryStore:  newMockCategoryStore(),
+		ImageStore:     &mockImageStore{},
+		RateStore:      &mockExchangeRateStore{},
+		Ledger:         &mockLedger{},
+		WarehouseStore: &mockWarehouseStore{},
+		UserStore:      newMockUserStore(),
+		Transactor:     &mockTransactor{},
+	})
+
+	getProducts := func(url string) (*httptest.ResponseRecorder, types.ProductPage) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/products", handler.handleGetProducts).Methods(http.MethodGet)
+		router.ServeHTTP(rr, req)
+
+		var page types.ProductPage
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return rr, page
+	}
+
+	t.Run("should page through the products with a cursor", func(t *testing.T) {
+		rr, page := getProducts("/products?sort=price&limit=2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(page.Products) != 2 || page.Products[0].ID != 2 || page.Products[1].ID != 3 {
+			t.Fatalf("expected the two cheapest products, got %+v", page.Products)
+		}
+
+		if page.Total != 3 || page.NextCursor == "" {
+			t.Fatalf("expected a total of 3 and a next cursor, got %d and %q", page.Total, page.NextCursor)
+		}
+
+		rr, page = getProducts("/products?sort=price&limit=2&cursor=" + page.NextCursor)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(page.Products) != 1 || page.Products[0].ID != 1 || page.NextCursor != "" {
+			t.Errorf("expected only the last product and no cursor, got %+v and %q", page.Products, page.NextCursor)
+		}
+	})
+
+	t.Run("should filter by price and stock", func(t *testing.T) {
+		_, page := getProducts("/products?minPrice=15&inStock=true")
+
+		if page.Total != 2 {
+			t.Errorf("expected 2 products, got %d", page.Total)
+		}
+
+		for _, p := range page.Products {
+			if p.Price.Cmp(dollars(15)) < 0 || p.Quantity == 0 {
+				t.Errorf("unexpected product %+v", p)
+			}
+		}
+	})
+
+	t.Run("should reject invalid listing options", func(t *testing.T) {
+		for _, url := range []string{
+			"/products?sort=color",
+			"/products?limit=0",
+			"/products?minPrice=abc",
+			"/products?minPrice=30&maxPrice=10",
+			"/products?inStock=maybe",
+			"/products?cursor=not-a-cursor",
+			"/products?sort=name&cursor=" + encodeCursor(types.ProductCursor{SortBy: "price", Value: "10", ID: 2}),
+		} {
+			rr, _ := getProducts(url)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, url, rr.Code)
+			}
+		}
+	})
+}
+
+func TestProductCurrencies(t *testing.T) {
+	rates := types.ExchangeRates{"EUR": 50_000_000} // 1 USD buys 0.50 EUR
+	productStore := newMockProductStore(
+		types.Product{ID: 1, Name: "lamp", Price: dollars(30), Quantity: 2},
+		types.Product{ID: 2, Name: "mug", Price: types.NewMoney(1000, "EUR"), Quantity: 4},
+	)
+	productStore.rates = rates
+	handler := NewHandler(Deps{
+		ProductStore:   productStore,
+		CategoryStore:  newMockCategoryStore(),
+		ImageStore:     &mockImageStore{},
+		RateStore:      &mockExchangeRateStore{rates: rates},
+		Ledger:         &mockLedger{},
+		WarehouseStore: &mockWarehouseStore{},
+		UserStore:      newMockUserStore(staff),
+		Transactor:     &mockTransactor{},
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should list prices in the requested currency", func(t *testing.T) {
+		rr := get("/products?sort=price&currency=eur")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var page types.ProductPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+
+		// the EUR 10 mug is worth USD 20, less than the USD 30 lamp
+		want := []types.Money{types.NewMoney(1000, "EUR"), types.NewMoney(1500, "EUR")}
+		if len(page.Products) != 2 || page.Products[0].Price != want[0] || page.Products[1].Price != want[1] {
+			t.Errorf("expected prices %v, got %+v", want, page.Products)
+		}
+	})
+
+	t.Run("should filter by prices in the requested currency", func(t *testing.T) {
+		rr := get("/products?currency=USD&minPrice=25")
+
+		var page types.ProductPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != 1 || page.Products[0].ID != 1 {
+			t.Errorf("expected only the lamp, got %+v", page.Products)
+		}
+	})
+
+	t.Run("should convert a single product", func(t *testing.T) {
+		rr := get("/products/2")
+
+		var product types.Product
+		if err := json.NewDecoder(rr.Body).Decode(&product); err != nil {
+			t.Fatal(err)
+		}
+
+		if product.Price != dollars(20) {
+			t.Errorf("expected USD 20.00, got %s", product.Price)
+		}
+	})
+
+	t.Run("should reject currencies without a rate", func(t *testing.T) {
+		for _, url := range []string{"/products?currency=GBP", "/products?currency=XYZ", "/products/1?currency=GBP"} {
+			if rr := get(url); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, url, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should only price products in currencies with a rate", func(t *testing.T) {
+		for currency, want := range map[string]int{"EUR": http.StatusCreated, "GBP": http.StatusBadRequest} {
+			marshalled, err := json.Marshal(types.CreateProductPayload{Name: "vase", Price: types.NewMoney(999, currency), Quantity: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := newAuthenticatedRequest(t, http.MethodPost, "/products", bytes.NewBuffer(marshalled), staff)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != want {
+				t.Errorf("expected status code %d for %s, got %d", want, currency, rr.Code)
+			}
+		}
+	})
+}
+
+func TestProductSearch(t *testing.T) {
+	deletedAt := time.Now()
+	productStore := newMockProductStore(
+		types.Product{ID: 1, Name: "Red shirt", Description: "A cotton shirt in red", Price: dollars(15)},
+		types.Product{ID: 2, Name: "Blue jeans", Description: "Goes well with a red shirt & boots", Price: dollars(15)},
+		types.Product{ID: 3, Name: "Red cap", Description: "Old stock", DeletedAt: &deletedAt, Price: dollars(15)},
+	)
+	handler := NewHandler(Deps{
+		ProductStore:   productStore,
+		CategoryStore:  newMockCategoryStore(),
+		ImageStore:     &mockImageStore{},
+		RateStore:      &mockExchangeRateStore{},
+		Ledger:         &mockLedger{},
+		WarehouseStore: &mockWarehouseStore{},
+		UserStore:      newMockUserStore(),
+		Transactor:     &mockTransactor{},
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	search := func(url string) (*httptest.ResponseRecorder, []types.ProductSearchResult) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var results []types.ProductSearchResult
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return rr, results
+	}
+
+	t.Run("should rank and highlight matching products", func(t *testing.T) {
+		rr, results := search("/products/search?q=red+shirt")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if len(results) != 2 || results[0].ID != 1 || results[1].ID != 2 {
+			t.Fatalf("expected products 1 and 2 and no deleted product, got %+v", results)
+		}
+
+		if want := "<mark>Red</mark> <mark>shirt</mark>"; results[0].Highlights.Name != want {
+			t.Errorf("expected name highlight %q, got %q", want, results[0].Highlights.Name)
+		}
+
+		if want := "Goes well with a <mark>red</mark> <mark>shirt</mark> &amp; boots"; results[1].Highlights.Description != want {
+			t.Errorf("expected description highlight %q, got %q", want, results[1].Highlights.Description)
+		}
+	})
+
+	t.Run("should limit the results", func(t *testing.T) {
+		_, results := search("/products/search?q=red&limit=1")
+		if len(results) != 1 {
+			t.Errorf("expected 1 result, got %d", len(results))
+		}
+	})
+
+	t.Run("should reject a missing query", func(t *testing.T) {
+		for _, url := range []string{"/products/search", "/products/search?q=+", "/products/search?q=red&limit=x"} {
+			rr, _ := search(url)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, url, rr.Code)
+			}
+		}
+	})
+}
+
+func TestProductCategories(t *testing.T) {
+	clothing := 1
+	newRouter := func() *mux.Router {
+		categoryStore := newMockCategoryStore(
+			types.Category{ID: 1, Name: "Clothing"},
+			types.Category{ID: 2, Name: "Shirts", ParentID: &clothing},
+			types.Category{ID: 3, Name: "Kitchen"},
+		)
+		categoryStore.links[1] = []int{2}
+		categoryStore.links[2] = []int{1}
+		categoryStore.links[3] = []int{3}
+
+		productStore := newMockProductStore(
+			types.Product{ID: 1, Name: "shirt", Price: dollars(20), Quantity: 1},
+			types.Product{ID: 2, Name: "jeans", Price: dollars(40), Quantity: 1},
+			types.Product{ID: 3, Name: "mug", Price: dollars(10), Quantity: 1},
+		)
+		productStore.links = categoryStore.links
+
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			ProductStore:   productStore,
+			CategoryStore:  categoryStore,
+			ImageStore:     &mockImageStore{},
+			RateStore:      &mockExchangeRateStore{},
+			Ledger:         &mockLedger{},
+			WarehouseStore: &mockWarehouseStore{},
+			UserStore:      newMockUserStore(customer, staff, admin),
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(router)
+		return router
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decodePage := func(rr *httptest.ResponseRecorder) types.ProductPage {
+		var page types.ProductPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+
+		return page
+	}
+
+	t.Run("should list the products of a category and its subcategories", func(t *testing.T) {
+		rr := send(newRouter(), http.MethodGet, "/categories/1/products", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		page := decodePage(rr)
+		if page.Total != 2 || page.Products[0].ID != 1 || page.Products[1].ID != 2 {
+			t.Fatalf("expected the shirt and the jeans, got %+v", page.Products)
+		}
+
+		if c := page.Products[0].Categories; len(c) != 1 || c[0].Name != "Shirts" {
+			t.Errorf("expected the shirt to be listed under Shirts, got %+v", c)
+		}
+	})
+
+	t.Run("should filter the listing by category", func(t *testing.T) {
+		router := newRouter()
+
+		page := decodePage(send(router, http.MethodGet, "/products?category=2", "", nil))
+		if page.Total != 1 || page.Products[0].ID != 1 {
+			t.Errorf("expected only the shirt, got %+v", page.Products)
+		}
+
+		if rr := send(router, http.MethodGet, "/products?category=99", "", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d for an unknown category, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		if rr := send(router, http.MethodGet, "/products?category=shirts", "", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for an invalid category, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should list the categories of a product", func(t *testing.T) {
+		rr := send(newRouter(), http.MethodGet, "/products/3", "", nil)
+
+		var product types.Product
+		if err := json.NewDecoder(rr.Body).Decode(&product); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(product.Categories) != 1 || product.Categories[0].Name != "Kitchen" {
+			t.Errorf("expected the mug to be listed under Kitchen, got %+v", product.Categories)
+		}
+	})
+
+	t.Run("should let staff move a product to other categories", func(t *testing.T) {
+		router := newRouter()
+
+		if rr := send(router, http.MethodPut, "/products/3/categories", `{"categoryIDs": [1]}`, customer); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d for a customer, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		if rr := send(router, http.MethodPut, "/products/3/categories", `{"categoryIDs": [99]}`, staff); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d for an unknown category, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr := send(router, http.MethodPut, "/products/3/categories", `{"categoryIDs": [1]}`, staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		page := decodePage(send(router, http.MethodGet, "/categories/1/products", "", nil))
+		if page.Total != 3 {
+			t.Errorf("expected the mug to join the Clothing listing, got %+v", page.Products)
+		}
+
+		page = decodePage(send(router, http.MethodGet, "/categories/3/products", "", nil))
+		if page.Total != 0 {
+			t.Errorf("expected the mug to leave the Kitchen listing, got %+v", page.Products)
+		}
+	})
+}
+
+func TestProductAdminRoutes(t *testing.T) {
+	handler := NewHandler(Deps{
+		ProductStore:   newMockProductStore(),
+		CategoryStore:  newMockCategoryStore(),
+		ImageStore:     &mockImageStore{},
+		RateStore:      &mockExchangeRateStore{},
+		Ledger:         &mockLedger{},
+		WarehouseStore: &mockWarehouseStore{},
+		UserStore:      newMockUserStore(customer, staff, admin),
+		Transactor:     &mockTransactor{},
+	})
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	createProduct := func(user *types.User) int {
+		marshalled, err := json.Marshal(types.CreateProductPayload{Name: "test", Price: dollars(100), Quantity: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := newAuthenticatedRequest(t, http.MethodPost, "/products", bytes.NewBuffer(marshalled), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("should not let anonymous users create products", func(t *testing.T) {
+		if code := createProduct(nil); code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, code)
+		}
+	})
+
+	t.Run("should not let customers create products", func(t *testing.T) {
+		if code := createProduct(customer); code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, code)
+		}
+	})
+
+	t.Run("should let staff and admins create products", func(t *testing.T) {
+		for _, user := range []*types.User{staff, admin} {
+			if code := createProduct(user); code != http.StatusCreated {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusCreated, user.Role, code)
+			}
+		}
+	})
+}
+
+func TestProductUpdateAndDelete(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockProductStore) {
+		productStore := newMockProductStore(types.Product{ID: 1, Name: "mug", Description: "a mug", Price: dollars(10), Quantity: 5})
+
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			ProductStore:   productStore,
+			CategoryStore:  newMockCategoryStore(),
+			ImageStore:     &mockImageStore{},
+			RateStore:      &mockExchangeRateStore{},
+			Ledger:         &mockLedger{products: productStore},
+			WarehouseStore: &mockWarehouseStore{},
+			UserStore:      newMockUserStore(customer, staff, admin),
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(router)
+		return router, productStore
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should not let customers change products", func(t *testing.T) {
+		router, productStore := newRouter()
+
+		for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			rr := send(router, method, "/products/1", `{"name": "cup", "price": {"amount": 1200, "currency": "USD"}}`, customer)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusForbidden, method, rr.Code)
+			}
+		}
+
+		if p := productStore.products[1]; p.Name != "mug" || p.DeletedAt != nil {
+			t.Errorf("expected the product to be untouched, got %+v", p)
+		}
+	})
+
+	t.Run("should replace a product", func(t *testing.T) {
+		router, productStore := newRouter()
+
+		rr := send(router, http.MethodPut, "/products/1", `{"name": "cup", "price": {"amount": 1200, "currency": "USD"}}`, staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if p := productStore.products[1]; p.Name != "cup" || p.Description != "" || p.Price != dollars(12) || p.Quantity != 5 {
+			t.Errorf("expected the product to be replaced, got %+v", p)
+		}
+	})
+
+	t.Run("should fail to replace a product without a price", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := send(router, http.MethodPut, "/products/1", `{"name": "cup"}`, staff)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should only change the patched fields", func(t *testing.T) {
+		router, productStore := newRouter()
+
+		rr := send(router, http.MethodPatch, "/products/1", `{"price": {"amount": 1550, "currency": "USD"}}`, admin)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if p := productStore.products[1]; p.Name != "mug" || p.Description != "a mug" || p.Price != types.NewMoney(1550, types.DefaultCurrency) || p.Quantity != 5 {
+			t.Errorf("expected only the price to change, got %+v", p)
+		}
+	})
+
+	t.Run("should patch the reorder threshold", func(t *testing.T) {
+		router, productStore := newRouter()
+
+		rr := send(router, http.MethodPatch, "/products/1", `{"reorderThreshold": 10}`, staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if p := productStore.products[1]; p.ReorderThreshold != 10 || p.Quantity != 5 {
+			t.Errorf("expected only the reorder threshold to change, got %+v", p)
+		}
+	})
+
+	t.Run("should validate patched fields", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, payload := range []string{`{"price": {"amount": -100, "currency": "USD"}}`, `{"price": {"amount": 100, "currency": "EUR"}}`, `{"name": ""}`, `{"reorderThreshold": -1}`, `{}`} {
+			rr := send(router, http.MethodPatch, "/products/1", payload, admin)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should soft delete a product and hide it", func(t *testing.T) {
+		router, productStore := newRouter()
+
+		rr := send(router, http.MethodDelete, "/products/1", "", staff)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if productStore.products[1].DeletedAt == nil {
+			t.Error("expected the product row to be kept with deletedAt set")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/products/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+
+		rr = send(router, http.MethodDelete, "/products/1", "", staff)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected deleting twice to return %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestProductStock(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockProductStore, *mockLedger) {
+		productStore := newMockProductStore(types.Product{ID: 1, Name: "mug", Price: dollars(10), Quantity: 5})
+		ledger := &mockLedger{products: productStore}
+
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			ProductStore:   productStore,
+			CategoryStore:  newMockCategoryStore(),
+			ImageStore:     &mockImageStore{},
+			RateStore:      &mockExchangeRateStore{},
+			Ledger:         ledger,
+			WarehouseStore: &mockWarehouseStore{},
+			UserStore:      newMockUserStore(customer, staff, admin),
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(router)
+		return router, productStore, ledger
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should leave the stock alone when the product is edited", func(t *testing.T) {
+		router, productStore, ledger := newRouter()
+
+		// sales since the client read the product must not be undone
+		productStore.products[1].Quantity = 3
+
+		if rr := send(router, http.MethodPut, "/products/1", `{"name": "cup", "price": {"amount": 1000, "currency": "USD"}, "quantity": 5}`, staff); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if rr := send(router, http.MethodPatch, "/products/1", `{"name": "mug", "quantity": 5}`, staff); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if rr := send(router, http.MethodPatch, "/products/1", `{"quantity": 5}`, staff); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected a patch of the quantity alone to have nothing to update, got %d", rr.Code)
+		}
+
+		if productStore.products[1].Quantity != 3 || len(ledger.movements) != 0 {
+			t.Errorf("expected the 3 units left to be kept without a movement, got %d and %+v", productStore.products[1].Quantity, ledger.movements)
+		}
+	})
+
+	t.Run("should record stock brought in and list it newest first", func(t *testing.T) {
+		router, productStore, _ := newRouter()
+
+		rr := send(router, http.MethodPost, "/products/1/stock-movements", `{"change": 10, "reason": "import", "reference": "PO-7"}`, staff)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		rr = send(router, http.MethodPost, "/products/1/stock-movements", `{"change": -1, "reason": "adjustment", "reference": "broken"}`, admin)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		if productStore.products[1].Quantity != 14 {
+			t.Errorf("expected 14 units left, got %d", productStore.products[1].Quantity)
+		}
+
+		rr = send(router, http.MethodGet, "/products/1/stock-history", "", staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var history []types.StockMovement
+		if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(history) != 2 || history[0].Reference != "broken" || history[0].Balance != 14 || history[1].Reference != "PO-7" {
+			t.Errorf("expected both movements newest first, got %+v", history)
+		}
+	})
+
+	t.Run("should not take more units than are left", func(t *testing.T) {
+		router, productStore, _ := newRouter()
+
+		rr := send(router, http.MethodPost, "/products/1/stock-movements", `{"change": -6, "reason": "adjustment"}`, staff)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if productStore.products[1].Quantity != 5 {
+			t.Errorf("expected the stock to be untouched, got %d", productStore.products[1].Quantity)
+		}
+	})
+
+	t.Run("should reject movements the system records itself", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		for _, payload := range []string{`{"change": 1, "reason": "sale"}`, `{"change": 1, "reason": "return"}`, `{"change": 0, "reason": "import"}`} {
+			rr := send(router, http.MethodPost, "/products/1/stock-movements", payload, staff)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should only show the stock history to staff", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		if rr := send(router, http.MethodGet, "/products/1/stock-history", "", customer); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		if rr := send(router, http.MethodGet, "/products/9/stock-history", "", staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d for a missing product, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+// newAuthenticatedRequest builds a request carrying a JWT for user, or no
+// token at all when user is nil.
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+// mockProductStore keeps products in memory and, like the real store, hides
+// the soft deleted ones.
+type mockProductStore struct {
+	products map[int]*types.Product
+	// links maps product ids to their category ids, shared with a
+	// mockCategoryStore when a test needs the category filter
+	links map[int][]int
+	// rates convert the prices before they are filtered and sorted
+	rates types.ExchangeRates
+}
+
+func newMockProductStore(products ...types.Product) *mockProductStore {
+	m := &mockProductStore{products: map[int]*types.Product{}}
+	for _, p := range products {
+		m.products[p.ID] = &p
+	}
+
+	return m
+}
 
 func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
-	return &types.Product{}, nil
+	p, ok := m.products[productID]
+	if !ok || p.DeletedAt != nil {
+		return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+	}
+
+	product := *p
+	return &product, nil
+}
+
+// GetProducts applies the filters and pages through the products sorted by
+// price or, for any other sort key, by id.
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	priceIn := func(p *types.Product, currency string) types.Money {
+		price, _, _ := m.rates.Convert(p.Price, currency)
+		return price
+	}
+
+	matching := []*types.Product{}
+	for _, p := range m.products {
+		if p.DeletedAt != nil ||
+			(query.MinPrice != nil && priceIn(p, query.MinPrice.Currency).Cmp(*query.MinPrice) < 0) ||
+			(query.MaxPrice != nil && priceIn(p, query.MaxPrice.Currency).Cmp(*query.MaxPrice) > 0) ||
+			(query.InStock != nil && *query.InStock != (p.Quantity > 0)) ||
+			(len(query.CategoryIDs) > 0 && !slices.ContainsFunc(m.links[p.ID], func(id int) bool {
+				return slices.Contains(query.CategoryIDs, id)
+			})) {
+			continue
+		}
+
+		matching = append(matching, p)
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		a, b := priceIn(matching[i], types.DefaultCurrency), priceIn(matching[j], types.DefaultCurrency)
+		if query.SortBy == "price" && a != b {
+			return a.Cmp(b) < 0
+		}
+
+		return matching[i].ID < matching[j].ID
+	})
+
+	start := 0
+	if query.After != nil {
+		for i, p := range matching {
+			if p.ID == query.After.ID {
+				start = i + 1
+			}
+		}
+	}
+
+	return matching[start:min(start+query.Limit, len(matching))], len(matching), nil
 }
 
-func (m *mockProductStore) GetProducts() ([]*types.Product, error) {
-	return []*types.Product{}, nil
+// SearchProducts matches products whose name or description contains any of
+// the words in text, scoring one point per matching word.
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	results := []types.ProductSearchResult{}
+	for _, p := range m.products {
+		if p.DeletedAt != nil {
+			continue
+		}
+
+		haystack := strings.ToLower(p.Name + " " + p.Description)
+		relevance := 0.0
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			if strings.Contains(haystack, word) {
+				relevance++
+			}
+		}
+
+		if relevance > 0 {
+			results = append(results, types.ProductSearchResult{Product: *p, Relevance: relevance})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Relevance != results[j].Relevance {
+			return results[i].Relevance > results[j].Relevance
+		}
+
+		return results[i].ID < results[j].ID
+	})
+
+	return results[:min(limit, len(results))], nil
 }
 
-func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) error {
-	return nil
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
 }
 
+// UpdateProduct keeps the stored quantity, which only the ledger changes.
 func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	product.Quantity = m.products[product.ID].Quantity
+	m.products[product.ID] = &product
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	p, ok := m.products[productID]
+	if !ok || p.DeletedAt != nil {
+		return fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+	}
+
+	now := time.Now()
+	p.DeletedAt = &now
 	return nil
 }
 
@@ -142,10 +1042,299 @@ func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
 	return []types.Product{}, nil
 }
 
-type mockUserStore struct{}
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	return []types.LowStockProduct{}, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+// mockCategoryStore keeps the category tree and the product links in memory.
+type mockCategoryStore struct {
+	categories map[int]*types.Category
+	links      map[int][]int
+}
+
+func newMockCategoryStore(categories ...types.Category) *mockCategoryStore {
+	m := &mockCategoryStore{categories: map[int]*types.Category{}, links: map[int][]int{}}
+	for _, c := range categories {
+		m.categories[c.ID] = &c
+	}
+
+	return m
+}
+
+func (m *mockCategoryStore) GetCategories() ([]types.Category, error) {
+	categories := []types.Category{}
+	for _, c := range m.categories {
+		categories = append(categories, *c)
+	}
+
+	return categories, nil
+}
+
+func (m *mockCategoryStore) GetCategoryByID(categoryID int) (*types.Category, error) {
+	c, ok := m.categories[categoryID]
+	if !ok {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	category := *c
+	return &category, nil
+}
+
+func (m *mockCategoryStore) GetSubtreeIDs(categoryID int) ([]int, error) {
+	if _, ok := m.categories[categoryID]; !ok {
+		return nil, fmt.Errorf("category %d %w", categoryID, types.ErrNotFound)
+	}
+
+	ids := []int{categoryID}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range m.categories {
+			if c.ParentID != nil && *c.ParentID == ids[i] {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func (m *mockCategoryStore) GetCategoriesByProductIDs(productIDs []int) (map[int][]types.Category, error) {
+	categories := map[int][]types.Category{}
+	for _, productID := range productIDs {
+		for _, categoryID := range m.links[productID] {
+			categories[productID] = append(categories[productID], *m.categories[categoryID])
+		}
+	}
+
+	return categories, nil
+}
+
+func (m *mockCategoryStore) CreateCategory(c types.Category) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCategoryStore) UpdateCategory(c types.Category) error {
+	return nil
+}
+
+func (m *mockCategoryStore) DeleteCategory(categoryID int) error {
+	return nil
+}
+
+func (m *mockCategoryStore) SetProductCategories(productID int, categoryIDs []int) error {
+	m.links[productID] = categoryIDs
+	return nil
+}
+
+func (m *mockCategoryStore) WithTx(tx *sql.Tx) types.CategoryStore {
+	return m
+}
+
+type mockImageStore struct {
+	images map[int][]types.ProductImage
+}
+
+func (m *mockImageStore) GetImagesByProductIDs(productIDs []int) (map[int][]types.ProductImage, error) {
+	images := map[int][]types.ProductImage{}
+	for _, id := range productIDs {
+		if m.images[id] != nil {
+			images[id] = m.images[id]
+		}
+	}
+
+	return images, nil
+}
+
+func (m *mockImageStore) GetImageByID(productID int, imageID int) (*types.ProductImage, error) {
+	return nil, fmt.Errorf("image %d %w", imageID, types.ErrNotFound)
+}
+
+func (m *mockImageStore) CreateImage(img types.ProductImage) (int, error) {
+	return 0, nil
+}
+
+func (m *mockImageStore) DeleteImage(productID int, imageID int) error {
+	return nil
+}
+
+func (m *mockImageStore) ReorderImages(productID int, imageIDs []int) error {
+	return nil
+}
+
+func (m *mockImageStore) WithTx(tx *sql.Tx) types.ImageStore {
+	return m
+}
+
+type mockExchangeRateStore struct {
+	rates types.ExchangeRates
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+// mockLedger keeps the movements in memory and applies the ones of products
+// to the stock of products when it is set.
+type mockWarehouseStore struct {
+	availability map[int][]types.WarehouseStock
+}
+
+func (m *mockWarehouseStore) GetWarehouses() ([]types.Warehouse, error) {
+	return []types.Warehouse{}, nil
+}
+
+func (m *mockWarehouseStore) GetWarehouse(id int) (*types.Warehouse, error) {
+	return nil, fmt.Errorf("warehouse %d %w", id, types.ErrNotFound)
+}
+
+func (m *mockWarehouseStore) CreateWarehouse(w types.Warehouse) (int, error) {
+	return 0, nil
+}
+
+func (m *mockWarehouseStore) UpdateWarehouse(w types.Warehouse) error {
+	return nil
+}
+
+func (m *mockWarehouseStore) GetInventoryLevels(productIDs []int) ([]types.InventoryLevel, error) {
+	return []types.InventoryLevel{}, nil
+}
+
+func (m *mockWarehouseStore) GetAvailability(productIDs []int) (map[int][]types.WarehouseStock, error) {
+	availability := map[int][]types.WarehouseStock{}
+	for _, id := range productIDs {
+		if stock, ok := m.availability[id]; ok {
+			availability[id] = stock
+		}
+	}
+
+	return availability, nil
+}
+
+func (m *mockWarehouseStore) CreateAllocations(allocations []types.OrderAllocation) error {
+	return nil
+}
+
+func (m *mockWarehouseStore) GetAllocations(orderID int) ([]types.OrderAllocation, error) {
+	return []types.OrderAllocation{}, nil
+}
+
+func (m *mockWarehouseStore) GetPickingList(warehouseID int) ([]types.PickingListItem, error) {
+	return []types.PickingListItem{}, nil
+}
+
+func (m *mockWarehouseStore) WithTx(tx *sql.Tx) types.WarehouseStore {
+	return m
+}
+
+type mockLedger struct {
+	products  *mockProductStore
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	if m.products != nil && movement.VariantID == nil {
+		p, ok := m.products.products[movement.ProductID]
+		if !ok {
+			return fmt.Errorf("product %d %w", movement.ProductID, types.ErrNotFound)
+		}
+
+		if p.Quantity+movement.Change < 0 {
+			return fmt.Errorf("product %d is not available in the quantity requested: %w", movement.ProductID, types.ErrConflict)
+		}
+
+		p.Quantity += movement.Change
+		movement.Balance = p.Quantity
+	}
+
+	movement.ID = len(m.movements) + 1
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for i := len(m.movements) - 1; i >= 0; i-- {
+		if m.movements[i].ProductID == productID {
+			movements = append(movements, m.movements[i])
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
 
 func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
-	return &types.User{}, nil
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
 }
 
 func (m *mockUserStore) CreateUser(user types.User) error {
@@ -0,0 +1,134 @@
+package product
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxSearchQueryLength = 200
+
+	// snippetLength is roughly how many characters of the description are
+	// kept around the first match.
+	snippetLength = 160
+)
+
+// parseSearchQuery reads the q and limit parameters of a search request and
+// returns the trimmed search text.
+func parseSearchQuery(r *http.Request) (string, int, error) {
+	params := r.URL.Query()
+
+	text := strings.TrimSpace(params.Get("q"))
+	if text == "" {
+		return "", 0, fmt.Errorf("missing search query")
+	}
+	if len(text) > maxSearchQueryLength {
+		return "", 0, fmt.Errorf("search query is longer than %d characters", maxSearchQueryLength)
+	}
+
+	limit := defaultProductsLimit
+	if str := params.Get("limit"); str != "" {
+		l, err := strconv.Atoi(str)
+		if err != nil || l <= 0 {
+			return "", 0, fmt.Errorf("invalid limit")
+		}
+
+		limit = min(l, maxProductsLimit)
+	}
+
+	return text, limit, nil
+}
+
+// searchTerms splits the search text into the words to highlight.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// termsPattern matches any of the terms at the start of a word, ignoring
+// case, so "shirt" also marks "shirts". The term is its first group, as the
+// match also holds the character before the word. RE2's \b only knows ASCII
+// words and would find "ber" inside "über". It returns nil when there are no
+// terms.
+func termsPattern(terms []string) *regexp.Regexp {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(quoted, "|") + `)`)
+}
+
+// highlight HTML-escapes text and wraps every match of pattern in <mark>
+// tags.
+func highlight(text string, pattern *regexp.Regexp) string {
+	if pattern == nil {
+		return html.EscapeString(text)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:loc[2]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[loc[2]:loc[3]]))
+		b.WriteString("</mark>")
+		last = loc[3]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+
+	return b.String()
+}
+
+// snippet cuts text down to about snippetLength characters around the first
+// match of pattern, on word boundaries, adding an ellipsis where text was
+// dropped. Text without a match is cut from the start.
+func snippet(text string, pattern *regexp.Regexp) string {
+	if len(text) <= snippetLength {
+		return text
+	}
+
+	start := 0
+	if pattern != nil {
+		if loc := pattern.FindStringSubmatchIndex(text); loc != nil {
+			start = max(loc[2]-snippetLength/4, 0)
+		}
+	}
+	end := min(start+snippetLength, len(text))
+
+	// move both ends outwards to the nearest space so no word is split
+	if start > 0 {
+		if i := strings.LastIndexByte(text[:start], ' '); i >= 0 {
+			start = i + 1
+		} else {
+			start = 0
+		}
+	}
+	if end < len(text) {
+		if i := strings.IndexByte(text[end:], ' '); i >= 0 {
+			end += i
+		} else {
+			end = len(text)
+		}
+	}
+
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+
+	return out
+}
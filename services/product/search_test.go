@@ -0,0 +1,46 @@
+package product
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		want  string
+	}{
+		{"shirt", "Red shirts", "Red <mark>shirt</mark>s"},
+		{"über", "Über jacket, über warm", "<mark>Über</mark> jacket, <mark>über</mark> warm"},
+		{"ação", "Promoção: ação rápida", "Promoção: <mark>ação</mark> rápida"},
+		// RE2's \b would see a word start after the ü
+		{"ber", "über", "über"},
+		{"ção", "Promoção", "Promoção"},
+		{"red", "<red>", "&lt;<mark>red</mark>&gt;"},
+	}
+
+	for _, tt := range tests {
+		if got := highlight(tt.text, termsPattern(searchTerms(tt.query))); got != tt.want {
+			t.Errorf("highlighting %q in %q: expected %q, got %q", tt.query, tt.text, tt.want, got)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	pattern := termsPattern(searchTerms("needle"))
+	text := strings.Repeat("hay ", 60) + "needle " + strings.Repeat("straw ", 60)
+
+	got := snippet(text, pattern)
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("expected the snippet to be cut on both sides, got %q", got)
+	}
+
+	if !strings.Contains(got, "needle") {
+		t.Errorf("expected the snippet to contain the match, got %q", got)
+	}
+
+	if short := "a short needle"; snippet(short, pattern) != short {
+		t.Errorf("expected short text to be kept whole")
+	}
+}
@@ -9,33 +9,43 @@ import (
 )
 
 type Store struct {
-	db *sql.DB
+	db types.DBTX
 }
 
 func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ProductStore {
+	return &Store{db: tx}
+}
+
+// productColumns lists the columns read by scanRowsIntoProduct, in order.
+// The currency comes before the price as it decides how the price is read.
+const productColumns = "id, name, description, image, currency, price, taxClass, weight, quantity, reorderThreshold, createdAt, deletedAt"
+
 func (s *Store) GetProductByID(productID int) (*types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products WHERE id = ?", productID)
+	rows, err := s.db.Query("SELECT "+productColumns+" FROM products WHERE id = ? AND deletedAt IS NULL", productID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	p := new(types.Product)
-	for rows.Next() {
-		p, err = scanRowsIntoProduct(rows)
-		if err != nil {
-			return nil, err
-		}
+	if !rows.Next() {
+		return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
 	}
 
-	return p, nil
+	return scanRowsIntoProduct(rows)
 }
 
 func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
+	if len(productIDs) == 0 {
+		return []types.Product{}, nil
+	}
+
 	placeholders := strings.Repeat(",?", len(productIDs)-1)
-	query := fmt.Sprintf("SELECT * FROM products WHERE id IN (?%s)", placeholders)
+	query := fmt.Sprintf("SELECT "+productColumns+" FROM products WHERE id IN (?%s) AND deletedAt IS NULL", placeholders)
 
 	// Convert productIDs to []interface{}
 	args := make([]interface{}, len(productIDs))
@@ -47,6 +57,7 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := []types.Product{}
 	for rows.Next() {
@@ -62,27 +73,164 @@ func (s *Store) GetProductsByID(productIDs []int) ([]types.Product, error) {
 
 }
 
-func (s *Store) GetProducts() ([]*types.Product, error) {
-	rows, err := s.db.Query("SELECT * FROM products")
+// sortColumns maps the sort keys accepted by the API to product columns.
+// Prices are sorted once converted into the default currency, so products
+// priced in different currencies interleave correctly.
+var sortColumns = map[string]string{
+	"price":     basePrice("products"),
+	"createdAt": "createdAt",
+	"name":      "name",
+}
+
+// basePrice converts the price of the products row aliased as table into
+// types.DefaultCurrency, which has no row in exchange_rates.
+func basePrice(table string) string {
+	return fmt.Sprintf("(%[1]s.price / COALESCE((SELECT r.rate FROM exchange_rates r WHERE r.currency = %[1]s.currency), 1))", table)
+}
+
+// priceCondition compares the price of the products row, converted into the
+// currency of price, against price.
+func priceCondition(op string, price types.Money) (string, []any) {
+	cond := fmt.Sprintf("%s * COALESCE((SELECT r.rate FROM exchange_rates r WHERE r.currency = ?), 1) %s ?", basePrice("products"), op)
+	return cond, []any{price.Currency, price}
+}
+
+// inStockCondition holds for products with stock left. Products sold in
+// variants are in stock while any of their variants is.
+const inStockCondition = "IF(" +
+	"EXISTS (SELECT 1 FROM product_variants v WHERE v.productId = products.id AND v.deletedAt IS NULL), " +
+	"EXISTS (SELECT 1 FROM product_variants v WHERE v.productId = products.id AND v.deletedAt IS NULL AND v.quantity > 0), " +
+	"quantity > 0)"
+
+func (s *Store) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	column, ok := sortColumns[query.SortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot sort products by %q", query.SortBy)
+	}
+
+	where := []string{"deletedAt IS NULL"}
+	args := []interface{}{}
+
+	if query.MinPrice != nil {
+		cond, condArgs := priceCondition(">=", *query.MinPrice)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	if query.MaxPrice != nil {
+		cond, condArgs := priceCondition("<=", *query.MaxPrice)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	if query.InStock != nil {
+		if *query.InStock {
+			where = append(where, inStockCondition)
+		} else {
+			where = append(where, "NOT "+inStockCondition)
+		}
+	}
+
+	if len(query.CategoryIDs) > 0 {
+		placeholders := strings.Repeat(",?", len(query.CategoryIDs)-1)
+		where = append(where, "id IN (SELECT productId FROM product_categories WHERE categoryId IN (?"+placeholders+"))")
+		for _, id := range query.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM products WHERE " + strings.Join(where, " AND ")
+	if err := s.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	direction, op := "ASC", ">"
+	if query.Desc {
+		direction, op = "DESC", "<"
+	}
+
+	// keyset pagination: continue after the cursor's (value, id) pair, with
+	// the id breaking ties between products sharing the same value
+	if query.After != nil {
+		// converted prices are read back from the cursor's product rather
+		// than carried in the cursor, so they compare exactly
+		value, valueArg := "?", any(query.After.Value)
+		if query.SortBy == "price" {
+			value, valueArg = "(SELECT "+basePrice("c")+" FROM products c WHERE c.id = ?)", query.After.ID
+		}
+
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s ?))", column, op, value))
+		args = append(args, valueArg, valueArg, query.After.ID)
+	}
+
+	listQuery := fmt.Sprintf(
+		"SELECT %s FROM products WHERE %s ORDER BY %s %s, id %s LIMIT ?",
+		productColumns, strings.Join(where, " AND "), column, direction, direction,
+	)
+	args = append(args, query.Limit)
+
+	rows, err := s.db.Query(listQuery, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer rows.Close()
 
 	products := make([]*types.Product, 0)
 	for rows.Next() {
 		p, err := scanRowsIntoProduct(rows)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		products = append(products, p)
 	}
 
-	return products, nil
+	return products, total, rows.Err()
+}
+
+func (s *Store) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	rows, err := s.db.Query(
+		"SELECT "+productColumns+", MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) AS relevance "+
+			"FROM products WHERE deletedAt IS NULL AND MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) "+
+			"ORDER BY relevance DESC, id LIMIT ?",
+		text, text, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]types.ProductSearchResult, 0)
+	for rows.Next() {
+		var relevance float64
+		p, err := scanRowsIntoProduct(rows, &relevance)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, types.ProductSearchResult{Product: *p, Relevance: relevance})
+	}
+
+	return results, rows.Err()
+}
+
+func (s *Store) CreateProduct(product types.CreateProductPayload) (int, error) {
+	res, err := s.db.Exec("INSERT INTO products (name, currency, price, taxClass, weight, image, description, quantity, reorderThreshold) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)", product.Name, product.Price.Currency, product.Price, product.TaxClass, product.Weight, product.Image, product.Description, product.ReorderThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
-func (s *Store) CreateProduct(product types.CreateProductPayload) error {
-	_, err := s.db.Exec("INSERT INTO products (name, price, image, description, quantity) VALUES (?, ?, ?, ?, ?)", product.Name, product.Price, product.Image, product.Description, product.Quantity)
+func (s *Store) UpdateProduct(product types.Product) error {
+	_, err := s.db.Exec("UPDATE products SET name = ?, currency = ?, price = ?, taxClass = ?, weight = ?, image = ?, description = ?, reorderThreshold = ? WHERE id = ? AND deletedAt IS NULL", product.Name, product.Price.Currency, product.Price, product.TaxClass, product.Weight, product.Image, product.Description, product.ReorderThreshold, product.ID)
 	if err != nil {
 		return err
 	}
@@ -90,27 +238,83 @@ func (s *Store) CreateProduct(product types.CreateProductPayload) error {
 	return nil
 }
 
-func (s *Store) UpdateProduct(product types.Product) error {
-	_, err := s.db.Exec("UPDATE products SET name = ?, price = ?, image = ?, description = ?, quantity = ? WHERE id = ?", product.Name, product.Price, product.Image, product.Description, product.Quantity, product.ID)
+func (s *Store) DeleteProduct(productID int) error {
+	res, err := s.db.Exec("UPDATE products SET deletedAt = CURRENT_TIMESTAMP WHERE id = ? AND deletedAt IS NULL", productID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if affected == 0 {
+		return fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+	}
+
 	return nil
 }
 
-func scanRowsIntoProduct(rows *sql.Rows) (*types.Product, error) {
+func (s *Store) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	products := make([]types.LowStockProduct, 0)
+	if productIDs != nil && len(productIDs) == 0 {
+		return products, nil
+	}
+
+	where, args := "", []any{}
+	if productIDs != nil {
+		for _, id := range productIDs {
+			args = append(args, id)
+		}
+		where = " AND id IN (?" + strings.Repeat(",?", len(productIDs)-1) + ")"
+	}
+
+	rows, err := s.db.Query(
+		"SELECT * FROM ("+
+			"SELECT id, name, quantity + COALESCE((SELECT SUM(v.quantity) FROM product_variants v WHERE v.productId = products.id AND v.deletedAt IS NULL), 0) AS stock, reorderThreshold"+
+			" FROM products WHERE deletedAt IS NULL"+where+
+			") p WHERE stock <= reorderThreshold ORDER BY stock, id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p types.LowStockProduct
+		if err := rows.Scan(&p.ProductID, &p.Name, &p.Quantity, &p.ReorderThreshold); err != nil {
+			return nil, err
+		}
+
+		products = append(products, p)
+	}
+
+	return products, rows.Err()
+}
+
+// scanRowsIntoProduct reads the productColumns of the current row, followed
+// by any extra columns the query selected into extra.
+func scanRowsIntoProduct(rows *sql.Rows, extra ...any) (*types.Product, error) {
 	product := new(types.Product)
 
-	err := rows.Scan(
+	dest := []any{
 		&product.ID,
 		&product.Name,
 		&product.Description,
 		&product.Image,
+		&product.Price.Currency,
 		&product.Price,
+		&product.TaxClass,
+		&product.Weight,
 		&product.Quantity,
+		&product.ReorderThreshold,
 		&product.CreatedAt,
-	)
+		&product.DeletedAt,
+	}
+
+	err := rows.Scan(append(dest, extra...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,15 @@
+package product
+
+import "testing"
+
+func TestGetProductsByIDWithoutIDs(t *testing.T) {
+	// no query is run, so the store needs no database
+	products, err := NewStore(nil).GetProductsByID([]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(products) != 0 {
+		t.Errorf("expected no products, got %+v", products)
+	}
+}
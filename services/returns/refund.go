@@ -0,0 +1,116 @@
+package returns
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// ErrInvalidReturn is returned when a return asks for items the order doesn't
+// have, or for more units than are left to return.
+var ErrInvalidReturn = errors.New("invalid return")
+
+// returnable tells whether customers can send back units of an order.
+func returnable(status types.OrderStatus) bool {
+	return status == types.OrderStatusDelivered || status == types.OrderStatusPartiallyRefunded
+}
+
+// Items checks the requested units against the items of the order and the
+// returns already made for it, and merges lines that name the same item.
+// Units in a rejected return can be asked for again.
+func Items(items []types.OrderItemDetail, returns []types.Return, requested []types.ReturnItemPayload) ([]types.ReturnItem, error) {
+	left := make(map[int]int, len(items))
+	for _, item := range items {
+		left[item.ID] = item.Quantity
+	}
+
+	for _, r := range returns {
+		if r.Status == types.ReturnRejected {
+			continue
+		}
+
+		for _, item := range r.Items {
+			left[item.OrderItemID] -= item.Quantity
+		}
+	}
+
+	merged := make([]types.ReturnItem, 0, len(requested))
+	index := make(map[int]int, len(requested))
+	for _, item := range requested {
+		if _, ok := left[item.OrderItemID]; !ok {
+			return nil, fmt.Errorf("order item %d is not part of the order: %w", item.OrderItemID, ErrInvalidReturn)
+		}
+
+		if i, ok := index[item.OrderItemID]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+
+		index[item.OrderItemID] = len(merged)
+		merged = append(merged, types.ReturnItem{OrderItemID: item.OrderItemID, Quantity: item.Quantity})
+	}
+
+	for _, item := range merged {
+		if item.Quantity > left[item.OrderItemID] {
+			return nil, fmt.Errorf("only %d units of order item %d are left to return: %w", max(left[item.OrderItemID], 0), item.OrderItemID, ErrInvalidReturn)
+		}
+	}
+
+	return merged, nil
+}
+
+// RefundAmount works out how much to give back for receiving r. Every unit is
+// refunded at its share of what was paid for its line, after discounts and
+// with taxes. The return that brings back the last unit of the order
+// refunds whatever is left of the order's total instead, shipping included,
+// and full reports that the order is now refunded in full.
+func RefundAmount(order *types.Order, items []types.OrderItemDetail, returns []types.Return, r *types.Return) (amount types.Money, full bool) {
+	received := make(map[int]int, len(items))
+	for _, other := range returns {
+		if other.Status != types.ReturnReceived || other.ID == r.ID {
+			continue
+		}
+
+		for _, item := range other.Items {
+			received[item.OrderItemID] += item.Quantity
+		}
+	}
+
+	for _, item := range r.Items {
+		received[item.OrderItemID] += item.Quantity
+	}
+
+	left := order.Total.Sub(order.Refunded)
+
+	full = true
+	for _, item := range items {
+		if received[item.ID] < item.Quantity {
+			full = false
+			break
+		}
+	}
+
+	if full {
+		return left, true
+	}
+
+	lines := make(map[int]types.OrderItemDetail, len(items))
+	for _, item := range items {
+		lines[item.ID] = item
+	}
+
+	amount = types.NewMoney(0, order.Total.Currency)
+	for _, item := range r.Items {
+		line := lines[item.OrderItemID]
+		paid := line.Price.Mul(line.Quantity).Sub(line.Discount).Add(line.Tax)
+		amount = amount.Add(paid.Scale(int64(item.Quantity), int64(line.Quantity)))
+	}
+
+	// rounding must not give back more than is left
+	if amount.Cmp(left) > 0 {
+		amount = left
+	}
+
+	return amount, false
+}
@@ -0,0 +1,84 @@
+package returns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestItems(t *testing.T) {
+	items := []types.OrderItemDetail{
+		{OrderItem: types.OrderItem{ID: 1, Quantity: 3}},
+		{OrderItem: types.OrderItem{ID: 2, Quantity: 1}},
+	}
+	returns := []types.Return{
+		{ID: 1, Status: types.ReturnApproved, Items: []types.ReturnItem{{OrderItemID: 1, Quantity: 1}}},
+		{ID: 2, Status: types.ReturnRejected, Items: []types.ReturnItem{{OrderItemID: 2, Quantity: 1}}},
+	}
+
+	t.Run("should merge lines naming the same item", func(t *testing.T) {
+		got, err := Items(items, returns, []types.ReturnItemPayload{
+			{OrderItemID: 1, Quantity: 1},
+			{OrderItemID: 2, Quantity: 1},
+			{OrderItemID: 1, Quantity: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 2 || got[0] != (types.ReturnItem{OrderItemID: 1, Quantity: 2}) {
+			t.Errorf("expected the lines of item 1 to be merged, got %+v", got)
+		}
+	})
+
+	t.Run("should not return more units than are left", func(t *testing.T) {
+		_, err := Items(items, returns, []types.ReturnItemPayload{{OrderItemID: 1, Quantity: 3}})
+		if !errors.Is(err, ErrInvalidReturn) {
+			t.Errorf("expected the return to be invalid, got %v", err)
+		}
+	})
+
+	t.Run("should reject items of other orders", func(t *testing.T) {
+		_, err := Items(items, returns, []types.ReturnItemPayload{{OrderItemID: 9, Quantity: 1}})
+		if !errors.Is(err, ErrInvalidReturn) {
+			t.Errorf("expected the return to be invalid, got %v", err)
+		}
+	})
+}
+
+func TestRefundAmount(t *testing.T) {
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, types.DefaultCurrency) }
+
+	// 3 units at $10 with $3 off and $2.70 of tax, 1 unit at $20, and $5 of
+	// shipping
+	order := &types.Order{ID: 1, Total: usd(5170), Refunded: usd(0)}
+	items := []types.OrderItemDetail{
+		{OrderItem: types.OrderItem{ID: 1, Quantity: 3, Price: usd(1000), Discount: usd(300), Tax: usd(270)}},
+		{OrderItem: types.OrderItem{ID: 2, Quantity: 1, Price: usd(2000), Discount: usd(0), Tax: usd(0)}},
+	}
+
+	t.Run("should refund a unit at its share of the line", func(t *testing.T) {
+		r := &types.Return{ID: 1, Items: []types.ReturnItem{{OrderItemID: 1, Quantity: 1}}}
+
+		amount, full := RefundAmount(order, items, nil, r)
+		if amount != usd(990) || full {
+			t.Errorf("expected $9.90 of a partial refund, got %s, %v", amount, full)
+		}
+	})
+
+	t.Run("should refund what is left with the last unit", func(t *testing.T) {
+		order := *order
+		order.Refunded = usd(990)
+		returns := []types.Return{
+			{ID: 1, Status: types.ReturnReceived, Items: []types.ReturnItem{{OrderItemID: 1, Quantity: 1}}},
+			{ID: 2, Status: types.ReturnRejected, Items: []types.ReturnItem{{OrderItemID: 2, Quantity: 1}}},
+		}
+		r := &types.Return{ID: 3, Items: []types.ReturnItem{{OrderItemID: 1, Quantity: 2}, {OrderItemID: 2, Quantity: 1}}}
+
+		amount, full := RefundAmount(&order, items, returns, r)
+		if amount != usd(4180) || !full {
+			t.Errorf("expected the $41.80 left to be refunded in full, got %s, %v", amount, full)
+		}
+	})
+}
@@ -0,0 +1,474 @@
+package returns
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store          types.ReturnStore
+	orderStore     types.OrderStore
+	ledger         types.StockLedger
+	paymentStore   types.PaymentStore
+	paymentSettler types.PaymentSettler
+	userStore      types.UserStore
+	transactor     types.Transactor
+}
+
+// Deps are the stores and services the return handlers work with.
+type Deps struct {
+	ReturnStore    types.ReturnStore
+	OrderStore     types.OrderStore
+	Ledger         types.StockLedger
+	PaymentStore   types.PaymentStore
+	PaymentSettler types.PaymentSettler
+	UserStore      types.UserStore
+	Transactor     types.Transactor
+}
+
+func NewHandler(deps Deps) *Handler {
+	return &Handler{
+		store:          deps.ReturnStore,
+		orderStore:     deps.OrderStore,
+		ledger:         deps.Ledger,
+		paymentStore:   deps.PaymentStore,
+		paymentSettler: deps.PaymentSettler,
+		userStore:      deps.UserStore,
+		transactor:     deps.Transactor,
+	}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/orders/{orderID}/returns", auth.WithJWTAuth(h.handleGetOrderReturns, h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/orders/{orderID}/returns", auth.WithJWTAuth(h.handleCreateReturn, h.userStore)).Methods(http.MethodPost)
+
+	// staff routes
+	router.HandleFunc("/returns", auth.WithJWTAuth(auth.RequireRole(h.handleGetReturns, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/returns/{returnID}/approve", auth.WithJWTAuth(auth.RequireRole(h.handleApproveReturn, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/returns/{returnID}/reject", auth.WithJWTAuth(auth.RequireRole(h.handleRejectReturn, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/returns/{returnID}/receive", auth.WithJWTAuth(auth.RequireRole(h.handleReceiveReturn, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+}
+
+func (h *Handler) handleGetOrderReturns(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := getIDFromPath(r, "orderID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// orders of other users are reported as missing, like GET /orders/{orderID}
+	if _, err := h.orderStore.GetOrderByID(userID, orderID); err != nil {
+		if errors.Is(err, types.ErrNotFound) {
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order %d not found", orderID))
+			return
+		}
+
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	returns, err := h.store.GetReturnsByOrderID(orderID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (h *Handler) handleCreateReturn(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserIDFromContext(r.Context())
+
+	orderID, err := getIDFromPath(r, "orderID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.CreateReturnPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	var created *types.Return
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		orderStore := h.orderStore.WithTx(tx)
+		store := h.store.WithTx(tx)
+
+		current, err := orderStore.GetOrderByID(userID, orderID)
+		if err != nil {
+			return err
+		}
+
+		if !returnable(current.Status) {
+			return fmt.Errorf("order %d is %s, only delivered orders can be returned: %w", orderID, current.Status, types.ErrConflict)
+		}
+
+		items, err := orderStore.GetOrderItems(orderID)
+		if err != nil {
+			return err
+		}
+
+		returns, err := store.GetReturnsByOrderID(orderID)
+		if err != nil {
+			return err
+		}
+
+		returnItems, err := Items(items, returns, payload.Items)
+		if err != nil {
+			return err
+		}
+
+		id, err := store.CreateReturn(types.Return{OrderID: orderID, UserID: userID, Reason: payload.Reason, Items: returnItems})
+		if err != nil {
+			return err
+		}
+
+		created, err = store.GetReturn(id)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+			utils.WriteError(w, http.StatusNotFound, fmt.Errorf("order %d not found", orderID))
+		case errors.Is(err, types.ErrConflict):
+			utils.WriteError(w, http.StatusConflict, err)
+		case errors.Is(err, ErrInvalidReturn):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) handleGetReturns(w http.ResponseWriter, r *http.Request) {
+	status := types.ReturnStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", types.ReturnRequested, types.ReturnApproved, types.ReturnRejected, types.ReturnReceived:
+	default:
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown return status %q", status))
+		return
+	}
+
+	returns, err := h.store.GetReturns(status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, returns)
+}
+
+func (h *Handler) handleApproveReturn(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, types.ReturnApproved)
+}
+
+func (h *Handler) handleRejectReturn(w http.ResponseWriter, r *http.Request) {
+	h.review(w, r, types.ReturnRejected)
+}
+
+// review approves or rejects a requested return.
+func (h *Handler) review(w http.ResponseWriter, r *http.Request, status types.ReturnStatus) {
+	returnID, err := getIDFromPath(r, "returnID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ReviewReturnPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	ret, err := h.store.GetReturn(returnID)
+	if err != nil {
+		writeReturnError(w, returnID, err)
+		return
+	}
+
+	if ret.Status != types.ReturnRequested {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("return %d is already %s", returnID, ret.Status))
+		return
+	}
+
+	ret.Status = status
+	ret.Note = payload.Note
+	if err := h.store.UpdateReturn(*ret, types.ReturnRequested); err != nil {
+		writeReturnError(w, returnID, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, ret)
+}
+
+// handleReceiveReturn records that the units of an approved return came
+// back. They go back in stock if asked to, and their money is refunded
+// through the payment gateway when the order's payment was captured. The
+// gateway is only called once everything else is committed; a refund it
+// fails is retried in the background, and the return answered as accepted
+// until then.
+func (h *Handler) handleReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	actorID := auth.GetUserIDFromContext(r.Context())
+
+	returnID, err := getIDFromPath(r, "returnID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ReceiveReturnPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	var (
+		ret    *types.Return
+		refund types.Refund
+		status types.OrderStatus
+	)
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+		orderStore := h.orderStore.WithTx(tx)
+
+		var err error
+		ret, err = store.GetReturn(returnID)
+		if err != nil {
+			return err
+		}
+
+		if ret.Status != types.ReturnApproved {
+			return fmt.Errorf("return %d is %s, only approved returns can be received: %w", returnID, ret.Status, types.ErrConflict)
+		}
+
+		current, err := orderStore.GetOrder(ret.OrderID)
+		if err != nil {
+			return err
+		}
+
+		if !returnable(current.Status) {
+			return fmt.Errorf("order %d is %s: %w", current.ID, current.Status, types.ErrConflict)
+		}
+
+		items, err := orderStore.GetOrderItems(current.ID)
+		if err != nil {
+			return err
+		}
+
+		returns, err := store.GetReturnsByOrderID(current.ID)
+		if err != nil {
+			return err
+		}
+
+		if payload.Restock {
+			if err := h.restock(tx, items, ret, actorID); err != nil {
+				return err
+			}
+		}
+
+		amount, full := RefundAmount(current, items, returns, ret)
+		refund, err = h.refund(tx, current, ret.ID, amount, full)
+		if err != nil {
+			return err
+		}
+
+		next := types.OrderStatusPartiallyRefunded
+		if full {
+			next = types.OrderStatusRefunded
+		}
+
+		if current.Status != next {
+			if err := order.Transition(orderStore, current, next, &actorID, fmt.Sprintf("return %d received", ret.ID)); err != nil {
+				return err
+			}
+		}
+		status = current.Status
+
+		ret.Status = types.ReturnReceived
+		ret.Restocked = payload.Restock
+		if payload.Note != "" {
+			ret.Note = payload.Note
+		}
+
+		return store.UpdateReturn(*ret, types.ReturnApproved)
+	})
+	if err != nil {
+		writeReturnError(w, returnID, err)
+		return
+	}
+
+	code := http.StatusOK
+	if refund.PaymentID != nil {
+		if err := h.paymentSettler.Settle(r.Context(), *refund.PaymentID); err != nil {
+			log.Printf("failed to settle payment %d of order %d, it will be retried: %v", *refund.PaymentID, refund.OrderID, err)
+			code = http.StatusAccepted
+		}
+	}
+
+	utils.WriteJSON(w, code, map[string]interface{}{
+		"return":       ret,
+		"refund":       refund,
+		"order_status": status,
+	})
+}
+
+// restock puts the returned units back on the shelf of the warehouses they
+// were sold from, in the order they were taken. Units the order's sales
+// don't account for go back to the default warehouse.
+func (h *Handler) restock(tx *sql.Tx, items []types.OrderItemDetail, ret *types.Return, actorID int) error {
+	lines := make(map[int]types.OrderItemDetail, len(items))
+	for _, item := range items {
+		lines[item.ID] = item
+	}
+
+	ledger := h.ledger.WithTx(tx)
+
+	movements, err := ledger.GetMovementsByReference(order.Reference(ret.OrderID))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range ret.Items {
+		line := lines[item.OrderItemID]
+
+		left := item.Quantity
+		for _, m := range movements {
+			if left == 0 {
+				break
+			}
+			if m.Reason != types.StockSale || m.ProductID != line.ProductID || !sameVariant(m.VariantID, line.VariantID) {
+				continue
+			}
+
+			quantity := min(left, -m.Change)
+			if err := h.moveReturned(ledger, line, m.WarehouseID, quantity, ret.ID, actorID); err != nil {
+				return err
+			}
+			left -= quantity
+		}
+
+		if left > 0 {
+			if err := h.moveReturned(ledger, line, nil, left, ret.ID, actorID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) moveReturned(ledger types.StockLedger, line types.OrderItemDetail, warehouseID *int, quantity int, returnID int, actorID int) error {
+	return ledger.Move(types.StockMovement{
+		ProductID:   line.ProductID,
+		VariantID:   line.VariantID,
+		WarehouseID: warehouseID,
+		Change:      quantity,
+		Reason:      types.StockReturn,
+		ActorID:     &actorID,
+		Reference:   fmt.Sprintf("return:%d", returnID),
+	})
+}
+
+func sameVariant(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// refund gives amount back for a return and records it against the order.
+// Money only goes through the gateway when the payment was captured; the
+// refund is otherwise recorded for staff to settle by hand. The payment is
+// marked refunded once the whole order is.
+func (h *Handler) refund(tx *sql.Tx, o *types.Order, returnID int, amount types.Money, full bool) (types.Refund, error) {
+	refund := types.Refund{OrderID: o.ID, ReturnID: &returnID, Amount: amount}
+	if !amount.IsPositive() {
+		return refund, nil
+	}
+
+	paymentStore := h.paymentStore.WithTx(tx)
+
+	p, err := payment.Current(paymentStore, o.ID)
+	if err != nil {
+		return refund, err
+	}
+
+	if p != nil && p.Status == types.PaymentCaptured {
+		if err := payment.Refund(paymentStore, p, amount); err != nil {
+			return refund, err
+		}
+
+		refund.PaymentID = &p.ID
+	}
+
+	refund.ID, err = h.orderStore.WithTx(tx).RecordRefund(refund)
+	if err != nil {
+		return refund, err
+	}
+	o.Refunded = o.Refunded.Add(amount)
+
+	if full && refund.PaymentID != nil {
+		return refund, paymentStore.UpdatePaymentStatus(p.ID, types.PaymentRefunded)
+	}
+
+	return refund, nil
+}
+
+func writeReturnError(w http.ResponseWriter, returnID int, err error) {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("return %d not found", returnID))
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func getIDFromPath(r *http.Request, name string) (int, error) {
+	str, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s", name)
+	}
+
+	id, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+
+	return id, nil
+}
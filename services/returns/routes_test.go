@@ -0,0 +1,720 @@
+package returns
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+)
+
+// dollars builds a price in whole dollars.
+func dollars(n int64) types.Money {
+	return types.NewMoney(n*100, types.DefaultCurrency)
+}
+
+func TestReturnHandlers(t *testing.T) {
+	customer := &types.User{ID: 1, Role: types.RoleCustomer}
+	staff := &types.User{ID: 2, Role: types.RoleStaff}
+	userStore := &mockUserStore{users: map[int]*types.User{customer.ID: customer, staff.ID: staff}}
+
+	type fixture struct {
+		router       *mux.Router
+		store        *mockReturnStore
+		orderStore   *mockOrderStore
+		ledger       *mockLedger
+		paymentStore *mockPaymentStore
+		gateway      *mockPaymentGateway
+	}
+
+	// order 1 was delivered: 2 units at $10 and 1 at $20 plus $5 of shipping,
+	// paid with a captured payment. Order 2 is still pending and order 3
+	// belongs to someone else.
+	setup := func() fixture {
+		f := fixture{
+			store: &mockReturnStore{},
+			orderStore: &mockOrderStore{
+				orders: []types.Order{
+					{ID: 1, UserID: customer.ID, Total: dollars(45), Refunded: dollars(0), Status: types.OrderStatusDelivered},
+					{ID: 2, UserID: customer.ID, Total: dollars(10), Status: types.OrderStatusPending},
+					{ID: 3, UserID: 7, Total: dollars(10), Status: types.OrderStatusDelivered},
+				},
+				items: map[int][]types.OrderItemDetail{
+					1: {
+						{OrderItem: types.OrderItem{ID: 1, OrderID: 1, ProductID: 1, Quantity: 2, Price: dollars(10), Discount: dollars(0), Tax: dollars(0)}},
+						{OrderItem: types.OrderItem{ID: 2, OrderID: 1, ProductID: 2, Quantity: 1, Price: dollars(20), Discount: dollars(0), Tax: dollars(0)}},
+					},
+					2: {{OrderItem: types.OrderItem{ID: 3, OrderID: 2, ProductID: 1, Quantity: 1, Price: dollars(10)}}},
+				},
+			},
+			ledger: &mockLedger{},
+			paymentStore: &mockPaymentStore{payments: []types.Payment{
+				{ID: 1, OrderID: 1, Reference: "auth_1", Amount: dollars(45), Status: types.PaymentCaptured},
+			}},
+			gateway: &mockPaymentGateway{},
+		}
+
+		settler, err := payment.NewSettler(f.paymentStore, f.gateway, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.router = mux.NewRouter()
+		NewHandler(Deps{
+			ReturnStore:    f.store,
+			OrderStore:     f.orderStore,
+			Ledger:         f.ledger,
+			PaymentStore:   f.paymentStore,
+			PaymentSettler: settler,
+			UserStore:      userStore,
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(f.router)
+		return f
+	}
+
+	do := func(f fixture, user *types.User, method string, url string, payload string) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		f.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// receive walks a return of order 1 through approval and receipt.
+	receive := func(f fixture, items string, restock bool) *httptest.ResponseRecorder {
+		rr := do(f, customer, http.MethodPost, "/orders/1/returns", `{"items": `+items+`, "reason": "doesn't fit"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var created types.Return
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		rr = do(f, staff, http.MethodPost, fmt.Sprintf("/returns/%d/approve", created.ID), `{}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		return do(f, staff, http.MethodPost, fmt.Sprintf("/returns/%d/receive", created.ID), fmt.Sprintf(`{"restock": %t}`, restock))
+	}
+
+	t.Run("should let customers request a return of a delivered order", func(t *testing.T) {
+		f := setup()
+
+		rr := do(f, customer, http.MethodPost, "/orders/1/returns", `{"items": [{"orderItemID": 1, "quantity": 1}], "reason": "doesn't fit"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		if len(f.store.returns) != 1 || f.store.returns[0].Status != types.ReturnRequested || f.store.returns[0].UserID != customer.ID {
+			t.Errorf("expected a requested return, got %+v", f.store.returns)
+		}
+	})
+
+	t.Run("should only return delivered orders of the customer", func(t *testing.T) {
+		f := setup()
+
+		rr := do(f, customer, http.MethodPost, "/orders/2/returns", `{"items": [{"orderItemID": 3, "quantity": 1}], "reason": "changed my mind"}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		rr = do(f, customer, http.MethodPost, "/orders/3/returns", `{"items": [{"orderItemID": 4, "quantity": 1}], "reason": "changed my mind"}`)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should not return more units than were bought", func(t *testing.T) {
+		f := setup()
+
+		rr := do(f, customer, http.MethodPost, "/orders/1/returns", `{"items": [{"orderItemID": 1, "quantity": 3}], "reason": "doesn't fit"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should not let customers review returns", func(t *testing.T) {
+		f := setup()
+		f.store.returns = []types.Return{{ID: 1, OrderID: 1, UserID: customer.ID, Status: types.ReturnRequested}}
+
+		rr := do(f, customer, http.MethodPost, "/returns/1/approve", `{}`)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should only receive approved returns", func(t *testing.T) {
+		f := setup()
+		f.store.returns = []types.Return{{ID: 1, OrderID: 1, UserID: customer.ID, Status: types.ReturnRequested}}
+
+		rr := do(f, staff, http.MethodPost, "/returns/1/receive", `{"restock": true}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		rr = do(f, staff, http.MethodPost, "/returns/1/reject", `{"note": "worn"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		rr = do(f, staff, http.MethodPost, "/returns/1/approve", `{}`)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected a rejected return not to be approved, got %d", rr.Code)
+		}
+	})
+
+	t.Run("should restock and partially refund a received return", func(t *testing.T) {
+		f := setup()
+
+		rr := receive(f, `[{"orderItemID": 1, "quantity": 1}]`, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(f.ledger.movements) != 1 {
+			t.Fatalf("expected 1 movement, got %+v", f.ledger.movements)
+		}
+
+		if m := f.ledger.movements[0]; m.ProductID != 1 || m.Change != 1 || m.Reason != types.StockReturn || m.ActorID == nil || *m.ActorID != staff.ID {
+			t.Errorf("expected staff to restock 1 unit of product 1, got %+v", m)
+		}
+
+		if len(f.gateway.refunded) != 1 || f.gateway.refunded[0] != dollars(10) {
+			t.Errorf("expected $10 to be refunded, got %v", f.gateway.refunded)
+		}
+
+		order := f.orderStore.orders[0]
+		if order.Status != types.OrderStatusPartiallyRefunded || order.Refunded != dollars(10) {
+			t.Errorf("expected the order to be partially refunded by $10, got %s and %s", order.Status, order.Refunded)
+		}
+
+		if len(f.orderStore.refunds) != 1 || f.orderStore.refunds[0].ReturnID == nil || f.orderStore.refunds[0].PaymentID == nil {
+			t.Errorf("expected the refund to point at the return and the payment, got %+v", f.orderStore.refunds)
+		}
+
+		if f.paymentStore.payments[0].Status != types.PaymentCaptured {
+			t.Errorf("expected the payment to stay captured, got %s", f.paymentStore.payments[0].Status)
+		}
+	})
+
+	t.Run("should keep a refund the gateway failed for a retry", func(t *testing.T) {
+		f := setup()
+		f.gateway.err = fmt.Errorf("gateway unavailable")
+
+		rr := receive(f, `[{"orderItemID": 1, "quantity": 1}]`, false)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body)
+		}
+
+		order := f.orderStore.orders[0]
+		if order.Status != types.OrderStatusPartiallyRefunded || len(f.orderStore.refunds) != 1 {
+			t.Errorf("expected the refund to be recorded, got %s and %+v", order.Status, f.orderStore.refunds)
+		}
+
+		if len(f.paymentStore.operations) != 1 || f.paymentStore.operations[0].Status != types.PaymentOperationPending || f.paymentStore.operations[0].Amount != dollars(10) {
+			t.Errorf("expected the $10 refund to be left pending, got %+v", f.paymentStore.operations)
+		}
+	})
+
+	t.Run("should restock the warehouses the units were sold from", func(t *testing.T) {
+		f := setup()
+		f.ledger.movements = []types.StockMovement{
+			{ProductID: 1, WarehouseID: intPtr(2), Change: -1, Reason: types.StockSale, Reference: "order:1"},
+			{ProductID: 2, WarehouseID: intPtr(2), Change: -1, Reason: types.StockSale, Reference: "order:1"},
+			{ProductID: 1, WarehouseID: intPtr(3), Change: -1, Reason: types.StockSale, Reference: "order:1"},
+		}
+
+		rr := receive(f, `[{"orderItemID": 1, "quantity": 2}]`, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		restocked := f.ledger.movements[3:]
+		if len(restocked) != 2 {
+			t.Fatalf("expected 2 movements, got %+v", restocked)
+		}
+
+		for i, warehouseID := range []int{2, 3} {
+			if m := restocked[i]; m.ProductID != 1 || m.WarehouseID == nil || *m.WarehouseID != warehouseID || m.Change != 1 {
+				t.Errorf("expected 1 unit back in warehouse %d, got %+v", warehouseID, m)
+			}
+		}
+	})
+
+	t.Run("should refund the rest of the order with its last units", func(t *testing.T) {
+		f := setup()
+
+		if rr := receive(f, `[{"orderItemID": 1, "quantity": 1}]`, false); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		rr := receive(f, `[{"orderItemID": 1, "quantity": 1}, {"orderItemID": 2, "quantity": 1}]`, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(f.ledger.movements) != 0 {
+			t.Errorf("expected nothing to be restocked, got %+v", f.ledger.movements)
+		}
+
+		// shipping goes back with the last units
+		if len(f.gateway.refunded) != 2 || f.gateway.refunded[1] != dollars(35) {
+			t.Errorf("expected the $35 left to be refunded, got %v", f.gateway.refunded)
+		}
+
+		order := f.orderStore.orders[0]
+		if order.Status != types.OrderStatusRefunded || order.Refunded != order.Total {
+			t.Errorf("expected the order to be refunded in full, got %s and %s", order.Status, order.Refunded)
+		}
+
+		if f.paymentStore.payments[0].Status != types.PaymentRefunded {
+			t.Errorf("expected the payment to be refunded, got %s", f.paymentStore.payments[0].Status)
+		}
+	})
+
+	t.Run("should list returns by status for staff", func(t *testing.T) {
+		f := setup()
+		f.store.returns = []types.Return{
+			{ID: 1, OrderID: 1, Status: types.ReturnRequested},
+			{ID: 2, OrderID: 1, Status: types.ReturnRejected},
+		}
+
+		rr := do(f, staff, http.MethodGet, "/returns?status=requested", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var returns []types.Return
+		if err := json.NewDecoder(rr.Body).Decode(&returns); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(returns) != 1 || returns[0].ID != 1 {
+			t.Errorf("expected only the requested return, got %+v", returns)
+		}
+
+		rr = do(f, staff, http.MethodGet, "/returns?status=lost", "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+type mockReturnStore struct {
+	returns []types.Return
+}
+
+func (m *mockReturnStore) GetReturns(status types.ReturnStatus) ([]types.Return, error) {
+	returns := []types.Return{}
+	for _, r := range m.returns {
+		if status == "" || r.Status == status {
+			returns = append(returns, r)
+		}
+	}
+
+	return returns, nil
+}
+
+func (m *mockReturnStore) GetReturnsByOrderID(orderID int) ([]types.Return, error) {
+	returns := []types.Return{}
+	for _, r := range m.returns {
+		if r.OrderID == orderID {
+			returns = append(returns, r)
+		}
+	}
+
+	return returns, nil
+}
+
+func (m *mockReturnStore) GetReturn(returnID int) (*types.Return, error) {
+	for _, r := range m.returns {
+		if r.ID == returnID {
+			return &r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("return %d %w", returnID, types.ErrNotFound)
+}
+
+func (m *mockReturnStore) CreateReturn(r types.Return) (int, error) {
+	r.ID = len(m.returns) + 1
+	r.Status = types.ReturnRequested
+	m.returns = append(m.returns, r)
+	return r.ID, nil
+}
+
+func (m *mockReturnStore) UpdateReturn(r types.Return, from types.ReturnStatus) error {
+	for i := range m.returns {
+		if m.returns[i].ID != r.ID {
+			continue
+		}
+
+		if m.returns[i].Status != from {
+			return fmt.Errorf("return %d is no longer %s: %w", r.ID, from, types.ErrConflict)
+		}
+
+		m.returns[i] = r
+		return nil
+	}
+
+	return fmt.Errorf("return %d %w", r.ID, types.ErrNotFound)
+}
+
+func (m *mockReturnStore) WithTx(tx *sql.Tx) types.ReturnStore {
+	return m
+}
+
+// newAuthenticatedRequest builds a request carrying a JWT for user.
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", token)
+	return req
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) CreateUser(u types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) UpdateUserRole(id int, role types.Role) error {
+	return nil
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+type mockOrderStore struct {
+	orders  []types.Order
+	items   map[int][]types.OrderItemDetail
+	history []types.OrderStatusChange
+	refunds []types.Refund
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	orders := []types.Order{}
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		if m.orders[i].UserID == userID {
+			orders = append(orders, m.orders[i])
+		}
+	}
+
+	total := len(orders)
+	if offset > total {
+		offset = total
+	}
+
+	return orders[offset:min(offset+limit, total)], total, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID && o.UserID == userID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrder(orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	return m.items[orderID], nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	for i := range m.orders {
+		if m.orders[i].ID != orderID {
+			continue
+		}
+
+		if m.orders[i].Status != from {
+			return fmt.Errorf("order %d is no longer %s: %w", orderID, from, types.ErrConflict)
+		}
+
+		m.orders[i].Status = to
+		return nil
+	}
+
+	return fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	for i := range m.orders {
+		if m.orders[i].ID == orderID {
+			m.orders[i].CancelledBy = cancelledBy
+			m.orders[i].CancelReason = reason
+		}
+	}
+
+	return nil
+}
+
+func (m *mockOrderStore) CreateStatusChange(change types.OrderStatusChange) error {
+	m.history = append(m.history, change)
+	return nil
+}
+
+func (m *mockOrderStore) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	history := []types.OrderStatusChange{}
+	for _, change := range m.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+
+	return history, nil
+}
+
+func (m *mockOrderStore) RecordRefund(refund types.Refund) (int, error) {
+	refund.ID = len(m.refunds) + 1
+	m.refunds = append(m.refunds, refund)
+
+	for i := range m.orders {
+		if m.orders[i].ID == refund.OrderID {
+			m.orders[i].Refunded = m.orders[i].Refunded.Add(refund.Amount)
+		}
+	}
+
+	return refund.ID, nil
+}
+
+func (m *mockOrderStore) GetRefunds(orderID int) ([]types.Refund, error) {
+	refunds := []types.Refund{}
+	for _, refund := range m.refunds {
+		if refund.OrderID == orderID {
+			refunds = append(refunds, refund)
+		}
+	}
+
+	return refunds, nil
+}
+
+func (m *mockOrderStore) WithTx(tx *sql.Tx) types.OrderStore {
+	return m
+}
+
+// mockLedger keeps the movements recorded, in order.
+type mockLedger struct {
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+type mockPaymentStore struct {
+	payments   []types.Payment
+	operations []types.PaymentOperation
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	for _, p := range m.payments {
+		if p.ID == paymentID {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) CreatePayment(p types.Payment) (int, error) {
+	return 0, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	for i := range m.payments {
+		if m.payments[i].ID == paymentID {
+			m.payments[i].Status = status
+		}
+	}
+
+	return nil
+}
+
+func (m *mockPaymentStore) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	op.ID = len(m.operations) + 1
+	op.Status = types.PaymentOperationPending
+	m.operations = append(m.operations, op)
+	return op.ID, nil
+}
+
+func (m *mockPaymentStore) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	ops := []types.PaymentOperation{}
+	for _, op := range m.operations {
+		if op.PaymentID == paymentID && op.Status == types.PaymentOperationPending {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops, nil
+}
+
+func (m *mockPaymentStore) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *mockPaymentStore) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	m.operations[op.ID-1].Attempts++
+	return true, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	m.operations[operationID-1].Status = status
+	m.operations[operationID-1].Error = errMsg
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx *sql.Tx) types.PaymentStore {
+	return m
+}
+
+// mockPaymentGateway keeps track of what was done with the payments, or
+// fails every call with err.
+type mockPaymentGateway struct {
+	err      error
+	captured []string
+	voided   []string
+	refunded []types.Money
+}
+
+func (m *mockPaymentGateway) Name() string {
+	return "mock"
+}
+
+func (m *mockPaymentGateway) Authorize(ctx context.Context, orderID int, amount types.Money) (string, error) {
+	return "", m.err
+}
+
+func (m *mockPaymentGateway) Capture(ctx context.Context, reference string, amount types.Money) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.captured = append(m.captured, reference)
+	return nil
+}
+
+func (m *mockPaymentGateway) Refund(ctx context.Context, reference string, amount types.Money) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.refunded = append(m.refunded, amount)
+	return nil
+}
+
+func (m *mockPaymentGateway) Void(ctx context.Context, reference string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.voided = append(m.voided, reference)
+	return nil
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
@@ -0,0 +1,181 @@
+package returns
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ReturnStore {
+	return &Store{db: tx}
+}
+
+// returnColumns lists the columns read by scanRowsIntoReturn, in order.
+const returnColumns = "id, orderId, userId, status, reason, note, restocked, createdAt, updatedAt"
+
+func (s *Store) GetReturns(status types.ReturnStatus) ([]types.Return, error) {
+	if status == "" {
+		return s.getReturns("SELECT " + returnColumns + " FROM returns ORDER BY id")
+	}
+
+	return s.getReturns("SELECT "+returnColumns+" FROM returns WHERE status = ? ORDER BY id", status)
+}
+
+func (s *Store) GetReturnsByOrderID(orderID int) ([]types.Return, error) {
+	return s.getReturns("SELECT "+returnColumns+" FROM returns WHERE orderId = ? ORDER BY id", orderID)
+}
+
+func (s *Store) GetReturn(returnID int) (*types.Return, error) {
+	returns, err := s.getReturns("SELECT "+returnColumns+" FROM returns WHERE id = ?", returnID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(returns) == 0 {
+		return nil, fmt.Errorf("return %d %w", returnID, types.ErrNotFound)
+	}
+
+	return &returns[0], nil
+}
+
+// getReturns runs a query selecting returnColumns and fills in the items of
+// every return it returns.
+func (s *Store) getReturns(query string, args ...any) ([]types.Return, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := make([]types.Return, 0)
+	for rows.Next() {
+		r, err := scanRowsIntoReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		returns = append(returns, *r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(returns) == 0 {
+		return returns, nil
+	}
+
+	index := make(map[int]*types.Return, len(returns))
+	ids := make([]any, len(returns))
+	for i := range returns {
+		returns[i].Items = []types.ReturnItem{}
+		index[returns[i].ID] = &returns[i]
+		ids[i] = returns[i].ID
+	}
+
+	itemRows, err := s.db.Query(
+		"SELECT returnId, orderItemId, quantity FROM return_items WHERE returnId IN (?"+strings.Repeat(",?", len(ids)-1)+") ORDER BY orderItemId",
+		ids...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var returnID int
+		var item types.ReturnItem
+		if err := itemRows.Scan(&returnID, &item.OrderItemID, &item.Quantity); err != nil {
+			return nil, err
+		}
+
+		r := index[returnID]
+		r.Items = append(r.Items, item)
+	}
+
+	return returns, itemRows.Err()
+}
+
+func (s *Store) CreateReturn(r types.Return) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO returns (orderId, userId, status, reason) VALUES (?, ?, ?, ?)",
+		r.OrderID, r.UserID, types.ReturnRequested, r.Reason,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]any, 0, len(r.Items)*3)
+	for _, item := range r.Items {
+		args = append(args, id, item.OrderItemID, item.Quantity)
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO return_items (returnId, orderItemId, quantity) VALUES (?, ?, ?)"+strings.Repeat(", (?, ?, ?)", len(r.Items)-1),
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateReturn(r types.Return, from types.ReturnStatus) error {
+	res, err := s.db.Exec(
+		"UPDATE returns SET status = ?, note = ?, restocked = ? WHERE id = ? AND status = ?",
+		r.Status, r.Note, r.Restocked, r.ID, from,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// another request already moved the return along
+	if affected == 0 {
+		return fmt.Errorf("return %d is no longer %s: %w", r.ID, from, types.ErrConflict)
+	}
+
+	return nil
+}
+
+func scanRowsIntoReturn(rows *sql.Rows) (*types.Return, error) {
+	r := new(types.Return)
+
+	err := rows.Scan(
+		&r.ID,
+		&r.OrderID,
+		&r.UserID,
+		&r.Status,
+		&r.Reason,
+		&r.Note,
+		&r.Restocked,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
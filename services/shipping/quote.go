@@ -0,0 +1,66 @@
+package shipping
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// ShipsTo reports whether m is active and ships to the address.
+func ShipsTo(m types.ShippingMethod, to types.PostalAddress) bool {
+	if !m.Active {
+		return false
+	}
+
+	return len(m.Countries) == 0 || slices.Contains(m.Countries, strings.ToUpper(to.Country))
+}
+
+// Quote works out what shipping an order with m costs, given the subtotal of
+// the order and its weight in grams. The cost is in the currency of
+// subtotal, into which rates convert the amounts of m.
+func Quote(m types.ShippingMethod, subtotal types.Money, weight int, rates types.ExchangeRates) (types.Money, error) {
+	cost, _, err := rates.Convert(m.Cost, subtotal.Currency)
+	if err != nil {
+		return types.Money{}, err
+	}
+
+	switch m.Type {
+	case types.ShippingFlatRate:
+		return cost, nil
+
+	case types.ShippingWeightBased:
+		if m.CostPerKg == nil {
+			return types.Money{}, fmt.Errorf("shipping method %s has no cost per kilogram", m.Code)
+		}
+
+		perKg, _, err := rates.Convert(*m.CostPerKg, subtotal.Currency)
+		if err != nil {
+			return types.Money{}, err
+		}
+
+		// every started kilogram is charged in full
+		kilograms := (weight + 999) / 1000
+		return cost.Add(perKg.Mul(kilograms)), nil
+
+	case types.ShippingFreeOverThreshold:
+		if m.FreeOver == nil {
+			return types.Money{}, fmt.Errorf("shipping method %s has no threshold", m.Code)
+		}
+
+		threshold, _, err := rates.Convert(*m.FreeOver, subtotal.Currency)
+		if err != nil {
+			return types.Money{}, err
+		}
+
+		if subtotal.Cmp(threshold) >= 0 {
+			return types.NewMoney(0, subtotal.Currency), nil
+		}
+
+		return cost, nil
+
+	default:
+		return types.Money{}, fmt.Errorf("shipping method %s has an unknown type %q", m.Code, m.Type)
+	}
+}
@@ -0,0 +1,60 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestQuote(t *testing.T) {
+	usd := func(cents int64) types.Money { return types.NewMoney(cents, types.DefaultCurrency) }
+	perKg, freeOver := usd(150), usd(5000)
+	rates := types.ExchangeRates{"EUR": 50_000_000}
+
+	for _, tc := range []struct {
+		name     string
+		method   types.ShippingMethod
+		subtotal types.Money
+		weight   int
+		want     types.Money
+	}{
+		{"flat rate", types.ShippingMethod{Type: types.ShippingFlatRate, Cost: usd(500)}, usd(1000), 0, usd(500)},
+		{"flat rate converted", types.ShippingMethod{Type: types.ShippingFlatRate, Cost: usd(500)}, types.NewMoney(1000, "EUR"), 0, types.NewMoney(250, "EUR")},
+		{"weightless", types.ShippingMethod{Type: types.ShippingWeightBased, Cost: usd(500), CostPerKg: &perKg}, usd(1000), 0, usd(500)},
+		{"exact kilograms", types.ShippingMethod{Type: types.ShippingWeightBased, Cost: usd(500), CostPerKg: &perKg}, usd(1000), 2000, usd(800)},
+		{"started kilogram", types.ShippingMethod{Type: types.ShippingWeightBased, Cost: usd(500), CostPerKg: &perKg}, usd(1000), 2001, usd(950)},
+		{"under the threshold", types.ShippingMethod{Type: types.ShippingFreeOverThreshold, Cost: usd(500), FreeOver: &freeOver}, usd(4999), 0, usd(500)},
+		{"at the threshold", types.ShippingMethod{Type: types.ShippingFreeOverThreshold, Cost: usd(500), FreeOver: &freeOver}, usd(5000), 0, usd(0)},
+	} {
+		got, err := Quote(tc.method, tc.subtotal, tc.weight, rates)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestShipsTo(t *testing.T) {
+	everywhere := types.ShippingMethod{Active: true}
+	uk := types.ShippingMethod{Countries: []string{"GB"}, Active: true}
+	inactive := types.ShippingMethod{}
+
+	for _, tc := range []struct {
+		method types.ShippingMethod
+		to     string
+		want   bool
+	}{
+		{everywhere, "FR", true},
+		{uk, "gb", true},
+		{uk, "FR", false},
+		{inactive, "FR", false},
+	} {
+		if got := ShipsTo(tc.method, types.PostalAddress{Country: tc.to}); got != tc.want {
+			t.Errorf("ShipsTo(%+v, %s) = %v, want %v", tc.method, tc.to, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package shipping
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.ShippingMethodStore
+	rateStore  types.ExchangeRateStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.ShippingMethodStore, rateStore types.ExchangeRateStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, rateStore: rateStore, userStore: userStore, transactor: transactor}
+}
+
+// RegisterRoutes only registers admin routes: customers get the methods that
+// ship to them, with their cost, from GET /cart/shipping-quotes.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/shipping-methods", auth.WithJWTAuth(auth.RequireRole(h.handleGetMethods, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/shipping-methods/{methodID}", auth.WithJWTAuth(auth.RequireRole(h.handleGetMethod, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/shipping-methods", auth.WithJWTAuth(auth.RequireRole(h.handleCreateMethod, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/shipping-methods/{methodID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateMethod, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/shipping-methods/{methodID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteMethod, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetMethods(w http.ResponseWriter, r *http.Request) {
+	methods, err := h.store.GetShippingMethods()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, methods)
+}
+
+func (h *Handler) handleGetMethod(w http.ResponseWriter, r *http.Request) {
+	methodID, err := getMethodIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	method, err := h.store.GetShippingMethodByID(methodID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, method)
+}
+
+func (h *Handler) handleCreateMethod(w http.ResponseWriter, r *http.Request) {
+	payload, err := parseMethodPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var method *types.ShippingMethod
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		m := methodFromPayload(payload)
+		if err := h.checkMethod(tx, m, 0); err != nil {
+			return err
+		}
+
+		methodID, err := store.CreateShippingMethod(m)
+		if err != nil {
+			return err
+		}
+
+		method, err = store.GetShippingMethodByID(methodID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, method)
+}
+
+func (h *Handler) handleUpdateMethod(w http.ResponseWriter, r *http.Request) {
+	methodID, err := getMethodIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload, err := parseMethodPayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var method *types.ShippingMethod
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := store.GetShippingMethodByID(methodID); err != nil {
+			return err
+		}
+
+		m := methodFromPayload(payload)
+		m.ID = methodID
+		if err := h.checkMethod(tx, m, methodID); err != nil {
+			return err
+		}
+
+		if err := store.UpdateShippingMethod(m); err != nil {
+			return err
+		}
+
+		method, err = store.GetShippingMethodByID(methodID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, method)
+}
+
+func (h *Handler) handleDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	methodID, err := getMethodIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteShippingMethod(methodID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errInvalidMethod reports a method that is well formed but can't be
+// stored, such as one priced in a currency with no exchange rate.
+type errInvalidMethod struct{ error }
+
+// checkMethod makes sure the code of m isn't taken by another method than
+// methodID and that its amounts can be converted.
+func (h *Handler) checkMethod(tx *sql.Tx, m types.ShippingMethod, methodID int) error {
+	existing, err := h.store.WithTx(tx).GetShippingMethodByCode(m.Code)
+	if err == nil && existing.ID != methodID {
+		return fmt.Errorf("shipping method code %s is already taken: %w", m.Code, types.ErrConflict)
+	}
+	if err != nil && !errors.Is(err, types.ErrNotFound) {
+		return err
+	}
+
+	for _, amount := range []*types.Money{m.CostPerKg, m.FreeOver} {
+		if amount != nil && amount.Currency != m.Cost.Currency {
+			return errInvalidMethod{fmt.Errorf("every amount of a shipping method must be in %s, the currency of its cost", m.Cost.Currency)}
+		}
+	}
+
+	rates, err := h.rateStore.WithTx(tx).GetExchangeRates()
+	if err != nil {
+		return err
+	}
+
+	if _, err := types.NewExchangeRates(rates).CrossRate(m.Cost.Currency, types.DefaultCurrency); err != nil {
+		return errInvalidMethod{err}
+	}
+
+	return nil
+}
+
+func parseMethodPayload(r *http.Request) (types.ShippingMethodPayload, error) {
+	var payload types.ShippingMethodPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return payload, fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return payload, nil
+}
+
+// methodFromPayload only keeps the amounts that make sense for the type of
+// method, and stores countries in upper case.
+func methodFromPayload(payload types.ShippingMethodPayload) types.ShippingMethod {
+	m := types.ShippingMethod{
+		Code:      payload.Code,
+		Name:      payload.Name,
+		Type:      payload.Type,
+		Cost:      payload.Cost,
+		Countries: make([]string, len(payload.Countries)),
+		Active:    payload.Active == nil || *payload.Active,
+	}
+
+	for i, country := range payload.Countries {
+		m.Countries[i] = strings.ToUpper(country)
+	}
+
+	switch payload.Type {
+	case types.ShippingWeightBased:
+		m.CostPerKg = payload.CostPerKg
+	case types.ShippingFreeOverThreshold:
+		m.FreeOver = payload.FreeOver
+	}
+
+	return m
+}
+
+func getMethodIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["methodID"]
+	if !ok {
+		return 0, fmt.Errorf("missing shipping method ID")
+	}
+
+	methodID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shipping method ID")
+	}
+
+	return methodID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalidMethod errInvalidMethod
+	switch {
+	case errors.As(err, &invalidMethod):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,299 @@
+package shipping
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+func TestShippingMethodHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockShippingStore) {
+		store := newMockShippingStore(types.ShippingMethod{ID: 1, Code: "standard", Name: "Standard", Type: types.ShippingFlatRate, Cost: types.NewMoney(500, "USD"), Active: true})
+		rates := &mockExchangeRateStore{rates: types.ExchangeRates{"EUR": 92_000_000}}
+
+		router := mux.NewRouter()
+		NewHandler(store, rates, newMockUserStore(customer, staff), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should only let staff see and change methods", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodGet, "/shipping-methods", "", customer),
+			send(router, http.MethodPost, "/shipping-methods", `{"code": "express", "name": "Express", "type": "flat_rate", "cost": {"amount": 900, "currency": "USD"}}`, customer),
+			send(router, http.MethodDelete, "/shipping-methods/1", "", nil),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should create a method", func(t *testing.T) {
+		router, store := newRouter()
+
+		payload := `{"code": "express", "name": "Express", "type": "weight_based", "cost": {"amount": 900, "currency": "EUR"}, "costPerKg": {"amount": 150, "currency": "EUR"}, "countries": ["fr", "de"]}`
+		rr := send(router, http.MethodPost, "/shipping-methods", payload, staff)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var method types.ShippingMethod
+		if err := json.NewDecoder(rr.Body).Decode(&method); err != nil {
+			t.Fatal(err)
+		}
+
+		if !method.Active || method.CostPerKg == nil || strings.Join(method.Countries, ",") != "FR,DE" {
+			t.Errorf("unexpected method %+v", method)
+		}
+
+		if _, err := store.GetShippingMethodByCode("express"); err != nil {
+			t.Errorf("expected the method to be stored: %v", err)
+		}
+	})
+
+	t.Run("should create a free flat rate method", func(t *testing.T) {
+		router, _ := newRouter()
+
+		payload := `{"code": "pickup", "name": "Store pickup", "type": "flat_rate", "cost": {"amount": 0, "currency": "USD"}}`
+		if rr := send(router, http.MethodPost, "/shipping-methods", payload, staff); rr.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should reject invalid methods", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for name, payload := range map[string]string{
+			"no cost":               `{"code": "x", "name": "X", "type": "flat_rate"}`,
+			"negative cost":         `{"code": "x", "name": "X", "type": "flat_rate", "cost": {"amount": -100, "currency": "USD"}}`,
+			"zero threshold":        `{"code": "x", "name": "X", "type": "free_over_threshold", "cost": {"amount": 900, "currency": "USD"}, "freeOver": {"amount": 0, "currency": "USD"}}`,
+			"missing cost per kg":   `{"code": "x", "name": "X", "type": "weight_based", "cost": {"amount": 900, "currency": "USD"}}`,
+			"missing threshold":     `{"code": "x", "name": "X", "type": "free_over_threshold", "cost": {"amount": 900, "currency": "USD"}}`,
+			"mixed currencies":      `{"code": "x", "name": "X", "type": "free_over_threshold", "cost": {"amount": 900, "currency": "USD"}, "freeOver": {"amount": 5000, "currency": "EUR"}}`,
+			"currency with no rate": `{"code": "x", "name": "X", "type": "flat_rate", "cost": {"amount": 900, "currency": "GBP"}}`,
+			"bad country":           `{"code": "x", "name": "X", "type": "flat_rate", "cost": {"amount": 900, "currency": "USD"}, "countries": ["FRA"]}`,
+		} {
+			if rr := send(router, http.MethodPost, "/shipping-methods", payload, staff); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should not reuse a code", func(t *testing.T) {
+		router, _ := newRouter()
+
+		payload := `{"code": "standard", "name": "Other", "type": "flat_rate", "cost": {"amount": 900, "currency": "USD"}}`
+		if rr := send(router, http.MethodPost, "/shipping-methods", payload, staff); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if rr := send(router, http.MethodPut, "/shipping-methods/1", payload, staff); rr.Code != http.StatusOK {
+			t.Errorf("expected a method to keep its own code, got %d: %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("should deactivate and delete a method", func(t *testing.T) {
+		router, store := newRouter()
+
+		payload := `{"code": "standard", "name": "Standard", "type": "flat_rate", "cost": {"amount": 500, "currency": "USD"}, "active": false}`
+		if rr := send(router, http.MethodPut, "/shipping-methods/1", payload, staff); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if store.methods[1].Active {
+			t.Errorf("expected the method to be inactive")
+		}
+
+		if rr := send(router, http.MethodDelete, "/shipping-methods/1", "", staff); rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if rr := send(router, http.MethodGet, "/shipping-methods/1", "", staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+type mockShippingStore struct {
+	methods map[int]*types.ShippingMethod
+	nextID  int
+}
+
+func newMockShippingStore(methods ...types.ShippingMethod) *mockShippingStore {
+	m := &mockShippingStore{methods: map[int]*types.ShippingMethod{}}
+	for _, method := range methods {
+		m.methods[method.ID] = &method
+		m.nextID = max(m.nextID, method.ID)
+	}
+
+	return m
+}
+
+func (m *mockShippingStore) GetShippingMethods() ([]types.ShippingMethod, error) {
+	methods := []types.ShippingMethod{}
+	for _, method := range m.methods {
+		methods = append(methods, *method)
+	}
+
+	return methods, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByID(methodID int) (*types.ShippingMethod, error) {
+	method, ok := m.methods[methodID]
+	if !ok {
+		return nil, fmt.Errorf("shipping method %d %w", methodID, types.ErrNotFound)
+	}
+
+	found := *method
+	return &found, nil
+}
+
+func (m *mockShippingStore) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	for _, method := range m.methods {
+		if strings.EqualFold(method.Code, code) {
+			found := *method
+			return &found, nil
+		}
+	}
+
+	return nil, fmt.Errorf("shipping method %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockShippingStore) CreateShippingMethod(method types.ShippingMethod) (int, error) {
+	m.nextID++
+	method.ID = m.nextID
+	m.methods[method.ID] = &method
+	return method.ID, nil
+}
+
+func (m *mockShippingStore) UpdateShippingMethod(method types.ShippingMethod) error {
+	m.methods[method.ID] = &method
+	return nil
+}
+
+func (m *mockShippingStore) DeleteShippingMethod(methodID int) error {
+	if _, ok := m.methods[methodID]; !ok {
+		return fmt.Errorf("shipping method %d %w", methodID, types.ErrNotFound)
+	}
+
+	delete(m.methods, methodID)
+	return nil
+}
+
+func (m *mockShippingStore) WithTx(tx *sql.Tx) types.ShippingMethodStore {
+	return m
+}
+
+type mockExchangeRateStore struct {
+	rates types.ExchangeRates
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
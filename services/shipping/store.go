@@ -0,0 +1,241 @@
+package shipping
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.ShippingMethodStore {
+	return &Store{db: tx}
+}
+
+// methodColumns lists the columns read by scanRowsIntoMethod, in order.
+const methodColumns = "id, code, name, type, currency, cost, costPerKg, freeOver, active, createdAt"
+
+func (s *Store) GetShippingMethods() ([]types.ShippingMethod, error) {
+	return s.getMethods("SELECT " + methodColumns + " FROM shipping_methods ORDER BY name, id")
+}
+
+func (s *Store) GetShippingMethodByID(methodID int) (*types.ShippingMethod, error) {
+	methods, err := s.getMethods("SELECT "+methodColumns+" FROM shipping_methods WHERE id = ?", methodID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("shipping method %d %w", methodID, types.ErrNotFound)
+	}
+
+	return &methods[0], nil
+}
+
+func (s *Store) GetShippingMethodByCode(code string) (*types.ShippingMethod, error) {
+	methods, err := s.getMethods("SELECT "+methodColumns+" FROM shipping_methods WHERE code = ?", code)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("shipping method %s %w", code, types.ErrNotFound)
+	}
+
+	return &methods[0], nil
+}
+
+// getMethods runs a query selecting methodColumns and fills in the countries
+// of every method it returns.
+func (s *Store) getMethods(query string, args ...any) ([]types.ShippingMethod, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := make([]types.ShippingMethod, 0)
+	for rows.Next() {
+		m, err := scanRowsIntoMethod(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		methods = append(methods, *m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(methods) == 0 {
+		return methods, nil
+	}
+
+	index := make(map[int]*types.ShippingMethod, len(methods))
+	ids := make([]any, len(methods))
+	for i := range methods {
+		index[methods[i].ID] = &methods[i]
+		ids[i] = methods[i].ID
+	}
+
+	countries, err := s.db.Query(
+		"SELECT methodId, country FROM shipping_method_countries WHERE methodId IN (?"+strings.Repeat(",?", len(ids)-1)+") ORDER BY country",
+		ids...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer countries.Close()
+
+	for countries.Next() {
+		var methodID int
+		var country string
+		if err := countries.Scan(&methodID, &country); err != nil {
+			return nil, err
+		}
+
+		m := index[methodID]
+		m.Countries = append(m.Countries, country)
+	}
+
+	return methods, countries.Err()
+}
+
+func (s *Store) CreateShippingMethod(m types.ShippingMethod) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO shipping_methods (code, name, type, currency, cost, costPerKg, freeOver, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		m.Code, m.Name, m.Type, m.Cost.Currency, m.Cost, m.CostPerKg, m.FreeOver, m.Active,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.setCountries(int(id), m.Countries); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateShippingMethod(m types.ShippingMethod) error {
+	res, err := s.db.Exec(
+		"UPDATE shipping_methods SET code = ?, name = ?, type = ?, currency = ?, cost = ?, costPerKg = ?, freeOver = ?, active = ? WHERE id = ?",
+		m.Code, m.Name, m.Type, m.Cost.Currency, m.Cost, m.CostPerKg, m.FreeOver, m.Active, m.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// an update that changes nothing also affects no rows, so check the
+	// method exists before treating it as missing
+	if affected == 0 {
+		if _, err := s.GetShippingMethodByID(m.ID); err != nil {
+			return err
+		}
+	}
+
+	return s.setCountries(m.ID, m.Countries)
+}
+
+// setCountries replaces the countries the method ships to.
+func (s *Store) setCountries(methodID int, countries []string) error {
+	if _, err := s.db.Exec("DELETE FROM shipping_method_countries WHERE methodId = ?", methodID); err != nil {
+		return err
+	}
+
+	if len(countries) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(countries)*2)
+	for _, country := range countries {
+		args = append(args, methodID, country)
+	}
+
+	_, err := s.db.Exec(
+		"INSERT IGNORE INTO shipping_method_countries (methodId, country) VALUES (?, ?)"+strings.Repeat(", (?, ?)", len(countries)-1),
+		args...,
+	)
+	return err
+}
+
+// DeleteShippingMethod deletes a method. Orders shipped with it keep its
+// name and cost.
+func (s *Store) DeleteShippingMethod(methodID int) error {
+	res, err := s.db.Exec("DELETE FROM shipping_methods WHERE id = ?", methodID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("shipping method %d %w", methodID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+func scanRowsIntoMethod(rows *sql.Rows) (*types.ShippingMethod, error) {
+	m := new(types.ShippingMethod)
+
+	var costPerKg, freeOver sql.NullString
+	err := rows.Scan(
+		&m.ID,
+		&m.Code,
+		&m.Name,
+		&m.Type,
+		&m.Cost.Currency,
+		&m.Cost,
+		&costPerKg,
+		&freeOver,
+		&m.Active,
+		&m.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Countries = []string{}
+
+	for _, amount := range []struct {
+		dst **types.Money
+		src sql.NullString
+	}{{&m.CostPerKg, costPerKg}, {&m.FreeOver, freeOver}} {
+		if !amount.src.Valid {
+			continue
+		}
+
+		money, err := types.ParseMoney(amount.src.String, m.Cost.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		*amount.dst = &money
+	}
+
+	return m, nil
+}
@@ -0,0 +1,84 @@
+package tax
+
+import (
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// Table implements types.TaxCalculator on a fixed set of rates, keyed by
+// destination and tax class. Lines no rate covers are charged no tax.
+type Table struct {
+	rates map[key]types.Rate
+}
+
+type key struct {
+	country  string
+	region   string
+	taxClass string
+}
+
+func NewTable(rates []types.TaxRate) *Table {
+	t := &Table{rates: make(map[key]types.Rate, len(rates))}
+	for _, r := range rates {
+		t.rates[newKey(r.Country, r.Region, r.TaxClass)] = r.Rate
+	}
+
+	return t
+}
+
+// newKey ignores the case of countries and regions, as addresses are typed
+// in by customers.
+func newKey(country string, region string, taxClass string) key {
+	if taxClass == "" {
+		taxClass = types.DefaultTaxClass
+	}
+
+	return key{
+		country:  strings.ToUpper(strings.TrimSpace(country)),
+		region:   strings.ToUpper(strings.TrimSpace(region)),
+		taxClass: taxClass,
+	}
+}
+
+// Rate returns the rate of taxClass at the address: the one of its region
+// if there is one, else the one of its country, else zero.
+func (t *Table) Rate(to types.PostalAddress, taxClass string) types.Rate {
+	if to.State != "" {
+		if rate, ok := t.rates[newKey(to.Country, to.State, taxClass)]; ok {
+			return rate
+		}
+	}
+
+	return t.rates[newKey(to.Country, "", taxClass)]
+}
+
+func (t *Table) Calculate(to types.PostalAddress, lines []types.TaxLine) ([]types.Money, error) {
+	taxes := make([]types.Money, len(lines))
+	for i, line := range lines {
+		// a tax rate turns an amount into the tax on it the same way an
+		// exchange rate turns it into another currency, rounding included
+		taxes[i] = line.Amount.Convert(line.Amount.Currency, t.Rate(to, line.TaxClass))
+	}
+
+	return taxes, nil
+}
+
+// Calculator implements types.TaxCalculator with the rates in the store. It
+// reads them for every order, so new rates apply right away.
+type Calculator struct {
+	store types.TaxRateStore
+}
+
+func NewCalculator(store types.TaxRateStore) *Calculator {
+	return &Calculator{store: store}
+}
+
+func (c *Calculator) Calculate(to types.PostalAddress, lines []types.TaxLine) ([]types.Money, error) {
+	rates, err := c.store.GetTaxRates()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTable(rates).Calculate(to, lines)
+}
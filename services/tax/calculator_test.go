@@ -0,0 +1,51 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+func TestTable(t *testing.T) {
+	table := NewTable([]types.TaxRate{
+		{Country: "US", TaxClass: types.DefaultTaxClass, Rate: 4_000_000},
+		{Country: "US", Region: "NY", TaxClass: types.DefaultTaxClass, Rate: 8_875_000},
+		{Country: "GB", TaxClass: types.DefaultTaxClass, Rate: 20_000_000},
+		{Country: "GB", TaxClass: "reduced", Rate: 5_000_000},
+	})
+
+	for _, tc := range []struct {
+		name     string
+		to       types.PostalAddress
+		taxClass string
+		want     types.Rate
+	}{
+		{"region first", types.PostalAddress{Country: "US", State: "ny"}, "standard", 8_875_000},
+		{"country otherwise", types.PostalAddress{Country: "US", State: "CA"}, "standard", 4_000_000},
+		{"default class", types.PostalAddress{Country: "GB"}, "", 20_000_000},
+		{"other class", types.PostalAddress{Country: "gb"}, "reduced", 5_000_000},
+		{"no rate", types.PostalAddress{Country: "FR"}, "standard", 0},
+	} {
+		if got := table.Rate(tc.to, tc.taxClass); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+
+	taxes, err := table.Calculate(types.PostalAddress{Country: "US", State: "NY"}, []types.TaxLine{
+		{TaxClass: "standard", Amount: types.NewMoney(1000, "USD")},
+		{TaxClass: "reduced", Amount: types.NewMoney(1000, "USD")},
+		{TaxClass: "standard", Amount: types.NewMoney(1000, "JPY")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8.875% of 10.00 is 0.8875, rounded half away from zero; there is no
+	// reduced rate in New York
+	want := []types.Money{types.NewMoney(89, "USD"), types.NewMoney(0, "USD"), types.NewMoney(89, "JPY")}
+	for i := range want {
+		if taxes[i] != want[i] {
+			t.Errorf("line %d: expected %s, got %s", i, want[i], taxes[i])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package tax
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.TaxRateStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.TaxRateStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/tax-rates", h.handleGetTaxRates).Methods(http.MethodGet)
+
+	// admin routes
+	router.HandleFunc("/tax-rates", auth.WithJWTAuth(auth.RequireRole(h.handleSetTaxRates, types.RoleAdmin), h.userStore)).Methods(http.MethodPut)
+}
+
+func (h *Handler) handleGetTaxRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.store.GetTaxRates()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+// handleSetTaxRates replaces the whole tax table with the one in the
+// payload, so rates left out are removed.
+func (h *Handler) handleSetTaxRates(w http.ResponseWriter, r *http.Request) {
+	var payload types.TaxRatesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	rates, err := ratesFromPayload(payload)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if err := store.SetTaxRates(rates); err != nil {
+			return err
+		}
+
+		var err error
+		rates, err = store.GetTaxRates()
+		return err
+	})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, rates)
+}
+
+// ratesFromPayload stores countries and regions in upper case, the way the
+// calculator looks them up, and refuses two rates for the same key.
+func ratesFromPayload(payload types.TaxRatesPayload) ([]types.TaxRate, error) {
+	rates := make([]types.TaxRate, 0, len(payload.Rates))
+	seen := map[key]bool{}
+
+	for _, p := range payload.Rates {
+		k := newKey(p.Country, p.Region, p.TaxClass)
+		if seen[k] {
+			return nil, fmt.Errorf("more than one %s rate for %s", p.TaxClass, strings.TrimSuffix(k.country+" "+k.region, " "))
+		}
+		seen[k] = true
+
+		rates = append(rates, types.TaxRate{Country: k.country, Region: k.region, TaxClass: k.taxClass, Rate: p.Rate})
+	}
+
+	return rates, nil
+}
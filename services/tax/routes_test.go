@@ -0,0 +1,184 @@
+package tax
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	staff = &types.User{ID: 2, Role: types.RoleStaff}
+	admin = &types.User{ID: 3, Role: types.RoleAdmin}
+)
+
+func TestTaxRateHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockTaxRateStore) {
+		store := &mockTaxRateStore{rates: []types.TaxRate{
+			{Country: "GB", TaxClass: types.DefaultTaxClass, Rate: 20_000_000},
+		}}
+
+		router := mux.NewRouter()
+		NewHandler(store, newMockUserStore(staff, admin), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should list the rates", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := send(router, http.MethodGet, "/tax-rates", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var rates []types.TaxRate
+		if err := json.NewDecoder(rr.Body).Decode(&rates); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rates) != 1 || rates[0].Country != "GB" || rates[0].Rate != 20_000_000 {
+			t.Errorf("expected the GB rate, got %+v", rates)
+		}
+	})
+
+	t.Run("should only let admins replace the rates", func(t *testing.T) {
+		router, _ := newRouter()
+
+		if rr := send(router, http.MethodPut, "/tax-rates", `{"rates": []}`, staff); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should replace the whole table", func(t *testing.T) {
+		router, store := newRouter()
+
+		payload := `{"rates": [
+			{"country": "us", "region": "ny", "taxClass": "standard", "rate": "0.08875"},
+			{"country": "US", "taxClass": "standard", "rate": "0.04"}
+		]}`
+		rr := send(router, http.MethodPut, "/tax-rates", payload, admin)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		if len(store.rates) != 2 || store.rates[0].Country != "US" || store.rates[0].Region != "NY" || store.rates[0].Rate != 8_875_000 {
+			t.Errorf("expected only the two US rates, got %+v", store.rates)
+		}
+	})
+
+	t.Run("should reject invalid tables", func(t *testing.T) {
+		router, store := newRouter()
+
+		for name, payload := range map[string]string{
+			"over 100%":      `{"rates": [{"country": "US", "taxClass": "standard", "rate": "1.5"}]}`,
+			"bad country":    `{"rates": [{"country": "USA", "taxClass": "standard", "rate": "0.1"}]}`,
+			"no tax class":   `{"rates": [{"country": "US", "rate": "0.1"}]}`,
+			"duplicate rate": `{"rates": [{"country": "US", "taxClass": "standard", "rate": "0.1"}, {"country": "us", "taxClass": "standard", "rate": "0.2"}]}`,
+		} {
+			if rr := send(router, http.MethodPut, "/tax-rates", payload, admin); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", name, http.StatusBadRequest, rr.Code)
+			}
+		}
+
+		if len(store.rates) != 1 {
+			t.Errorf("expected the table to be left alone, got %+v", store.rates)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+type mockTaxRateStore struct {
+	rates []types.TaxRate
+}
+
+func (m *mockTaxRateStore) GetTaxRates() ([]types.TaxRate, error) {
+	return m.rates, nil
+}
+
+func (m *mockTaxRateStore) SetTaxRates(rates []types.TaxRate) error {
+	m.rates = rates
+	return nil
+}
+
+func (m *mockTaxRateStore) WithTx(tx *sql.Tx) types.TaxRateStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,60 @@
+package tax
+
+import (
+	"database/sql"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.TaxRateStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) GetTaxRates() ([]types.TaxRate, error) {
+	rows, err := s.db.Query("SELECT country, region, taxClass, rate, updatedAt FROM tax_rates ORDER BY country, region, taxClass")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make([]types.TaxRate, 0)
+	for rows.Next() {
+		var rate types.TaxRate
+		if err := rows.Scan(&rate.Country, &rate.Region, &rate.TaxClass, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// SetTaxRates should run inside a transaction, or orders placed meanwhile
+// may see an empty table.
+func (s *Store) SetTaxRates(rates []types.TaxRate) error {
+	if _, err := s.db.Exec("DELETE FROM tax_rates"); err != nil {
+		return err
+	}
+
+	for _, rate := range rates {
+		_, err := s.db.Exec(
+			"INSERT INTO tax_rates (country, region, taxClass, rate) VALUES (?, ?, ?, ?)",
+			rate.Country, rate.Region, rate.TaxClass, rate.Rate,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
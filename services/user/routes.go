@@ -32,6 +32,10 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 	// Registra a rota de obtenção de informações de um usuário, exigindo autenticação JWT para o acesso.
 	// A função 'auth.WithJWTAuth' é um middleware que valida o token JWT antes de chamar o manipulador real.
 	router.HandleFunc("/users/{userID}", auth.WithJWTAuth(h.handleGetUser, h.store)).Methods(http.MethodGet)
+
+	// Rotas de administração de usuários: apenas administradores podem listar usuários e alterar papéis.
+	router.HandleFunc("/users", auth.WithJWTAuth(auth.RequireRole(h.handleGetUsers, types.RoleAdmin), h.store)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{userID}/role", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateUserRole, types.RoleAdmin), h.store)).Methods(http.MethodPut)
 }
 
 // handleLogin é o manipulador que trata a requisição de login de um usuário.
@@ -66,8 +70,8 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Cria um token JWT para o usuário.
-	secret := []byte(configs.Envs.JWTSecret)   // Obtém o segredo para o JWT da configuração.
-	token, err := auth.CreateJWT(secret, u.ID) // Gera o token JWT com o ID do usuário.
+	secret := []byte(configs.Envs.JWTSecret)           // Obtém o segredo para o JWT da configuração.
+	token, err := auth.CreateJWT(secret, u.ID, u.Role) // Gera o token JWT com o ID e o papel do usuário.
 	if err != nil {
 
 		// Se houver erro ao criar o token, responde com erro 500.
@@ -150,6 +154,14 @@ func (h *Handler) handleGetUser(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
 		return
 	}
+	// Clientes só podem consultar o próprio cadastro; a equipe pode consultar qualquer usuário.
+	ctx := r.Context()
+	role := auth.GetRoleFromContext(ctx)
+	if userID != auth.GetUserIDFromContext(ctx) && role != types.RoleAdmin && role != types.RoleStaff {
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+		return
+	}
+
 	// Tenta buscar o usuário no banco de dados pelo ID fornecido.
 	user, err := h.store.GetUserByID(userID)
 	if err != nil {
@@ -160,3 +172,55 @@ func (h *Handler) handleGetUser(w http.ResponseWriter, r *http.Request) {
 	// Responde com os dados do usuário (status 200 OK).
 	utils.WriteJSON(w, http.StatusOK, user)
 }
+
+// handleGetUsers é o manipulador que lista todos os usuários (somente administradores).
+func (h *Handler) handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.store.GetUsers()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, users)
+}
+
+// handleUpdateUserRole é o manipulador que altera o papel de um usuário (somente administradores).
+func (h *Handler) handleUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	// Obtém e converte o parâmetro "userID" da URL.
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		return
+	}
+
+	var payload types.UpdateUserRolePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	// Garante que o usuário existe antes de alterar o papel.
+	if _, err := h.store.GetUserByID(userID); err != nil {
+		utils.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if err := h.store.UpdateUserRole(userID, payload.Role); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
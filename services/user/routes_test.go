@@ -1,17 +1,29 @@
 package user
 
 import (
+	"bytes"             // Pacote para montar o corpo das requisições.
+	"fmt"               // Pacote para formatação de strings e erros.
+	"io"                // Pacote com a interface io.Reader usada no corpo das requisições.
 	"net/http"          // Pacote para manipulação de requisições e respostas HTTP.
 	"net/http/httptest" // Pacote para criar testes de servidores HTTP.
 	"testing"           // Pacote para escrever testes unitários.
 
-	"github.com/gorilla/mux"          // Pacote de roteamento HTTP utilizado para manipulação de rotas.
-	"github.com/sikozonpc/ecom/types" // Importa o pacote 'types' que define o tipo 'User'.
+	"github.com/gorilla/mux"                  // Pacote de roteamento HTTP utilizado para manipulação de rotas.
+	"github.com/sikozonpc/ecom/configs"       // Pacote de configuração, usado para obter o segredo do JWT.
+	"github.com/sikozonpc/ecom/services/auth" // Pacote de autenticação, usado para gerar tokens nos testes.
+	"github.com/sikozonpc/ecom/types"         // Importa o pacote 'types' que define o tipo 'User'.
+)
+
+// Usuários conhecidos pelo "mock", um para cada papel.
+var (
+	customer = &types.User{ID: 42, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 43, Role: types.RoleStaff}
+	admin    = &types.User{ID: 44, Role: types.RoleAdmin}
 )
 
 func TestUserServiceHandlers(t *testing.T) {
 	// Cria um "mock" da camada de armazenamento de usuários (mockUserStore) que simula operações no banco de dados.
-	userStore := &mockUserStore{}
+	userStore := newMockUserStore(customer, staff, admin)
 	handler := NewHandler(userStore) // Cria um novo manipulador (handler) passando o "mock" como a camada de persistência.
 
 	t.Run("should fail if the user ID is not a number", func(t *testing.T) {
@@ -40,17 +52,40 @@ func TestUserServiceHandlers(t *testing.T) {
 
 	t.Run("should handle get user by ID", func(t *testing.T) {
 
-		// Cria uma nova requisição GET para o endpoint "/user/42" (ID válido).
-		req, err := http.NewRequest(http.MethodGet, "/user/42", nil)
-		if err != nil {
-			t.Fatal(err)
+		// O próprio usuário consulta o seu cadastro (ID 42), passando pelo middleware de autenticação.
+		req := newAuthenticatedRequest(t, http.MethodGet, "/users/42", nil, customer)
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 		}
+	})
+
+	t.Run("should not let a customer read another user", func(t *testing.T) {
+		req := newAuthenticatedRequest(t, http.MethodGet, "/users/43", nil, customer)
 
 		rr := httptest.NewRecorder()
 		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+		router.ServeHTTP(rr, req)
 
-		router.HandleFunc("/user/{userID}", handler.handleGetUser).Methods(http.MethodGet)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should let staff read any user", func(t *testing.T) {
+		req := newAuthenticatedRequest(t, http.MethodGet, "/users/42", nil, staff)
 
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
 		router.ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusOK {
@@ -59,10 +94,120 @@ func TestUserServiceHandlers(t *testing.T) {
 	})
 }
 
-type mockUserStore struct{}
+func TestUserAdminRoutes(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockUserStore) {
+		// Cada teste usa cópias dos usuários, já que a troca de papel altera o "mock".
+		c, s, a := *customer, *staff, *admin
+		userStore := newMockUserStore(&c, &s, &a)
 
-func (m *mockUserStore) UpdateUser(u types.User) error {
-	return nil
+		router := mux.NewRouter()
+		NewHandler(userStore).RegisterRoutes(router)
+		return router, userStore
+	}
+
+	t.Run("should only let admins list users", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, tt := range []struct {
+			user *types.User
+			code int
+		}{
+			{customer, http.StatusForbidden},
+			{staff, http.StatusForbidden},
+			{admin, http.StatusOK},
+		} {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, newAuthenticatedRequest(t, http.MethodGet, "/users", nil, tt.user))
+
+			if rr.Code != tt.code {
+				t.Errorf("expected status code %d for %s, got %d", tt.code, tt.user.Role, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should not let staff change roles", func(t *testing.T) {
+		router, userStore := newRouter()
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newAuthenticatedRequest(t, http.MethodPut, "/users/42/role", bytes.NewBufferString(`{"role": "admin"}`), staff))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+
+		if userStore.users[42].Role != types.RoleCustomer {
+			t.Errorf("expected the role to stay customer, got %s", userStore.users[42].Role)
+		}
+	})
+
+	t.Run("should let admins change roles", func(t *testing.T) {
+		router, userStore := newRouter()
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newAuthenticatedRequest(t, http.MethodPut, "/users/42/role", bytes.NewBufferString(`{"role": "staff"}`), admin))
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if userStore.users[42].Role != types.RoleStaff {
+			t.Errorf("expected the role to be staff, got %s", userStore.users[42].Role)
+		}
+	})
+
+	t.Run("should reject an unknown role", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newAuthenticatedRequest(t, http.MethodPut, "/users/42/role", bytes.NewBufferString(`{"role": "owner"}`), admin))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should reject a token issued before a role change", func(t *testing.T) {
+		router, userStore := newRouter()
+		userStore.users[44].Role = types.RoleCustomer
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newAuthenticatedRequest(t, http.MethodGet, "/users", nil, admin))
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+}
+
+// Função auxiliar que cria uma requisição com um token JWT do usuário informado.
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", token)
+	return req
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
 }
 
 func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
@@ -74,5 +219,24 @@ func (m *mockUserStore) CreateUser(u types.User) error {
 }
 
 func (m *mockUserStore) GetUserByID(id int) (*types.User, error) {
-	return &types.User{}, nil
+	u, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	users := []*types.User{}
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(id int, role types.Role) error {
+	m.users[id].Role = role
+	return nil
 }
@@ -38,6 +38,7 @@ func (s *Store) GetUserByEmail(email string) (*types.User, error) {
 	if err != nil {
 		return nil, err // Se ocorrer um erro ao executar a consulta, retorna o erro.
 	}
+	defer rows.Close()
 
 	// Cria uma nova instância de 'User' para armazenar os dados recuperados.
 	u := new(types.User)
@@ -69,6 +70,7 @@ func (s *Store) GetUserByID(id int) (*types.User, error) {
 	if err != nil {
 		return nil, err // Se ocorrer um erro ao executar a consulta, retorna o erro.
 	}
+	defer rows.Close()
 
 	// Cria uma nova instância de 'User' para armazenar os dados recuperados.
 	u := new(types.User)
@@ -90,6 +92,33 @@ func (s *Store) GetUserByID(id int) (*types.User, error) {
 	return u, nil
 }
 
+// Função para listar todos os usuários cadastrados, usada pelas rotas de administração.
+func (s *Store) GetUsers() ([]*types.User, error) {
+	rows, err := s.db.Query("SELECT * FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*types.User, 0)
+	for rows.Next() {
+		u, err := scanRowsIntoUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// Função para alterar o papel (role) de um usuário.
+func (s *Store) UpdateUserRole(id int, role types.Role) error {
+	_, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
 // Função auxiliar para mapear os dados de uma linha do banco de dados para uma estrutura 'User'.
 func scanRowsIntoUser(rows *sql.Rows) (*types.User, error) {
 
@@ -104,6 +133,7 @@ func scanRowsIntoUser(rows *sql.Rows) (*types.User, error) {
 		&user.Email,
 		&user.Password,
 		&user.CreatedAt,
+		&user.Role,
 	)
 	if err != nil {
 		return nil, err
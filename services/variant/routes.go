@@ -0,0 +1,377 @@
+package variant
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store        types.VariantStore
+	productStore types.ProductStore
+	rateStore    types.ExchangeRateStore
+	ledger       types.StockLedger
+	userStore    types.UserStore
+	transactor   types.Transactor
+}
+
+func NewHandler(store types.VariantStore, productStore types.ProductStore, rateStore types.ExchangeRateStore, ledger types.StockLedger, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, productStore: productStore, rateStore: rateStore, ledger: ledger, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/products/{productID}/variants", h.handleGetVariants).Methods(http.MethodGet)
+
+	// admin routes
+	router.HandleFunc("/products/{productID}/options", auth.WithJWTAuth(auth.RequireRole(h.handleSetOptions, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/products/{productID}/variants", auth.WithJWTAuth(auth.RequireRole(h.handleCreateVariant, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/products/{productID}/variants/{variantID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateVariant, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/products/{productID}/variants/{variantID}", auth.WithJWTAuth(auth.RequireRole(h.handleDeleteVariant, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodDelete)
+}
+
+func (h *Handler) handleGetVariants(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.productStore.GetProductByID(productID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	options, err := h.store.GetOptions(productID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	variants, err := h.store.GetVariantsByProductIDs([]int{productID})
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ProductVariants{Options: options, Variants: variants})
+}
+
+// handleSetOptions replaces the options of a product. Every variant carries a
+// value for each option, so they can only change while the product has no
+// variants.
+func (h *Handler) handleSetOptions(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.ProductOptionsPayload
+	if err := parsePayload(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, name := range payload.Options {
+		if seen[name] {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("option %q is listed twice", name))
+			return
+		}
+		seen[name] = true
+	}
+
+	var options []types.ProductOption
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := h.productStore.WithTx(tx).GetProductByID(productID); err != nil {
+			return err
+		}
+
+		variants, err := store.GetVariantsByProductIDs([]int{productID})
+		if err != nil {
+			return err
+		}
+
+		if len(variants) > 0 {
+			return fmt.Errorf("product %d still has variants: %w", productID, types.ErrConflict)
+		}
+
+		if err := store.SetOptions(productID, payload.Options); err != nil {
+			return err
+		}
+
+		options, err = store.GetOptions(productID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, options)
+}
+
+func (h *Handler) handleCreateVariant(w http.ResponseWriter, r *http.Request) {
+	actorID := auth.GetUserIDFromContext(r.Context())
+
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.CreateVariantPayload
+	if err := parsePayload(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.checkPriceCurrency(payload.Price); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var variant *types.ProductVariant
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		if _, err := h.productStore.WithTx(tx).GetProductByID(productID); err != nil {
+			return err
+		}
+
+		options, err := store.GetOptions(productID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkOptionValues(options, payload.Options); err != nil {
+			return err
+		}
+
+		if err := checkSKUAvailable(store, payload.SKU, 0); err != nil {
+			return err
+		}
+
+		variants, err := store.GetVariantsByProductIDs([]int{productID})
+		if err != nil {
+			return err
+		}
+
+		for _, v := range variants {
+			if maps.Equal(v.Options, payload.Options) {
+				return fmt.Errorf("variant %s already has these options: %w", v.SKU, types.ErrConflict)
+			}
+		}
+
+		variantID, err := store.CreateVariant(types.ProductVariant{
+			ProductID: productID,
+			SKU:       payload.SKU,
+			Price:     payload.Price,
+			Options:   payload.Options,
+		})
+		if err != nil {
+			return err
+		}
+
+		// the variant starts out empty and its stock comes in as an import
+		if payload.Quantity > 0 {
+			err := h.ledger.WithTx(tx).Move(types.StockMovement{
+				ProductID: productID,
+				VariantID: &variantID,
+				Change:    payload.Quantity,
+				Reason:    types.StockImport,
+				ActorID:   &actorID,
+				Reference: "initial stock",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		variant, err = store.GetVariantByID(productID, variantID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, variant)
+}
+
+func (h *Handler) handleUpdateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variantID, err := getIDFromPath(r, "variantID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload types.UpdateVariantPayload
+	if err := parsePayload(r, &payload); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.checkPriceCurrency(payload.Price); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var variant *types.ProductVariant
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		variant, err = store.GetVariantByID(productID, variantID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkSKUAvailable(store, payload.SKU, variantID); err != nil {
+			return err
+		}
+
+		variant.SKU = payload.SKU
+		variant.Price = payload.Price
+
+		return store.UpdateVariant(*variant)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, variant)
+}
+
+func (h *Handler) handleDeleteVariant(w http.ResponseWriter, r *http.Request) {
+	productID, err := getIDFromPath(r, "productID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variantID, err := getIDFromPath(r, "variantID")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.store.DeleteVariant(productID, variantID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errInvalidOptions reports option values that don't match the options of
+// the product.
+type errInvalidOptions struct{ error }
+
+// checkOptionValues makes sure values holds exactly one value for each of the
+// product's options.
+func checkOptionValues(options []types.ProductOption, values map[string]string) error {
+	if len(values) != len(options) {
+		return errInvalidOptions{fmt.Errorf("expected a value for each of the %d product options, got %d", len(options), len(values))}
+	}
+
+	for _, o := range options {
+		if _, ok := values[o.Name]; !ok {
+			return errInvalidOptions{fmt.Errorf("missing a value for option %q", o.Name)}
+		}
+	}
+
+	return nil
+}
+
+// checkSKUAvailable fails with ErrConflict if the SKU belongs to any variant
+// other than variantID, deleted ones included.
+func checkSKUAvailable(store types.VariantStore, sku string, variantID int) error {
+	existing, err := store.GetVariantBySKU(sku)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.ID != variantID {
+		return fmt.Errorf("sku %s is already taken: %w", sku, types.ErrConflict)
+	}
+
+	return nil
+}
+
+func parsePayload(r *http.Request, payload any) error {
+	if err := utils.ParseJSON(r, payload); err != nil {
+		return err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return nil
+}
+
+func getIDFromPath(r *http.Request, name string) (int, error) {
+	str, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s", name)
+	}
+
+	id, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+
+	return id, nil
+}
+
+// checkPriceCurrency makes sure a variant price, if set, is in a currency
+// with an exchange rate.
+func (h *Handler) checkPriceCurrency(price *types.Money) error {
+	if price == nil {
+		return nil
+	}
+
+	rates, err := h.rateStore.GetExchangeRates()
+	if err != nil {
+		return err
+	}
+
+	_, err = types.NewExchangeRates(rates).CrossRate(types.DefaultCurrency, price.Currency)
+	return err
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalidOptions errInvalidOptions
+	switch {
+	case errors.As(err, &invalidOptions), errors.Is(err, types.ErrNoExchangeRate):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
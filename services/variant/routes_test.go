@@ -0,0 +1,449 @@
+package variant
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+)
+
+func TestVariantHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockVariantStore, *mockLedger) {
+		store := &mockVariantStore{
+			options: map[int][]string{1: {"size", "color"}},
+			variants: map[int]*types.ProductVariant{
+				11: {ID: 11, ProductID: 1, SKU: "TS-M-RED", Quantity: 5, Options: map[string]string{"size": "M", "color": "red"}},
+			},
+			nextID: 11,
+		}
+
+		router := mux.NewRouter()
+		rates := &mockExchangeRateStore{rates: types.ExchangeRates{"EUR": 92_000_000}}
+		ledger := &mockLedger{variants: store}
+		NewHandler(store, &mockProductStore{}, rates, ledger, newMockUserStore(customer, staff), &mockTransactor{}).RegisterRoutes(router)
+		return router, store, ledger
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should list the options and variants of a product", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		rr := send(router, http.MethodGet, "/products/1/variants", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var body types.ProductVariants
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Options) != 2 || len(body.Variants) != 1 || body.Variants[0].SKU != "TS-M-RED" {
+			t.Errorf("expected 2 options and the TS-M-RED variant, got %+v", body)
+		}
+	})
+
+	t.Run("should return 404 for an unknown product", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		if rr := send(router, http.MethodGet, "/products/99/variants", "", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should only let staff change variants", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodPut, "/products/1/options", `{"options": ["size"]}`, customer),
+			send(router, http.MethodPost, "/products/1/variants", `{"sku": "TS-L-RED", "options": {"size": "L", "color": "red"}}`, customer),
+			send(router, http.MethodDelete, "/products/1/variants/11", "", nil),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should not change the options of a product with variants", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		if rr := send(router, http.MethodPut, "/products/1/options", `{"options": ["size"]}`, staff); rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
+		}
+
+		if rr := send(router, http.MethodPut, "/products/2/options", `{"options": ["size", "size"]}`, staff); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		if rr := send(router, http.MethodPut, "/products/2/options", `{"options": ["size"]}`, staff); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should create a variant", func(t *testing.T) {
+		router, store, ledger := newRouter()
+
+		rr := send(router, http.MethodPost, "/products/1/variants", `{"sku": "TS-L-RED", "price": {"amount": 2500, "currency": "USD"}, "quantity": 3, "options": {"size": "L", "color": "red"}}`, staff)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var variant types.ProductVariant
+		if err := json.NewDecoder(rr.Body).Decode(&variant); err != nil {
+			t.Fatal(err)
+		}
+
+		if variant.SKU != "TS-L-RED" || variant.Price == nil || *variant.Price != types.NewMoney(2500, types.DefaultCurrency) || store.variants[variant.ID] == nil {
+			t.Errorf("expected TS-L-RED to be stored, got %+v", variant)
+		}
+
+		if variant.Quantity != 3 || len(ledger.movements) != 1 || ledger.movements[0].Reason != types.StockImport {
+			t.Errorf("expected the initial stock to come in as an import, got %d and %+v", variant.Quantity, ledger.movements)
+		}
+	})
+
+	t.Run("should reject invalid variants", func(t *testing.T) {
+		router, _, _ := newRouter()
+
+		cases := []struct {
+			name    string
+			payload string
+			status  int
+		}{
+			{"missing option", `{"sku": "TS-L", "options": {"size": "L"}}`, http.StatusBadRequest},
+			{"unknown option", `{"sku": "TS-L", "options": {"size": "L", "fit": "slim"}}`, http.StatusBadRequest},
+			{"taken sku", `{"sku": "TS-M-RED", "options": {"size": "L", "color": "red"}}`, http.StatusConflict},
+			{"taken options", `{"sku": "TS-M-RED-2", "options": {"size": "M", "color": "red"}}`, http.StatusConflict},
+			{"unknown currency", `{"sku": "TS-L", "price": {"amount": 2500, "currency": "XYZ"}, "options": {"size": "L", "color": "red"}}`, http.StatusBadRequest},
+			{"currency without a rate", `{"sku": "TS-L", "price": {"amount": 2500, "currency": "GBP"}, "options": {"size": "L", "color": "red"}}`, http.StatusBadRequest},
+		}
+
+		for _, c := range cases {
+			if rr := send(router, http.MethodPost, "/products/1/variants", c.payload, staff); rr.Code != c.status {
+				t.Errorf("%s: expected status code %d, got %d", c.name, c.status, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should update a variant", func(t *testing.T) {
+		router, store, ledger := newRouter()
+
+		if rr := send(router, http.MethodPut, "/products/1/variants/11", `{"sku": "TS-M-RED", "quantity": 9}`, staff); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		// the stock only changes through the product's stock movements
+		if store.variants[11].Quantity != 5 || len(ledger.movements) != 0 {
+			t.Errorf("expected the stock to be left alone, got %d and %+v", store.variants[11].Quantity, ledger.movements)
+		}
+
+		if rr := send(router, http.MethodPut, "/products/1/variants/11", `{"sku": "TS-M-RED", "price": {"amount": 1800, "currency": "EUR"}}`, staff); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if price := store.variants[11].Price; price == nil || *price != types.NewMoney(1800, "EUR") {
+			t.Errorf("expected the price to be EUR 18.00, got %v", price)
+		}
+
+		if rr := send(router, http.MethodPut, "/products/2/variants/11", `{"sku": "TS-M-RED", "quantity": 9}`, staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should delete a variant", func(t *testing.T) {
+		router, store, _ := newRouter()
+
+		if rr := send(router, http.MethodDelete, "/products/1/variants/11", "", staff); rr.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if store.variants[11].DeletedAt == nil {
+			t.Errorf("expected the variant to be soft deleted")
+		}
+
+		if rr := send(router, http.MethodDelete, "/products/1/variants/11", "", staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+// mockVariantStore keeps the options and variants in memory. Deleted variants
+// stay around so their SKUs remain taken, like in the real store.
+type mockVariantStore struct {
+	options  map[int][]string
+	variants map[int]*types.ProductVariant
+	nextID   int
+}
+
+func (m *mockVariantStore) GetOptions(productID int) ([]types.ProductOption, error) {
+	options := []types.ProductOption{}
+	for i, name := range m.options[productID] {
+		options = append(options, types.ProductOption{ID: i + 1, ProductID: productID, Name: name, Position: i})
+	}
+
+	return options, nil
+}
+
+func (m *mockVariantStore) SetOptions(productID int, names []string) error {
+	m.options[productID] = names
+	return nil
+}
+
+func (m *mockVariantStore) GetVariantsByProductIDs(productIDs []int) ([]types.ProductVariant, error) {
+	variants := []types.ProductVariant{}
+	for _, productID := range productIDs {
+		for _, v := range m.variants {
+			if v.ProductID == productID && v.DeletedAt == nil {
+				variants = append(variants, *v)
+			}
+		}
+	}
+
+	return variants, nil
+}
+
+func (m *mockVariantStore) GetVariantByID(productID int, variantID int) (*types.ProductVariant, error) {
+	v, ok := m.variants[variantID]
+	if !ok || v.ProductID != productID || v.DeletedAt != nil {
+		return nil, fmt.Errorf("variant %d %w", variantID, types.ErrNotFound)
+	}
+
+	variant := *v
+	return &variant, nil
+}
+
+func (m *mockVariantStore) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	for _, v := range m.variants {
+		if v.SKU == sku {
+			variant := *v
+			return &variant, nil
+		}
+	}
+
+	return nil, fmt.Errorf("variant %s %w", sku, types.ErrNotFound)
+}
+
+// CreateVariant and UpdateVariant leave the stock to the ledger, like the
+// real store.
+func (m *mockVariantStore) CreateVariant(v types.ProductVariant) (int, error) {
+	m.nextID++
+	v.ID = m.nextID
+	v.Quantity = 0
+	v.Options = maps.Clone(v.Options)
+	m.variants[v.ID] = &v
+	return v.ID, nil
+}
+
+func (m *mockVariantStore) UpdateVariant(v types.ProductVariant) error {
+	v.Quantity = m.variants[v.ID].Quantity
+	m.variants[v.ID] = &v
+	return nil
+}
+
+func (m *mockVariantStore) DeleteVariant(productID int, variantID int) error {
+	v, ok := m.variants[variantID]
+	if !ok || v.ProductID != productID || v.DeletedAt != nil {
+		return fmt.Errorf("variant %d %w", variantID, types.ErrNotFound)
+	}
+
+	deletedAt := v.CreatedAt
+	v.DeletedAt = &deletedAt
+	return nil
+}
+
+func (m *mockVariantStore) WithTx(tx *sql.Tx) types.VariantStore {
+	return m
+}
+
+// mockProductStore knows products 1 and 2.
+type mockProductStore struct{}
+
+func (m *mockProductStore) GetProductByID(productID int) (*types.Product, error) {
+	if productID != 1 && productID != 2 {
+		return nil, fmt.Errorf("product %d %w", productID, types.ErrNotFound)
+	}
+
+	return &types.Product{ID: productID}, nil
+}
+
+func (m *mockProductStore) GetProductsByID(ids []int) ([]types.Product, error) {
+	return []types.Product{}, nil
+}
+
+func (m *mockProductStore) GetProducts(query types.ProductQuery) ([]*types.Product, int, error) {
+	return []*types.Product{}, 0, nil
+}
+
+func (m *mockProductStore) SearchProducts(text string, limit int) ([]types.ProductSearchResult, error) {
+	return []types.ProductSearchResult{}, nil
+}
+
+func (m *mockProductStore) CreateProduct(product types.CreateProductPayload) (int, error) {
+	return 0, nil
+}
+
+func (m *mockProductStore) UpdateProduct(product types.Product) error {
+	return nil
+}
+
+func (m *mockProductStore) DeleteProduct(productID int) error {
+	return nil
+}
+
+func (m *mockProductStore) GetLowStockProducts(productIDs []int) ([]types.LowStockProduct, error) {
+	return []types.LowStockProduct{}, nil
+}
+
+func (m *mockProductStore) WithTx(tx *sql.Tx) types.ProductStore {
+	return m
+}
+
+type mockExchangeRateStore struct {
+	rates types.ExchangeRates
+}
+
+func (m *mockExchangeRateStore) GetExchangeRates() ([]types.ExchangeRate, error) {
+	rates := []types.ExchangeRate{}
+	for currency, rate := range m.rates {
+		rates = append(rates, types.ExchangeRate{Currency: currency, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+func (m *mockExchangeRateStore) SetExchangeRates(rates map[string]types.Rate) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) DeleteExchangeRate(currency string) error {
+	return nil
+}
+
+func (m *mockExchangeRateStore) WithTx(tx *sql.Tx) types.ExchangeRateStore {
+	return m
+}
+
+// mockLedger applies the movements to the stock of the variants and keeps
+// them in order.
+type mockLedger struct {
+	variants  *mockVariantStore
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	v := m.variants.variants[*movement.VariantID]
+	v.Quantity += movement.Change
+	movement.Balance = v.Quantity
+
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,270 @@
+package variant
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.VariantStore {
+	return &Store{db: tx}
+}
+
+// variantColumns lists the columns read by scanRowsIntoVariant, in order.
+const variantColumns = "id, productId, sku, currency, price, quantity, createdAt, deletedAt"
+
+func (s *Store) GetOptions(productID int) ([]types.ProductOption, error) {
+	rows, err := s.db.Query("SELECT id, productId, name, position FROM product_options WHERE productId = ? ORDER BY position, id", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := make([]types.ProductOption, 0)
+	for rows.Next() {
+		var o types.ProductOption
+		if err := rows.Scan(&o.ID, &o.ProductID, &o.Name, &o.Position); err != nil {
+			return nil, err
+		}
+
+		options = append(options, o)
+	}
+
+	return options, rows.Err()
+}
+
+func (s *Store) SetOptions(productID int, names []string) error {
+	if _, err := s.db.Exec("DELETE FROM product_options WHERE productId = ?", productID); err != nil {
+		return err
+	}
+
+	for i, name := range names {
+		_, err := s.db.Exec("INSERT INTO product_options (productId, name, position) VALUES (?, ?, ?)", productID, name, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) GetVariantsByProductIDs(productIDs []int) ([]types.ProductVariant, error) {
+	if len(productIDs) == 0 {
+		return []types.ProductVariant{}, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	return s.queryVariants(
+		"SELECT "+variantColumns+" FROM product_variants WHERE productId IN (?"+placeholders+") AND deletedAt IS NULL ORDER BY productId, id",
+		args...,
+	)
+}
+
+func (s *Store) GetVariantByID(productID int, variantID int) (*types.ProductVariant, error) {
+	variants, err := s.queryVariants(
+		"SELECT "+variantColumns+" FROM product_variants WHERE id = ? AND productId = ? AND deletedAt IS NULL",
+		variantID, productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("variant %d %w", variantID, types.ErrNotFound)
+	}
+
+	return &variants[0], nil
+}
+
+func (s *Store) GetVariantBySKU(sku string) (*types.ProductVariant, error) {
+	variants, err := s.queryVariants("SELECT "+variantColumns+" FROM product_variants WHERE sku = ?", sku)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("variant %s %w", sku, types.ErrNotFound)
+	}
+
+	return &variants[0], nil
+}
+
+func (s *Store) CreateVariant(v types.ProductVariant) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO product_variants (productId, sku, currency, price, quantity) VALUES (?, ?, ?, ?, 0)",
+		v.ProductID, v.SKU, priceCurrency(v.Price), v.Price,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for name, value := range v.Options {
+		_, err := s.db.Exec(
+			"INSERT INTO product_variant_options (variantId, optionId, value) SELECT ?, id, ? FROM product_options WHERE productId = ? AND name = ?",
+			id, value, v.ProductID, name,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateVariant(v types.ProductVariant) error {
+	_, err := s.db.Exec(
+		"UPDATE product_variants SET sku = ?, currency = ?, price = ? WHERE id = ? AND productId = ?",
+		v.SKU, priceCurrency(v.Price), v.Price, v.ID, v.ProductID,
+	)
+	return err
+}
+
+func (s *Store) DeleteVariant(productID int, variantID int) error {
+	res, err := s.db.Exec(
+		"UPDATE product_variants SET deletedAt = CURRENT_TIMESTAMP WHERE id = ? AND productId = ? AND deletedAt IS NULL",
+		variantID, productID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("variant %d %w", variantID, types.ErrNotFound)
+	}
+
+	return nil
+}
+
+// queryVariants runs a query selecting variantColumns and fills in the
+// option values of the variants it returns.
+func (s *Store) queryVariants(query string, args ...interface{}) ([]types.ProductVariant, error) {
+	variants, err := s.scanVariants(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return variants, s.loadOptionValues(variants)
+}
+
+func (s *Store) scanVariants(query string, args ...interface{}) ([]types.ProductVariant, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make([]types.ProductVariant, 0)
+	for rows.Next() {
+		v, err := scanRowsIntoVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, *v)
+	}
+
+	return variants, rows.Err()
+}
+
+func (s *Store) loadOptionValues(variants []types.ProductVariant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	index := make(map[int]*types.ProductVariant, len(variants))
+	args := make([]interface{}, len(variants))
+	for i := range variants {
+		variants[i].Options = map[string]string{}
+		index[variants[i].ID] = &variants[i]
+		args[i] = variants[i].ID
+	}
+
+	rows, err := s.db.Query(
+		"SELECT vo.variantId, o.name, vo.value FROM product_variant_options vo "+
+			"JOIN product_options o ON o.id = vo.optionId "+
+			"WHERE vo.variantId IN (?"+strings.Repeat(",?", len(variants)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantID int
+		var name, value string
+		if err := rows.Scan(&variantID, &name, &value); err != nil {
+			return err
+		}
+
+		index[variantID].Options[name] = value
+	}
+
+	return rows.Err()
+}
+
+func scanRowsIntoVariant(rows *sql.Rows) (*types.ProductVariant, error) {
+	v := new(types.ProductVariant)
+
+	var currency, price sql.NullString
+	err := rows.Scan(
+		&v.ID,
+		&v.ProductID,
+		&v.SKU,
+		&currency,
+		&price,
+		&v.Quantity,
+		&v.CreatedAt,
+		&v.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if price.Valid {
+		m, err := types.ParseMoney(price.String, currency.String)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Price = &m
+	}
+
+	return v, nil
+}
+
+// priceCurrency is the value of the currency column for a variant price,
+// NULL along with the price when the variant sells at the product's price.
+func priceCurrency(price *types.Money) any {
+	if price == nil {
+		return nil
+	}
+
+	return price.Currency
+}
@@ -0,0 +1,201 @@
+package warehouse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// ParseStrategy validates an allocation strategy coming from the
+// configuration.
+func ParseStrategy(name string) (types.AllocationStrategy, error) {
+	switch name {
+	case "closest":
+		return Closest{}, nil
+	case "single-shipment":
+		return SingleShipment{}, nil
+	case "most-stock":
+		return MostStock{}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation strategy %q", name)
+	}
+}
+
+// Closest ships each line from the warehouses closest to the address,
+// splitting it over the next closest ones when the first runs short.
+type Closest struct{}
+
+func (Closest) Allocate(to types.PostalAddress, lines []types.AllocationLine, warehouses []types.Warehouse, levels []types.InventoryLevel) ([]types.OrderAllocation, error) {
+	return fill(lines, byDistance(to, warehouses), newStock(levels))
+}
+
+// SingleShipment ships the whole order from one warehouse when any holds
+// all of it, the closest of them when several do, so the customer gets a
+// single parcel. Otherwise it falls back to Closest.
+type SingleShipment struct{}
+
+func (SingleShipment) Allocate(to types.PostalAddress, lines []types.AllocationLine, warehouses []types.Warehouse, levels []types.InventoryLevel) ([]types.OrderAllocation, error) {
+	stock := newStock(levels)
+	ranked := byDistance(to, warehouses)
+
+	for _, w := range ranked {
+		if stock.holdsAll(w.ID, lines) {
+			return fill(lines, []types.Warehouse{w}, stock)
+		}
+	}
+
+	return fill(lines, ranked, stock)
+}
+
+// MostStock ships each line from the warehouses that hold the most of it,
+// which keeps the stock of the warehouses even.
+type MostStock struct{}
+
+func (MostStock) Allocate(to types.PostalAddress, lines []types.AllocationLine, warehouses []types.Warehouse, levels []types.InventoryLevel) ([]types.OrderAllocation, error) {
+	stock := newStock(levels)
+
+	allocations := []types.OrderAllocation{}
+	for _, line := range lines {
+		ranked := append([]types.Warehouse{}, warehouses...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return stock.get(ranked[i].ID, line) > stock.get(ranked[j].ID, line)
+		})
+
+		allocated, err := fill([]types.AllocationLine{line}, ranked, stock)
+		if err != nil {
+			return nil, err
+		}
+
+		allocations = append(allocations, allocated...)
+	}
+
+	return allocations, nil
+}
+
+// fill allocates each line from the warehouses in the order given, taking
+// what it allocates out of stock.
+func fill(lines []types.AllocationLine, warehouses []types.Warehouse, stock stock) ([]types.OrderAllocation, error) {
+	allocations := []types.OrderAllocation{}
+	for _, line := range lines {
+		left := line.Quantity
+		for _, w := range warehouses {
+			if left == 0 {
+				break
+			}
+
+			take := min(left, stock.get(w.ID, line))
+			if take <= 0 {
+				continue
+			}
+
+			stock.take(w.ID, line, take)
+			left -= take
+			allocations = append(allocations, types.OrderAllocation{
+				OrderItemID: line.OrderItemID,
+				WarehouseID: w.ID,
+				Quantity:    take,
+			})
+		}
+
+		if left > 0 {
+			return nil, fmt.Errorf("product %d is not available in the quantity requested: %w", line.ProductID, types.ErrConflict)
+		}
+	}
+
+	return allocations, nil
+}
+
+// byDistance sorts the warehouses closest to the address first: those in
+// the same country, then in the same state, then sharing the longest
+// start of the postal code. Ties keep the default warehouse first.
+func byDistance(to types.PostalAddress, warehouses []types.Warehouse) []types.Warehouse {
+	ranked := append([]types.Warehouse{}, warehouses...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := closeness(to, ranked[i].Address), closeness(to, ranked[j].Address)
+		if a != b {
+			return a > b
+		}
+		return ranked[i].IsDefault && !ranked[j].IsDefault
+	})
+
+	return ranked
+}
+
+// closeness scores how close two addresses are without geocoding them; a
+// higher score is closer. Sharing the country outweighs sharing the state,
+// which outweighs any part of the postal code.
+func closeness(a, b types.PostalAddress) int {
+	if a.Country == "" || !strings.EqualFold(a.Country, b.Country) {
+		return 0
+	}
+
+	score := 1000
+	if a.State != "" && strings.EqualFold(a.State, b.State) {
+		score += 100
+	}
+
+	pa, pb := normalizePostalCode(a.PostalCode), normalizePostalCode(b.PostalCode)
+	for i := 0; i < len(pa) && i < len(pb) && i < 99 && pa[i] == pb[i]; i++ {
+		score++
+	}
+
+	return score
+}
+
+func normalizePostalCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// stock is what each warehouse holds of each product and variant, keyed on
+// the warehouse and the line.
+type stock map[stockKey]int
+
+type stockKey struct {
+	warehouseID int
+	productID   int
+	variantID   int
+}
+
+func newStock(levels []types.InventoryLevel) stock {
+	s := stock{}
+	for _, l := range levels {
+		s[key(l.WarehouseID, l.ProductID, l.VariantID)] += l.Quantity
+	}
+
+	return s
+}
+
+func key(warehouseID, productID int, variantID *int) stockKey {
+	k := stockKey{warehouseID: warehouseID, productID: productID}
+	if variantID != nil {
+		k.variantID = *variantID
+	}
+
+	return k
+}
+
+func (s stock) get(warehouseID int, line types.AllocationLine) int {
+	return s[key(warehouseID, line.ProductID, line.VariantID)]
+}
+
+func (s stock) take(warehouseID int, line types.AllocationLine, quantity int) {
+	s[key(warehouseID, line.ProductID, line.VariantID)] -= quantity
+}
+
+// holdsAll reports whether the warehouse holds every line in full.
+func (s stock) holdsAll(warehouseID int, lines []types.AllocationLine) bool {
+	needed := stock{}
+	for _, line := range lines {
+		needed[key(warehouseID, line.ProductID, line.VariantID)] += line.Quantity
+	}
+
+	for k, quantity := range needed {
+		if s[k] < quantity {
+			return false
+		}
+	}
+
+	return true
+}
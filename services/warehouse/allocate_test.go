@@ -0,0 +1,128 @@
+package warehouse
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+// the customer lives in Lyon; warehouse 1 is the default one, in Paris,
+// warehouse 2 is in Lyon and warehouse 3 in Madrid
+var (
+	customerAddress = types.PostalAddress{City: "Lyon", State: "ARA", PostalCode: "69002", Country: "FR"}
+
+	warehouses = []types.Warehouse{
+		{ID: 1, Code: "paris", Address: types.PostalAddress{State: "IDF", PostalCode: "75011", Country: "FR"}, IsDefault: true},
+		{ID: 2, Code: "lyon", Address: types.PostalAddress{State: "ARA", PostalCode: "69007", Country: "FR"}},
+		{ID: 3, Code: "madrid", Address: types.PostalAddress{State: "MD", PostalCode: "28001", Country: "ES"}},
+	}
+)
+
+func TestAllocationStrategies(t *testing.T) {
+	variantID := 11
+	lines := []types.AllocationLine{
+		{OrderItemID: 1, ProductID: 1, Quantity: 3},
+		{OrderItemID: 2, ProductID: 2, VariantID: &variantID, Quantity: 1},
+	}
+
+	// Lyon only holds part of product 1, Paris holds the whole order and
+	// Madrid holds the most of product 1
+	levels := []types.InventoryLevel{
+		{WarehouseID: 1, ProductID: 1, Quantity: 3},
+		{WarehouseID: 1, ProductID: 2, VariantID: &variantID, Quantity: 1},
+		{WarehouseID: 2, ProductID: 1, Quantity: 2},
+		{WarehouseID: 2, ProductID: 2, VariantID: &variantID, Quantity: 1},
+		{WarehouseID: 3, ProductID: 1, Quantity: 10},
+		// the product's own stock doesn't count for its variants
+		{WarehouseID: 3, ProductID: 2, Quantity: 5},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		strategy types.AllocationStrategy
+		want     []types.OrderAllocation
+	}{
+		{
+			name:     "closest",
+			strategy: Closest{},
+			want: []types.OrderAllocation{
+				{OrderItemID: 1, WarehouseID: 2, Quantity: 2},
+				{OrderItemID: 1, WarehouseID: 1, Quantity: 1},
+				{OrderItemID: 2, WarehouseID: 2, Quantity: 1},
+			},
+		},
+		{
+			name:     "single shipment",
+			strategy: SingleShipment{},
+			want: []types.OrderAllocation{
+				{OrderItemID: 1, WarehouseID: 1, Quantity: 3},
+				{OrderItemID: 2, WarehouseID: 1, Quantity: 1},
+			},
+		},
+		{
+			name:     "most stock",
+			strategy: MostStock{},
+			want: []types.OrderAllocation{
+				{OrderItemID: 1, WarehouseID: 3, Quantity: 3},
+				{OrderItemID: 2, WarehouseID: 1, Quantity: 1},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allocations, err := tc.strategy.Allocate(customerAddress, lines, warehouses, levels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(allocations) != len(tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, allocations)
+			}
+
+			for i := range tc.want {
+				if allocations[i] != tc.want[i] {
+					t.Errorf("expected %+v, got %+v", tc.want[i], allocations[i])
+				}
+			}
+		})
+	}
+
+	t.Run("should split the order when no warehouse holds all of it", func(t *testing.T) {
+		allocations, err := SingleShipment{}.Allocate(customerAddress, lines, warehouses, levels[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(allocations) != 3 || allocations[0].WarehouseID != 2 || allocations[1].WarehouseID != 3 {
+			t.Errorf("expected product 1 to ship from Lyon and Madrid, got %+v", allocations)
+		}
+	})
+
+	t.Run("should fail when the warehouses don't hold enough", func(t *testing.T) {
+		for _, strategy := range []types.AllocationStrategy{Closest{}, SingleShipment{}, MostStock{}} {
+			_, err := strategy.Allocate(customerAddress, []types.AllocationLine{{OrderItemID: 1, ProductID: 1, Quantity: 16}}, warehouses, levels)
+			if !errors.Is(err, types.ErrConflict) {
+				t.Errorf("expected %T to fail with a conflict, got %v", strategy, err)
+			}
+		}
+	})
+
+	t.Run("should prefer the default warehouse when none is closer", func(t *testing.T) {
+		ranked := byDistance(types.PostalAddress{Country: "US"}, warehouses)
+		if ranked[0].ID != 1 {
+			t.Errorf("expected the default warehouse first, got %+v", ranked)
+		}
+	})
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, name := range []string{"closest", "single-shipment", "most-stock"} {
+		if _, err := ParseStrategy(name); err != nil {
+			t.Errorf("expected %q to parse, got %v", name, err)
+		}
+	}
+
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Errorf("expected an unknown strategy to fail")
+	}
+}
@@ -0,0 +1,219 @@
+package warehouse
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+type Handler struct {
+	store      types.WarehouseStore
+	userStore  types.UserStore
+	transactor types.Transactor
+}
+
+func NewHandler(store types.WarehouseStore, userStore types.UserStore, transactor types.Transactor) *Handler {
+	return &Handler{store: store, userStore: userStore, transactor: transactor}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/warehouses", auth.WithJWTAuth(auth.RequireRole(h.handleGetWarehouses, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+	router.HandleFunc("/warehouses", auth.WithJWTAuth(auth.RequireRole(h.handleCreateWarehouse, types.RoleAdmin), h.userStore)).Methods(http.MethodPost)
+	router.HandleFunc("/warehouses/{warehouseID}", auth.WithJWTAuth(auth.RequireRole(h.handleUpdateWarehouse, types.RoleAdmin), h.userStore)).Methods(http.MethodPut)
+	router.HandleFunc("/warehouses/{warehouseID}/picking-list", auth.WithJWTAuth(auth.RequireRole(h.handleGetPickingList, types.RoleAdmin, types.RoleStaff), h.userStore)).Methods(http.MethodGet)
+}
+
+func (h *Handler) handleGetWarehouses(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := h.store.GetWarehouses()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, warehouses)
+}
+
+func (h *Handler) handleCreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	payload, err := parseWarehousePayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var warehouse *types.Warehouse
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		wh := warehouseFromPayload(payload)
+		if err := checkCode(store, wh.Code, 0); err != nil {
+			return err
+		}
+
+		warehouseID, err := store.CreateWarehouse(wh)
+		if err != nil {
+			return err
+		}
+
+		warehouse, err = store.GetWarehouse(warehouseID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, warehouse)
+}
+
+func (h *Handler) handleUpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	warehouseID, err := getWarehouseIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload, err := parseWarehousePayload(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var warehouse *types.Warehouse
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		store := h.store.WithTx(tx)
+
+		existing, err := store.GetWarehouse(warehouseID)
+		if err != nil {
+			return err
+		}
+
+		// the stock that isn't brought into a particular warehouse needs
+		// somewhere to go
+		if existing.IsDefault && !payload.IsDefault {
+			return errNoDefault
+		}
+
+		wh := warehouseFromPayload(payload)
+		wh.ID = warehouseID
+		if err := checkCode(store, wh.Code, warehouseID); err != nil {
+			return err
+		}
+
+		if err := store.UpdateWarehouse(wh); err != nil {
+			return err
+		}
+
+		warehouse, err = store.GetWarehouse(warehouseID)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, warehouse)
+}
+
+func (h *Handler) handleGetPickingList(w http.ResponseWriter, r *http.Request) {
+	warehouseID, err := getWarehouseIDFromPath(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := h.store.GetWarehouse(warehouseID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	items, err := h.store.GetPickingList(warehouseID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, items)
+}
+
+var errNoDefault = errors.New("the default warehouse can only change by making another warehouse the default")
+
+// checkCode makes sure code isn't taken by another warehouse than
+// warehouseID.
+func checkCode(store types.WarehouseStore, code string, warehouseID int) error {
+	warehouses, err := store.GetWarehouses()
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warehouses {
+		if w.Code == code && w.ID != warehouseID {
+			return fmt.Errorf("warehouse code %s is already taken: %w", code, types.ErrConflict)
+		}
+	}
+
+	return nil
+}
+
+func parseWarehousePayload(r *http.Request) (types.WarehousePayload, error) {
+	var payload types.WarehousePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		return payload, err
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		return payload, fmt.Errorf("invalid payload: %v", errors)
+	}
+
+	return payload, nil
+}
+
+// warehouseFromPayload stores the country in upper case, so it compares
+// with the countries of shipping addresses.
+func warehouseFromPayload(payload types.WarehousePayload) types.Warehouse {
+	w := types.Warehouse{
+		Code:      payload.Code,
+		Name:      payload.Name,
+		Address:   payload.Address,
+		IsDefault: payload.IsDefault,
+	}
+	w.Address.Country = strings.ToUpper(w.Address.Country)
+
+	return w
+}
+
+func getWarehouseIDFromPath(r *http.Request) (int, error) {
+	str, ok := mux.Vars(r)["warehouseID"]
+	if !ok {
+		return 0, fmt.Errorf("missing warehouse ID")
+	}
+
+	warehouseID, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid warehouse ID")
+	}
+
+	return warehouseID, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoDefault):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, types.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, types.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,286 @@
+package warehouse
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/configs"
+	"github.com/sikozonpc/ecom/services/auth"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var (
+	customer = &types.User{ID: 1, Role: types.RoleCustomer}
+	staff    = &types.User{ID: 2, Role: types.RoleStaff}
+	admin    = &types.User{ID: 3, Role: types.RoleAdmin}
+)
+
+func TestWarehouseHandlers(t *testing.T) {
+	newRouter := func() (*mux.Router, *mockWarehouseStore) {
+		store := &mockWarehouseStore{
+			warehouses: []types.Warehouse{{ID: 1, Code: "main", Name: "Main warehouse", IsDefault: true}},
+			picking: map[int][]types.PickingListItem{
+				1: {{OrderID: 4, OrderItemID: 7, ProductID: 1, ProductName: "mug", Quantity: 2}},
+			},
+		}
+
+		router := mux.NewRouter()
+		NewHandler(store, newMockUserStore(customer, staff, admin), &mockTransactor{}).RegisterRoutes(router)
+		return router, store
+	}
+
+	send := func(router *mux.Router, method string, url string, payload string, user *types.User) *httptest.ResponseRecorder {
+		req := newAuthenticatedRequest(t, method, url, bytes.NewBufferString(payload), user)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	lyon := `{"code": "lyon", "name": "Lyon", "address": {"fullName": "Lyon warehouse", "line1": "1 rue de Gerland", "city": "Lyon", "postalCode": "69007", "country": "fr"}`
+
+	t.Run("should only let admins change warehouses", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, rr := range []*httptest.ResponseRecorder{
+			send(router, http.MethodGet, "/warehouses", "", customer),
+			send(router, http.MethodPost, "/warehouses", lyon+`}`, staff),
+			send(router, http.MethodPut, "/warehouses/1", lyon+`}`, staff),
+			send(router, http.MethodGet, "/warehouses/1/picking-list", "", customer),
+		} {
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, got %d", http.StatusForbidden, rr.Code)
+			}
+		}
+	})
+
+	t.Run("should create a warehouse", func(t *testing.T) {
+		router, store := newRouter()
+
+		rr := send(router, http.MethodPost, "/warehouses", lyon+`}`, admin)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		var warehouse types.Warehouse
+		if err := json.NewDecoder(rr.Body).Decode(&warehouse); err != nil {
+			t.Fatal(err)
+		}
+
+		if warehouse.ID != 2 || warehouse.Address.Country != "FR" || warehouse.IsDefault {
+			t.Errorf("unexpected warehouse %+v", warehouse)
+		}
+
+		if !store.warehouses[0].IsDefault {
+			t.Errorf("expected the main warehouse to stay the default one")
+		}
+	})
+
+	t.Run("should reject invalid warehouses", func(t *testing.T) {
+		router, _ := newRouter()
+
+		for _, payload := range []string{
+			`{"code": "lyon", "name": "Lyon"}`,
+			`{"code": "main", "name": "Main", "address": {"fullName": "Main", "line1": "1 Main St", "city": "Springfield", "postalCode": "12345", "country": "USA"}}`,
+		} {
+			if rr := send(router, http.MethodPost, "/warehouses", payload, admin); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, payload, rr.Code)
+			}
+		}
+
+		rr := send(router, http.MethodPost, "/warehouses", `{"code": "main", "name": "Main", "address": {"fullName": "Main", "line1": "1 Main St", "city": "Springfield", "postalCode": "12345", "country": "US"}}`, admin)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected a taken code to conflict, got %d", rr.Code)
+		}
+	})
+
+	t.Run("should move the default to another warehouse", func(t *testing.T) {
+		router, store := newRouter()
+
+		if rr := send(router, http.MethodPost, "/warehouses", lyon+`, "isDefault": true}`, admin); rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+		}
+
+		if store.warehouses[0].IsDefault || !store.warehouses[1].IsDefault {
+			t.Errorf("expected Lyon to be the only default warehouse, got %+v", store.warehouses)
+		}
+
+		// the default can't be left without another one taking its place
+		rr := send(router, http.MethodPut, "/warehouses/2", lyon+`}`, admin)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("should list what to pick in a warehouse", func(t *testing.T) {
+		router, _ := newRouter()
+
+		rr := send(router, http.MethodGet, "/warehouses/1/picking-list", "", staff)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		var items []types.PickingListItem
+		if err := json.NewDecoder(rr.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].OrderID != 4 || items[0].Quantity != 2 {
+			t.Errorf("unexpected picking list %+v", items)
+		}
+
+		if rr := send(router, http.MethodGet, "/warehouses/9/picking-list", "", staff); rr.Code != http.StatusNotFound {
+			t.Errorf("expected an unknown warehouse to be %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func newAuthenticatedRequest(t *testing.T, method string, url string, body io.Reader, user *types.User) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != nil {
+		token, err := auth.CreateJWT([]byte(configs.Envs.JWTSecret), user.ID, user.Role)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", token)
+	}
+
+	return req
+}
+
+type mockWarehouseStore struct {
+	warehouses []types.Warehouse
+	picking    map[int][]types.PickingListItem
+}
+
+func (m *mockWarehouseStore) GetWarehouses() ([]types.Warehouse, error) {
+	return m.warehouses, nil
+}
+
+func (m *mockWarehouseStore) GetWarehouse(id int) (*types.Warehouse, error) {
+	for _, w := range m.warehouses {
+		if w.ID == id {
+			return &w, nil
+		}
+	}
+
+	return nil, fmt.Errorf("warehouse %d %w", id, types.ErrNotFound)
+}
+
+func (m *mockWarehouseStore) CreateWarehouse(w types.Warehouse) (int, error) {
+	w.ID = len(m.warehouses) + 1
+	m.warehouses = append(m.warehouses, w)
+	if w.IsDefault {
+		m.clearDefault(w.ID)
+	}
+
+	return w.ID, nil
+}
+
+func (m *mockWarehouseStore) UpdateWarehouse(w types.Warehouse) error {
+	for i := range m.warehouses {
+		if m.warehouses[i].ID == w.ID {
+			m.warehouses[i] = w
+		}
+	}
+
+	if w.IsDefault {
+		m.clearDefault(w.ID)
+	}
+
+	return nil
+}
+
+func (m *mockWarehouseStore) clearDefault(warehouseID int) {
+	for i := range m.warehouses {
+		if m.warehouses[i].ID != warehouseID {
+			m.warehouses[i].IsDefault = false
+		}
+	}
+}
+
+func (m *mockWarehouseStore) GetInventoryLevels(productIDs []int) ([]types.InventoryLevel, error) {
+	return []types.InventoryLevel{}, nil
+}
+
+func (m *mockWarehouseStore) GetAvailability(productIDs []int) (map[int][]types.WarehouseStock, error) {
+	return map[int][]types.WarehouseStock{}, nil
+}
+
+func (m *mockWarehouseStore) CreateAllocations(allocations []types.OrderAllocation) error {
+	return nil
+}
+
+func (m *mockWarehouseStore) GetAllocations(orderID int) ([]types.OrderAllocation, error) {
+	return []types.OrderAllocation{}, nil
+}
+
+func (m *mockWarehouseStore) GetPickingList(warehouseID int) ([]types.PickingListItem, error) {
+	items, ok := m.picking[warehouseID]
+	if !ok {
+		return []types.PickingListItem{}, nil
+	}
+
+	return items, nil
+}
+
+func (m *mockWarehouseStore) WithTx(tx *sql.Tx) types.WarehouseStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+type mockUserStore struct {
+	users map[int]*types.User
+}
+
+func newMockUserStore(users ...*types.User) *mockUserStore {
+	m := &mockUserStore{users: map[int]*types.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+
+	return m
+}
+
+func (m *mockUserStore) GetUserByID(userID int) (*types.User, error) {
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return u, nil
+}
+
+func (m *mockUserStore) GetUsers() ([]*types.User, error) {
+	return []*types.User{}, nil
+}
+
+func (m *mockUserStore) UpdateUserRole(userID int, role types.Role) error {
+	return nil
+}
+
+func (m *mockUserStore) CreateUser(user types.User) error {
+	return nil
+}
+
+func (m *mockUserStore) GetUserByEmail(email string) (*types.User, error) {
+	return &types.User{}, nil
+}
@@ -0,0 +1,284 @@
+package warehouse
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.WarehouseStore {
+	return &Store{db: tx}
+}
+
+// warehouseColumns lists the columns read by scanRowsIntoWarehouse, in order.
+const warehouseColumns = "id, code, name, fullName, line1, line2, city, state, postalCode, country, phone, isDefault, createdAt"
+
+func (s *Store) GetWarehouses() ([]types.Warehouse, error) {
+	rows, err := s.db.Query("SELECT " + warehouseColumns + " FROM warehouses ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := make([]types.Warehouse, 0)
+	for rows.Next() {
+		w, err := scanRowsIntoWarehouse(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		warehouses = append(warehouses, *w)
+	}
+
+	return warehouses, rows.Err()
+}
+
+func (s *Store) GetWarehouse(id int) (*types.Warehouse, error) {
+	rows, err := s.db.Query("SELECT "+warehouseColumns+" FROM warehouses WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("warehouse %d %w", id, types.ErrNotFound)
+	}
+
+	return scanRowsIntoWarehouse(rows)
+}
+
+func (s *Store) CreateWarehouse(w types.Warehouse) (int, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO warehouses (code, name, fullName, line1, line2, city, state, postalCode, country, phone, isDefault) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		w.Code, w.Name, w.Address.FullName, w.Address.Line1, w.Address.Line2, w.Address.City, w.Address.State, w.Address.PostalCode, w.Address.Country, w.Address.Phone, w.IsDefault,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if w.IsDefault {
+		if err := s.clearDefault(int(id)); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), nil
+}
+
+func (s *Store) UpdateWarehouse(w types.Warehouse) error {
+	_, err := s.db.Exec(
+		"UPDATE warehouses SET code = ?, name = ?, fullName = ?, line1 = ?, line2 = ?, city = ?, state = ?, postalCode = ?, country = ?, phone = ?, isDefault = ? WHERE id = ?",
+		w.Code, w.Name, w.Address.FullName, w.Address.Line1, w.Address.Line2, w.Address.City, w.Address.State, w.Address.PostalCode, w.Address.Country, w.Address.Phone, w.IsDefault, w.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if w.IsDefault {
+		return s.clearDefault(w.ID)
+	}
+
+	return nil
+}
+
+// clearDefault leaves warehouseID as the only default warehouse.
+func (s *Store) clearDefault(warehouseID int) error {
+	_, err := s.db.Exec("UPDATE warehouses SET isDefault = FALSE WHERE id <> ? AND isDefault", warehouseID)
+	return err
+}
+
+func (s *Store) GetInventoryLevels(productIDs []int) ([]types.InventoryLevel, error) {
+	levels := make([]types.InventoryLevel, 0)
+	if len(productIDs) == 0 {
+		return levels, nil
+	}
+
+	args := make([]any, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	rows, err := s.db.Query(
+		"SELECT warehouseId, productId, variantId, quantity FROM inventory_levels WHERE quantity > 0 AND productId IN (?"+placeholders+") ORDER BY warehouseId",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l types.InventoryLevel
+		var variantID sql.NullInt64
+		if err := rows.Scan(&l.WarehouseID, &l.ProductID, &variantID, &l.Quantity); err != nil {
+			return nil, err
+		}
+
+		if variantID.Valid {
+			id := int(variantID.Int64)
+			l.VariantID = &id
+		}
+
+		levels = append(levels, l)
+	}
+
+	return levels, rows.Err()
+}
+
+func (s *Store) GetAvailability(productIDs []int) (map[int][]types.WarehouseStock, error) {
+	availability := make(map[int][]types.WarehouseStock, len(productIDs))
+	if len(productIDs) == 0 {
+		return availability, nil
+	}
+
+	args := make([]any, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.Repeat(",?", len(productIDs)-1)
+	rows, err := s.db.Query(
+		"SELECT l.productId, w.id, w.code, SUM(l.quantity) FROM inventory_levels l JOIN warehouses w ON w.id = l.warehouseId"+
+			" WHERE l.quantity > 0 AND l.productId IN (?"+placeholders+")"+
+			" GROUP BY l.productId, w.id, w.code ORDER BY w.code",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID int
+		var stock types.WarehouseStock
+		if err := rows.Scan(&productID, &stock.WarehouseID, &stock.Warehouse, &stock.Quantity); err != nil {
+			return nil, err
+		}
+
+		availability[productID] = append(availability[productID], stock)
+	}
+
+	return availability, rows.Err()
+}
+
+func (s *Store) CreateAllocations(allocations []types.OrderAllocation) error {
+	if len(allocations) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(allocations)*4)
+	for _, a := range allocations {
+		args = append(args, a.OrderID, a.OrderItemID, a.WarehouseID, a.Quantity)
+	}
+
+	_, err := s.db.Exec(
+		"INSERT INTO order_allocations (orderId, orderItemId, warehouseId, quantity) VALUES (?, ?, ?, ?)"+strings.Repeat(", (?, ?, ?, ?)", len(allocations)-1),
+		args...,
+	)
+	return err
+}
+
+func (s *Store) GetAllocations(orderID int) ([]types.OrderAllocation, error) {
+	rows, err := s.db.Query(
+		"SELECT id, orderId, orderItemId, warehouseId, quantity, createdAt FROM order_allocations WHERE orderId = ? ORDER BY id",
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := make([]types.OrderAllocation, 0)
+	for rows.Next() {
+		var a types.OrderAllocation
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.OrderItemID, &a.WarehouseID, &a.Quantity, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		allocations = append(allocations, a)
+	}
+
+	return allocations, rows.Err()
+}
+
+func (s *Store) GetPickingList(warehouseID int) ([]types.PickingListItem, error) {
+	rows, err := s.db.Query(
+		"SELECT a.orderId, a.orderItemId, i.productId, i.variantId, p.name, COALESCE(v.sku, ''), a.quantity"+
+			" FROM order_allocations a"+
+			" JOIN orders o ON o.id = a.orderId"+
+			" JOIN order_items i ON i.id = a.orderItemId"+
+			" JOIN products p ON p.id = i.productId"+
+			" LEFT JOIN product_variants v ON v.id = i.variantId"+
+			" WHERE a.warehouseId = ? AND o.status = ?"+
+			" ORDER BY a.orderId, a.id",
+		warehouseID, types.OrderStatusPaid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]types.PickingListItem, 0)
+	for rows.Next() {
+		var item types.PickingListItem
+		var variantID sql.NullInt64
+		err := rows.Scan(&item.OrderID, &item.OrderItemID, &item.ProductID, &variantID, &item.ProductName, &item.VariantSKU, &item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		if variantID.Valid {
+			id := int(variantID.Int64)
+			item.VariantID = &id
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func scanRowsIntoWarehouse(rows *sql.Rows) (*types.Warehouse, error) {
+	w := new(types.Warehouse)
+
+	err := rows.Scan(
+		&w.ID,
+		&w.Code,
+		&w.Name,
+		&w.Address.FullName,
+		&w.Address.Line1,
+		&w.Address.Line2,
+		&w.Address.City,
+		&w.Address.State,
+		&w.Address.PostalCode,
+		&w.Address.Country,
+		&w.Address.Phone,
+		&w.IsDefault,
+		&w.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
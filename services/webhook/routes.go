@@ -0,0 +1,278 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+	"github.com/sikozonpc/ecom/utils"
+)
+
+const (
+	paymentsSource = "payments"
+	// maxBodyBytes keeps payloads within the TEXT column they are stored in.
+	maxBodyBytes = 64 << 10
+)
+
+// eventStatuses maps the payment event types to the status the payment
+// moved to.
+var eventStatuses = map[string]types.PaymentStatus{
+	"payment.authorized": types.PaymentAuthorized,
+	"payment.captured":   types.PaymentCaptured,
+	"payment.declined":   types.PaymentDeclined,
+	"payment.failed":     types.PaymentFailed,
+	"payment.voided":     types.PaymentVoided,
+	"payment.refunded":   types.PaymentRefunded,
+}
+
+type Handler struct {
+	store        types.WebhookEventStore
+	orderStore   types.OrderStore
+	ledger       types.StockLedger
+	couponStore  types.CouponStore
+	reservations types.ReservationStore
+	paymentStore types.PaymentStore
+	gateway      types.PaymentGateway
+	settler      types.PaymentSettler
+	secret       []byte
+	transactor   types.Transactor
+}
+
+// Deps are the stores and services the payment webhook works with.
+type Deps struct {
+	EventStore     types.WebhookEventStore
+	OrderStore     types.OrderStore
+	Ledger         types.StockLedger
+	CouponStore    types.CouponStore
+	Reservations   types.ReservationStore
+	PaymentStore   types.PaymentStore
+	PaymentGateway types.PaymentGateway
+	PaymentSettler types.PaymentSettler
+	Secret         []byte
+	Transactor     types.Transactor
+}
+
+func NewHandler(deps Deps) *Handler {
+	return &Handler{
+		store:        deps.EventStore,
+		orderStore:   deps.OrderStore,
+		ledger:       deps.Ledger,
+		couponStore:  deps.CouponStore,
+		reservations: deps.Reservations,
+		paymentStore: deps.PaymentStore,
+		gateway:      deps.PaymentGateway,
+		settler:      deps.PaymentSettler,
+		secret:       deps.Secret,
+		transactor:   deps.Transactor,
+	}
+}
+
+// RegisterRoutes serves the webhook only when there is a secret to check the
+// signatures against; a well-known or empty key would let anyone forge
+// payments.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	if len(h.secret) == 0 {
+		return
+	}
+
+	// the gateway proves who it is with the signature, not a user token
+	router.HandleFunc("/webhooks/payments", h.handlePaymentEvent).Methods(http.MethodPost)
+}
+
+// handlePaymentEvent applies an event reported by the payment gateway to the
+// payment and its order. Events are recorded in the same transaction, so a
+// redelivered event is acknowledged without being applied twice, and an
+// event that fails can be delivered again.
+func (h *Handler) handlePaymentEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(body) > maxBodyBytes {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("payload larger than %d bytes", maxBodyBytes))
+		return
+	}
+
+	if err := VerifySignature(h.secret, body, r.Header.Get(SignatureHeader)); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var event types.PaymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(event); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		return
+	}
+
+	status, ok := eventStatuses[event.Type]
+	if !ok {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown event type %q", event.Type))
+		return
+	}
+
+	var (
+		current *types.Order
+		settled *types.Payment
+	)
+	duplicate := false
+	err = h.transactor.WithinTx(func(tx *sql.Tx) error {
+		err := h.store.WithTx(tx).RecordWebhookEvent(paymentsSource, event.ID, event.Type, body)
+		if errors.Is(err, types.ErrConflict) {
+			duplicate = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		current, err = h.orderStore.WithTx(tx).GetOrder(event.OrderID)
+		if err != nil {
+			return err
+		}
+
+		p, err := h.recordPayment(tx, current, event.Reference, status)
+		if err != nil {
+			return err
+		}
+
+		settled, err = h.applyPaymentStatus(tx, current, p, status, event.ID)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+			utils.WriteError(w, http.StatusNotFound, err)
+		case errors.Is(err, types.ErrConflict):
+			utils.WriteError(w, http.StatusConflict, err)
+		default:
+			utils.WriteError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if duplicate {
+		utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"event_id": event.ID, "duplicate": true})
+		return
+	}
+
+	// the event is recorded either way, so a failure here is left to the
+	// background retries rather than reported to the gateway
+	if settled != nil {
+		if err := h.settler.Settle(r.Context(), settled.ID); err != nil {
+			log.Printf("failed to settle payment %d of order %d, it will be retried: %v", settled.ID, current.ID, err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"event_id": event.ID, "order_status": current.Status})
+}
+
+// recordPayment moves the payment the event is about to status. Events about
+// a payment checkout never recorded fail with ErrNotFound, so a reference
+// can't add payments to an order. Events without a reference leave the
+// payments alone and return nil.
+func (h *Handler) recordPayment(tx *sql.Tx, o *types.Order, reference string, status types.PaymentStatus) (*types.Payment, error) {
+	if reference == "" {
+		return nil, nil
+	}
+
+	paymentStore := h.paymentStore.WithTx(tx)
+
+	p, err := paymentStore.GetPaymentByReference(h.gateway.Name(), reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.OrderID != o.ID {
+		return nil, fmt.Errorf("payment %s belongs to order %d, not %d: %w", reference, p.OrderID, o.ID, types.ErrConflict)
+	}
+
+	if err := paymentStore.UpdatePaymentStatus(p.ID, status); err != nil {
+		return nil, err
+	}
+
+	p.Status = status
+	return p, nil
+}
+
+// applyPaymentStatus moves the order along with its payment: a payment held
+// or collected pays a pending order, one that fell through cancels it and
+// releases its stock, and a refund refunds what is left of it, cancelling it
+// and restocking its units when it hadn't shipped yet. Money taken for an
+// order that was cancelled in the meantime is given back. Events that don't
+// change where the order stands, such as a failed retry on a paid order, are
+// only recorded. It returns the payment left with gateway calls to make once
+// tx commits, if any.
+func (h *Handler) applyPaymentStatus(tx *sql.Tx, o *types.Order, p *types.Payment, status types.PaymentStatus, eventID string) (*types.Payment, error) {
+	orderStore := h.orderStore.WithTx(tx)
+	note := fmt.Sprintf("payment %s (event %s)", status, eventID)
+
+	switch status {
+	case types.PaymentAuthorized, types.PaymentCaptured:
+		switch {
+		case o.Status == types.OrderStatusPending:
+			return nil, order.Transition(orderStore, o, types.OrderStatusPaid, nil, note)
+		case o.Status == types.OrderStatusCancelled && p != nil:
+			if err := payment.GiveBack(h.paymentStore.WithTx(tx), p); err != nil {
+				return nil, err
+			}
+
+			if p.Status == types.PaymentRefunded {
+				if _, err := orderStore.RecordRefund(types.Refund{OrderID: o.ID, PaymentID: &p.ID, Amount: p.Amount}); err != nil {
+					return nil, err
+				}
+			}
+
+			return p, nil
+		}
+
+	case types.PaymentDeclined, types.PaymentFailed, types.PaymentVoided:
+		// a voided authorization no longer pays for the order
+		if o.Status == types.OrderStatusPending || status == types.PaymentVoided && o.Status == types.OrderStatusPaid {
+			return nil, order.Cancel(orderStore, h.ledger.WithTx(tx), h.couponStore.WithTx(tx), h.reservations.WithTx(tx), o, nil, note)
+		}
+
+	case types.PaymentRefunded:
+		// an order that hasn't shipped is cancelled instead, so its units go
+		// back in stock
+		unshipped := o.Status == types.OrderStatusPaid || o.Status == types.OrderStatusFulfilled
+		if !unshipped && order.CanTransition(o.Status, types.OrderStatusRefunded) != nil {
+			return nil, nil
+		}
+
+		if left := o.Total.Sub(o.Refunded); left.IsPositive() {
+			refund := types.Refund{OrderID: o.ID, Amount: left}
+			if p != nil {
+				refund.PaymentID = &p.ID
+			}
+
+			if _, err := orderStore.RecordRefund(refund); err != nil {
+				return nil, err
+			}
+		}
+
+		if unshipped {
+			return nil, order.Cancel(orderStore, h.ledger.WithTx(tx), h.couponStore.WithTx(tx), h.reservations.WithTx(tx), o, nil, note)
+		}
+
+		return nil, order.Transition(orderStore, o, types.OrderStatusRefunded, nil, note)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,643 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sikozonpc/ecom/services/order"
+	"github.com/sikozonpc/ecom/services/payment"
+	"github.com/sikozonpc/ecom/types"
+)
+
+var secret = []byte("webhook-secret")
+
+func dollars(n int64) types.Money {
+	return types.NewMoney(n*100, types.DefaultCurrency)
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	type fixture struct {
+		server       *httptest.Server
+		events       *mockWebhookEventStore
+		orderStore   *mockOrderStore
+		ledger       *mockLedger
+		paymentStore *mockPaymentStore
+		gateway      *mockPaymentGateway
+	}
+
+	// order 1 is pending with an authorization recorded at checkout, order 2
+	// was cancelled after its payment failed and order 3 is paid
+	setup := func(t *testing.T) fixture {
+		f := fixture{
+			events: &mockWebhookEventStore{events: map[string]bool{}},
+			orderStore: &mockOrderStore{
+				orders: []types.Order{
+					{ID: 1, Total: dollars(30), Status: types.OrderStatusPending},
+					{ID: 2, Total: dollars(20), Status: types.OrderStatusCancelled},
+					{ID: 3, Total: dollars(10), Status: types.OrderStatusPaid},
+				},
+				items: map[int][]types.OrderItemDetail{
+					1: {{OrderItem: types.OrderItem{OrderID: 1, ProductID: 7, Quantity: 3}}},
+				},
+			},
+			ledger: &mockLedger{},
+			paymentStore: &mockPaymentStore{payments: []types.Payment{
+				{ID: 1, OrderID: 1, Provider: "mock", Reference: "auth_1", Amount: dollars(30), Status: types.PaymentAuthorized},
+				{ID: 2, OrderID: 3, Provider: "mock", Reference: "auth_3", Amount: dollars(10), Status: types.PaymentCaptured},
+				{ID: 3, OrderID: 2, Provider: "mock", Reference: "auth_2", Amount: dollars(20), Status: types.PaymentFailed},
+			}},
+			gateway: &mockPaymentGateway{},
+		}
+
+		settler, err := payment.NewSettler(f.paymentStore, f.gateway, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			EventStore:     f.events,
+			OrderStore:     f.orderStore,
+			Ledger:         f.ledger,
+			CouponStore:    &mockCouponStore{},
+			Reservations:   &mockReservationStore{},
+			PaymentStore:   f.paymentStore,
+			PaymentGateway: f.gateway,
+			PaymentSettler: settler,
+			Secret:         secret,
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(router)
+		f.server = httptest.NewServer(router)
+		t.Cleanup(f.server.Close)
+
+		return f
+	}
+
+	post := func(t *testing.T, f fixture, body []byte, signature string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, f.server.URL+"/webhooks/payments", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(SignatureHeader, signature)
+
+		res, err := f.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+
+		return res
+	}
+
+	send := func(t *testing.T, f fixture, event types.PaymentEvent) *http.Response {
+		body, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return post(t, f, body, Sign(secret, body))
+	}
+
+	t.Run("should reject events that aren't signed with the secret", func(t *testing.T) {
+		f := setup(t)
+		body := []byte(`{"id": "evt_1", "type": "payment.captured", "orderID": 1, "reference": "auth_1"}`)
+
+		for _, signature := range []string{"", Sign([]byte("guess"), body)} {
+			if res := post(t, f, body, signature); res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, res.StatusCode)
+			}
+		}
+
+		if len(f.events.events) != 0 || f.orderStore.orders[0].Status != types.OrderStatusPending {
+			t.Errorf("expected the event to be ignored")
+		}
+	})
+
+	t.Run("should not serve the webhook without a secret", func(t *testing.T) {
+		router := mux.NewRouter()
+		NewHandler(Deps{
+			EventStore:     &mockWebhookEventStore{},
+			OrderStore:     &mockOrderStore{},
+			Ledger:         &mockLedger{},
+			CouponStore:    &mockCouponStore{},
+			Reservations:   &mockReservationStore{},
+			PaymentStore:   &mockPaymentStore{},
+			PaymentGateway: &mockPaymentGateway{},
+			Transactor:     &mockTransactor{},
+		}).RegisterRoutes(router)
+
+		body := []byte(`{"id": "evt_1", "type": "payment.captured", "orderID": 1, "reference": "auth_1"}`)
+		req, err := http.NewRequest(http.MethodPost, "/webhooks/payments", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(SignatureHeader, Sign(nil, body))
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+
+	t.Run("should pay a pending order once however often the event is delivered", func(t *testing.T) {
+		f := setup(t)
+		event := types.PaymentEvent{ID: "evt_1", Type: "payment.captured", OrderID: 1, Reference: "auth_1"}
+
+		for i := 0; i < 2; i++ {
+			res := send(t, f, event)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+			}
+
+			var body struct {
+				Duplicate bool `json:"duplicate"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Duplicate != (i == 1) {
+				t.Errorf("delivery %d: expected duplicate to be %v", i+1, i == 1)
+			}
+		}
+
+		if f.orderStore.orders[0].Status != types.OrderStatusPaid || len(f.orderStore.history) != 1 {
+			t.Errorf("expected the order to be paid once, got %s with history %+v", f.orderStore.orders[0].Status, f.orderStore.history)
+		}
+
+		if change := f.orderStore.history[0]; change.ActorID != nil || change.Note != "payment captured (event evt_1)" {
+			t.Errorf("expected the system to pay the order, got %+v", change)
+		}
+
+		if f.paymentStore.payments[0].Status != types.PaymentCaptured {
+			t.Errorf("expected the payment to be captured, got %s", f.paymentStore.payments[0].Status)
+		}
+	})
+
+	t.Run("should cancel a pending order and release its stock when the payment fails", func(t *testing.T) {
+		f := setup(t)
+
+		if res := send(t, f, types.PaymentEvent{ID: "evt_1", Type: "payment.declined", OrderID: 1, Reference: "auth_1"}); res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+		}
+
+		if order := f.orderStore.orders[0]; order.Status != types.OrderStatusCancelled || order.CancelledBy != nil {
+			t.Errorf("expected the system to cancel the order, got %+v", order)
+		}
+
+		if len(f.ledger.movements) != 1 || f.ledger.movements[0].ProductID != 7 || f.ledger.movements[0].Change != 3 {
+			t.Errorf("expected 3 units of product 7 to be restocked, got %+v", f.ledger.movements)
+		}
+	})
+
+	t.Run("should void a late authorization of a cancelled order", func(t *testing.T) {
+		f := setup(t)
+
+		if res := send(t, f, types.PaymentEvent{ID: "evt_1", Type: "payment.authorized", OrderID: 2, Reference: "auth_2"}); res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+		}
+
+		if f.orderStore.orders[1].Status != types.OrderStatusCancelled {
+			t.Errorf("expected the order to stay cancelled, got %s", f.orderStore.orders[1].Status)
+		}
+
+		want := types.Payment{ID: 3, OrderID: 2, Provider: "mock", Reference: "auth_2", Amount: dollars(20), Status: types.PaymentVoided}
+		if len(f.paymentStore.payments) != 3 || f.paymentStore.payments[2] != want {
+			t.Errorf("expected %+v to be recorded, got %+v", want, f.paymentStore.payments)
+		}
+
+		if len(f.gateway.voided) != 1 || f.gateway.voided[0] != "auth_2" {
+			t.Errorf("expected auth_2 to be voided, got %v", f.gateway.voided)
+		}
+
+		if ops := f.paymentStore.operations; len(ops) != 1 || ops[0].Type != types.PaymentOperationVoid || ops[0].Status != types.PaymentOperationSucceeded {
+			t.Errorf("expected the void to be recorded as made, got %+v", ops)
+		}
+	})
+
+	t.Run("should cancel and restock a paid order refunded before it shipped", func(t *testing.T) {
+		f := setup(t)
+		f.ledger.movements = []types.StockMovement{{ProductID: 9, Change: -2, Reason: types.StockSale, Reference: order.Reference(3)}}
+
+		if res := send(t, f, types.PaymentEvent{ID: "evt_1", Type: "payment.refunded", OrderID: 3, Reference: "auth_3"}); res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+		}
+
+		if f.orderStore.orders[2].Status != types.OrderStatusCancelled || f.paymentStore.payments[1].Status != types.PaymentRefunded {
+			t.Errorf("expected the order to be cancelled and its payment refunded, got %s and %s", f.orderStore.orders[2].Status, f.paymentStore.payments[1].Status)
+		}
+
+		if refunds := f.orderStore.refunds; len(refunds) != 1 || refunds[0].Amount != dollars(10) || *refunds[0].PaymentID != 2 {
+			t.Errorf("expected the whole order to be recorded as refunded, got %+v", refunds)
+		}
+
+		if len(f.ledger.movements) != 2 || f.ledger.movements[1].ProductID != 9 || f.ledger.movements[1].Change != 2 {
+			t.Errorf("expected the 2 units sold to be restocked, got %+v", f.ledger.movements)
+		}
+	})
+
+	t.Run("should refund a shipped order", func(t *testing.T) {
+		f := setup(t)
+		f.orderStore.orders[2].Status = types.OrderStatusShipped
+
+		if res := send(t, f, types.PaymentEvent{ID: "evt_1", Type: "payment.refunded", OrderID: 3, Reference: "auth_3"}); res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+		}
+
+		if f.orderStore.orders[2].Status != types.OrderStatusRefunded || len(f.orderStore.refunds) != 1 {
+			t.Errorf("expected the order to be refunded, got %s and %+v", f.orderStore.orders[2].Status, f.orderStore.refunds)
+		}
+
+		if len(f.ledger.movements) != 0 {
+			t.Errorf("expected units that went out not to be restocked, got %+v", f.ledger.movements)
+		}
+	})
+
+	t.Run("should reject events it can't apply", func(t *testing.T) {
+		f := setup(t)
+
+		for _, tc := range []struct {
+			event types.PaymentEvent
+			code  int
+		}{
+			{types.PaymentEvent{ID: "evt_1", Type: "payment.lost", OrderID: 1}, http.StatusBadRequest},
+			{types.PaymentEvent{Type: "payment.captured", OrderID: 1}, http.StatusBadRequest},
+			{types.PaymentEvent{ID: "evt_2", Type: "payment.captured", OrderID: 99}, http.StatusNotFound},
+			{types.PaymentEvent{ID: "evt_3", Type: "payment.captured", OrderID: 1, Reference: "auth_3"}, http.StatusConflict},
+			{types.PaymentEvent{ID: "evt_4", Type: "payment.captured", OrderID: 1, Reference: "auth_forged"}, http.StatusNotFound},
+		} {
+			if res := send(t, f, tc.event); res.StatusCode != tc.code {
+				t.Errorf("expected status code %d for %+v, got %d", tc.code, tc.event, res.StatusCode)
+			}
+		}
+
+		if len(f.paymentStore.payments) != 3 || f.orderStore.orders[0].Status != types.OrderStatusPending {
+			t.Errorf("expected no payment to be added and the order to stay pending, got %+v", f.paymentStore.payments)
+		}
+	})
+}
+
+// mockWebhookEventStore remembers the ids of the events recorded.
+type mockWebhookEventStore struct {
+	events map[string]bool
+}
+
+func (m *mockWebhookEventStore) RecordWebhookEvent(source string, eventID string, eventType string, payload []byte) error {
+	if m.events[eventID] {
+		return fmt.Errorf("webhook event %s was already received: %w", eventID, types.ErrConflict)
+	}
+
+	m.events[eventID] = true
+	return nil
+}
+
+func (m *mockWebhookEventStore) WithTx(tx *sql.Tx) types.WebhookEventStore {
+	return m
+}
+
+type mockPaymentStore struct {
+	payments   []types.Payment
+	operations []types.PaymentOperation
+}
+
+func (m *mockPaymentStore) GetPaymentsByOrderID(orderID int) ([]types.Payment, error) {
+	payments := []types.Payment{}
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m *mockPaymentStore) GetPaymentByID(paymentID int) (*types.Payment, error) {
+	for _, p := range m.payments {
+		if p.ID == paymentID {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %d %w", paymentID, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) GetPaymentByReference(provider string, reference string) (*types.Payment, error) {
+	for _, p := range m.payments {
+		if p.Provider == provider && p.Reference == reference {
+			return &p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("payment %s %w", reference, types.ErrNotFound)
+}
+
+func (m *mockPaymentStore) CreatePayment(p types.Payment) (int, error) {
+	p.ID = len(m.payments) + 1
+	m.payments = append(m.payments, p)
+	return p.ID, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentStatus(paymentID int, status types.PaymentStatus) error {
+	for i := range m.payments {
+		if m.payments[i].ID == paymentID {
+			m.payments[i].Status = status
+		}
+	}
+
+	return nil
+}
+
+func (m *mockPaymentStore) CreatePaymentOperation(op types.PaymentOperation) (int, error) {
+	op.ID = len(m.operations) + 1
+	op.Status = types.PaymentOperationPending
+	m.operations = append(m.operations, op)
+	return op.ID, nil
+}
+
+func (m *mockPaymentStore) GetPendingPaymentOperations(paymentID int) ([]types.PaymentOperation, error) {
+	ops := []types.PaymentOperation{}
+	for _, op := range m.operations {
+		if op.PaymentID == paymentID && op.Status == types.PaymentOperationPending {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops, nil
+}
+
+func (m *mockPaymentStore) GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (m *mockPaymentStore) ClaimPaymentOperation(op types.PaymentOperation) (bool, error) {
+	m.operations[op.ID-1].Attempts++
+	return true, nil
+}
+
+func (m *mockPaymentStore) UpdatePaymentOperation(operationID int, status types.PaymentOperationStatus, errMsg string) error {
+	m.operations[operationID-1].Status = status
+	m.operations[operationID-1].Error = errMsg
+	return nil
+}
+
+func (m *mockPaymentStore) WithTx(tx *sql.Tx) types.PaymentStore {
+	return m
+}
+
+// mockPaymentGateway keeps track of the payments given back.
+type mockPaymentGateway struct {
+	voided   []string
+	refunded []string
+}
+
+func (m *mockPaymentGateway) Name() string {
+	return "mock"
+}
+
+func (m *mockPaymentGateway) Authorize(ctx context.Context, orderID int, amount types.Money) (string, error) {
+	return fmt.Sprintf("auth_%d", orderID), nil
+}
+
+func (m *mockPaymentGateway) Capture(ctx context.Context, reference string, amount types.Money) error {
+	return nil
+}
+
+func (m *mockPaymentGateway) Refund(ctx context.Context, reference string, amount types.Money) error {
+	m.refunded = append(m.refunded, reference)
+	return nil
+}
+
+func (m *mockPaymentGateway) Void(ctx context.Context, reference string) error {
+	m.voided = append(m.voided, reference)
+	return nil
+}
+
+type mockOrderStore struct {
+	orders  []types.Order
+	items   map[int][]types.OrderItemDetail
+	history []types.OrderStatusChange
+	refunds []types.Refund
+}
+
+func (m *mockOrderStore) CreateOrder(order types.Order) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) CreateOrderItem(orderItem types.OrderItem) (int, error) {
+	return 0, nil
+}
+
+func (m *mockOrderStore) GetOrdersByUserID(userID int, limit int, offset int) ([]types.Order, int, error) {
+	return []types.Order{}, 0, nil
+}
+
+func (m *mockOrderStore) GetOrderByID(userID int, orderID int) (*types.Order, error) {
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrder(orderID int) (*types.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == orderID {
+			return &o, nil
+		}
+	}
+
+	return nil, fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) GetOrderItems(orderID int) ([]types.OrderItemDetail, error) {
+	return m.items[orderID], nil
+}
+
+func (m *mockOrderStore) UpdateOrderStatus(orderID int, from types.OrderStatus, to types.OrderStatus) error {
+	for i := range m.orders {
+		if m.orders[i].ID != orderID {
+			continue
+		}
+
+		if m.orders[i].Status != from {
+			return fmt.Errorf("order %d is no longer %s: %w", orderID, from, types.ErrConflict)
+		}
+
+		m.orders[i].Status = to
+		return nil
+	}
+
+	return fmt.Errorf("order %d %w", orderID, types.ErrNotFound)
+}
+
+func (m *mockOrderStore) RecordCancellation(orderID int, cancelledBy *int, reason string) error {
+	for i := range m.orders {
+		if m.orders[i].ID == orderID {
+			m.orders[i].CancelledBy = cancelledBy
+			m.orders[i].CancelReason = reason
+		}
+	}
+
+	return nil
+}
+
+func (m *mockOrderStore) CreateStatusChange(change types.OrderStatusChange) error {
+	m.history = append(m.history, change)
+	return nil
+}
+
+func (m *mockOrderStore) GetStatusHistory(orderID int) ([]types.OrderStatusChange, error) {
+	return m.history, nil
+}
+
+func (m *mockOrderStore) RecordRefund(refund types.Refund) (int, error) {
+	refund.ID = len(m.refunds) + 1
+	m.refunds = append(m.refunds, refund)
+
+	for i := range m.orders {
+		if m.orders[i].ID == refund.OrderID {
+			m.orders[i].Refunded = m.orders[i].Refunded.Add(refund.Amount)
+		}
+	}
+
+	return refund.ID, nil
+}
+
+func (m *mockOrderStore) GetRefunds(orderID int) ([]types.Refund, error) {
+	refunds := []types.Refund{}
+	for _, refund := range m.refunds {
+		if refund.OrderID == orderID {
+			refunds = append(refunds, refund)
+		}
+	}
+
+	return refunds, nil
+}
+
+func (m *mockOrderStore) WithTx(tx *sql.Tx) types.OrderStore {
+	return m
+}
+
+// mockLedger keeps the movements recorded, in order.
+type mockLedger struct {
+	movements []types.StockMovement
+}
+
+func (m *mockLedger) Move(movement types.StockMovement) error {
+	m.movements = append(m.movements, movement)
+	return nil
+}
+
+func (m *mockLedger) GetMovements(productID int) ([]types.StockMovement, error) {
+	return m.movements, nil
+}
+
+func (m *mockLedger) GetMovementsByReference(reference string) ([]types.StockMovement, error) {
+	movements := []types.StockMovement{}
+	for _, movement := range m.movements {
+		if movement.Reference == reference {
+			movements = append(movements, movement)
+		}
+	}
+
+	return movements, nil
+}
+
+func (m *mockLedger) Reconcile() ([]types.StockDiscrepancy, error) {
+	return []types.StockDiscrepancy{}, nil
+}
+
+func (m *mockLedger) WithTx(tx *sql.Tx) types.StockLedger {
+	return m
+}
+
+// mockCouponStore keeps the orders whose redemption was released.
+type mockCouponStore struct {
+	released []int
+}
+
+func (m *mockCouponStore) GetCoupons() ([]types.Coupon, error) {
+	return []types.Coupon{}, nil
+}
+
+func (m *mockCouponStore) GetCouponByID(couponID int) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %d %w", couponID, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) GetCouponByCode(code string) (*types.Coupon, error) {
+	return nil, fmt.Errorf("coupon %s %w", code, types.ErrNotFound)
+}
+
+func (m *mockCouponStore) CreateCoupon(c types.Coupon) (int, error) {
+	return 0, nil
+}
+
+func (m *mockCouponStore) UpdateCoupon(c types.Coupon) error {
+	return nil
+}
+
+func (m *mockCouponStore) DeleteCoupon(couponID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error) {
+	return productIDs, nil
+}
+
+func (m *mockCouponStore) RedeemCoupon(couponID int, userID int, orderID int) error {
+	return nil
+}
+
+func (m *mockCouponStore) ReleaseRedemption(orderID int) error {
+	m.released = append(m.released, orderID)
+	return nil
+}
+
+func (m *mockCouponStore) WithTx(tx *sql.Tx) types.CouponStore {
+	return m
+}
+
+// mockReservationStore keeps the orders whose reservations were released.
+type mockReservationStore struct {
+	released []int
+}
+
+func (m *mockReservationStore) GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (types.ReservedStock, error) {
+	return types.ReservedStock{}, nil
+}
+
+func (m *mockReservationStore) CreateReservations(reservations []types.StockReservation) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseCartReservations(userID int) error {
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseOrderReservations(orderID int) error {
+	m.released = append(m.released, orderID)
+	return nil
+}
+
+func (m *mockReservationStore) ReleaseExpiredCartReservations(now time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *mockReservationStore) GetExpiredOrderIDs(now time.Time, limit int) ([]int, error) {
+	return []int{}, nil
+}
+
+func (m *mockReservationStore) WithTx(tx *sql.Tx) types.ReservationStore {
+	return m
+}
+
+type mockTransactor struct{}
+
+func (m *mockTransactor) WithinTx(fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
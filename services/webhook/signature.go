@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SignatureHeader carries the signature of a webhook's body, written as
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the body.
+const SignatureHeader = "X-Signature"
+
+// Sign returns the value of SignatureHeader for body.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks that signature was made for body with secret.
+func VerifySignature(secret []byte, body []byte, signature string) error {
+	digest, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return fmt.Errorf("missing or malformed signature")
+	}
+
+	got, err := hex.DecodeString(digest)
+	if err != nil {
+		return fmt.Errorf("malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	// compare in constant time so the signature can't be guessed byte by byte
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
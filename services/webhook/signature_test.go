@@ -0,0 +1,29 @@
+package webhook
+
+import "testing"
+
+func TestVerifySignature(t *testing.T) {
+	secret, body := []byte("shh"), []byte(`{"id": "evt_1"}`)
+	signature := Sign(secret, body)
+
+	if err := VerifySignature(secret, body, signature); err != nil {
+		t.Errorf("expected the signature to verify, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		secret    []byte
+		body      []byte
+		signature string
+	}{
+		{"another secret", []byte("other"), body, signature},
+		{"a changed body", secret, []byte(`{"id": "evt_2"}`), signature},
+		{"no signature", secret, body, ""},
+		{"no prefix", secret, body, signature[len("sha256="):]},
+		{"not hex", secret, body, "sha256=zz"},
+	} {
+		if err := VerifySignature(tc.secret, tc.body, tc.signature); err == nil {
+			t.Errorf("expected %s to fail verification", tc.name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/sikozonpc/ecom/types"
+)
+
+type Store struct {
+	db types.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) types.WebhookEventStore {
+	return &Store{db: tx}
+}
+
+func (s *Store) RecordWebhookEvent(source string, eventID string, eventType string, payload []byte) error {
+	// the unique key on (source, eventId) makes a concurrent delivery of the
+	// same event wait for this transaction, then be ignored
+	res, err := s.db.Exec(
+		"INSERT IGNORE INTO webhook_events (source, eventId, type, payload) VALUES (?, ?, ?, ?)",
+		source, eventID, eventType, payload,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("webhook event %s was already received: %w", eventID, types.ErrConflict)
+	}
+
+	return nil
+}
@@ -0,0 +1,419 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the base currency: exchange rates are quoted against it,
+// and it is what prices are shown and charged in unless a client asks for
+// another currency.
+const DefaultCurrency = "USD"
+
+// currencyExponents maps the ISO 4217 currencies we accept to the number of
+// decimal places of their minor unit.
+var currencyExponents = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"BRL": 2,
+	"CAD": 2,
+	"AUD": 2,
+	"CHF": 2,
+	"JPY": 0,
+}
+
+// Money is an exact amount in the minor unit of its currency, such as cents
+// for USD. Adding amounts and multiplying them by whole quantities is exact;
+// Scale and Convert are the only operations that round.
+//
+// In JSON it is written as {"amount": 1999, "currency": "USD"}, the amount
+// still in minor units.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// KnownCurrency reports whether currency is an ISO 4217 code we accept.
+func KnownCurrency(currency string) bool {
+	_, ok := currencyExponents[currency]
+	return ok
+}
+
+// ParseMoney reads a decimal amount in the major unit of the currency, like
+// "19.99" for USD. It refuses more decimal places than the currency has
+// rather than rounding them away.
+func ParseMoney(s string, currency string) (Money, error) {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency %q", currency)
+	}
+
+	amount, err := parseDecimal(s, exp)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Add returns m + o. The zero Money adds to an amount of any currency, so
+// totals can start from it; any other mix of currencies is a bug and panics.
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.sameCurrency(o)}
+}
+
+// Sub returns m - o, with the same currency rules as Add.
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.sameCurrency(o)}
+}
+
+// Mul returns m times a whole quantity.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// Scale returns m * num / den rounded to the nearest minor unit, halves away
+// from zero. It is meant for rates and percentages, e.g. Scale(15, 100).
+func (m Money) Scale(num int64, den int64) Money {
+	if den == 0 {
+		panic("money: scale by a zero denominator")
+	}
+
+	if den < 0 {
+		num, den = -num, -den
+	}
+
+	p := m.Amount * num
+	q, r := p/den, p%den
+	// the remainder is at least half of den: round the magnitude up
+	if 2*abs(r) >= den {
+		if p < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+
+	return Money{Amount: q, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Cmp compares two amounts of the same currency, returning -1, 0 or 1.
+func (m Money) Cmp(o Money) int {
+	m.sameCurrency(o)
+
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Decimal formats the amount in the major unit of its currency, like "19.99".
+func (m Money) Decimal() string {
+	exp := m.exponent()
+	if exp == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	unit := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Value stores the amount as a decimal, to fit the DECIMAL columns.
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+// Scan reads a DECIMAL column. The currency isn't part of the column, so it
+// is left alone if already set and defaults to DefaultCurrency otherwise.
+func (m *Money) Scan(src any) error {
+	if m.Currency == "" {
+		m.Currency = DefaultCurrency
+	}
+
+	switch v := src.(type) {
+	case []byte:
+		return m.scanDecimal(string(v))
+	case string:
+		return m.scanDecimal(v)
+	case int64:
+		m.Amount = v * int64(math.Pow10(m.exponent()))
+		return nil
+	case float64:
+		// only computed columns come back as floats; round them to the
+		// nearest minor unit
+		m.Amount = int64(math.Round(v * math.Pow10(m.exponent())))
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into money", src)
+	}
+}
+
+func (m *Money) scanDecimal(s string) error {
+	amount, err := parseDecimal(s, m.exponent())
+	if err != nil {
+		return err
+	}
+
+	m.Amount = amount
+	return nil
+}
+
+func (m Money) exponent() int {
+	if exp, ok := currencyExponents[m.Currency]; ok {
+		return exp
+	}
+
+	return 2
+}
+
+func (m Money) sameCurrency(o Money) string {
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency
+	case m == Money{}:
+		return o.Currency
+	case o == Money{}:
+		return m.Currency
+	default:
+		panic(fmt.Sprintf("money: mixing %s and %s", m.Currency, o.Currency))
+	}
+}
+
+// parseDecimal reads s as a count of 10^-exp units, failing if s has more
+// than exp decimal places that aren't zeros.
+func parseDecimal(s string, exp int) (int64, error) {
+	invalid := fmt.Errorf("invalid amount %q", s)
+
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" && frac == "" {
+		return 0, invalid
+	}
+
+	// zeros past the minor unit don't change the amount, as in "10.500"
+	if len(frac) > exp {
+		if strings.Trim(frac[exp:], "0") != "" {
+			return 0, fmt.Errorf("amount %q has more than %d decimal places", s, exp)
+		}
+		frac = frac[:exp]
+	}
+
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	if strings.Trim(digits, "0123456789") != "" {
+		return 0, invalid
+	}
+
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, invalid
+	}
+
+	if neg {
+		amount = -amount
+	}
+
+	return amount, nil
+}
+
+// ErrNoExchangeRate is returned (usually wrapped) when a price can't be
+// converted because a currency has no exchange rate.
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// rateDecimals is the precision of a Rate, matching the DECIMAL(18, 8)
+// columns rates are stored in.
+const rateDecimals = 8
+
+// Rate is an exchange rate with 8 decimal places, kept as an integer count of
+// 10^-8 so it round trips exactly through the database and JSON. In JSON it
+// is written as a decimal string, like "5.4321".
+type Rate int64
+
+// ParseRate reads a decimal rate like "0.92", refusing negative rates and
+// more than 8 decimal places. Whether a zero rate is acceptable is up to the
+// caller.
+func ParseRate(s string) (Rate, error) {
+	n, err := parseDecimal(s, rateDecimals)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, fmt.Errorf("rate %q can't be negative", s)
+	}
+
+	return Rate(n), nil
+}
+
+// oneRate is the rate of DefaultCurrency against itself.
+const oneRate = Rate(100_000_000)
+
+func (r Rate) String() string {
+	s := fmt.Sprintf("%d.%08d", r/oneRate, r%oneRate)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON accepts the rate as a string or as a plain JSON number, in
+// both cases without going through a float.
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+
+	*r = rate
+	return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into a rate", src)
+	}
+
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+
+	*r = rate
+	return nil
+}
+
+// Convert changes m into currency at rate, the units of currency one unit of
+// m's currency buys. The result is rounded to the minor unit of currency,
+// halves away from zero.
+func (m Money) Convert(currency string, rate Rate) Money {
+	to := Money{Currency: currency}
+
+	// amount * rate * 10^toExp / (10^8 * 10^fromExp), in big integers as the
+	// intermediate product easily overflows an int64
+	num := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(int64(rate)))
+	num.Mul(num, pow10(to.exponent()))
+	den := new(big.Int).Mul(big.NewInt(int64(oneRate)), pow10(m.exponent()))
+
+	to.Amount = roundDiv(num, den).Int64()
+	return to
+}
+
+// ExchangeRates maps currencies to how many units of them one unit of
+// DefaultCurrency buys. DefaultCurrency itself is always at 1.
+type ExchangeRates map[string]Rate
+
+func (r ExchangeRates) rate(currency string) (Rate, error) {
+	if currency == DefaultCurrency {
+		return oneRate, nil
+	}
+
+	rate, ok := r[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w for %s", ErrNoExchangeRate, currency)
+	}
+
+	return rate, nil
+}
+
+// CrossRate returns how many units of to one unit of from buys, rounded to 8
+// decimal places. Conversions use this rounded rate, so storing it is enough
+// to reproduce them.
+func (r ExchangeRates) CrossRate(from string, to string) (Rate, error) {
+	fromRate, err := r.rate(from)
+	if err != nil {
+		return 0, err
+	}
+
+	toRate, err := r.rate(to)
+	if err != nil {
+		return 0, err
+	}
+
+	if from == to {
+		return oneRate, nil
+	}
+
+	num := new(big.Int).Mul(big.NewInt(int64(toRate)), big.NewInt(int64(oneRate)))
+	return Rate(roundDiv(num, big.NewInt(int64(fromRate))).Int64()), nil
+}
+
+// Convert changes m into currency, returning the cross rate it used.
+func (r ExchangeRates) Convert(m Money, currency string) (Money, Rate, error) {
+	rate, err := r.CrossRate(m.Currency, currency)
+	if err != nil {
+		return Money{}, 0, err
+	}
+
+	return m.Convert(currency, rate), rate, nil
+}
+
+// roundDiv divides num by a positive den, rounding halves away from zero.
+func roundDiv(num *big.Int, den *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+
+	r.Abs(r).Mul(r, big.NewInt(2))
+	if r.Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+
+	return q
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,306 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+// amounts are kept well inside int64 so sums and products can't overflow
+func smallAmount(n int64) int64 {
+	return n % 1_000_000_000
+}
+
+func TestMoneyDecimalRoundTrip(t *testing.T) {
+	for currency := range currencyExponents {
+		roundTrip := func(n int64) bool {
+			m := NewMoney(smallAmount(n), currency)
+
+			parsed, err := ParseMoney(m.Decimal(), currency)
+			return err == nil && parsed == m
+		}
+
+		if err := quick.Check(roundTrip, nil); err != nil {
+			t.Errorf("%s: %v", currency, err)
+		}
+	}
+}
+
+func TestMoneyScanValueRoundTrip(t *testing.T) {
+	roundTrip := func(n int64) bool {
+		m := NewMoney(smallAmount(n), DefaultCurrency)
+
+		v, err := m.Value()
+		if err != nil {
+			return false
+		}
+
+		var scanned Money
+		return scanned.Scan([]byte(v.(string))) == nil && scanned == m
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	roundTrip := func(n int64) bool {
+		m := NewMoney(n, "EUR")
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+
+		var decoded Money
+		return json.Unmarshal(b, &decoded) == nil && decoded == m
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMoneyTotalsAreExact adds up quantities of prices and compares the
+// result with the same sum done over the decimal strings with exact rational
+// arithmetic.
+func TestMoneyTotalsAreExact(t *testing.T) {
+	exact := func(prices []int64, quantities []uint8) bool {
+		var total Money
+		want := new(big.Rat)
+
+		for i, p := range prices {
+			price := NewMoney(smallAmount(p), DefaultCurrency)
+			quantity := 1
+			if i < len(quantities) {
+				quantity = int(quantities[i])
+			}
+
+			total = total.Add(price.Mul(quantity))
+
+			r, ok := new(big.Rat).SetString(price.Decimal())
+			if !ok {
+				return false
+			}
+			want.Add(want, r.Mul(r, big.NewRat(int64(quantity), 1)))
+		}
+
+		got, ok := new(big.Rat).SetString(total.Decimal())
+		return ok && got.Cmp(want) == 0
+	}
+
+	if err := quick.Check(exact, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMoneyAddIsCommutativeAndAssociative(t *testing.T) {
+	laws := func(a, b, c int64) bool {
+		x := NewMoney(smallAmount(a), DefaultCurrency)
+		y := NewMoney(smallAmount(b), DefaultCurrency)
+		z := NewMoney(smallAmount(c), DefaultCurrency)
+
+		return x.Add(y) == y.Add(x) &&
+			x.Add(y).Add(z) == x.Add(y.Add(z)) &&
+			x.Add(y).Sub(y) == x
+	}
+
+	if err := quick.Check(laws, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMoneyScaleRounding checks Scale against exact rational arithmetic:
+// the result is never more than half a minor unit away, and exact halves
+// round away from zero.
+func TestMoneyScaleRounding(t *testing.T) {
+	rounding := func(n int64, num int16, den uint16) bool {
+		if den == 0 {
+			return true
+		}
+
+		m := NewMoney(smallAmount(n), DefaultCurrency)
+		got := m.Scale(int64(num), int64(den))
+
+		exact := big.NewRat(m.Amount*int64(num), int64(den))
+		diff := new(big.Rat).Sub(exact, new(big.Rat).SetInt64(got.Amount))
+
+		half := big.NewRat(1, 2)
+		switch diff.Abs(diff).Cmp(half) {
+		case 1:
+			return false
+		case 0:
+			return new(big.Rat).SetInt64(abs(got.Amount)).Cmp(new(big.Rat).Abs(exact)) > 0
+		default:
+			return true
+		}
+	}
+
+	if err := quick.Check(rounding, nil); err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		amount, num, den, want int64
+	}{
+		{250, 1, 100, 3},
+		{-250, 1, 100, -3},
+		{249, 1, 100, 2},
+		{1999, 15, 100, 300},
+		{1000, 1, 3, 333},
+	}
+
+	for _, c := range cases {
+		if got := NewMoney(c.amount, DefaultCurrency).Scale(c.num, c.den); got.Amount != c.want {
+			t.Errorf("%d * %d / %d: expected %d, got %d", c.amount, c.num, c.den, c.want, got.Amount)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		s        string
+		currency string
+		want     int64
+		ok       bool
+	}{
+		{"19.99", "USD", 1999, true},
+		{"19.9", "USD", 1990, true},
+		{"19", "USD", 1900, true},
+		{".5", "USD", 50, true},
+		{"-0.01", "USD", -1, true},
+		{"10.500", "USD", 1050, true},
+		{"0.001", "USD", 0, false},
+		{"1e3", "USD", 0, false},
+		{"", "USD", 0, false},
+		{"abc", "USD", 0, false},
+		{"500", "JPY", 500, true},
+		{"500.5", "JPY", 0, false},
+		{"1", "XXX", 0, false},
+	}
+
+	for _, c := range cases {
+		m, err := ParseMoney(c.s, c.currency)
+		if (err == nil) != c.ok || (c.ok && m.Amount != c.want) {
+			t.Errorf("ParseMoney(%q, %s): expected %d (ok: %v), got %d, %v", c.s, c.currency, c.want, c.ok, m.Amount, err)
+		}
+	}
+}
+
+func TestMoneyRefusesToMixCurrencies(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected adding USD to EUR to panic")
+		}
+	}()
+
+	NewMoney(100, "USD").Add(NewMoney(100, "EUR"))
+}
+
+func TestRateRoundTrip(t *testing.T) {
+	roundTrip := func(n uint32, frac uint32) bool {
+		r := Rate(int64(n)*int64(oneRate) + int64(frac)%int64(oneRate))
+
+		b, err := json.Marshal(r)
+		if err != nil {
+			return false
+		}
+
+		var decoded, scanned Rate
+		return json.Unmarshal(b, &decoded) == nil && decoded == r &&
+			scanned.Scan([]byte(r.String())) == nil && scanned == r
+	}
+
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	var r Rate
+	if err := json.Unmarshal([]byte(`5.4321`), &r); err != nil || r.String() != "5.4321" {
+		t.Errorf("expected a plain number to be read as 5.4321, got %s, %v", r, err)
+	}
+}
+
+// TestMoneyConvertRounding checks Convert against exact rational arithmetic,
+// going between currencies with different minor units.
+func TestMoneyConvertRounding(t *testing.T) {
+	rounding := func(n int64, rate uint32) bool {
+		if rate == 0 {
+			return true
+		}
+
+		m := NewMoney(smallAmount(n), "USD")
+		got := m.Convert("JPY", Rate(rate))
+
+		// cents * rate / 10^8 / 100 yen
+		exact := new(big.Rat).SetFrac(
+			new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(int64(rate))),
+			big.NewInt(int64(oneRate)*100),
+		)
+		diff := new(big.Rat).Sub(exact, new(big.Rat).SetInt64(got.Amount))
+
+		return got.Currency == "JPY" && diff.Abs(diff).Cmp(big.NewRat(1, 2)) <= 0
+	}
+
+	if err := quick.Check(rounding, nil); err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		from Money
+		to   string
+		rate string
+		want Money
+	}{
+		{NewMoney(1000, "USD"), "EUR", "0.92", NewMoney(920, "EUR")},
+		{NewMoney(1999, "USD"), "BRL", "5.4321", NewMoney(10859, "BRL")},
+		{NewMoney(1000, "USD"), "JPY", "149.5", NewMoney(1495, "JPY")},
+		{NewMoney(1495, "JPY"), "USD", "0.00668896", NewMoney(1000, "USD")},
+		{NewMoney(-250, "USD"), "EUR", "0.5", NewMoney(-125, "EUR")},
+		{NewMoney(5, "USD"), "EUR", "0.5", NewMoney(3, "EUR")},
+	}
+
+	for _, c := range cases {
+		rate, err := ParseRate(c.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.from.Convert(c.to, rate); got != c.want {
+			t.Errorf("%s at %s: expected %s, got %s", c.from, c.rate, c.want, got)
+		}
+	}
+}
+
+func TestExchangeRates(t *testing.T) {
+	rates := ExchangeRates{"EUR": 92_000_000, "BRL": 540_000_000}
+
+	cases := []struct {
+		from, to string
+		want     string
+	}{
+		{"USD", "USD", "1"},
+		{"USD", "EUR", "0.92"},
+		{"EUR", "USD", "1.08695652"},
+		{"EUR", "BRL", "5.86956522"},
+		{"BRL", "BRL", "1"},
+	}
+
+	for _, c := range cases {
+		rate, err := rates.CrossRate(c.from, c.to)
+		if err != nil || rate.String() != c.want {
+			t.Errorf("%s to %s: expected %s, got %s, %v", c.from, c.to, c.want, rate, err)
+		}
+	}
+
+	if _, _, err := rates.Convert(NewMoney(100, "GBP"), "USD"); !errors.Is(err, ErrNoExchangeRate) {
+		t.Errorf("expected converting from GBP to fail with ErrNoExchangeRate, got %v", err)
+	}
+
+	if _, err := rates.CrossRate("USD", "JPY"); !errors.Is(err, ErrNoExchangeRate) {
+		t.Errorf("expected converting to JPY to fail with ErrNoExchangeRate, got %v", err)
+	}
+}
@@ -1,77 +1,1258 @@
 package types
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
 	"time"
 )
 
+// ErrNotFound is returned (usually wrapped) by stores when the requested
+// record doesn't exist, so handlers can answer with a 404.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned (usually wrapped) by stores when the record exists
+// but its current state doesn't allow the requested change.
+var ErrConflict = errors.New("conflict")
+
+// ErrPaymentDeclined is returned (usually wrapped) by payment gateways when
+// the payment is refused, as opposed to the gateway failing to answer.
+var ErrPaymentDeclined = errors.New("payment declined")
+
+// Role decides which admin routes a user can reach. Every user registers as
+// a customer; staff and admins are promoted by an admin.
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleStaff    Role = "staff"
+	RoleAdmin    Role = "admin"
+)
+
 type User struct {
 	ID        int       `json:"id"`
 	FirstName string    `json:"firstName"`
 	LastName  string    `json:"lastName"`
 	Email     string    `json:"email"`
 	Password  string    `json:"-"`
+	Role      Role      `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
 type Product struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Image       string  `json:"image"`
-	Price       float64 `json:"price"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Price       Money  `json:"price"`
+	// TaxClass picks the tax rates that apply to the product, such as
+	// "standard" or "reduced".
+	TaxClass string `json:"taxClass"`
+	// Weight is the shipping weight of one unit, in grams.
+	Weight int `json:"weight"`
 	// note that this isn't the best way to handle quantity
 	// because it's not atomic (in ACID), but it's good enough for this example
-	Quantity  int       `json:"quantity"`
+	Quantity int `json:"quantity"`
+	// ReorderThreshold is the stock, variants included, at or under which
+	// the product needs restocking.
+	ReorderThreshold int       `json:"reorderThreshold"`
+	CreatedAt        time.Time `json:"createdAt"`
+	// DeletedAt is set once the product is taken off the catalog. The row is
+	// kept so past order items still resolve.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Categories the product is listed under, filled in by the handlers.
+	Categories []Category `json:"categories"`
+	// Images is the product's gallery in display order, filled in by the
+	// handlers. The first image is the primary one, and Image points at it.
+	Images []ProductImage `json:"images"`
+	// Availability is the stock of the product, variants included, in each
+	// warehouse that holds any, filled in by the handlers. Quantity is the
+	// product's own stock across all of them.
+	Availability []WarehouseStock `json:"availability"`
+}
+
+// ProductQuery narrows, orders and pages the product listing.
+type ProductQuery struct {
+	// Currency is the one the client asked prices in. MinPrice and MaxPrice
+	// are in it, and products priced in other currencies are converted
+	// before being compared with them.
+	Currency string
+	MinPrice *Money
+	MaxPrice *Money
+	// InStock keeps only products with (true) or without (false) stock.
+	InStock *bool
+	// SortBy is one of "price", "createdAt" or "name".
+	SortBy string
+	Desc   bool
+	Limit  int
+	// After, when set, resumes the listing right after the given product.
+	After *ProductCursor
+	// CategoryIDs keeps only products listed under any of these categories.
+	CategoryIDs []int
+}
+
+// ProductCursor marks the last product of a page: its value for the sort
+// column and its id, which breaks ties between equal values. Cursors of the
+// price sort only carry the id, as the price is looked up again.
+type ProductCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     int    `json:"id"`
+}
+
+type ProductPage struct {
+	Products   []*Product `json:"products"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	Total      int        `json:"total"`
+}
+
+// Category is a node of the category tree; top level categories have no
+// ParentID.
+type Category struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *int      `json:"parentID"`
 	CreatedAt time.Time `json:"createdAt"`
+	// Children is only filled in when categories are returned as a tree.
+	Children []*Category `json:"children,omitempty"`
+}
+
+// ProductSearchResult is a product matched by a full-text search, with its
+// relevance score and the matched terms wrapped in <mark> tags.
+type ProductSearchResult struct {
+	Product
+	Relevance  float64           `json:"relevance"`
+	Highlights ProductHighlights `json:"highlights"`
+}
+
+// ProductHighlights holds HTML-escaped copies of the searched fields. The
+// description is cut down to a snippet around the first match.
+type ProductHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ProductOption is a dimension a product varies in, such as size or color.
+type ProductOption struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"productID"`
+	Name      string `json:"name"`
+	Position  int    `json:"position"`
 }
 
+// ProductVariant is a version of a product with its own SKU and stock, such
+// as the medium red shirt. Price, when set, replaces the product's price.
+// Products that have variants can only be sold through them.
+type ProductVariant struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"productID"`
+	SKU       string `json:"sku"`
+	Price     *Money `json:"price"`
+	Quantity  int    `json:"quantity"`
+	// Options maps each option name of the product to this variant's value.
+	Options   map[string]string `json:"options"`
+	CreatedAt time.Time         `json:"createdAt"`
+	DeletedAt *time.Time        `json:"deletedAt,omitempty"`
+}
+
+// ProductImage is one picture of a product's gallery, along with a smaller
+// copy for listings.
+type ProductImage struct {
+	ID           int       `json:"id"`
+	ProductID    int       `json:"productID"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnailUrl"`
+	ContentType  string    `json:"contentType"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Position     int       `json:"position"`
+	Primary      bool      `json:"primary"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// ProductVariants lists the ways a product can be bought.
+type ProductVariants struct {
+	Options  []ProductOption  `json:"options"`
+	Variants []ProductVariant `json:"variants"`
+}
+
+// CartCheckoutItem is one line of a checkout. VariantID is 0 for products
+// that aren't sold in variants.
 type CartCheckoutItem struct {
 	ProductID int `json:"productID"`
+	VariantID int `json:"variantID,omitempty"`
 	Quantity  int `json:"quantity"`
 }
 
-type Order struct {
+type CartItem struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"userID"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"`
-	Address   string    `json:"address"`
+	ProductID int       `json:"productID"`
+	VariantID int       `json:"variantID,omitempty"`
+	Quantity  int       `json:"quantity"`
 	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CartView is the stored cart as returned to the client, priced with the
+// current catalog prices and flagged where the stock can't cover it.
+type CartView struct {
+	Items []CartViewItem `json:"items"`
+	Total Money          `json:"total"`
+}
+
+type CartViewItem struct {
+	ProductID int    `json:"productID"`
+	VariantID int    `json:"variantID,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+	Name      string `json:"name"`
+	Image     string `json:"image"`
+	Price     Money  `json:"price"`
+	Quantity  int    `json:"quantity"`
+	Subtotal  Money  `json:"subtotal"`
+	InStock   int    `json:"inStock"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// PostalAddress is the deliverable part of an address. It's shared by the
+// address book and the snapshot copied onto every order.
+type PostalAddress struct {
+	FullName   string `json:"fullName" validate:"required"`
+	Line1      string `json:"line1" validate:"required"`
+	Line2      string `json:"line2"`
+	City       string `json:"city" validate:"required"`
+	State      string `json:"state"`
+	PostalCode string `json:"postalCode" validate:"required"`
+	Country    string `json:"country" validate:"required,len=2"`
+	Phone      string `json:"phone"`
 }
 
+// String formats the address on a single line, the way it's stored in the
+// orders.address column.
+func (a PostalAddress) String() string {
+	parts := []string{a.FullName, a.Line1, a.Line2, a.City, a.State, a.PostalCode, a.Country}
+
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+
+	return strings.Join(nonEmpty, ", ")
+}
+
+type Address struct {
+	ID     int `json:"id"`
+	UserID int `json:"userID"`
+	PostalAddress
+	IsDefault bool      `json:"isDefault"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OrderStatus is a step of the order lifecycle. The allowed moves between
+// statuses are enforced by the order package.
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusFulfilled OrderStatus = "fulfilled"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusRefunded  OrderStatus = "refunded"
+	// OrderStatusPartiallyRefunded is a delivered order some of which was
+	// returned and refunded.
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// Order amounts are in the currency the order was charged in, and
+// ExchangeRate is how many units of that currency one unit of DefaultCurrency
+// bought at checkout. Total is Subtotal minus Discount plus Tax and
+// ShippingCost, and Refunded is how much of it was given back.
+type Order struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"userID"`
+	Subtotal     Money  `json:"subtotal"`
+	Discount     Money  `json:"discount"`
+	Tax          Money  `json:"tax"`
+	ShippingCost Money  `json:"shippingCost"`
+	Total        Money  `json:"total"`
+	Refunded     Money  `json:"refunded"`
+	ExchangeRate Rate   `json:"exchangeRate"`
+	CouponID     *int   `json:"couponID,omitempty"`
+	CouponCode   string `json:"couponCode,omitempty"`
+	// FreeShipping is set by free shipping coupons.
+	FreeShipping bool `json:"freeShipping"`
+	// ShippingMethod is the name of the method the order ships with, kept
+	// in case the method is changed or deleted.
+	ShippingMethodID *int        `json:"shippingMethodID,omitempty"`
+	ShippingMethod   string      `json:"shippingMethod,omitempty"`
+	Status           OrderStatus `json:"status"`
+	Address          string      `json:"address"`
+	// ShippingAddress is a copy of the address book entry taken at checkout,
+	// so later edits to the address book don't rewrite the order.
+	ShippingAddress PostalAddress `json:"shippingAddress"`
+	CancelledBy     *int          `json:"cancelledBy,omitempty"`
+	CancelReason    string        `json:"cancelReason,omitempty"`
+	CancelledAt     *time.Time    `json:"cancelledAt,omitempty"`
+	CreatedAt       time.Time     `json:"createdAt"`
+}
+
+// OrderItem.Price is in the order's currency. ListPrice is the catalog price
+// the item was sold at, and ExchangeRate the rate that turned it into Price.
+// Discount is the part of the order discount taken off the whole line, and
+// Tax the tax charged on what is left of it.
 type OrderItem struct {
+	ID           int       `json:"id"`
+	OrderID      int       `json:"orderID"`
+	ProductID    int       `json:"productID"`
+	VariantID    *int      `json:"variantID,omitempty"`
+	Quantity     int       `json:"quantity"`
+	Price        Money     `json:"price"`
+	Discount     Money     `json:"discount"`
+	Tax          Money     `json:"tax"`
+	ListPrice    Money     `json:"listPrice"`
+	ExchangeRate Rate      `json:"exchangeRate"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// OrderItemDetail is an order line joined with the product it refers to.
+type OrderItemDetail struct {
+	OrderItem
+	ProductName  string `json:"productName"`
+	ProductImage string `json:"productImage"`
+	VariantSKU   string `json:"variantSKU,omitempty"`
+}
+
+// OrderStatusChange is one entry of an order's status history. FromStatus is
+// empty for the entry written when the order is placed, and ActorID is nil
+// for changes made by the system rather than a user.
+type OrderStatusChange struct {
+	ID         int         `json:"id"`
+	OrderID    int         `json:"orderID"`
+	FromStatus OrderStatus `json:"fromStatus"`
+	ToStatus   OrderStatus `json:"toStatus"`
+	ActorID    *int        `json:"actorID"`
+	Note       string      `json:"note"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+// PaymentStatus is where a payment stands with the payment gateway.
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentCaptured   PaymentStatus = "captured"
+	PaymentVoided     PaymentStatus = "voided"
+	PaymentRefunded   PaymentStatus = "refunded"
+	PaymentDeclined   PaymentStatus = "declined"
+	// PaymentFailed is an attempt the gateway didn't answer, such as one
+	// that timed out.
+	PaymentFailed PaymentStatus = "failed"
+)
+
+// Payment is one attempt to charge an order, in the order's currency.
+// Reference is the id the gateway gave the authorization; it is empty when
+// the attempt was declined or failed, and Error then tells why.
+type Payment struct {
+	ID        int           `json:"id"`
+	OrderID   int           `json:"orderID"`
+	Provider  string        `json:"provider"`
+	Reference string        `json:"reference"`
+	Amount    Money         `json:"amount"`
+	Status    PaymentStatus `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+// PaymentOperationType is the gateway call a payment operation makes.
+type PaymentOperationType string
+
+const (
+	PaymentOperationCapture PaymentOperationType = "capture"
+	PaymentOperationVoid    PaymentOperationType = "void"
+	PaymentOperationRefund  PaymentOperationType = "refund"
+)
+
+// PaymentOperationStatus is where a payment operation stands.
+type PaymentOperationStatus string
+
+const (
+	PaymentOperationPending   PaymentOperationStatus = "pending"
+	PaymentOperationSucceeded PaymentOperationStatus = "succeeded"
+	// PaymentOperationFailed is an operation that ran out of attempts and
+	// has to be settled by hand.
+	PaymentOperationFailed PaymentOperationStatus = "failed"
+)
+
+// PaymentOperation is a call owed to the payment gateway for a payment. It
+// is recorded in the transaction that decides it and made once that
+// commits, so the gateway never moves money for a change that rolled back.
+// Error tells why the last attempt failed.
+type PaymentOperation struct {
+	ID        int                    `json:"id"`
+	PaymentID int                    `json:"paymentID"`
+	Type      PaymentOperationType   `json:"type"`
+	Amount    Money                  `json:"amount"`
+	Status    PaymentOperationStatus `json:"status"`
+	Attempts  int                    `json:"attempts"`
+	Error     string                 `json:"error,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+// PaymentEvent is a notification the payment gateway sends to
+// POST /webhooks/payments. Type is "payment." followed by the status the
+// payment moved to, such as "payment.captured", and Reference is the id the
+// gateway gave the payment.
+type PaymentEvent struct {
+	ID        string `json:"id" validate:"required,max=255"`
+	Type      string `json:"type" validate:"required,max=64"`
+	OrderID   int    `json:"orderID" validate:"required"`
+	Reference string `json:"reference" validate:"max=255"`
+}
+
+// Refund is money given back for an order, in the order's currency.
+// ReturnID is set when the refund is for a return, and PaymentID when it was
+// given back through the payment gateway.
+type Refund struct {
 	ID        int       `json:"id"`
 	OrderID   int       `json:"orderID"`
+	ReturnID  *int      `json:"returnID,omitempty"`
+	PaymentID *int      `json:"paymentID,omitempty"`
+	Amount    Money     `json:"amount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type OrderDetail struct {
+	Order
+	Items         []OrderItemDetail   `json:"items"`
+	StatusHistory []OrderStatusChange `json:"statusHistory"`
+	Refunds       []Refund            `json:"refunds"`
+}
+
+// ReturnStatus is a step of a return: a customer requests it, staff approve
+// or reject it, and an approved return is received back, which refunds it.
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnRejected  ReturnStatus = "rejected"
+	ReturnReceived  ReturnStatus = "received"
+)
+
+// Return is a customer's request to send back some units of the items of a
+// delivered order. Note is left by the staff reviewing it, and Restocked
+// tells whether the units went back on the shelf once received.
+type Return struct {
+	ID        int          `json:"id"`
+	OrderID   int          `json:"orderID"`
+	UserID    int          `json:"userID"`
+	Status    ReturnStatus `json:"status"`
+	Reason    string       `json:"reason"`
+	Note      string       `json:"note"`
+	Restocked bool         `json:"restocked"`
+	Items     []ReturnItem `json:"items"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+type ReturnItem struct {
+	OrderItemID int `json:"orderItemID"`
+	Quantity    int `json:"quantity"`
+}
+
+// StockMovementReason tells why the stock of a product or variant changed.
+type StockMovementReason string
+
+const (
+	StockSale         StockMovementReason = "sale"
+	StockCancellation StockMovementReason = "cancellation"
+	StockReturn       StockMovementReason = "return"
+	StockAdjustment   StockMovementReason = "adjustment"
+	// StockImport is stock brought in, such as a delivery from a supplier
+	// or the initial stock of a new product.
+	StockImport StockMovementReason = "import"
+)
+
+// StockMovement is an entry of the stock ledger. Change is the number of
+// units added to a warehouse, negative when units were taken, and Balance
+// the stock left after it across all warehouses. A nil WarehouseID moves the
+// stock of the default warehouse; it stays nil on the movements recorded
+// before there were warehouses. ActorID is nil for the changes the system
+// makes on its own, and Reference names what caused the change, such as
+// "order:12".
+type StockMovement struct {
+	ID          int                 `json:"id"`
+	ProductID   int                 `json:"productID"`
+	VariantID   *int                `json:"variantID,omitempty"`
+	WarehouseID *int                `json:"warehouseID,omitempty"`
+	Change      int                 `json:"change"`
+	Balance     int                 `json:"balance"`
+	Reason      StockMovementReason `json:"reason"`
+	ActorID     *int                `json:"actorID,omitempty"`
+	Reference   string              `json:"reference"`
+	CreatedAt   time.Time           `json:"createdAt"`
+}
+
+// StockDiscrepancy is a product, or one of its variants, whose stock doesn't
+// add up to the sum of its movements or to the sum of its inventory levels.
+type StockDiscrepancy struct {
+	ProductID int  `json:"productID"`
+	VariantID *int `json:"variantID,omitempty"`
+	Quantity  int  `json:"quantity"`
+	Ledger    int  `json:"ledger"`
+	Levels    int  `json:"levels"`
+}
+
+// LowStockProduct is a product whose stock, variants included, is at or
+// under its reorder threshold.
+type LowStockProduct struct {
+	ProductID        int    `json:"productID"`
+	Name             string `json:"name"`
+	Quantity         int    `json:"quantity"`
+	ReorderThreshold int    `json:"reorderThreshold"`
+}
+
+// LowStockEvent is raised when the units an order takes bring a product to
+// or under its reorder threshold.
+type LowStockEvent struct {
+	LowStockProduct
+	OrderID int
+}
+
+// StockNotifier tells whoever restocks the products that one runs low.
+type StockNotifier interface {
+	NotifyLowStock(ctx context.Context, event LowStockEvent) error
+}
+
+// Warehouse is a place stock is kept and orders are shipped from. Stock that
+// isn't brought into a particular warehouse, such as the quantity set on a
+// product, goes to the default one.
+type Warehouse struct {
+	ID        int           `json:"id"`
+	Code      string        `json:"code"`
+	Name      string        `json:"name"`
+	Address   PostalAddress `json:"address"`
+	IsDefault bool          `json:"isDefault"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// InventoryLevel is the stock of a product, or of one of its variants, in a
+// warehouse.
+type InventoryLevel struct {
+	WarehouseID int  `json:"warehouseID"`
+	ProductID   int  `json:"productID"`
+	VariantID   *int `json:"variantID,omitempty"`
+	Quantity    int  `json:"quantity"`
+}
+
+// WarehouseStock is how many units of a product, variants included, a
+// warehouse holds.
+type WarehouseStock struct {
+	WarehouseID int    `json:"warehouseID"`
+	Warehouse   string `json:"warehouse"`
+	Quantity    int    `json:"quantity"`
+}
+
+// AllocationLine is an order item waiting to be allocated to warehouses.
+type AllocationLine struct {
+	OrderItemID int
+	ProductID   int
+	VariantID   *int
+	Quantity    int
+}
+
+// OrderAllocation is the part of an order item that ships from a warehouse.
+type OrderAllocation struct {
+	ID          int       `json:"id"`
+	OrderID     int       `json:"orderID"`
+	OrderItemID int       `json:"orderItemID"`
+	WarehouseID int       `json:"warehouseID"`
+	Quantity    int       `json:"quantity"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// PickingListItem is a line to pick in a warehouse for an order that was
+// paid and is waiting to be fulfilled.
+type PickingListItem struct {
+	OrderID     int    `json:"orderID"`
+	OrderItemID int    `json:"orderItemID"`
+	ProductID   int    `json:"productID"`
+	VariantID   *int   `json:"variantID,omitempty"`
+	ProductName string `json:"productName"`
+	VariantSKU  string `json:"variantSKU,omitempty"`
+	Quantity    int    `json:"quantity"`
+}
+
+// StockReservation holds units of a product, or of one of its variants,
+// until ExpiresAt. A cart reservation has UserID set and keeps the units of
+// the user's cart from other customers while they check out. An order
+// reservation has OrderID set and gives a pending order until ExpiresAt to
+// be paid; its units were already taken off the stock.
+type StockReservation struct {
+	ID        int       `json:"id"`
 	ProductID int       `json:"productID"`
+	VariantID *int      `json:"variantID,omitempty"`
 	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
+	UserID    *int      `json:"userID,omitempty"`
+	OrderID   *int      `json:"orderID,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// ReservedStock counts the units held by cart reservations, by product for
+// products sold without variants and by variant.
+type ReservedStock struct {
+	Products map[int]int
+	Variants map[int]int
+}
+
+// Of returns the units held of a product, or of its variant when variantID
+// isn't 0.
+func (r ReservedStock) Of(productID int, variantID int) int {
+	if variantID != 0 {
+		return r.Variants[variantID]
+	}
+
+	return r.Products[productID]
+}
+
+// OrderPage is one page of a user's order history, newest first.
+type OrderPage struct {
+	Orders []Order `json:"orders"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
+
+// ShippingMethodType decides how a shipping method is priced.
+type ShippingMethodType string
+
+const (
+	// ShippingFlatRate charges Cost on every order.
+	ShippingFlatRate ShippingMethodType = "flat_rate"
+	// ShippingWeightBased charges Cost plus CostPerKg for every started
+	// kilogram the order weighs.
+	ShippingWeightBased ShippingMethodType = "weight_based"
+	// ShippingFreeOverThreshold charges Cost unless the order subtotal
+	// reaches FreeOver.
+	ShippingFreeOverThreshold ShippingMethodType = "free_over_threshold"
+)
+
+// ShippingMethod is a way orders can be shipped. Its amounts share one
+// currency and are converted into the order's currency. A method with no
+// Countries ships everywhere.
+type ShippingMethod struct {
+	ID        int                `json:"id"`
+	Code      string             `json:"code"`
+	Name      string             `json:"name"`
+	Type      ShippingMethodType `json:"type"`
+	Cost      Money              `json:"cost"`
+	CostPerKg *Money             `json:"costPerKg,omitempty"`
+	FreeOver  *Money             `json:"freeOver,omitempty"`
+	Countries []string           `json:"countries"`
+	Active    bool               `json:"active"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+// ShippingQuote is what shipping the cart with a method costs, in the
+// currency the client asked for.
+type ShippingQuote struct {
+	MethodID int    `json:"methodID"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Cost     Money  `json:"cost"`
+}
+
+// CouponType decides what a coupon takes off an order.
+type CouponType string
+
+const (
+	CouponPercentage   CouponType = "percentage"
+	CouponFixedAmount  CouponType = "fixed_amount"
+	CouponFreeShipping CouponType = "free_shipping"
+)
+
+// Coupon is a discount code redeemed at checkout. AmountOff and MinCartValue
+// share one currency and are converted into the order's currency. A coupon
+// with no ProductIDs and no CategoryIDs applies to every product; otherwise
+// only to the listed products and the products of the listed categories and
+// their subcategories. Nil limits and dates don't restrict the coupon.
+type Coupon struct {
+	ID             int        `json:"id"`
+	Code           string     `json:"code"`
+	Type           CouponType `json:"type"`
+	PercentOff     int        `json:"percentOff,omitempty"`
+	AmountOff      *Money     `json:"amountOff,omitempty"`
+	MinCartValue   *Money     `json:"minCartValue,omitempty"`
+	MaxUses        *int       `json:"maxUses"`
+	MaxUsesPerUser *int       `json:"maxUsesPerUser"`
+	Uses           int        `json:"uses"`
+	StartsAt       *time.Time `json:"startsAt"`
+	EndsAt         *time.Time `json:"endsAt"`
+	ProductIDs     []int      `json:"productIDs"`
+	CategoryIDs    []int      `json:"categoryIDs"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// DefaultTaxClass is the tax class of products that weren't given one.
+const DefaultTaxClass = "standard"
+
+// TaxRate is the share of the price charged as tax on products of TaxClass
+// shipped to Country, e.g. 0.2 for 20%. An empty Region covers the whole
+// country; a rate for the region of an address takes precedence over it.
+type TaxRate struct {
+	Country   string    `json:"country"`
+	Region    string    `json:"region"`
+	TaxClass  string    `json:"taxClass"`
+	Rate      Rate      `json:"rate"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TaxLine is an order line as seen by a TaxCalculator: the amount tax is
+// charged on, after discounts.
+type TaxLine struct {
+	TaxClass string
+	Amount   Money
+}
+
+// ExchangeRate is how many units of Currency one unit of DefaultCurrency
+// buys.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      Rate      `json:"rate"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// NewExchangeRates indexes a list of rates by currency.
+func NewExchangeRates(rates []ExchangeRate) ExchangeRates {
+	r := make(ExchangeRates, len(rates))
+	for _, rate := range rates {
+		r[rate.Currency] = rate.Rate
+	}
+
+	return r
+}
+
 type UserStore interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
+	GetUsers() ([]*User, error)
 	CreateUser(User) error
+	UpdateUserRole(id int, role Role) error
+}
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so a store can run the same
+// queries against the connection pool or inside a transaction.
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
+// Transactor runs fn inside a single database transaction. The transaction is
+// committed when fn returns nil and rolled back otherwise.
+type Transactor interface {
+	WithinTx(fn func(tx *sql.Tx) error) error
+}
+
+type CategoryStore interface {
+	GetCategories() ([]Category, error)
+	GetCategoryByID(categoryID int) (*Category, error)
+	// GetSubtreeIDs returns the id of the category followed by the ids of
+	// every category nested under it, or ErrNotFound if it doesn't exist.
+	GetSubtreeIDs(categoryID int) ([]int, error)
+	// GetCategoriesByProductIDs maps each product id to its categories.
+	GetCategoriesByProductIDs(productIDs []int) (map[int][]Category, error)
+	CreateCategory(Category) (int, error)
+	UpdateCategory(Category) error
+	DeleteCategory(categoryID int) error
+	// SetProductCategories replaces the categories a product is listed under.
+	SetProductCategories(productID int, categoryIDs []int) error
+	WithTx(tx *sql.Tx) CategoryStore
+}
+
+// ProductStore only ever returns products that haven't been deleted.
 type ProductStore interface {
 	GetProductByID(id int) (*Product, error)
 	GetProductsByID(ids []int) ([]Product, error)
-	GetProducts() ([]*Product, error)
-	CreateProduct(CreateProductPayload) error
+	// GetProducts returns up to query.Limit products matching the query and
+	// the total number of products matching its filters.
+	GetProducts(query ProductQuery) ([]*Product, int, error)
+	// SearchProducts runs a full-text search over name and description and
+	// returns up to limit matches, most relevant first.
+	SearchProducts(text string, limit int) ([]ProductSearchResult, error)
+	// CreateProduct stores a product without stock and returns its id. The
+	// stock comes in through the StockLedger.
+	CreateProduct(CreateProductPayload) (int, error)
+	// UpdateProduct leaves the stock alone; it only changes through the
+	// StockLedger.
 	UpdateProduct(Product) error
+	// DeleteProduct soft deletes a product by setting its DeletedAt.
+	DeleteProduct(id int) error
+	// GetLowStockProducts lists the products at or under their reorder
+	// threshold, lowest stock first. Only productIDs are checked, unless it
+	// is nil.
+	GetLowStockProducts(productIDs []int) ([]LowStockProduct, error)
+	WithTx(tx *sql.Tx) ProductStore
+}
+
+// VariantStore only returns variants that haven't been deleted, except for
+// GetVariantBySKU.
+type VariantStore interface {
+	GetOptions(productID int) ([]ProductOption, error)
+	// SetOptions replaces the options of a product, keeping the given order.
+	SetOptions(productID int, names []string) error
+	GetVariantsByProductIDs(productIDs []int) ([]ProductVariant, error)
+	// GetVariantByID only returns the variant if it belongs to productID.
+	GetVariantByID(productID int, variantID int) (*ProductVariant, error)
+	// GetVariantBySKU also finds deleted variants, as their SKUs stay taken.
+	GetVariantBySKU(sku string) (*ProductVariant, error)
+	// CreateVariant stores the variant, without stock, and its value for
+	// each option name. The stock comes in through the StockLedger.
+	CreateVariant(ProductVariant) (int, error)
+	// UpdateVariant stores the SKU and price of a variant.
+	UpdateVariant(ProductVariant) error
+	// DeleteVariant soft deletes a variant so past order items still resolve.
+	DeleteVariant(productID int, variantID int) error
+	WithTx(tx *sql.Tx) VariantStore
+}
+
+// ImageStore keeps the galleries in order: positions always run from 0, and
+// the image at position 0 is the primary one. Every change to a gallery also
+// points the product's image at its primary image.
+type ImageStore interface {
+	GetImagesByProductIDs(productIDs []int) (map[int][]ProductImage, error)
+	// GetImageByID only returns the image if it belongs to productID.
+	GetImageByID(productID int, imageID int) (*ProductImage, error)
+	// CreateImage appends the image to the end of the product's gallery.
+	CreateImage(ProductImage) (int, error)
+	DeleteImage(productID int, imageID int) error
+	// ReorderImages moves the images to the positions they are listed in.
+	// imageIDs must hold every image of the product.
+	ReorderImages(productID int, imageIDs []int) error
+	WithTx(tx *sql.Tx) ImageStore
+}
+
+// BlobStore keeps uploaded files, such as product images, and tells where
+// they are served from.
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing any file already
+	// there, and returns the URL it is served from.
+	Put(key string, r io.Reader) (string, error)
+	Delete(key string) error
+}
+
+// TaxCalculator works out the tax on each line of an order shipped to an
+// address. The amounts it returns are in the same order as lines and in the
+// currency of each line.
+type TaxCalculator interface {
+	Calculate(to PostalAddress, lines []TaxLine) ([]Money, error)
+}
+
+type TaxRateStore interface {
+	GetTaxRates() ([]TaxRate, error)
+	// SetTaxRates replaces every stored rate with rates.
+	SetTaxRates(rates []TaxRate) error
+	WithTx(tx *sql.Tx) TaxRateStore
+}
+
+type ExchangeRateStore interface {
+	GetExchangeRates() ([]ExchangeRate, error)
+	// SetExchangeRates adds or replaces the rates of the given currencies.
+	SetExchangeRates(rates map[string]Rate) error
+	// DeleteExchangeRate fails with ErrConflict while products or variants
+	// are still priced in the currency.
+	DeleteExchangeRate(currency string) error
+	WithTx(tx *sql.Tx) ExchangeRateStore
+}
+
+type ShippingMethodStore interface {
+	GetShippingMethods() ([]ShippingMethod, error)
+	GetShippingMethodByID(methodID int) (*ShippingMethod, error)
+	GetShippingMethodByCode(code string) (*ShippingMethod, error)
+	// CreateShippingMethod and UpdateShippingMethod also store the
+	// countries the method ships to.
+	CreateShippingMethod(ShippingMethod) (int, error)
+	UpdateShippingMethod(ShippingMethod) error
+	DeleteShippingMethod(methodID int) error
+	WithTx(tx *sql.Tx) ShippingMethodStore
+}
+
+// PaymentGateway talks to a payment provider. Authorize holds the amount of
+// an order and returns the provider's reference for it, which the other
+// calls take. A refused payment is reported with ErrPaymentDeclined; any
+// other error, such as a timeout, leaves the outcome unknown.
+type PaymentGateway interface {
+	// Name identifies the provider on stored payments.
+	Name() string
+	Authorize(ctx context.Context, orderID int, amount Money) (string, error)
+	// Capture collects amount, up to what was authorized.
+	Capture(ctx context.Context, reference string, amount Money) error
+	// Refund gives back amount, up to what was captured and not yet
+	// refunded.
+	Refund(ctx context.Context, reference string, amount Money) error
+	// Void releases an authorization that was never captured.
+	Void(ctx context.Context, reference string) error
+}
+
+type PaymentStore interface {
+	// GetPaymentsByOrderID returns the payment attempts of an order, oldest
+	// first.
+	GetPaymentsByOrderID(orderID int) ([]Payment, error)
+	GetPaymentByID(paymentID int) (*Payment, error)
+	// GetPaymentByReference finds a payment by the id its gateway gave it.
+	GetPaymentByReference(provider string, reference string) (*Payment, error)
+	CreatePayment(Payment) (int, error)
+	UpdatePaymentStatus(paymentID int, status PaymentStatus) error
+	CreatePaymentOperation(PaymentOperation) (int, error)
+	// GetPendingPaymentOperations returns the operations of a payment still
+	// to be made, oldest first.
+	GetPendingPaymentOperations(paymentID int) ([]PaymentOperation, error)
+	// GetPaymentIDsWithPendingOperations returns up to limit payments with
+	// operations pending that weren't tried since before.
+	GetPaymentIDsWithPendingOperations(before time.Time, limit int) ([]int, error)
+	// ClaimPaymentOperation counts an attempt at a pending operation. It
+	// reports false when the operation was attempted or settled since it
+	// was read, in which case someone else is making it.
+	ClaimPaymentOperation(op PaymentOperation) (bool, error)
+	// UpdatePaymentOperation records the outcome of the last attempt.
+	UpdatePaymentOperation(operationID int, status PaymentOperationStatus, errMsg string) error
+	WithTx(tx *sql.Tx) PaymentStore
+}
+
+// PaymentSettler makes the payment operations recorded for a payment once
+// the transaction that recorded them has committed.
+type PaymentSettler interface {
+	Settle(ctx context.Context, paymentID int) error
+}
+
+type WebhookEventStore interface {
+	// RecordWebhookEvent stores an event received from source, along with
+	// its raw payload. It fails with ErrConflict if an event with the same
+	// id was already recorded, so every event is handled once.
+	RecordWebhookEvent(source string, eventID string, eventType string, payload []byte) error
+	WithTx(tx *sql.Tx) WebhookEventStore
+}
+
+type CouponStore interface {
+	GetCoupons() ([]Coupon, error)
+	GetCouponByID(couponID int) (*Coupon, error)
+	// GetCouponByCode ignores the case of code.
+	GetCouponByCode(code string) (*Coupon, error)
+	// CreateCoupon and UpdateCoupon also store the product and category
+	// scope. Codes are stored in upper case.
+	CreateCoupon(Coupon) (int, error)
+	UpdateCoupon(Coupon) error
+	DeleteCoupon(couponID int) error
+	// GetEligibleProductIDs returns those of productIDs the coupon applies to.
+	GetEligibleProductIDs(couponID int, productIDs []int) ([]int, error)
+	// RedeemCoupon counts one use of the coupon by userID for orderID. The
+	// check against the usage limits and the count are a single atomic
+	// step, and it fails with ErrConflict once a limit is reached.
+	RedeemCoupon(couponID int, userID int, orderID int) error
+	// ReleaseRedemption gives back the use counted for orderID, if its
+	// order redeemed a coupon.
+	ReleaseRedemption(orderID int) error
+	WithTx(tx *sql.Tx) CouponStore
+}
+
+type CartStore interface {
+	GetCartItems(userID int) ([]CartItem, error)
+	// AddCartItem adds quantity units of a product, or of one of its
+	// variants, to the user's cart on top of whatever is already there.
+	AddCartItem(userID int, productID int, variantID int, quantity int) error
+	// UpdateCartItem sets the quantity of a line already in the cart.
+	UpdateCartItem(userID int, productID int, variantID int, quantity int) error
+	RemoveCartItem(userID int, productID int, variantID int) error
+	ClearCart(userID int) error
+	WithTx(tx *sql.Tx) CartStore
+}
+
+type AddressStore interface {
+	GetAddressesByUserID(userID int) ([]Address, error)
+	// GetAddressByID only returns the address if it belongs to userID.
+	GetAddressByID(userID int, addressID int) (*Address, error)
+	GetDefaultAddress(userID int) (*Address, error)
+	CreateAddress(Address) (int, error)
+	UpdateAddress(Address) error
+	DeleteAddress(userID int, addressID int) error
+	// SetDefaultAddress flags addressID as the user's default and clears the
+	// flag on every other address of theirs.
+	SetDefaultAddress(userID int, addressID int) error
+	WithTx(tx *sql.Tx) AddressStore
 }
 
 type OrderStore interface {
 	CreateOrder(Order) (int, error)
-	CreateOrderItem(OrderItem) error
+	CreateOrderItem(OrderItem) (int, error)
+	// GetOrdersByUserID returns one page of the user's orders, newest first,
+	// along with the total number of orders they have.
+	GetOrdersByUserID(userID int, limit int, offset int) ([]Order, int, error)
+	// GetOrderByID only returns the order if it belongs to userID.
+	GetOrderByID(userID int, orderID int) (*Order, error)
+	// GetOrder returns an order whoever it belongs to; it backs staff routes.
+	GetOrder(orderID int) (*Order, error)
+	GetOrderItems(orderID int) ([]OrderItemDetail, error)
+	// UpdateOrderStatus moves an order from one status to another. It fails
+	// with ErrConflict if the order is no longer in the from status. Callers
+	// should go through the order package, which enforces the lifecycle.
+	UpdateOrderStatus(orderID int, from OrderStatus, to OrderStatus) error
+	// RecordCancellation stores who cancelled an order and why. cancelledBy
+	// is nil when the system cancelled it.
+	RecordCancellation(orderID int, cancelledBy *int, reason string) error
+	CreateStatusChange(OrderStatusChange) error
+	GetStatusHistory(orderID int) ([]OrderStatusChange, error)
+	// RecordRefund stores a refund and adds it to the refunded total of its
+	// order.
+	RecordRefund(Refund) (int, error)
+	GetRefunds(orderID int) ([]Refund, error)
+	WithTx(tx *sql.Tx) OrderStore
+}
+
+type ReturnStore interface {
+	// GetReturns lists every return in a status, or all of them when status
+	// is empty, oldest first.
+	GetReturns(status ReturnStatus) ([]Return, error)
+	GetReturnsByOrderID(orderID int) ([]Return, error)
+	GetReturn(returnID int) (*Return, error)
+	CreateReturn(Return) (int, error)
+	// UpdateReturn stores the status, note and restocked flag of a return.
+	// It fails with ErrConflict if the return is no longer in the from
+	// status.
+	UpdateReturn(r Return, from ReturnStatus) error
+	WithTx(tx *sql.Tx) ReturnStore
 }
+
+// StockLedger is the only way the stock of products and variants changes:
+// every change is applied together with an entry appended to the ledger.
+type StockLedger interface {
+	// Move applies m.Change to the stock of m.ProductID, or of m.VariantID
+	// when it is set, and records m with the stock left after it. Taking
+	// more units than are left fails.
+	Move(m StockMovement) error
+	// GetMovements lists the movements of a product and of its variants,
+	// newest first.
+	GetMovements(productID int) ([]StockMovement, error)
+	// GetMovementsByReference lists the movements caused by reference,
+	// oldest first.
+	GetMovementsByReference(reference string) ([]StockMovement, error)
+	// Reconcile lists the products and variants whose stock differs from
+	// the sum of their movements or from the sum of their inventory levels.
+	Reconcile() ([]StockDiscrepancy, error)
+	WithTx(tx *sql.Tx) StockLedger
+}
+
+type WarehouseStore interface {
+	GetWarehouses() ([]Warehouse, error)
+	GetWarehouse(id int) (*Warehouse, error)
+	CreateWarehouse(Warehouse) (int, error)
+	UpdateWarehouse(Warehouse) error
+	// GetInventoryLevels lists the stock each warehouse holds of the
+	// products and of their variants, leaving out the empty levels.
+	GetInventoryLevels(productIDs []int) ([]InventoryLevel, error)
+	// GetAvailability sums the levels of each product per warehouse.
+	GetAvailability(productIDs []int) (map[int][]WarehouseStock, error)
+	CreateAllocations(allocations []OrderAllocation) error
+	GetAllocations(orderID int) ([]OrderAllocation, error)
+	// GetPickingList lists what to pick in a warehouse for the orders that
+	// were paid, oldest order first.
+	GetPickingList(warehouseID int) ([]PickingListItem, error)
+	WithTx(tx *sql.Tx) WarehouseStore
+}
+
+// AllocationStrategy decides which warehouses the lines of an order ship
+// from. It may split a line across warehouses, but the allocations of each
+// line must add up to its quantity and fit in the levels given; when they
+// can't, it fails with ErrConflict.
+type AllocationStrategy interface {
+	Allocate(to PostalAddress, lines []AllocationLine, warehouses []Warehouse, levels []InventoryLevel) ([]OrderAllocation, error)
+}
+
+type ReservationStore interface {
+	// GetReservedStock sums the cart reservations on the products that are
+	// still live at now, leaving out the ones of exceptUserID.
+	GetReservedStock(productIDs []int, exceptUserID int, now time.Time) (ReservedStock, error)
+	CreateReservations(reservations []StockReservation) error
+	ReleaseCartReservations(userID int) error
+	ReleaseOrderReservations(orderID int) error
+	// ReleaseExpiredCartReservations drops the cart reservations that
+	// expired before now and returns how many there were.
+	ReleaseExpiredCartReservations(now time.Time) (int, error)
+	// GetExpiredOrderIDs lists up to limit orders with reservations that
+	// expired before now, oldest first.
+	GetExpiredOrderIDs(now time.Time, limit int) ([]int, error)
+	WithTx(tx *sql.Tx) ReservationStore
+}
+
+// StockReserver holds stock for carts and orders for a limited time, so it
+// isn't sold to someone else while they are checked out and paid for.
+type StockReserver interface {
+	// Reserved counts the units other customers' carts hold on the products.
+	Reserved(productIDs []int, userID int) (ReservedStock, error)
+	// ReserveCart holds items for the user, replacing what their cart held
+	// before, and returns when they will be released.
+	ReserveCart(userID int, items []CartCheckoutItem) (time.Time, error)
+	// ReserveOrder gives a pending order until the reservation expires to
+	// be paid.
+	ReserveOrder(orderID int, items []CartCheckoutItem) error
+	ReleaseCart(userID int) error
+	ReleaseOrder(orderID int) error
+	WithTx(tx *sql.Tx) StockReserver
+}
+
+// Money in payloads must be in a known currency, and each field bounds its
+// amount with the amount_gt or amount_gte tags registered in utils: prices
+// must be positive, while costs where zero means free may be zero. The
+// handlers also require that currency to have an exchange rate.
 type CreateProductPayload struct {
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description"`
-	Image       string  `json:"image"`
-	Price       float64 `json:"price" validate:"required"`
-	Quantity    int     `json:"quantity" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Price       Money  `json:"price" validate:"amount_gt=0"`
+	TaxClass    string `json:"taxClass" validate:"omitempty,max=32"`
+	Weight      int    `json:"weight" validate:"gte=0"`
+	Quantity    int    `json:"quantity" validate:"gte=0"`
+	// ReorderThreshold defaults to 0, which only flags the product once it
+	// is out of stock.
+	ReorderThreshold int `json:"reorderThreshold" validate:"gte=0"`
+}
+
+// UpdateProductPayload replaces the details of a product. Its stock only
+// changes through POST /products/{productID}/stock-movements, so an edit
+// can't write back a quantity that sales have moved since it was read.
+type UpdateProductPayload struct {
+	Name             string `json:"name" validate:"required"`
+	Description      string `json:"description"`
+	Image            string `json:"image"`
+	Price            Money  `json:"price" validate:"amount_gt=0"`
+	TaxClass         string `json:"taxClass" validate:"omitempty,max=32"`
+	Weight           int    `json:"weight" validate:"gte=0"`
+	ReorderThreshold int    `json:"reorderThreshold" validate:"gte=0"`
+}
+
+// PatchProductPayload only changes the fields that are present. Like
+// UpdateProductPayload, it leaves the stock alone.
+type PatchProductPayload struct {
+	Name             *string `json:"name" validate:"omitempty,min=1"`
+	Description      *string `json:"description"`
+	Image            *string `json:"image"`
+	Price            *Money  `json:"price" validate:"omitempty,amount_gt=0"`
+	TaxClass         *string `json:"taxClass" validate:"omitempty,min=1,max=32"`
+	Weight           *int    `json:"weight" validate:"omitempty,gte=0"`
+	ReorderThreshold *int    `json:"reorderThreshold" validate:"omitempty,gte=0"`
+}
+
+// StockMovementPayload records stock staff add or take by hand. Manual
+// movements can only be adjustments or imports; the others come from orders
+// and returns. Without a WarehouseID the stock goes in or out of the default
+// warehouse.
+type StockMovementPayload struct {
+	VariantID   *int                `json:"variantID" validate:"omitempty,gt=0"`
+	WarehouseID *int                `json:"warehouseID" validate:"omitempty,gt=0"`
+	Change      int                 `json:"change" validate:"required"`
+	Reason      StockMovementReason `json:"reason" validate:"required,oneof=adjustment import"`
+	Reference   string              `json:"reference" validate:"max=255"`
+}
+
+// WarehousePayload makes the warehouse the default one when IsDefault is
+// set; the default warehouse can only change by making another one the
+// default.
+type WarehousePayload struct {
+	Code      string        `json:"code" validate:"required,max=50"`
+	Name      string        `json:"name" validate:"required,max=255"`
+	Address   PostalAddress `json:"address"`
+	IsDefault bool          `json:"isDefault"`
+}
+
+type CategoryPayload struct {
+	Name     string `json:"name" validate:"required,max=255"`
+	ParentID *int   `json:"parentID" validate:"omitempty,gt=0"`
+}
+
+type ProductCategoriesPayload struct {
+	CategoryIDs []int `json:"categoryIDs" validate:"dive,gt=0"`
+}
+
+type ReorderImagesPayload struct {
+	ImageIDs []int `json:"imageIDs" validate:"required,min=1"`
+}
+
+type ProductOptionsPayload struct {
+	Options []string `json:"options" validate:"max=5,dive,required,max=64"`
+}
+
+type CreateVariantPayload struct {
+	SKU      string            `json:"sku" validate:"required,max=64"`
+	Price    *Money            `json:"price" validate:"omitempty,amount_gt=0"`
+	Quantity int               `json:"quantity" validate:"gte=0"`
+	Options  map[string]string `json:"options" validate:"dive,required,max=64"`
+}
+
+// UpdateVariantPayload leaves the stock of the variant alone; it changes
+// through the stock movements of its product, with the variant's id.
+type UpdateVariantPayload struct {
+	SKU   string `json:"sku" validate:"required,max=64"`
+	Price *Money `json:"price" validate:"omitempty,amount_gt=0"`
+}
+
+// ExchangeRatesPayload maps currencies to their new rate against
+// DefaultCurrency, as in {"rates": {"EUR": "0.92"}}.
+type ExchangeRatesPayload struct {
+	Rates map[string]Rate `json:"rates" validate:"required,min=1,dive,gt=0"`
+}
+
+// TaxRatesPayload replaces the whole tax table.
+type TaxRatesPayload struct {
+	Rates []TaxRatePayload `json:"rates" validate:"dive"`
+}
+
+// TaxRatePayload.Rate is a share of the price, so it can't be more than 1.
+type TaxRatePayload struct {
+	Country  string `json:"country" validate:"required,len=2,alpha"`
+	Region   string `json:"region" validate:"max=255"`
+	TaxClass string `json:"taxClass" validate:"required,max=32"`
+	Rate     Rate   `json:"rate" validate:"lte=100000000"`
+}
+
+// ShippingMethodPayload creates or replaces a shipping method. CostPerKg is
+// required by weight based methods and FreeOver by free over threshold ones.
+// Methods are active unless Active is false.
+type ShippingMethodPayload struct {
+	Code      string             `json:"code" validate:"required,max=64,alphanum"`
+	Name      string             `json:"name" validate:"required,max=255"`
+	Type      ShippingMethodType `json:"type" validate:"required,oneof=flat_rate weight_based free_over_threshold"`
+	Cost      Money              `json:"cost" validate:"amount_gte=0"`
+	CostPerKg *Money             `json:"costPerKg" validate:"required_if=Type weight_based,omitempty,amount_gte=0"`
+	FreeOver  *Money             `json:"freeOver" validate:"required_if=Type free_over_threshold,omitempty,amount_gt=0"`
+	Countries []string           `json:"countries" validate:"dive,len=2,alpha"`
+	Active    *bool              `json:"active"`
+}
+
+// CouponPayload creates or replaces a coupon. PercentOff is required by
+// percentage coupons and AmountOff by fixed amount ones.
+type CouponPayload struct {
+	Code           string     `json:"code" validate:"required,max=64,alphanum"`
+	Type           CouponType `json:"type" validate:"required,oneof=percentage fixed_amount free_shipping"`
+	PercentOff     int        `json:"percentOff" validate:"required_if=Type percentage,omitempty,min=1,max=100"`
+	AmountOff      *Money     `json:"amountOff" validate:"required_if=Type fixed_amount,omitempty,amount_gt=0"`
+	MinCartValue   *Money     `json:"minCartValue" validate:"omitempty,amount_gte=0"`
+	MaxUses        *int       `json:"maxUses" validate:"omitempty,gt=0"`
+	MaxUsesPerUser *int       `json:"maxUsesPerUser" validate:"omitempty,gt=0"`
+	StartsAt       *time.Time `json:"startsAt"`
+	EndsAt         *time.Time `json:"endsAt"`
+	ProductIDs     []int      `json:"productIDs" validate:"dive,gt=0"`
+	CategoryIDs    []int      `json:"categoryIDs" validate:"dive,gt=0"`
 }
 
 type RegisterUserPayload struct {
@@ -86,6 +1267,62 @@ type LoginUserPayload struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// CartCheckoutPayload is the body of POST /cart/checkout. When Items is empty
+// the user's stored cart is checked out instead, and when AddressID is zero
+// the order ships to the user's default address. ShippingMethod is the code
+// of a method shipping to that address, and can only be left out when no
+// method does.
 type CartCheckoutPayload struct {
-	Items []CartCheckoutItem `json:"items" validate:"required"`
+	Items          []CartCheckoutItem `json:"items"`
+	AddressID      int                `json:"addressID"`
+	CouponCode     string             `json:"couponCode" validate:"max=64"`
+	ShippingMethod string             `json:"shippingMethod" validate:"max=64"`
+}
+
+type UpdateOrderStatusPayload struct {
+	Status string `json:"status" validate:"required"`
+	Note   string `json:"note" validate:"max=500"`
+}
+
+type UpdateUserRolePayload struct {
+	Role Role `json:"role" validate:"required,oneof=customer staff admin"`
+}
+
+type CreateReturnPayload struct {
+	Items  []ReturnItemPayload `json:"items" validate:"required,min=1,dive"`
+	Reason string              `json:"reason" validate:"required,max=500"`
+}
+
+type ReturnItemPayload struct {
+	OrderItemID int `json:"orderItemID" validate:"required"`
+	Quantity    int `json:"quantity" validate:"required,gt=0"`
+}
+
+type ReviewReturnPayload struct {
+	Note string `json:"note" validate:"max=500"`
+}
+
+// ReceiveReturnPayload.Restock puts the returned units back in stock.
+type ReceiveReturnPayload struct {
+	Restock bool   `json:"restock"`
+	Note    string `json:"note" validate:"max=500"`
+}
+
+type CancelOrderPayload struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type AddressPayload struct {
+	PostalAddress
+	IsDefault bool `json:"isDefault"`
+}
+
+type AddCartItemPayload struct {
+	ProductID int `json:"productID" validate:"required"`
+	VariantID int `json:"variantID" validate:"gte=0"`
+	Quantity  int `json:"quantity" validate:"required,gt=0"`
+}
+
+type UpdateCartItemPayload struct {
+	Quantity int `json:"quantity" validate:"required,gt=0"`
 }
@@ -4,11 +4,69 @@ import (
 	"encoding/json" // Pacote para codificar e decodificar JSON
 	"fmt"           // Pacote para formatação de strings e erros
 	"net/http"      // Pacote para manipulação de requisições e respostas HTTP
+	"strconv"       // Pacote para converter strings em números
+	"strings"       // Pacote para manipulação de strings
 
 	"github.com/go-playground/validator/v10" // Pacote para validação de dados (não utilizado diretamente neste código)
+	"github.com/sikozonpc/ecom/types"
 )
 
-var Validate = validator.New()
+var Validate = newValidator()
+
+// Cria o validador usado pelos handlers, já sabendo validar os valores em dinheiro.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterStructValidation(validateMoney, types.Money{})
+	v.RegisterValidation("amount_gt", compareAmount(func(amount, limit int64) bool { return amount > limit }))
+	v.RegisterValidation("amount_gte", compareAmount(func(amount, limit int64) bool { return amount >= limit }))
+	return v
+}
+
+// Todo valor em dinheiro recebido num payload precisa estar numa moeda
+// conhecida. Se a moeda tem taxa de câmbio cadastrada é verificado pelos
+// handlers, que consultam o banco.
+func validateMoney(sl validator.StructLevel) {
+	m := sl.Current().Interface().(types.Money)
+
+	if !types.KnownCurrency(m.Currency) {
+		sl.ReportError(m.Currency, "Currency", "currency", "iso4217", "")
+	}
+}
+
+// Cria as tags amount_gt e amount_gte, que comparam o valor de um campo Money
+// com o parâmetro da tag, em unidades menores. Cada campo diz o que aceita:
+// um preço precisa ser positivo, já um custo de frete pode ser zero (grátis).
+func compareAmount(ok func(amount, limit int64) bool) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		m, isMoney := fl.Field().Interface().(types.Money)
+		if !isMoney {
+			return false
+		}
+
+		limit, err := strconv.ParseInt(fl.Param(), 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("utils: invalid amount limit %q", fl.Param()))
+		}
+
+		return ok(m.Amount, limit)
+	}
+}
+
+// Função que lê a moeda pedida pelo cliente no parâmetro "currency" da query
+// string, usando a moeda padrão quando ele não é enviado
+func ParseCurrency(r *http.Request) (string, error) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		return types.DefaultCurrency, nil
+	}
+
+	// Só aceita as moedas que o tipo Money sabe representar
+	if !types.KnownCurrency(currency) {
+		return "", fmt.Errorf("unknown currency %q", currency)
+	}
+
+	return currency, nil
+}
 
 // Função que escreve uma resposta HTTP em formato JSON
 func WriteJSON(w http.ResponseWriter, status int, v any) error {